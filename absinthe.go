@@ -0,0 +1,188 @@
+package phx
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	absintheControlTopic = "__absinthe__:control"
+	absintheDocPrefix    = "__absinthe__:doc:"
+	absintheDataEvent    = "subscription:data"
+)
+
+// Absinthe runs GraphQL subscriptions with Absinthe over a Socket, side by side with plain Channels and LiveViews. It
+// joins the "__absinthe__:control" topic, and routes the "subscription:data" events the server sends to the
+// "__absinthe__:doc:*" topics of the subscriptions to their callbacks. Subscriptions are sent again after the control
+// Channel rejoins, as the server forgets them with the connection.
+type Absinthe struct {
+	// Channel is the control Channel, which must be joined with Join.
+	Channel *Channel
+
+	mu            sync.Mutex
+	subscriptions map[string]*AbsintheSubscription
+	active        map[*AbsintheSubscription]struct{}
+	joined        bool
+}
+
+// AbsintheSubscription is a GraphQL subscription started with Absinthe.Subscribe.
+type AbsintheSubscription struct {
+	// Push is the push of the subscription document. Its "error" reply has the errors of an invalid document.
+	Push *Push
+
+	absinthe     *Absinthe
+	query        string
+	variables    map[string]any
+	callback     func(result any)
+	id           string
+	unsubscribed bool
+}
+
+// NewAbsinthe creates an Absinthe for the given Socket.
+func NewAbsinthe(socket *Socket, params map[string]string) *Absinthe {
+	a := &Absinthe{
+		Channel:       socket.Channel(absintheControlTopic, params),
+		subscriptions: make(map[string]*AbsintheSubscription),
+		active:        make(map[*AbsintheSubscription]struct{}),
+	}
+	a.Channel.TopicMatcher = PrefixTopic(absintheDocPrefix)
+	a.Channel.On(absintheDataEvent, a.onData)
+	a.Channel.OnJoin(a.onJoin)
+	return a
+}
+
+// Join joins the control Channel.
+func (a *Absinthe) Join() (*Push, error) {
+	return a.Channel.Join()
+}
+
+// Subscribe sends the given subscription document with the given variables, and calls the given callback with the
+// "result" of every update the server sends for it, which has the "data" and "errors" of the GraphQL response.
+func (a *Absinthe) Subscribe(query string, variables map[string]any, callback func(result any)) (*AbsintheSubscription, error) {
+	if variables == nil {
+		variables = map[string]any{}
+	}
+	sub := &AbsintheSubscription{
+		absinthe:  a,
+		query:     query,
+		variables: variables,
+		callback:  callback,
+	}
+
+	a.mu.Lock()
+	a.active[sub] = struct{}{}
+	a.mu.Unlock()
+
+	err := sub.send()
+	if err != nil {
+		a.mu.Lock()
+		delete(a.active, sub)
+		a.mu.Unlock()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ID returns the id the server gave the subscription, or "" until it has replied.
+func (s *AbsintheSubscription) ID() string {
+	s.absinthe.mu.Lock()
+	defer s.absinthe.mu.Unlock()
+
+	return s.id
+}
+
+// Unsubscribe stops the subscription, telling the server to stop sending its updates.
+func (s *AbsintheSubscription) Unsubscribe() error {
+	a := s.absinthe
+	a.mu.Lock()
+	id := s.id
+	s.unsubscribed = true
+	delete(a.active, s)
+	delete(a.subscriptions, id)
+	a.mu.Unlock()
+
+	if id == "" {
+		// The reply to the document will unsubscribe once it gives the id
+		return nil
+	}
+	return a.unsubscribe(id)
+}
+
+// send pushes the document of the subscription and records the id of the reply.
+func (s *AbsintheSubscription) send() error {
+	push, err := s.absinthe.Channel.Push("doc", map[string]any{"query": s.query, "variables": s.variables})
+	if err != nil {
+		return err
+	}
+	push.Receive("ok", func(response any) {
+		reply, _ := response.(map[string]any)
+		id, _ := reply["subscriptionId"].(string)
+		if id == "" {
+			return
+		}
+
+		a := s.absinthe
+		a.mu.Lock()
+		unsubscribed := s.unsubscribed
+		if !unsubscribed {
+			delete(a.subscriptions, s.id)
+			s.id = id
+			a.subscriptions[id] = s
+		}
+		a.mu.Unlock()
+
+		if unsubscribed {
+			_ = a.unsubscribe(id)
+		}
+	})
+
+	s.absinthe.mu.Lock()
+	s.Push = push
+	s.absinthe.mu.Unlock()
+	return nil
+}
+
+func (a *Absinthe) unsubscribe(id string) error {
+	_, err := a.Channel.Push("unsubscribe", map[string]any{"subscriptionId": id})
+	if err != nil {
+		return fmt.Errorf("unsubscribing '%v': %w", id, err)
+	}
+	return nil
+}
+
+func (a *Absinthe) onData(payload any) {
+	data, _ := payload.(map[string]any)
+	id, _ := data["subscriptionId"].(string)
+
+	a.mu.Lock()
+	sub, ok := a.subscriptions[id]
+	a.mu.Unlock()
+
+	if ok {
+		sub.callback(data["result"])
+	}
+}
+
+// onJoin sends the subscriptions again when the control Channel rejoins. On the first join, the documents pushed
+// before it are still buffered and sent by the Channel.
+func (a *Absinthe) onJoin(payload any) {
+	a.mu.Lock()
+	rejoined := a.joined
+	a.joined = true
+	var resend []*AbsintheSubscription
+	if rejoined {
+		for sub := range a.active {
+			if sub.id != "" {
+				resend = append(resend, sub)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	for _, sub := range resend {
+		err := sub.send()
+		if err != nil {
+			a.Channel.socket.Logger.Printf(LogError, "absinthe", "could not resubscribe '%v': %v", sub.ID(), err)
+		}
+	}
+}
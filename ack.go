@@ -0,0 +1,70 @@
+package phx
+
+import "bytes"
+
+// ackRefKey is the key in a payload the server sets when it requests an acknowledgement
+const ackRefKey = "ack_ref"
+
+// ManualAck opts the given event out of automatic acknowledgements. Messages of this event that request an
+// acknowledgement must be acknowledged with Ack once they have been handled.
+func (c *Channel) ManualAck(event string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.manualAcks[event] = true
+}
+
+// Ack acknowledges the given payload by sending an AckEvent with its "ack_ref" back to the server. Payloads without
+// an "ack_ref" are ignored.
+func (c *Channel) Ack(payload any) error {
+	ackRef, ok := ackRefOf(payload)
+	if !ok {
+		return nil
+	}
+
+	return c.socket.PushMessage(Message{
+		Topic:   c.topic,
+		Event:   c.AckEvent,
+		Payload: map[string]any{ackRefKey: ackRef},
+		Ref:     c.socket.MakeRef(),
+		JoinRef: c.JoinRef(),
+	})
+}
+
+// autoAck acknowledges the given message if the server requested it and the event was not opted out with ManualAck.
+func (c *Channel) autoAck(msg Message) {
+	if msg.Event == string(ReplyEvent) || c.AckEvent == "" {
+		return
+	}
+	if _, ok := ackRefOf(msg.Payload); !ok {
+		return
+	}
+
+	c.mu.RLock()
+	manual := c.manualAcks[msg.Event]
+	c.mu.RUnlock()
+	if manual {
+		return
+	}
+
+	err := c.Ack(msg.Payload)
+	if err != nil {
+		c.socket.Logger.Printf(LogError, "channel", "error acknowledging '%v' on '%v': %v", msg.Event, c.topic, err)
+	}
+}
+
+// requestsAck returns true if the given encoded payload may have an "ack_ref", so that it must be decoded to be
+// acknowledged. Payloads that don't mention it at all can't request an acknowledgement.
+func requestsAck(payload []byte) bool {
+	return bytes.Contains(payload, []byte(ackRefKey))
+}
+
+// ackRefOf returns the "ack_ref" of the given payload, if it has one.
+func ackRefOf(payload any) (any, bool) {
+	m, ok := payload.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	ackRef, ok := m[ackRefKey]
+	return ackRef, ok && ackRef != nil
+}
@@ -0,0 +1,161 @@
+package phx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// analyzerSlots is the number of slots the sliding window of a PayloadAnalyzer is divided into
+const analyzerSlots = 60
+
+// analyzerBounds are the upper bounds, in bytes, of the buckets of the payload size histogram. The last bucket counts
+// everything larger.
+var analyzerBounds = []int{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// HistogramBucket is one bucket of the payload size histogram in a PayloadReport.
+type HistogramBucket struct {
+	// UpperBound is the largest size in bytes counted in this bucket, or 0 for the last bucket that counts everything
+	// larger than the previous bucket.
+	UpperBound int
+
+	// Count is the number of messages in this bucket.
+	Count uint64
+}
+
+// TopicBytes is the traffic for a topic in a PayloadReport.
+type TopicBytes struct {
+	Topic    string
+	Messages uint64
+	Bytes    uint64
+}
+
+// PayloadReport is the inbound traffic seen by a PayloadAnalyzer over its window.
+type PayloadReport struct {
+	// Window is the duration the report covers.
+	Window time.Duration
+
+	// Histogram counts messages by their encoded size.
+	Histogram []HistogramBucket
+
+	// TopTopics are the topics that received the most bytes, largest first.
+	TopTopics []TopicBytes
+}
+
+type analyzerSlot struct {
+	start    int64
+	counts   []uint64
+	topics   map[string]*TopicBytes
+	hasStart bool
+}
+
+// PayloadAnalyzer maintains a histogram of the size of inbound messages and a report of the topics receiving the most
+// bytes over a sliding window. Set it as Socket.Analyzer to use it. This can help guide server-side payload
+// optimization.
+type PayloadAnalyzer struct {
+	// Clock is the source of time for the window, which should be the Clock of the Socket it is set on. Defaults to
+	// the real clock.
+	Clock Clock
+
+	mu       sync.Mutex
+	window   time.Duration
+	k        int
+	slotSize int64
+	slots    []analyzerSlot
+}
+
+// NewPayloadAnalyzer creates a PayloadAnalyzer that reports on the last window of traffic and the top k topics.
+func NewPayloadAnalyzer(window time.Duration, k int) *PayloadAnalyzer {
+	slotSize := int64(window / analyzerSlots)
+	if slotSize <= 0 {
+		slotSize = 1
+	}
+	return &PayloadAnalyzer{
+		Clock:    NewRealClock(),
+		window:   window,
+		k:        k,
+		slotSize: slotSize,
+		slots:    make([]analyzerSlot, analyzerSlots),
+	}
+}
+
+// observe records an inbound message of the given size for the given topic.
+func (a *PayloadAnalyzer) observe(topic string, size int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := now.UnixNano() / a.slotSize
+	slot := &a.slots[start%int64(len(a.slots))]
+	if !slot.hasStart || slot.start != start {
+		slot.start = start
+		slot.hasStart = true
+		slot.counts = make([]uint64, len(analyzerBounds)+1)
+		slot.topics = make(map[string]*TopicBytes)
+	}
+
+	bucket := sort.SearchInts(analyzerBounds, size)
+	slot.counts[bucket]++
+
+	tb, ok := slot.topics[topic]
+	if !ok {
+		tb = &TopicBytes{Topic: topic}
+		slot.topics[topic] = tb
+	}
+	tb.Messages++
+	tb.Bytes += uint64(size)
+}
+
+// Report returns the histogram and top topics for the current window.
+func (a *PayloadAnalyzer) Report() PayloadReport {
+	return a.report(a.Clock.Now())
+}
+
+func (a *PayloadAnalyzer) report(now time.Time) PayloadReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report := PayloadReport{
+		Window:    a.window,
+		Histogram: make([]HistogramBucket, len(analyzerBounds)+1),
+	}
+	for i, bound := range analyzerBounds {
+		report.Histogram[i].UpperBound = bound
+	}
+
+	current := now.UnixNano() / a.slotSize
+	topics := make(map[string]*TopicBytes)
+	for i := range a.slots {
+		slot := &a.slots[i]
+		if !slot.hasStart || current-slot.start >= int64(len(a.slots)) {
+			continue
+		}
+		for bucket, count := range slot.counts {
+			report.Histogram[bucket].Count += count
+		}
+		for topic, tb := range slot.topics {
+			total, ok := topics[topic]
+			if !ok {
+				total = &TopicBytes{Topic: topic}
+				topics[topic] = total
+			}
+			total.Messages += tb.Messages
+			total.Bytes += tb.Bytes
+		}
+	}
+
+	report.TopTopics = make([]TopicBytes, 0, len(topics))
+	for _, tb := range topics {
+		report.TopTopics = append(report.TopTopics, *tb)
+	}
+	sort.Slice(report.TopTopics, func(i, j int) bool {
+		if report.TopTopics[i].Bytes == report.TopTopics[j].Bytes {
+			return report.TopTopics[i].Topic < report.TopTopics[j].Topic
+		}
+		return report.TopTopics[i].Bytes > report.TopTopics[j].Bytes
+	})
+	if a.k > 0 && len(report.TopTopics) > a.k {
+		report.TopTopics = report.TopTopics[:a.k]
+	}
+
+	return report
+}
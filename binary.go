@@ -0,0 +1,150 @@
+package phx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The kinds of messages in the binary wire format of Phoenix, given by their first byte.
+const (
+	binaryPushKind      = 0
+	binaryReplyKind     = 1
+	binaryBroadcastKind = 2
+)
+
+// binaryPayload marks the payload of a Message to be sent in a binary frame instead of being serialized.
+type binaryPayload []byte
+
+// PushBinary pushes the given event with a binary payload, sent in a binary frame as Phoenix expects for pushes such as
+// LiveView uploads, where the server receives it as {:binary, data}. Requires a Transport that implements
+// BinarySender, such as Websocket. Pushes, replies and broadcasts the server sends in binary frames are received with
+// a []byte payload, or a []byte "response" for replies.
+//
+// Requires FeatureBinaryPayloads in Socket.Features.
+func (c *Channel) PushBinary(event string, payload []byte) (*Push, error) {
+	if !c.socket.Features.Has(FeatureBinaryPayloads) {
+		return nil, errFeatureDisabled(FeatureBinaryPayloads)
+	}
+	push := NewPush(c, event, payload, c.PushTimeout)
+	push.binary = true
+	return c.pushOrBuffer(push)
+}
+
+// encodeBinaryPush encodes the given message for a binary frame as
+//
+//	<<0, join_ref_size, ref_size, topic_size, event_size, join_ref, ref, topic, event, payload>>
+func encodeBinaryPush(msg *Message, payload binaryPayload) ([]byte, error) {
+	var joinRef, ref string
+	if msg.JoinRef != 0 {
+		joinRef = strconv.FormatUint(uint64(msg.JoinRef), 10)
+	}
+	if msg.Ref != 0 {
+		ref = strconv.FormatUint(uint64(msg.Ref), 10)
+	}
+
+	fields := []string{joinRef, ref, msg.Topic, msg.Event}
+	data := make([]byte, 0, 1+len(fields)+len(joinRef)+len(ref)+len(msg.Topic)+len(msg.Event)+len(payload))
+	data = append(data, binaryPushKind)
+	for _, field := range fields {
+		if len(field) > 255 {
+			return nil, fmt.Errorf("binary message field '%v' is longer than 255 bytes", field)
+		}
+		data = append(data, byte(len(field)))
+	}
+	for _, field := range fields {
+		data = append(data, field...)
+	}
+	return append(data, payload...), nil
+}
+
+// decodeBinaryMessage decodes a message received in a binary frame. Pushes from the server are
+//
+//	<<0, join_ref_size, topic_size, event_size, join_ref, topic, event, payload>>
+//
+// replies are
+//
+//	<<1, join_ref_size, ref_size, topic_size, status_size, join_ref, ref, topic, status, response>>
+//
+// and broadcasts are
+//
+//	<<2, topic_size, event_size, topic, event, payload>>
+func decodeBinaryMessage(data []byte) (*Message, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty binary message")
+	}
+
+	switch data[0] {
+	case binaryPushKind:
+		fields, payload, err := binaryFields(data[1:], 3)
+		if err != nil {
+			return nil, err
+		}
+		joinRef, err := parseBinaryRef(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Message{JoinRef: joinRef, Topic: fields[1], Event: fields[2], Payload: payload}, nil
+
+	case binaryReplyKind:
+		fields, payload, err := binaryFields(data[1:], 4)
+		if err != nil {
+			return nil, err
+		}
+		joinRef, err := parseBinaryRef(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		ref, err := parseBinaryRef(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Message{
+			JoinRef: joinRef,
+			Ref:     ref,
+			Topic:   fields[2],
+			Event:   string(ReplyEvent),
+			Payload: map[string]any{"status": fields[3], "response": payload},
+		}, nil
+
+	case binaryBroadcastKind:
+		fields, payload, err := binaryFields(data[1:], 2)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Topic: fields[0], Event: fields[1], Payload: payload}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported binary message kind %d", data[0])
+	}
+}
+
+// binaryFields splits the given data into n fields, whose sizes are in the first n bytes, and the rest of the data.
+func binaryFields(data []byte, n int) ([]string, []byte, error) {
+	if len(data) < n {
+		return nil, nil, errors.New("binary message is truncated")
+	}
+	sizes := data[:n]
+	data = data[n:]
+
+	fields := make([]string, n)
+	for i, size := range sizes {
+		if len(data) < int(size) {
+			return nil, nil, errors.New("binary message is truncated")
+		}
+		fields[i] = string(data[:size])
+		data = data[size:]
+	}
+	return fields, data, nil
+}
+
+func parseBinaryRef(field string) (Ref, error) {
+	if field == "" {
+		return 0, nil
+	}
+	ref, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ref in binary message: %w", err)
+	}
+	return Ref(ref), nil
+}
@@ -0,0 +1,66 @@
+package phx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBinaryMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want *Message
+	}{
+		{
+			name: "push",
+			data: append([]byte{binaryPushKind, 1, 6, 4}, "3room:1ping\x00\x01"...),
+			want: &Message{JoinRef: 3, Topic: "room:1", Event: "ping", Payload: []byte{0, 1}},
+		},
+		{
+			name: "push without join ref",
+			data: append([]byte{binaryPushKind, 0, 6, 4}, "room:1ping\x02"...),
+			want: &Message{Topic: "room:1", Event: "ping", Payload: []byte{2}},
+		},
+		{
+			name: "reply",
+			data: append([]byte{binaryReplyKind, 1, 2, 6, 2}, "312room:1ok\x03"...),
+			want: &Message{
+				JoinRef: 3,
+				Ref:     12,
+				Topic:   "room:1",
+				Event:   string(ReplyEvent),
+				Payload: map[string]any{"status": "ok", "response": []byte{3}},
+			},
+		},
+		{
+			name: "broadcast",
+			data: append([]byte{binaryBroadcastKind, 6, 4}, "room:1ping\x04"...),
+			want: &Message{Topic: "room:1", Event: "ping", Payload: []byte{4}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := decodeBinaryMessage(test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(msg, test.want) {
+				t.Errorf("decoded %+v, want %+v", msg, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBinaryMessageMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":           {},
+		"unknown kind":    {3, 0, 0},
+		"truncated sizes": {binaryPushKind, 1},
+		"truncated field": append([]byte{binaryPushKind, 0, 6, 4}, "room:1pi"...),
+		"invalid ref":     append([]byte{binaryPushKind, 1, 6, 4}, "xroom:1ping"...),
+	} {
+		if _, err := decodeBinaryMessage(data); err == nil {
+			t.Errorf("%v: decoded without error", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package phx
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// BindingInfo describes the bindings registered on a Channel for one event, as returned by Channel.Bindings.
+type BindingInfo struct {
+	// Event is the event the bindings are for, such as "phx_reply" for the bindings of pushes waiting for a reply.
+	Event string
+
+	// Count is the number of bindings for the event.
+	Count int
+
+	// Sites counts the bindings by where they were registered, as "file:line" of the first caller outside of this
+	// package, or "internal" for those registered by the package itself. Only set if Socket.TrackBindingSites was set
+	// when they were registered.
+	Sites map[string]int
+}
+
+// A Binding is a callback bound to an event of a Channel, as returned by Channel.Bind. Any number of Bindings may be
+// bound to the same event, and every one of them is called with each message.
+type Binding struct {
+	// Event is the event the callback is bound to.
+	Event string
+
+	// Ref is the Ref of the binding, which can also be passed to Channel.Off.
+	Ref Ref
+
+	channel *Channel
+}
+
+// Bind registers the given callback for all matching events received on this Channel, like On, and returns a Binding
+// whose Unsubscribe removes it. Long-lived applications should unsubscribe handlers they no longer need, so that
+// their callbacks, and everything they reference, can be collected.
+func (c *Channel) Bind(event string, callback func(payload any)) *Binding {
+	return &Binding{Event: event, Ref: c.On(event, callback), channel: c}
+}
+
+// Unsubscribe removes the callback of the Binding, which isn't called for messages that arrive afterwards. It may be
+// called more than once, and from within the callback.
+func (b *Binding) Unsubscribe() {
+	b.channel.Off(b.Ref)
+}
+
+// packagePath is the import path of this package, to tell its frames apart from those of its callers.
+var packagePath = reflect.TypeOf(Channel{}).PkgPath()
+
+// Bindings returns the bindings registered on the Channel, by event, sorted by event. A count that grows across
+// reconnects is a sign of handlers that are registered again each time without being removed with Off.
+func (c *Channel) Bindings() []BindingInfo {
+	c.bindingsMu.RLock()
+	defer c.bindingsMu.RUnlock()
+
+	byEvent := make(map[string]*BindingInfo)
+	for _, binding := range c.bindings {
+		info, ok := byEvent[binding.event]
+		if !ok {
+			info = &BindingInfo{Event: binding.event}
+			byEvent[binding.event] = info
+		}
+		info.Count++
+		if binding.site != "" {
+			if info.Sites == nil {
+				info.Sites = make(map[string]int)
+			}
+			info.Sites[binding.site]++
+		}
+	}
+
+	infos := make([]BindingInfo, 0, len(byEvent))
+	for _, info := range byEvent {
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Event < infos[j].Event })
+	return infos
+}
+
+// bindingSite returns where a binding is being registered from, if Socket.TrackBindingSites is set.
+func (c *Channel) bindingSite() string {
+	if !c.socket.TrackBindingSites {
+		return ""
+	}
+
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "runtime") || strings.HasPrefix(frame.Function, "time.") {
+			// Registered from one of this package's own goroutines, such as a Push waiting for its reply on rejoin
+			return "internal"
+		}
+		if !strings.HasPrefix(frame.Function, packagePath+".") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "internal"
+		}
+	}
+}
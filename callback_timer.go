@@ -13,12 +13,14 @@ type callbackTimer struct {
 	mu        sync.Mutex
 	callback  timerCallback
 	timerCalc timerCalculator
-	timer     *time.Timer
+	clock     Clock
+	timer     Timer
 	tries     int
 }
 
-func newCallbackTimer(callback timerCallback, timerCalc timerCalculator) *callbackTimer {
+func newCallbackTimer(clock Clock, callback timerCallback, timerCalc timerCalculator) *callbackTimer {
 	return &callbackTimer{
+		clock:     clock,
 		callback:  callback,
 		timerCalc: timerCalc,
 		timer:     nil,
@@ -37,6 +39,14 @@ func (t *callbackTimer) Reset() {
 	}
 }
 
+// Tries returns the number of times the callback was called since the last Reset.
+func (t *callbackTimer) Tries() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tries
+}
+
 func (t *callbackTimer) Run() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -46,7 +56,7 @@ func (t *callbackTimer) Run() {
 		t.timer = nil
 	}
 
-	t.timer = time.AfterFunc(t.timerCalc(t.tries+1), func() {
+	t.timer = t.clock.AfterFunc(t.timerCalc(t.tries+1), func() {
 		t.mu.Lock()
 		defer t.mu.Unlock()
 
@@ -3,6 +3,7 @@ package phx
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,80 +11,196 @@ type channelBinding struct {
 	ref      Ref
 	event    string
 	callback func(payload any)
+	inline   bool   // called in the reading goroutine, in the order messages arrive, instead of dispatched
+	site     string // where the binding was registered, if Socket.TrackBindingSites is set
+}
+
+type afterJoinBinding struct {
+	ref      Ref
+	callback func(reply Reply)
 }
 
 // A Channel is a unique connection to the given Topic on the server. You can have many Channels connected over one
 // Socket, each handling messages independently.
 type Channel struct {
 	// PushTimeout is the time that a Push waits before considering it defunct and triggering a "timeout" event.
+	// Defaults to the Socket's PushTimeout.
 	PushTimeout time.Duration
 
-	// RejoinAfterFunc is a function that returns the duration to wait before rejoining based on given tries
+	// RejoinAfterFunc is a function that returns the duration to wait before rejoining based on given tries. The
+	// Channel rejoins with its original params whenever the Socket reconnects, and after join errors and timeouts,
+	// backing off with this function until a join succeeds. It may be changed at any time. Defaults to
+	// retry.RejoinSchedule with a Jitter of retry.RejoinJitter, read from the Rand of the Socket.
 	RejoinAfterFunc func(tries int) time.Duration
 
+	// JoinErrorBudget is the number of consecutive "error" replies to a join that are tolerated before the Channel
+	// gives up, stops rejoining and calls the OnJoinGiveUp callbacks. Error replies are considered permanent failures,
+	// such as being unauthorized for the topic, whereas timeouts and socket errors are transient and never count
+	// against the budget. Zero (the default) means rejoin forever.
+	JoinErrorBudget int
+
+	// ResyncFunc, if set, is called once the Channel has rejoined after the Socket was disconnected for longer than
+	// Socket.ResyncAfter, with the duration of the downtime. Use it to fetch the full state of the topic instead of
+	// relying on the messages that were missed.
+	ResyncFunc func(downtime time.Duration)
+
+	// DedupeSize is the number of recent "msg_id"s in payloads to remember, so that messages redelivered by the server
+	// are dropped before any handlers are called. Duplicates that request an acknowledgement are still acknowledged,
+	// since the server likely redelivered them because the first acknowledgement was lost. Zero (the default)
+	// disables deduplication.
+	DedupeSize int
+
+	// HandlerTimeout is the time a handler registered with OnContext may run before its context is canceled and it is
+	// abandoned. Zero (the default) means handlers may run for as long as the join lasts.
+	HandlerTimeout time.Duration
+
+	// ReplyCacheTTL is how long the reply to a Push, or its timeout, is remembered so that handlers attached with
+	// Receive after it arrived are still called, like phoenix.js does. Defaults to 1 minute.
+	ReplyCacheTTL time.Duration
+
+	// AckEvent is the event sent to acknowledge messages whose payload has an "ack_ref", as requested by servers that
+	// want reliable delivery. Use ManualAck to acknowledge an event yourself, or set to "" to disable acknowledgements.
+	// Defaults to "ack".
+	AckEvent string
+
+	// DeadSubscriberAfter is how long the buffer of a Subscription may stay full before its subscriber is considered
+	// abandoned and the Subscription is canceled, so that it stops accumulating drops. Zero means never. Defaults to 1
+	// minute.
+	DeadSubscriberAfter time.Duration
+
+	// TopicMatcher, if set, routes events broadcast to other topics than the Channel's own to it as well, such as
+	// topics derived from it. Defaults to nil, which only routes messages for the Channel's own topic.
+	TopicMatcher TopicMatcher
+
+	// PayloadSerializer, if set, encodes the payloads of the pushes of this Channel, other than its joins and leaves,
+	// which are sent in binary frames, and decodes the payloads of the broadcasts and replies it receives in binary
+	// frames, so that it can use another format than the Socket's Serializer on the same connection. Requires
+	// JSONSerializerV2 or a custom Serializer. Payloads that can't be decoded are passed to the OnDecodeError
+	// callbacks. Defaults to the serializer set for the topic with Socket.SetPayloadSerializer, if any.
+	PayloadSerializer PayloadSerializer
+
 	// private
-	topic           string
-	params          map[string]string
-	mu              sync.RWMutex
-	socket          *Socket
-	state           ChannelState
-	refGenerator    *atomicRef
-	joinPush        *Push
-	bindings        map[Ref]*channelBinding
-	rejoinTimer     *callbackTimer
-	socketCallbacks []Ref
+	topic            string
+	params           map[string]string
+	mu               sync.RWMutex
+	socket           *Socket
+	state            ChannelState
+	refGenerator     *atomicRef
+	joinPush         *Push
+	bindings         map[Ref]*channelBinding
+	rejoinTimer      *callbackTimer
+	socketCallbacks  []Ref
+	joinErrors       int
+	stats            channelStats
+	resyncDowntime   time.Duration
+	pushBuffer       []*Push
+	pending          map[*Push]struct{} // pushes waiting for replies
+	limiters         map[limiterKey]*LimitedPush
+	middleware       []ChannelMiddleware
+	manualAcks       map[string]bool
+	dedupe           *dedupeCache
+	done             chan struct{}
+	scheduled        map[*ScheduledPush]struct{}
+	bindingsMu       sync.RWMutex
+	timings          handlerTimings
+	joinInterceptors []JoinInterceptor
+	joinPayload      func(params map[string]string) any // builds the join payload from the params, for dialects
+	afterJoin        []afterJoinBinding
+	joinMu           sync.Mutex // held by Join and Leave, so that concurrent calls can't both send
 }
 
 // NewChannel creates a new Channel attached to the Socket. If there is already a Channel for the given topic, that
 // channel is returned instead of creating a new one.
 func NewChannel(topic string, params map[string]string, socket *Socket) *Channel {
+	socket.newChannelMu.Lock()
+	defer socket.newChannelMu.Unlock()
+
 	channel, exists := socket.getChannel(topic)
 	if exists {
 		return channel
 	}
 
 	c := &Channel{
-		PushTimeout:     defaultPushTimeout,
-		RejoinAfterFunc: defaultRejoinAfterFunc,
-		topic:           topic,
-		params:          params,
-		socket:          socket,
-		state:           ChannelClosed,
-		refGenerator:    newAtomicRef(),
-		bindings:        make(map[Ref]*channelBinding),
-		socketCallbacks: make([]Ref, 0, 2),
+		PushTimeout:         socket.PushTimeout,
+		RejoinAfterFunc:     socket.defaultRejoinAfter,
+		AckEvent:            defaultAckEvent,
+		ReplyCacheTTL:       defaultReplyCacheTTL,
+		DeadSubscriberAfter: defaultDeadSubscriberAfter,
+		PayloadSerializer:   socket.payloadSerializerFor(topic),
+		topic:               topic,
+		params:              params,
+		socket:              socket,
+		state:               ChannelClosed,
+		refGenerator:        newAtomicRef(),
+		bindings:            make(map[Ref]*channelBinding),
+		socketCallbacks:     make([]Ref, 0, 2),
+		limiters:            make(map[limiterKey]*LimitedPush),
+		manualAcks:          make(map[string]bool),
+		dedupe:              newDedupeCache(),
+		done:                make(chan struct{}),
+		scheduled:           make(map[*ScheduledPush]struct{}),
+		pending:             make(map[*Push]struct{}),
 	}
+	c.stats.stats.Topic = topic
 
-	c.rejoinTimer = newCallbackTimer(c.rejoin, c.RejoinAfterFunc)
+	c.rejoinTimer = newCallbackTimer(socket.Clock, c.rejoin, func(tries int) time.Duration {
+		return c.RejoinAfterFunc(tries)
+	})
 
 	c.OnClose(func(payload any) {
+		// The rejoinTimer is locked while it calls rejoin, which takes joinMu, so it must be reset before taking joinMu
+		c.rejoinTimer.Reset()
+		c.joinMu.Lock()
+		if payload == "leave" && (c.IsJoining() || c.IsJoined()) {
+			// Joined again since the leave, such as from a Socket.OnOpen callback that ran before this
+			c.joinMu.Unlock()
+			return
+		}
 		c.socket.Logger.Printf(LogInfo, "channel", "Channel '%v' closed. joinRef: %v", c.topic, c.JoinRef())
 		c.setState(ChannelClosed)
-		c.rejoinTimer.Reset()
+		c.releaseJoin()
+		c.joinMu.Unlock()
+		c.socket.recordSubscription(c, false)
 	})
 
 	c.OnError(func(payload any) {
 		c.socket.Logger.Printf(LogError, "channel", "Channel '%v' error: %+v", c.topic, payload)
+		c.stats.error()
+		if c.IsJoining() {
+			// A rejoin started since the error was raised, such as when the socket reopened before this ran
+			return
+		}
 		c.setState(ChannelErrored)
 		c.rejoinTimer.Run()
 	})
 
 	c.socketCallbacks = append(c.socketCallbacks, socket.OnOpen(func() {
 		c.rejoinTimer.Reset()
-		if c.IsErrored() {
+		if c.IsErrored() || c.IsJoining() {
 			c.rejoin()
 		}
 	}))
-	c.socketCallbacks = append(c.socketCallbacks, socket.OnClose(func() {
+	c.socketCallbacks = append(c.socketCallbacks, socket.OnDisconnect(func(info DisconnectInfo) {
 		c.rejoinTimer.Reset()
 		if c.IsJoined() || c.IsJoining() {
 			c.setState(ChannelErrored)
-			c.trigger(string(ErrorEvent), 0, nil)
+			c.trigger(string(ErrorEvent), 0, info)
+		}
+		// Callbacks run concurrently, so the next connection may have opened before this ran, missing the rejoin
+		if c.IsErrored() && c.socket.IsConnected() {
+			c.rejoin()
 		}
 	}))
 	c.socketCallbacks = append(c.socketCallbacks, socket.OnError(func(err error) {
+		if !c.IsJoined() && !c.IsJoining() {
+			return
+		}
+		if c.IsJoining() && c.socket.IsConnected() && c.getJoinPush().IsSent() {
+			// The error is from an earlier connection, and the join was already sent over the current one
+			return
+		}
 		c.setState(ChannelErrored)
-		c.trigger(string(ErrorEvent), 0, nil)
+		c.trigger(string(ErrorEvent), 0, err)
 		c.rejoinTimer.Reset()
 	}))
 
@@ -96,6 +213,32 @@ func NewChannel(topic string, params map[string]string, socket *Socket) *Channel
 // Join will send a JoinEvent to the server and attempt to join the topic of this Channel.
 // A Push is returned to which you can attach event handlers to with Receive, such as "ok", "error" and "timeout".
 func (c *Channel) Join() (*Push, error) {
+	c.joinMu.Lock()
+	defer c.joinMu.Unlock()
+
+	joinPush, err := c.startJoin()
+	if err != nil {
+		return nil, err
+	}
+
+	// While the socket is still connecting, wait for it to open rather than queue the join behind the connection,
+	// where it could be sent after the rejoin that happens on open, joining the topic twice.
+	if !c.socket.IsConnected() {
+		return joinPush, nil
+	}
+
+	err = joinPush.Send()
+	if err != nil {
+		c.setState(ChannelClosed)
+		return nil, err
+	}
+
+	return joinPush, nil
+}
+
+// startJoin creates the join Push and moves the Channel to joining, without sending the join. Must be called with
+// joinMu locked.
+func (c *Channel) startJoin() (*Push, error) {
 	if c.IsRemoved() {
 		return nil, fmt.Errorf("channel removed, create a new Channel")
 	}
@@ -112,18 +255,39 @@ func (c *Channel) Join() (*Push, error) {
 	joinPush := NewPush(c, string(JoinEvent), c.params, c.PushTimeout)
 	c.setJoinPush(joinPush)
 	joinPush.Receive("ok", func(response any) {
+		if c.leftBeforeJoin() {
+			return
+		}
 		c.socket.Logger.Printf(LogInfo, "channel", "joined channel '%v' joinRef:%v", c.topic, c.JoinRef())
 		c.setState(ChannelJoined)
+		c.resetJoinErrors()
+		c.socket.recordSubscription(c, true)
+		c.callAfterJoin(Reply{Status: "ok", Response: response})
+		c.flushPushBuffer()
 		c.trigger(string(JoinEvent), 0, response)
+		c.resync()
 		c.rejoinTimer.Reset()
 	})
 	joinPush.Receive("error", func(response any) {
+		if c.leftBeforeJoin() {
+			return
+		}
 		c.socket.Logger.Printf(LogError, "channel", "error joining channel '%v': %v", c.topic, response)
-		c.setState(ChannelErrored)
 		joinPush.reset()
+		if c.incJoinErrors() {
+			c.socket.Logger.Printf(LogError, "channel", "giving up joining channel '%v' after %v errors", c.topic, c.JoinErrorBudget)
+			c.setState(ChannelClosed)
+			c.trigger(joinGiveUpEvent, 0, response)
+			c.release()
+			return
+		}
+		c.setState(ChannelErrored)
 		c.rejoinTimer.Run()
 	})
 	joinPush.Receive("timeout", func(response any) {
+		if c.leftBeforeJoin() {
+			return
+		}
 		c.socket.Logger.Printf(LogError, "channel", "timeout joining channel '%v'", c.topic)
 		joinPush.reset()
 
@@ -135,18 +299,28 @@ func (c *Channel) Join() (*Push, error) {
 		c.rejoinTimer.Run()
 	})
 
+	c.resetJoinErrors()
+	c.renewDone()
 	c.setState(ChannelJoining)
-	err := joinPush.Send()
-	if err != nil {
-		return nil, err
-	}
 
 	return joinPush, nil
 }
 
 // Leave will send a LeaveEvent to the server to leave the topic of this Channel
-// A Push is returned to which you can attach event handlers to with Receive, such as "ok", "error" and "timeout".
+// A Push is returned to which you can attach event handlers to with Receive, such as "ok", "error" and "timeout", or
+// that can be awaited until the server acknowledges the leave.
+//
+// Rejoin attempts stop right away, and a join that is still waiting for its reply is abandoned. Once the leave is
+// replied to, or times out, the Channel is closed: the OnClose callbacks are called with "leave", and the bindings
+// waiting for replies to its pushes, its limiters and its scheduled pushes are released. Bindings registered with On
+// are kept, so that the Channel can be joined again, until it is removed with Remove.
 func (c *Channel) Leave() (*Push, error) {
+	// The rejoinTimer is locked while it calls rejoin, which takes joinMu, so it must be reset before taking joinMu
+	c.rejoinTimer.Reset()
+
+	c.joinMu.Lock()
+	defer c.joinMu.Unlock()
+
 	if c.IsRemoved() {
 		return nil, fmt.Errorf("channel removed, create a new Channel")
 	}
@@ -157,21 +331,39 @@ func (c *Channel) Leave() (*Push, error) {
 		return nil, fmt.Errorf("leave already in progress")
 	}
 
-	c.rejoinTimer.Reset()
 	c.setState(ChannelLeaving)
+	if joinPush := c.getJoinPush(); joinPush != nil {
+		// The join's reply, if it comes, is ignored, so stop waiting for it
+		joinPush.mu.Lock()
+		joinPush.cancelTimeout()
+		joinPush.settle()
+		joinPush.mu.Unlock()
+	}
 
 	// Send a leave message even if we aren't connected and joined
 	leavePush := NewPush(c, string(LeaveEvent), c.params, c.PushTimeout)
 	leavePush.Receive("ok", func(response any) {
 		c.socket.Logger.Printf(LogInfo, "channel", "left channel '%v'", c.topic)
+		if c.IsJoining() || c.IsJoined() {
+			// Joined again before the leave was acknowledged, so the channel isn't closed anymore
+			return
+		}
 		c.trigger(string(CloseEvent), 0, "leave")
 	})
 	leavePush.Receive("error", func(response any) {
 		c.socket.Logger.Printf(LogError, "channel", "error leaving channel '%v': %v", c.topic, response)
+		if c.IsJoining() || c.IsJoined() {
+			return
+		}
 		c.trigger(string(CloseEvent), 0, "leave")
 	})
 	leavePush.Receive("timeout", func(response any) {
 		c.socket.Logger.Printf(LogError, "channel", "timeout leaving channel '%v'", c.topic)
+		if c.IsJoining() || c.IsJoined() {
+			return
+		}
+		// The server closes the channel anyway once the connection is gone, so don't stay leaving forever
+		c.trigger(string(CloseEvent), 0, "leave")
 	})
 
 	if c.socket.IsConnected() {
@@ -196,6 +388,7 @@ func (c *Channel) Remove() error {
 		return fmt.Errorf("must Leave channel before removing")
 	}
 	c.setState(ChannelRemoved)
+	c.release()
 	c.socket.removeChannel(c)
 	for _, ref := range c.socketCallbacks {
 		c.socket.Off(ref)
@@ -204,27 +397,105 @@ func (c *Channel) Remove() error {
 }
 
 // Push will send the given Event and Payload to the server. A Push is returned to which you can attach event handlers
-// to with Receive() so you can process replies.
+// to with Receive() so you can process replies. If the Channel is not currently joined, such as while rejoining after a
+// reconnect, the Push is buffered and sent once the Channel is joined.
 func (c *Channel) Push(event string, payload any) (*Push, error) {
+	return c.PushKeyed("", event, payload)
+}
+
+// PushKeyed is like Push, but sends the Push in order with other pushes with the same ordering key, while pushes
+// with different keys may be interleaved. See Push.OrderingKey.
+func (c *Channel) PushKeyed(key string, event string, payload any) (*Push, error) {
+	push := NewPush(c, event, payload, c.PushTimeout)
+	push.OrderingKey = key
+	return c.pushOrBuffer(push)
+}
+
+// pushOrBuffer sends the given Push if the Channel is joined, or buffers it until it is.
+func (c *Channel) pushOrBuffer(push *Push) (*Push, error) {
 	if c.IsRemoved() {
 		return nil, fmt.Errorf("channel removed, create a new Channel")
 	}
-	if c.joinPush == nil {
+	if c.getJoinPush() == nil {
 		return nil, fmt.Errorf("cannot push before calling Join")
 	}
 
-	push := NewPush(c, event, payload, c.PushTimeout)
-	err := push.Send()
-	return push, err
+	if c.canPush() {
+		err := push.Send()
+		return push, err
+	}
+
+	// Hold on to the push until we are joined, but still let it time out
+	push.startTimeout()
+	c.bufferPush(push)
+	return push, nil
+}
+
+// canPush returns true if pushes can be sent right away, otherwise they should be buffered until joined.
+func (c *Channel) canPush() bool {
+	return c.socket.IsConnected() && c.IsJoined()
+}
+
+func (c *Channel) bufferPush(push *Push) {
+	// Estimate the memory held by the push by its encoded size
+	data, err := c.socket.Serializer.encode(&Message{Topic: c.topic, Event: push.Event, Payload: push.Payload})
+	if err == nil {
+		push.bufferedSize = len(data)
+	}
+
+	c.mu.Lock()
+	c.pushBuffer = append(c.pushBuffer, push)
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.socket.pushBufferBytes, int64(push.bufferedSize))
+	c.socket.checkMemory()
+}
+
+// takePushBuffer empties the push buffer and returns what was in it.
+func (c *Channel) takePushBuffer() []*Push {
+	c.mu.Lock()
+	buffer := c.pushBuffer
+	c.pushBuffer = nil
+	c.mu.Unlock()
+
+	var size int64
+	for _, push := range buffer {
+		size += int64(push.bufferedSize)
+	}
+	atomic.AddInt64(&c.socket.pushBufferBytes, -size)
+
+	return buffer
+}
+
+// flushPushBuffer sends all pushes that were buffered while not joined.
+func (c *Channel) flushPushBuffer() {
+	buffer := c.takePushBuffer()
+
+	for _, push := range buffer {
+		// Don't send pushes that the application has already been told timed out
+		if push.IsTimedOut() {
+			c.socket.drop(DropExpired, c.topic, push.Event)
+			continue
+		}
+		err := push.Send()
+		if err != nil {
+			c.socket.Logger.Printf(LogError, "channel", "error sending buffered push '%v' on '%v': %v", push.Event, c.topic, err)
+		}
+	}
 }
 
-// On will register the given callback for all matching events received on this Channel.
+// On will register the given callback for all matching events received on this Channel. Every callback registered for
+// an event is called with each of its messages.
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (c *Channel) On(event string, callback func(payload any)) (bindingRef Ref) {
 	bindingRef = c.refGenerator.nextRef()
+	site := c.bindingSite()
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
 	c.bindings[bindingRef] = &channelBinding{
 		event:    event,
 		callback: callback,
+		site:     site,
 	}
 	return
 }
@@ -234,10 +505,30 @@ func (c *Channel) On(event string, callback func(payload any)) (bindingRef Ref)
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (c *Channel) OnRef(ref Ref, event string, callback func(payload any)) (bindingRef Ref) {
 	bindingRef = c.refGenerator.nextRef()
+	site := c.bindingSite()
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
 	c.bindings[bindingRef] = &channelBinding{
 		ref:      ref,
 		event:    event,
 		callback: callback,
+		site:     site,
+	}
+	return
+}
+
+// onInline registers the given callback for all matching events like On, but calls it in the Socket's reading
+// goroutine, so that it sees messages in the order they arrived. The callback must not block or register bindings.
+func (c *Channel) onInline(event string, callback func(payload any)) (bindingRef Ref) {
+	bindingRef = c.refGenerator.nextRef()
+	site := c.bindingSite()
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
+	c.bindings[bindingRef] = &channelBinding{
+		event:    event,
+		callback: callback,
+		site:     site,
+		inline:   true,
 	}
 	return
 }
@@ -255,29 +546,106 @@ func (c *Channel) OnClose(callback func(payload any)) (bindingRef Ref) {
 }
 
 // OnError will register the given callback for whenever this channel gets an ErrorEvent message, such as the channel
-// process crashing.
+// process crashing. When the channel errors because of the Socket instead, the payload is the cause: a DisconnectInfo
+// when the connection ended, with the close code or error it ended with, or the error of the Socket.
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (c *Channel) OnError(callback func(payload any)) (bindingRef Ref) {
 	return c.On(string(ErrorEvent), callback)
 }
 
-// Off removes the callback for the given bindingRef, as returned by On, OnRef, OnJoin, OnClose, OnError.
+// AfterJoin will register the given callback to be called whenever this Channel joins or rejoins, with the reply to
+// the join. It is called before the pushes buffered while the Channel wasn't joined are sent, so that pushes made by
+// the callback, such as per-session setup, always precede the application's traffic. Callbacks are called in the
+// order they were registered, and should not block.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (c *Channel) AfterJoin(callback func(reply Reply)) (bindingRef Ref) {
+	bindingRef = c.refGenerator.nextRef()
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
+	c.afterJoin = append(c.afterJoin, afterJoinBinding{ref: bindingRef, callback: callback})
+	return
+}
+
+// callAfterJoin calls the AfterJoin callbacks with the given reply.
+func (c *Channel) callAfterJoin(reply Reply) {
+	c.bindingsMu.RLock()
+	afterJoin := c.afterJoin
+	c.bindingsMu.RUnlock()
+
+	for _, binding := range afterJoin {
+		binding.callback(reply)
+	}
+}
+
+// OnJoinGiveUp will register the given callback for whenever this Channel gives up joining because the server replied
+// with an error to more consecutive joins than allowed by JoinErrorBudget. The callback gets the last error response.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (c *Channel) OnJoinGiveUp(callback func(payload any)) (bindingRef Ref) {
+	return c.On(joinGiveUpEvent, callback)
+}
+
+// Off removes the callback for the given bindingRef, as returned by On, OnRef, OnJoin, OnClose, OnError, OnJoinGiveUp,
+// OnDeadSubscriber, OnDecodeError, or the Ref of a Binding.
 func (c *Channel) Off(bindingRef Ref) {
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
 	delete(c.bindings, bindingRef)
+	c.timings.forget(bindingRef)
+	for i, binding := range c.afterJoin {
+		if binding.ref == bindingRef {
+			c.afterJoin = append(c.afterJoin[:i:i], c.afterJoin[i+1:]...)
+			break
+		}
+	}
 }
 
 // Clear removes all bindings for the given event
 func (c *Channel) Clear(event string) {
+	c.bindingsMu.Lock()
+	defer c.bindingsMu.Unlock()
 	for ref, binding := range c.bindings {
 		if binding.event == event {
 			delete(c.bindings, ref)
+			c.timings.forget(ref)
 		}
 	}
 }
 
+// requestResync marks this channel as needing its ResyncFunc called the next time it is joined, or calls it right away
+// if the channel is already joined.
+func (c *Channel) requestResync(downtime time.Duration) {
+	c.mu.Lock()
+	c.resyncDowntime = downtime
+	c.mu.Unlock()
+
+	if c.IsJoined() {
+		c.resync()
+	}
+}
+
+// resync calls the ResyncFunc if a resync was requested.
+func (c *Channel) resync() {
+	c.mu.Lock()
+	downtime := c.resyncDowntime
+	c.resyncDowntime = 0
+	c.mu.Unlock()
+
+	if downtime > 0 && c.ResyncFunc != nil {
+		c.socket.Logger.Printf(LogInfo, "channel", "resyncing channel '%v' after %v downtime", c.topic, downtime)
+		resyncFunc := c.ResyncFunc
+		c.socket.run(func() { resyncFunc(downtime) })
+	}
+}
+
 // rejoin is a callback for the rejoinTimer, and shouldn't be called directly. It runs in a separate goroutine.
 func (c *Channel) rejoin() {
-	if c.IsRemoved() || c.IsJoined() || c.IsJoining() || c.IsLeaving() {
+	c.joinMu.Lock()
+	defer c.joinMu.Unlock()
+
+	if c.IsRemoved() || c.IsJoined() || c.IsLeaving() {
+		return
+	}
+	if push := c.getJoinPush(); c.IsJoining() && push != nil && push.IsSent() {
 		return
 	}
 
@@ -292,45 +660,125 @@ func (c *Channel) rejoin() {
 	}
 
 	c.socket.Logger.Println(LogInfo, "channel", "attempting to rejoin channel")
+	// Mark the channel as joining right away so that a rejoin from both the timer and the socket opening can't send two
+	// joins for the same topic
+	c.setState(ChannelJoining)
 	err := push.Send()
 	if err != nil {
 		c.socket.Logger.Println(LogError, "channel", "error on rejoin push", err)
+		c.setState(ChannelErrored)
+		// The rejoinTimer is locked while it calls rejoin, so schedule the next try once it has returned
+		c.socket.run(c.rejoinTimer.Run)
 	}
 }
 
-// process messages received from Socket
-func (c *Channel) process(msg *Message) {
+// process messages received from Socket. size is the length of the encoded message. Returns true if the message was
+// for this channel, even if it was then dropped.
+func (c *Channel) process(msg *Message, size int) bool {
 	if c.IsRemoved() {
 		// this shouldn't happen, but just in case
-		return
+		return false
 	}
 
-	// We only care about messages that match our topic
-	if msg.Topic != c.topic {
-		return
+	// We only care about messages that match our topic, or our TopicMatcher
+	if !c.routesTopic(msg) {
+		return false
 	}
 
 	// Replies will have a joinRef set to the Ref we joined with. If it doesn't match, then it's an old message
 	// from a previous join, and we should discard it.
 	if msg.JoinRef != 0 && msg.JoinRef != c.JoinRef() {
 		c.socket.Logger.Println(LogWarning, "channel", "dropping stale message", msg)
-		return
+		c.socket.drop(DropStale, msg.Topic, msg.Event)
+		return true
+	}
+
+	c.stats.received(size, c.socket.Clock.Now())
+
+	decoded, err := c.unmarshalMessage(msg)
+	if err != nil {
+		c.reportDecodeError(msg.Event, msg.Payload, err)
+		c.socket.drop(DropDecodeFailure, msg.Topic, msg.Event)
+		return true
+	}
+	msg = decoded
+
+	if c.isDuplicate(msg) {
+		c.socket.Logger.Println(LogDebug, "channel", "dropping duplicate message", msg)
+		c.autoAck(*msg)
+		c.socket.drop(DropDuplicate, msg.Topic, msg.Event)
+		return true
+	}
+
+	// Pass the message through any middleware, which triggers bindings with this event
+	c.dispatch(*msg)
+	return true
+}
+
+// wantsPayload returns true if this channel would look at the given encoded payload of the given message, because it
+// has a binding for its event, or middleware or deduplication that need the payload, or the payload requests an
+// acknowledgement.
+func (c *Channel) wantsPayload(msg *Message, payload []byte) bool {
+	if c.IsRemoved() || !c.routesTopic(msg) {
+		return false
+	}
+	if c.DedupeSize > 0 || (c.AckEvent != "" && requestsAck(payload)) {
+		return true
+	}
+
+	c.mu.RLock()
+	hasMiddleware := len(c.middleware) > 0
+	c.mu.RUnlock()
+	if hasMiddleware {
+		return true
 	}
 
-	// Trigger bindings with this event
-	c.trigger(msg.Event, msg.Ref, msg.Payload)
+	c.bindingsMu.RLock()
+	defer c.bindingsMu.RUnlock()
+	for _, binding := range c.bindings {
+		if binding.event == msg.Event {
+			return true
+		}
+	}
+	return false
 }
 
 // trigger calls all bindings (callbacks) that are interested in this event. For bindings that have also given us a
 // ref, only call the callback if the ref matches. This is so that Push can process ReplyEvents that only match its
 // ref, thus are a reply to that specific Push.
-func (c *Channel) trigger(event string, ref Ref, payload any) {
+// Returns the number of bindings that were triggered.
+func (c *Channel) trigger(event string, ref Ref, payload any) int {
+	// Callbacks run in their own goroutines, so check that the join they were triggered for hasn't ended by the time
+	// they run, unless they are for the events that end it.
+	done := c.Done()
+	lifecycle := isLifecycleEvent(event)
+
 	// For a given channelBinding to get called it must match the event and either have ref == 0 or match the ref
-	for _, binding := range c.bindings {
+	triggered := 0
+	var inline []func(payload any)
+	c.bindingsMu.RLock()
+	for bindingRef, binding := range c.bindings {
 		if binding.event == event && (binding.ref == 0 || binding.ref == ref) {
-			go binding.callback(payload)
+			triggered++
+			if binding.inline {
+				inline = append(inline, binding.callback)
+				continue
+			}
+			callback := c.measure(bindingRef, event, binding.callback)
+			c.socket.run(func() {
+				if !lifecycle && isDone(done) {
+					return
+				}
+				callback(payload)
+			})
 		}
 	}
+	c.bindingsMu.RUnlock()
+
+	for _, callback := range inline {
+		callback(payload)
+	}
+	return triggered
 }
 
 func (c *Channel) setJoinPush(push *Push) {
@@ -347,23 +795,59 @@ func (c *Channel) getJoinPush() *Push {
 	return c.joinPush
 }
 
+// Stats returns a snapshot of the traffic counters for this channel.
+func (c *Channel) Stats() ChannelStats {
+	return c.stats.snapshot()
+}
+
 // Topic returns the topic for this channel
 func (c *Channel) Topic() string {
 	return c.topic
 }
 
+// RejoinAttempts returns the number of rejoins attempted since the Channel last joined or the Socket last reconnected,
+// which is the number of tries RejoinAfterFunc was last called with.
+func (c *Channel) RejoinAttempts() int {
+	return c.rejoinTimer.Tries()
+}
+
+// leftBeforeJoin returns true if the Channel was left while its join was waiting for a reply, which is then ignored.
+func (c *Channel) leftBeforeJoin() bool {
+	if c.IsLeaving() || c.IsClosed() {
+		c.socket.Logger.Printf(LogInfo, "channel", "ignoring join reply of channel '%v' that was left", c.topic)
+		return true
+	}
+	return false
+}
+
 // JoinRef returns the JoinRef for this channel, which is the Ref of the Push returned by Join
 func (c *Channel) JoinRef() Ref {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if c.joinPush != nil {
-		return c.joinPush.Ref
+		return Ref(atomic.LoadUint64((*uint64)(&c.joinPush.Ref)))
 	} else {
 		return 0
 	}
 }
 
+// incJoinErrors records a join error reply and returns true if the JoinErrorBudget is now exhausted.
+func (c *Channel) incJoinErrors() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.joinErrors++
+	return c.JoinErrorBudget > 0 && c.joinErrors >= c.JoinErrorBudget
+}
+
+func (c *Channel) resetJoinErrors() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.joinErrors = 0
+}
+
 func (c *Channel) setState(state ChannelState) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,74 @@
+package phx
+
+import (
+	"time"
+)
+
+// Clock is the source of time for the timers of a Socket and its Channels, such as push timeouts, rejoins, heartbeats,
+// reconnects and scheduled pushes, and for the times they stamp and report, such as receive times and stats. Replace
+// it with a fake clock, such as phxtest.FakeClock, to run simulations and tests in virtual time, or with a clock
+// synchronized by other means, such as PTP.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine after the duration has passed, like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// NewTimer returns a Timer that sends the time on its channel after the duration has passed, like time.NewTimer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires. It is nil for timers created with AfterFunc.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing. Returns false if it already fired or was stopped.
+	Stop() bool
+}
+
+// sleepClock waits for the given duration on the given Clock, or until done is closed. Returns false if done was
+// closed first.
+func sleepClock[T any](clock Clock, d time.Duration, done <-chan T) bool {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// realClock is the Clock using the time package.
+type realClock struct{}
+
+// NewRealClock returns the Clock that uses the time package, which is the default.
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
@@ -0,0 +1,303 @@
+// Command phxgen generates Go types and typed On/Push wrappers for the events of a Phoenix channel from a manifest
+// exported by the Phoenix application, so that client and server contracts can be kept in sync.
+//
+// The manifest is a JSON file describing each event by either a JSON schema or a sample payload, and optionally the
+// reply to a push of that event:
+//
+//	{
+//	  "events": {
+//	    "new_msg": {"sample": {"body": "hello", "user_id": 1}},
+//	    "ping":    {"schema": {"type": "object", "properties": {"at": {"type": "integer"}}},
+//	                "reply":  {"sample": {"pong": true}}}
+//	  }
+//	}
+//
+// Usage:
+//
+//	phxgen -in manifest.json -out events.go -package chat
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Manifest is the input file format.
+type Manifest struct {
+	Events map[string]EventSpec `json:"events"`
+}
+
+// EventSpec describes the payload of one event, and optionally the reply when it is pushed.
+type EventSpec struct {
+	Schema json.RawMessage `json:"schema,omitempty"`
+	Sample json.RawMessage `json:"sample,omitempty"`
+	Reply  *EventSpec      `json:"reply,omitempty"`
+}
+
+// schema is the subset of JSON schema that is understood.
+type schema struct {
+	Type       string             `json:"type"`
+	Properties map[string]*schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *schema            `json:"items"`
+}
+
+func main() {
+	in := flag.String("in", "", "manifest file to read")
+	out := flag.String("out", "", "Go file to write, defaults to stdout")
+	pkg := flag.String("package", "events", "package name of the generated file")
+	phxImport := flag.String("phx", "github.com/ongkong/phxx", "import path of the phx package")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	src, err := generateFile(*in, *pkg, *phxImport)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "phxgen:", err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(src)
+		return
+	}
+	err = os.WriteFile(*out, src, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "phxgen:", err)
+		os.Exit(1)
+	}
+}
+
+func generateFile(path string, pkg string, phxImport string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	events := make([]string, 0, len(manifest.Events))
+	replies := false
+	for event, spec := range manifest.Events {
+		events = append(events, event)
+		replies = replies || spec.Reply != nil
+	}
+
+	g := &generator{}
+	g.printf("// Code generated by phxgen from %s. DO NOT EDIT.\n\n", path)
+	g.printf("package %s\n\n", pkg)
+	if replies {
+		// The PushXCtx wrappers of events with replies take a context
+		g.printf("import (\n\"context\"\n\nphx %q\n)\n", phxImport)
+	} else {
+		g.printf("import phx %q\n", phxImport)
+	}
+	sort.Strings(events)
+
+	for _, event := range events {
+		err = g.event(event, manifest.Events[event])
+		if err != nil {
+			return nil, fmt.Errorf("event %q: %w", event, err)
+		}
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	buf bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) event(event string, spec EventSpec) error {
+	name := goName(event)
+
+	payloadType, err := g.spec(name+"Payload", spec)
+	if err != nil {
+		return err
+	}
+
+	g.printf("\n// %sEvent is the %q event.\n", name, event)
+	g.printf("const %sEvent = %q\n", name, event)
+
+	g.printf("\n// On%s registers the given callback for %q events with the payload decoded into a %s.\n", name, event, payloadType)
+	g.printf("func On%s(channel *phx.Channel, callback func(payload %s)) phx.Ref {\n", name, payloadType)
+	g.printf("return phx.NewTypedChannel[%s, %s](channel, %sEvent).OnEvent(callback)\n", payloadType, payloadType, name)
+	g.printf("}\n")
+
+	if spec.Reply == nil {
+		g.printf("\n// Push%s pushes a %q event with the given payload.\n", name, event)
+		g.printf("func Push%s(channel *phx.Channel, payload %s) (*phx.Push, error) {\n", name, payloadType)
+		g.printf("return channel.Push(%sEvent, payload)\n", name)
+		g.printf("}\n")
+		return nil
+	}
+
+	replyType, err := g.spec(name+"Reply", *spec.Reply)
+	if err != nil {
+		return fmt.Errorf("reply: %w", err)
+	}
+
+	g.printf("\n// Push%s pushes a %q event with the given payload and waits for the reply, at most for the channel's\n", name, event)
+	g.printf("// PushTimeout.\n")
+	g.printf("func Push%s(channel *phx.Channel, payload %s) (%s, error) {\n", name, payloadType, replyType)
+	g.printf("return phx.NewTypedChannel[%s, %s](channel, %sEvent).Push(payload)\n", payloadType, replyType, name)
+	g.printf("}\n")
+
+	g.printf("\n// Push%sCtx pushes a %q event with the given payload and waits for the reply or for the context to be done.\n", name, event)
+	g.printf("func Push%sCtx(ctx context.Context, channel *phx.Channel, payload %s) (%s, error) {\n", name, payloadType, replyType)
+	g.printf("return phx.NewTypedChannel[%s, %s](channel, %sEvent).PushCtx(ctx, payload)\n", payloadType, replyType, name)
+	g.printf("}\n")
+	return nil
+}
+
+// spec emits the declarations needed for the given spec and returns the Go type to use for it.
+func (g *generator) spec(name string, spec EventSpec) (string, error) {
+	switch {
+	case len(spec.Schema) > 0:
+		var s schema
+		err := json.Unmarshal(spec.Schema, &s)
+		if err != nil {
+			return "", fmt.Errorf("invalid schema: %w", err)
+		}
+		return g.schemaType(name, &s), nil
+	case len(spec.Sample) > 0:
+		decoder := json.NewDecoder(bytes.NewReader(spec.Sample))
+		decoder.UseNumber()
+		var sample any
+		err := decoder.Decode(&sample)
+		if err != nil {
+			return "", fmt.Errorf("invalid sample: %w", err)
+		}
+		return g.sampleType(name, sample), nil
+	}
+	return "any", nil
+}
+
+func (g *generator) schemaType(name string, s *schema) string {
+	switch s.Type {
+	case "object":
+		if len(s.Properties) == 0 {
+			return "map[string]any"
+		}
+		required := make(map[string]bool, len(s.Required))
+		for _, key := range s.Required {
+			required[key] = true
+		}
+		fields := make(map[string]string, len(s.Properties))
+		for key, prop := range s.Properties {
+			fields[key] = g.schemaType(name+goName(key), prop)
+		}
+		g.structType(name, fields, required)
+		return name
+	case "array":
+		if s.Items == nil {
+			return "[]any"
+		}
+		return "[]" + g.schemaType(name+"Item", s.Items)
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	}
+	return "any"
+}
+
+func (g *generator) sampleType(name string, sample any) string {
+	switch v := sample.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			return "map[string]any"
+		}
+		fields := make(map[string]string, len(v))
+		for key, value := range v {
+			fields[key] = g.sampleType(name+goName(key), value)
+		}
+		g.structType(name, fields, nil)
+		return name
+	case []any:
+		if len(v) == 0 {
+			return "[]any"
+		}
+		return "[]" + g.sampleType(name+"Item", v[0])
+	case string:
+		return "string"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "float64"
+		}
+		return "int64"
+	case bool:
+		return "bool"
+	}
+	return "any"
+}
+
+// structType emits a struct declaration with the given JSON keys and Go types. Keys not in required are omitempty,
+// unless required is nil.
+func (g *generator) structType(name string, fields map[string]string, required map[string]bool) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	g.printf("\ntype %s struct {\n", name)
+	for _, key := range keys {
+		tag := key
+		if required != nil && !required[key] {
+			tag += ",omitempty"
+		}
+		g.printf("%s %s `json:%q`\n", goName(key), fields[key], tag)
+	}
+	g.printf("}\n")
+}
+
+// goName converts an event or key such as "new_msg" or "user-id" to an exported Go identifier such as "NewMsg".
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			if upper {
+				r -= 'a' - 'A'
+			}
+			b.WriteRune(r)
+			upper = false
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9' && b.Len() > 0:
+			b.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package phx
+
+import (
+	"encoding/json"
+)
+
+// PayloadCodec converts between typed Go values and the payloads that are sent to and received from the server. It is
+// used by TypedChannel and is configured centrally with Socket.Codec.
+type PayloadCodec interface {
+	// Encode converts the given value to a payload that can be sent with the Socket's Serializer.
+	Encode(v any) (any, error)
+
+	// Decode converts the given payload, as decoded by the Socket's Serializer, into the value pointed to by v.
+	Decode(payload any, v any) error
+}
+
+// JSONCodec is a PayloadCodec that converts values using their encoding/json representation.
+type JSONCodec struct{}
+
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{}
+}
+
+func (c *JSONCodec) Encode(v any) (any, error) {
+	// The JSON serializers already know how to encode any value
+	return v, nil
+}
+
+func (c *JSONCodec) Decode(payload any, v any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
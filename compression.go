@@ -0,0 +1,164 @@
+package phx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DictionaryCompressor compresses payloads with a dictionary trained on representative payloads, such as a zstd
+// dictionary trained with `zstd --train`. Dictionaries are identified by an id that is sent along with every
+// compressed payload, so that a new dictionary can be rolled out while payloads compressed with the old one are still
+// being received. Its methods must be safe for concurrent use.
+//
+// No compression library is bundled; with github.com/klauspost/compress/zstd, for example, Compress is
+// encoder.EncodeAll(data, nil) with an Encoder created with zstd.WithEncoderDict(dict), and Decompress is
+// decoder.DecodeAll(data, nil) with a Decoder created with zstd.WithDecoderDicts of every dictionary still in use.
+type DictionaryCompressor interface {
+	// DictionaryID returns the id of the dictionary Compress uses.
+	DictionaryID() string
+
+	// Compress compresses the given data with the dictionary of DictionaryID.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompresses the given data with the dictionary of the given id, or returns an error if the
+	// dictionary is unknown.
+	Decompress(dictID string, data []byte) ([]byte, error)
+}
+
+// PayloadCompression compresses the payloads of outbound messages and decompresses those of inbound messages with a
+// DictionaryCompressor. Set it as Socket.Compression to use it. The server must compress and decompress payloads the
+// same way, so it is only useful between cooperating clients and servers that exchange highly repetitive payloads.
+//
+// A compressed payload is replaced by an envelope holding the JSON encoded payload compressed and base64 encoded:
+//
+//	{"enc": "zstd", "dict": "dictionary id", "data": "base64 compressed JSON"}
+//
+// Inbound payloads without an "enc" field of Encoding are passed on as they are, so the server may leave small payloads
+// uncompressed. Heartbeats, binary payloads and the phx_close and phx_error events generated by the server itself
+// are not compressed. For replies, the response is compressed rather than the whole payload. When a Socket also has a
+// Signer, the envelope is signed.
+type PayloadCompression struct {
+	Compressor DictionaryCompressor
+
+	// Encoding is the name of the compression sent in the "enc" field of the envelope. Defaults to "zstd".
+	Encoding string
+
+	// MinSize is the size of the JSON encoded payload below which outbound payloads are sent uncompressed, as they
+	// would gain little. Defaults to 128 bytes.
+	MinSize int
+}
+
+func NewPayloadCompression(compressor DictionaryCompressor) *PayloadCompression {
+	return &PayloadCompression{
+		Compressor: compressor,
+		Encoding:   defaultCompressionEncoding,
+		MinSize:    defaultCompressMinSize,
+	}
+}
+
+// compressedPayload is the envelope a compressed payload is sent in.
+type compressedPayload struct {
+	Encoding     string `json:"enc"`
+	DictionaryID string `json:"dict"`
+	Data         string `json:"data"`
+}
+
+// compress replaces the payload of the given message with a compressed envelope, unless it is too small.
+func (c *PayloadCompression) compress(msg *Message) error {
+	if !isCompressedMessage(msg) {
+		return nil
+	}
+
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return err
+	}
+	minSize := c.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+	if len(data) < minSize {
+		return nil
+	}
+
+	compressed, err := c.Compressor.Compress(data)
+	if err != nil {
+		return err
+	}
+	msg.Payload = compressedPayload{
+		Encoding:     c.encoding(),
+		DictionaryID: c.Compressor.DictionaryID(),
+		Data:         base64.StdEncoding.EncodeToString(compressed),
+	}
+	return nil
+}
+
+// decompress replaces the payload of the given message with its decompressed payload, if it is compressed.
+func (c *PayloadCompression) decompress(msg *Message) error {
+	if !isCompressedMessage(msg) {
+		return nil
+	}
+
+	if msg.Event == string(ReplyEvent) {
+		reply, ok := msg.Payload.(map[string]any)
+		if !ok {
+			return nil
+		}
+		response, decompressed, err := c.decompressPayload(reply["response"])
+		if err != nil || !decompressed {
+			return err
+		}
+		copied := shallowCopy(reply)
+		copied["response"] = response
+		msg.Payload = copied
+		return nil
+	}
+
+	payload, decompressed, err := c.decompressPayload(msg.Payload)
+	if err != nil || !decompressed {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+// decompressPayload returns the payload in the given envelope, and true, or false if the payload isn't an envelope.
+func (c *PayloadCompression) decompressPayload(payload any) (any, bool, error) {
+	envelope, ok := payload.(map[string]any)
+	if !ok || envelope["enc"] != c.encoding() {
+		return payload, false, nil
+	}
+	dictID, _ := envelope["dict"].(string)
+	data, ok := envelope["data"].(string)
+	if !ok {
+		return nil, false, errors.New("compressed payload has no data")
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, false, err
+	}
+	decompressed, err := c.Compressor.Decompress(dictID, compressed)
+	if err != nil {
+		return nil, false, fmt.Errorf("decompressing with dictionary '%v': %w", dictID, err)
+	}
+	decoded, err := decodeJSONPayload(decompressed)
+	if err != nil {
+		return nil, false, err
+	}
+	return decoded, true, nil
+}
+
+func (c *PayloadCompression) encoding() string {
+	if c.Encoding == "" {
+		return defaultCompressionEncoding
+	}
+	return c.Encoding
+}
+
+// isCompressedMessage returns false for the messages that are never compressed.
+func isCompressedMessage(msg *Message) bool {
+	return msg.Topic != "phoenix" && msg.Event != string(CloseEvent) && msg.Event != string(ErrorEvent)
+}
@@ -0,0 +1,102 @@
+package phx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// The classes of failed connection attempts. The errors of connection attempts passed to OnError callbacks, and
+// returned by ConnectWithin, match one of them with errors.Is when their cause is known, while still wrapping the
+// error of the Transport, such as a *HandshakeError, so that applications can decide between retrying and refreshing
+// credentials.
+var (
+	// ErrUnauthorized is a connection refused by the server with 401 Unauthorized or 403 Forbidden, such as for
+	// missing or expired credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrTLS is a failed TLS handshake, such as for an untrusted certificate or a server that doesn't speak TLS.
+	ErrTLS = errors.New("tls handshake failed")
+
+	// ErrDNS is a failed lookup of the server's host name.
+	ErrDNS = errors.New("dns lookup failed")
+
+	// ErrConnectionRefused is a connection refused by the server's host, such as when nothing listens on the port.
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrTimeout is a connection attempt that didn't complete within the Socket's ConnectTimeout.
+	ErrTimeout = errors.New("connection attempt timed out")
+)
+
+// classifiedError is an error of a connection attempt that matches the class of its cause with errors.Is.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// Is matches ErrUnauthorized for a 401 Unauthorized or 403 Forbidden response.
+func (e *HandshakeError) Is(target error) bool {
+	return target == ErrUnauthorized && isUnauthorized(e.StatusCode)
+}
+
+// classifyConnectError returns the given error of a connection attempt wrapped to match its class, if it is known and
+// the error doesn't match it already.
+func classifyConnectError(err error) error {
+	class := connectErrorClass(err)
+	if class == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// connectErrorClass returns the class of the given error of a connection attempt, or nil if it is unknown.
+func connectErrorClass(err error) error {
+	var handshakeErr *HandshakeError
+	if errors.As(err, &handshakeErr) {
+		if isUnauthorized(handshakeErr.StatusCode) {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalidCertificate x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrDNS
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname), errors.As(err, &invalidCertificate),
+		errors.As(err, &recordHeader), strings.Contains(err.Error(), "tls: "):
+		// TLS alerts are unexported, so are only told apart by their message
+		return ErrTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnectionRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	}
+	return nil
+}
+
+func isUnauthorized(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
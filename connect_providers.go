@@ -0,0 +1,246 @@
+package phx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MergeMode determines how the values from a params or header provider are merged with the values already set by the
+// EndPoint, RequestHeader and earlier providers.
+type MergeMode int
+
+const (
+	// MergeReplace replaces all earlier values of every key the provider sets.
+	MergeReplace MergeMode = iota
+
+	// MergeAppend adds the provider's values after the earlier values of the same key.
+	MergeAppend
+
+	// MergeDefault only sets the keys that no earlier source has set.
+	MergeDefault
+)
+
+func (m MergeMode) String() string {
+	switch m {
+	case MergeReplace:
+		return "replace"
+	case MergeAppend:
+		return "append"
+	case MergeDefault:
+		return "default"
+	}
+	return "unknown"
+}
+
+type paramsProvider struct {
+	ref      Ref
+	mode     MergeMode
+	provider func() (url.Values, error)
+}
+
+type headerProvider struct {
+	ref      Ref
+	mode     MergeMode
+	provider func() (http.Header, error)
+}
+
+// AddParamsProvider registers the given provider of params for the query of the endpoint. Providers are called before
+// every connection attempt, including reconnections, so they can supply short-lived values such as tokens.
+//
+// The query starts with the params of EndPoint, and then the values of each provider are merged in the order the
+// providers were added, according to the given mode. A later provider therefore takes precedence over an earlier one
+// with MergeReplace, and yields to it with MergeDefault. If a provider returns an error, the connection attempt fails
+// with it and is retried like any other.
+// Returns a unique Ref that can be used to remove this provider via Off.
+func (s *Socket) AddParamsProvider(mode MergeMode, provider func() (url.Values, error)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.paramsProviders = append(s.paramsProviders, paramsProvider{ref: ref, mode: mode, provider: provider})
+	s.mu.Unlock()
+	return ref
+}
+
+// AddHeaderProvider registers the given provider of headers for the connection request. The headers start with
+// RequestHeader, and are otherwise merged like the params of AddParamsProvider. Header names are canonicalized.
+// Returns a unique Ref that can be used to remove this provider via Off.
+func (s *Socket) AddHeaderProvider(mode MergeMode, provider func() (http.Header, error)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.headerProviders = append(s.headerProviders, headerProvider{ref: ref, mode: mode, provider: provider})
+	s.mu.Unlock()
+	return ref
+}
+
+type beforeConnectHook struct {
+	ref  Ref
+	hook func(ctx context.Context) error
+}
+
+// BeforeConnect registers the given hook to be called before every connection attempt, including reconnections and
+// before the params and header providers, so that the attempt can be delayed or vetoed, such as until a captive portal
+// is cleared or a connectivity lease is acquired from the application's network manager. Hooks are called in the
+// order they were registered with a context that is canceled after ConnectTimeout. If a hook returns an error, the
+// attempt fails with it and is retried like any other.
+// Returns a unique Ref that can be used to remove this hook via Off.
+func (s *Socket) BeforeConnect(hook func(ctx context.Context) error) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.beforeConnectHooks = append(s.beforeConnectHooks, beforeConnectHook{ref: ref, hook: hook})
+	s.mu.Unlock()
+	return ref
+}
+
+type connectTargetHook struct {
+	ref  Ref
+	hook func() (url.Values, http.Header, error)
+}
+
+// OnBeforeConnect registers the given hook to be called before every connection attempt, including reconnections, to
+// return params for the query of the endpoint and headers for the connection request, such as a freshly issued token
+// when the one the Socket was created with expires. They are merged after those of the params and header providers,
+// replacing the earlier values of every key they set. If the hook returns an error, the attempt fails with it and is
+// retried like any other.
+// Returns a unique Ref that can be used to remove this hook via Off.
+func (s *Socket) OnBeforeConnect(hook func() (url.Values, http.Header, error)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.connectTargetHooks = append(s.connectTargetHooks, connectTargetHook{ref: ref, hook: hook})
+	s.mu.Unlock()
+	return ref
+}
+
+// callBeforeConnect calls the BeforeConnect hooks, and returns the error of the first one that fails.
+func (s *Socket) callBeforeConnect(hooks []beforeConnectHook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ConnectTimeout)
+	defer cancel()
+
+	for _, h := range hooks {
+		err := h.hook(ctx)
+		if err != nil {
+			return fmt.Errorf("before connect hook: %w", err)
+		}
+	}
+	return nil
+}
+
+// offProviders removes the params or header provider, or the BeforeConnect or OnBeforeConnect hook, with the given
+// ref. Must be called with mu locked.
+func (s *Socket) offProviders(ref Ref) {
+	for i, p := range s.paramsProviders {
+		if p.ref == ref {
+			s.paramsProviders = append(s.paramsProviders[:i:i], s.paramsProviders[i+1:]...)
+			return
+		}
+	}
+	for i, p := range s.headerProviders {
+		if p.ref == ref {
+			s.headerProviders = append(s.headerProviders[:i:i], s.headerProviders[i+1:]...)
+			return
+		}
+	}
+	for i, h := range s.beforeConnectHooks {
+		if h.ref == ref {
+			s.beforeConnectHooks = append(s.beforeConnectHooks[:i:i], s.beforeConnectHooks[i+1:]...)
+			return
+		}
+	}
+	for i, h := range s.connectTargetHooks {
+		if h.ref == ref {
+			s.connectTargetHooks = append(s.connectTargetHooks[:i:i], s.connectTargetHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// implements TransportHandler
+
+// connectTarget calls the BeforeConnect hooks, and returns the endpoint and headers to connect with, after merging in
+// the values of the providers and the OnBeforeConnect hooks.
+func (s *Socket) connectTarget(endPoint *url.URL, requestHeader http.Header) (*url.URL, http.Header, error) {
+	s.startConnectSpan(endPoint.Redacted())
+
+	s.mu.RLock()
+	beforeConnectHooks := s.beforeConnectHooks
+	paramsProviders := s.paramsProviders
+	headerProviders := s.headerProviders
+	connectTargetHooks := s.connectTargetHooks
+	s.mu.RUnlock()
+
+	err := s.callBeforeConnect(beforeConnectHooks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(paramsProviders) == 0 && len(headerProviders) == 0 && len(connectTargetHooks) == 0 && s.Credentials == nil {
+		return endPoint, requestHeader, nil
+	}
+
+	target := *endPoint
+	query := target.Query()
+	for _, p := range paramsProviders {
+		values, err := p.provider()
+		if err != nil {
+			return nil, nil, fmt.Errorf("params provider: %w", err)
+		}
+		mergeValues(query, values, p.mode)
+	}
+
+	header := requestHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	for _, p := range headerProviders {
+		values, err := p.provider()
+		if err != nil {
+			return nil, nil, fmt.Errorf("header provider: %w", err)
+		}
+		mergeValues(header, canonicalHeader(values), p.mode)
+	}
+
+	for _, h := range connectTargetHooks {
+		params, headers, err := h.hook()
+		if err != nil {
+			return nil, nil, fmt.Errorf("before connect hook: %w", err)
+		}
+		mergeValues(query, params, MergeReplace)
+		mergeValues(header, canonicalHeader(headers), MergeReplace)
+	}
+	target.RawQuery = query.Encode()
+
+	err = s.applyCredentials(&target, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &target, header, nil
+}
+
+// canonicalHeader returns a copy of the given header with canonicalized names.
+func canonicalHeader(header http.Header) http.Header {
+	canonical := make(http.Header, len(header))
+	for key, values := range header {
+		canonical[http.CanonicalHeaderKey(key)] = append(canonical[http.CanonicalHeaderKey(key)], values...)
+	}
+	return canonical
+}
+
+// mergeValues merges src into dst according to the given mode.
+func mergeValues(dst map[string][]string, src map[string][]string, mode MergeMode) {
+	for key, values := range src {
+		switch mode {
+		case MergeReplace:
+			dst[key] = append([]string(nil), values...)
+		case MergeAppend:
+			dst[key] = append(dst[key], values...)
+		case MergeDefault:
+			if _, ok := dst[key]; !ok {
+				dst[key] = append([]string(nil), values...)
+			}
+		}
+	}
+}
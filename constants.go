@@ -14,30 +14,79 @@ const (
 	// defaultHeartbeatInterval is the default time between heartbeats
 	defaultHeartbeatInterval = 30 * time.Second
 
-	// busyWait is the time for goroutines to sleep while waiting. Lower = more CPU. Higher = less responsive
-	busyWait = 100 * time.Millisecond
+	// defaultReconnectStableAfter is the default time a connection must stay open before reconnect attempts are reset
+	defaultReconnectStableAfter = 5 * time.Second
 
-	// messageQueueLength is the number of messages to queue when not connected before blocking
-	messageQueueLength = 1000
-)
+	// defaultReplyCacheTTL is the default time the reply to a Push is remembered for handlers attached late
+	defaultReplyCacheTTL = time.Minute
 
-func defaultReconnectAfterFunc(tries int) time.Duration {
-	schedule := []time.Duration{10, 50, 100, 150, 200, 250, 500, 1000, 2000}
-	if tries >= 1 && tries-1 < len(schedule) {
-		return schedule[tries-1] * time.Millisecond
-	} else {
-		return 5000 * time.Millisecond
-	}
-}
+	// fileLockTimeout is the time a FileSessionStore waits for the lock file of another process
+	fileLockTimeout = 10 * time.Second
 
-func defaultRejoinAfterFunc(tries int) time.Duration {
-	schedule := []time.Duration{1000, 2000, 5000}
-	if tries >= 1 && tries-1 < len(schedule) {
-		return schedule[tries-1] * time.Millisecond
-	} else {
-		return 10000 * time.Millisecond
-	}
-}
+	// fileLockStale is the age after which a lock file is assumed to be left over by a process that died
+	fileLockStale = 30 * time.Second
+
+	// fileLockRetry is the time between attempts to take a lock file
+	fileLockRetry = 10 * time.Millisecond
+
+	// defaultSignatureMaxAge is the default time a signed message can be verified after it was signed
+	defaultSignatureMaxAge = 5 * time.Minute
+
+	// defaultBeforeDisconnectTimeout is the default time the BeforeDisconnect hooks may take
+	defaultBeforeDisconnectTimeout = 5 * time.Second
+
+	// defaultLongPollTimeout is the default time a LongPoll poll may take
+	defaultLongPollTimeout = 20 * time.Second
+
+	// defaultDeadSubscriberAfter is the default time a Subscription's buffer may stay full before it is canceled
+	defaultDeadSubscriberAfter = time.Minute
+
+	// defaultSSEBufferSize and defaultSSEKeepAlive are the default buffer size per client and keep-alive interval of
+	// an SSEHandler
+	defaultSSEBufferSize = 64
+	defaultSSEKeepAlive  = 15 * time.Second
+
+	// defaultInboundQueueSize is the default number of received messages waiting to be dispatched with
+	// FeatureInboundQueue
+	defaultInboundQueueSize = 1000
+
+	// estimatedCallbackSize is the size MemoryUsage counts for each callback queued when ManualDispatch is set
+	estimatedCallbackSize = 256
+
+	// flushPollInterval is how often DisconnectGracefully checks whether everything in flight was sent
+	flushPollInterval = 10 * time.Millisecond
+
+	// defaultExportSubject and defaultExportQueueSize are the default subject template and queue size of an Exporter
+	defaultExportSubject   = "{{.Topic}}.{{.Event}}"
+	defaultExportQueueSize = 1000
+
+	// defaultCredentialsParam, defaultCredentialsHeader and defaultAuthEvent are the default names Credentials are
+	// sent under in the query, the headers and the first message
+	defaultCredentialsParam  = "token"
+	defaultCredentialsHeader = "Authorization"
+	defaultAuthEvent         = "phx_auth"
+
+	// defaultCompressionEncoding and defaultCompressMinSize are the default encoding and minimum size of payloads
+	// compressed by PayloadCompression
+	defaultCompressionEncoding = "zstd"
+	defaultCompressMinSize     = 128
+
+	// defaultTraceMaxEvents is the default number of events a TraceRecorder keeps
+	defaultTraceMaxEvents = 100000
+
+	// defaultAckEvent is the default event sent to acknowledge messages that request it
+	defaultAckEvent = "ack"
+
+	// defaultSendQueueSize is the default number of messages to queue when not connected before applying the
+	// SendQueuePolicy
+	defaultSendQueueSize = 1000
+
+	// priorityQueueLength is the number of priority messages, such as heartbeats, to queue before blocking
+	priorityQueueLength = 10
+
+	// longPollBatchSize is the most messages LongPoll sends in one request
+	longPollBatchSize = 100
+)
 
 type ConnectionState int
 
@@ -46,6 +95,9 @@ const (
 	ConnectionOpen
 	ConnectionClosing
 	ConnectionClosed
+
+	// ConnectionReconnecting is a connection that was lost or failed, and is being retried.
+	ConnectionReconnecting
 )
 
 func (s ConnectionState) String() string {
@@ -58,6 +110,35 @@ func (s ConnectionState) String() string {
 		return "closing"
 	case ConnectionClosed:
 		return "closed"
+	case ConnectionReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// HeartbeatTransferMode determines how heartbeats are sent while a large transfer is in progress.
+// See Socket.BeginTransfer.
+type HeartbeatTransferMode int
+
+const (
+	// HeartbeatQueued sends heartbeats normally, queued behind any messages already waiting to be sent.
+	HeartbeatQueued HeartbeatTransferMode = iota
+
+	// HeartbeatPrioritize sends heartbeats ahead of any queued messages, if the Transport is a PrioritySender.
+	HeartbeatPrioritize
+
+	// HeartbeatSuppress does not send heartbeats at all, relying on the transfer itself to keep the connection alive.
+	HeartbeatSuppress
+)
+
+func (m HeartbeatTransferMode) String() string {
+	switch m {
+	case HeartbeatQueued:
+		return "queued"
+	case HeartbeatPrioritize:
+		return "prioritize"
+	case HeartbeatSuppress:
+		return "suppress"
 	}
 	return "unknown"
 }
@@ -0,0 +1,137 @@
+package phx
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConnectCtx connects like Connect, and then blocks until the connection opens, including any retries, or the context
+// is done. If the context is done first, the Socket stops trying to connect and an error wrapping the context's error
+// is returned, along with the error of the last attempt.
+func (s *Socket) ConnectCtx(ctx context.Context) error {
+	err := s.connectAndWait(func(opened <-chan struct{}) bool {
+		select {
+		case <-opened:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("could not connect: %w (%v)", ctx.Err(), err)
+	}
+	return err
+}
+
+// JoinCtx joins like Join, and then blocks until the server replies to the join or the context is done. An "ok" reply
+// returns its response. An "error" reply is returned as a *ReplyError, and no reply before the Channel's PushTimeout
+// results in ErrPushTimeout, in both cases while the Channel keeps trying to rejoin like it does after Join. If the
+// context is done first, the Channel is left, so that it stops trying, and the context's error is returned.
+func (c *Channel) JoinCtx(ctx context.Context) (response any, err error) {
+	push, err := c.Join()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := awaitReply(ctx, push)
+	if ctx.Err() != nil && err == ctx.Err() {
+		_, _ = c.Leave()
+	}
+	return reply.Response, err
+}
+
+// LeaveCtx leaves like Leave, and then blocks until the server replies to the leave or the context is done. The
+// Channel closes either way; the reply only tells whether the server acknowledged it. An "error" reply is returned as
+// a *ReplyError, no reply before the Channel's PushTimeout results in ErrPushTimeout, and if the context is done first
+// the context's error is returned.
+func (c *Channel) LeaveCtx(ctx context.Context) error {
+	push, err := c.Leave()
+	if err != nil {
+		return err
+	}
+	if !push.IsSent() {
+		// Not connected, so the Channel was closed without a server to reply
+		return nil
+	}
+
+	_, err = awaitReply(ctx, push)
+	return err
+}
+
+// SendCtx sends like Send, and then blocks until the server replies or the context is done. An "ok" reply returns its
+// response. An "error" reply is returned as a *ReplyError, and no reply before the Push's Timeout results in
+// ErrPushTimeout. If the context is done first, the Push stops waiting for its reply, so that neither the reply nor a
+// timeout is reported to its Receive callbacks, and the context's error is returned.
+func (p *Push) SendCtx(ctx context.Context) (response any, err error) {
+	err = p.Send()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := awaitReply(ctx, p)
+	if ctx.Err() != nil && err == ctx.Err() {
+		p.reset()
+	}
+	return reply.Response, err
+}
+
+// AwaitCtx blocks until the server replies to the Push or the context is done, like Await. If the context is done
+// first, the context's error is returned, and the Push keeps waiting for its reply for its Receive callbacks.
+func (p *Push) AwaitCtx(ctx context.Context) (Reply, error) {
+	return awaitReply(ctx, p)
+}
+
+// timeoutContext returns a context that is canceled after the given timeout on the Socket's Clock, or after its
+// PushTimeout if the timeout is zero, for waiting like Push.Await.
+func (s *Socket) timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = s.PushTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := s.Clock.AfterFunc(timeout, cancel)
+	return ctx, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// awaitReply waits for the first "ok", "error" or "timeout" reply to the given Push, or for the context to be done.
+func awaitReply(ctx context.Context, push *Push) (Reply, error) {
+	return listenReply(push)(ctx)
+}
+
+// listenReply registers callbacks for the first "ok", "error" or "timeout" reply to the given Push, and returns a
+// function that waits for it like awaitReply. It can be called before sending the Push, so that a quick reply can't
+// be missed.
+func listenReply(push *Push) func(ctx context.Context) (Reply, error) {
+	type result struct {
+		reply Reply
+		err   error
+	}
+	done := make(chan result, 1)
+	finish := func(r result) {
+		select {
+		case done <- r:
+		default:
+		}
+	}
+	push.Receive("ok", func(response any) {
+		finish(result{reply: Reply{Status: "ok", Response: response}})
+	})
+	push.Receive("error", func(response any) {
+		finish(result{reply: Reply{Status: "error", Response: response}, err: &ReplyError{Response: response}})
+	})
+	push.Receive("timeout", func(response any) {
+		finish(result{err: push.timeoutErr()})
+	})
+
+	return func(ctx context.Context) (Reply, error) {
+		select {
+		case r := <-done:
+			return r.reply, r.err
+		case <-ctx.Done():
+			return Reply{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package phx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// CredentialPlacement is where a Socket sends its Credentials to the server.
+type CredentialPlacement int
+
+const (
+	// CredentialsInQuery sends the token as a param in the query of the endpoint, which is what Phoenix sockets
+	// usually expect, but which some load balancers and proxies log.
+	CredentialsInQuery CredentialPlacement = iota
+
+	// CredentialsInHeader sends the token as a header of the connection request.
+	CredentialsInHeader
+
+	// CredentialsInFirstMessage sends the token in the first message of every connection, on the "phoenix" topic, as
+	// {"token": token}. Channels don't join until the server replies "ok" to it, and a connection whose
+	// authentication fails or times out is closed and retried. This isn't part of the Phoenix protocol, so servers
+	// need a plugin that handles it.
+	CredentialsInFirstMessage
+)
+
+func (p CredentialPlacement) String() string {
+	switch p {
+	case CredentialsInQuery:
+		return "query"
+	case CredentialsInHeader:
+		return "header"
+	case CredentialsInFirstMessage:
+		return "first_message"
+	}
+	return "unknown"
+}
+
+// Credentials are the token a Socket authenticates with, and where it is sent. See Socket.Credentials.
+type Credentials struct {
+	// Placement is where the token is sent.
+	Placement CredentialPlacement
+
+	// Name is the query param or header the token is sent in, or the event of the authentication message. Defaults
+	// to "token", "Authorization" and "phx_auth" respectively. A header is sent with the token as is, so include any
+	// scheme, such as "Bearer ", in the token.
+	Name string
+
+	// Token returns the token. It is called before every connection attempt, or once every connection opens for
+	// CredentialsInFirstMessage, so it can supply short-lived tokens. If it returns an error, the attempt fails with
+	// it and is retried like any other.
+	Token func() (string, error)
+}
+
+// name returns the Name of the credentials, or the default for their Placement.
+func (c *Credentials) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	switch c.Placement {
+	case CredentialsInHeader:
+		return defaultCredentialsHeader
+	case CredentialsInFirstMessage:
+		return defaultAuthEvent
+	}
+	return defaultCredentialsParam
+}
+
+// applyCredentials sets the token of the Credentials in the given endpoint or headers, if they are placed there.
+func (s *Socket) applyCredentials(endPoint *url.URL, header http.Header) error {
+	creds := s.Credentials
+	if creds == nil || creds.Placement == CredentialsInFirstMessage {
+		return nil
+	}
+	token, err := creds.Token()
+	if err != nil {
+		return fmt.Errorf("credentials: %w", err)
+	}
+
+	switch creds.Placement {
+	case CredentialsInQuery:
+		query := endPoint.Query()
+		query.Set(creds.name(), token)
+		endPoint.RawQuery = query.Encode()
+	case CredentialsInHeader:
+		header.Set(creds.name(), token)
+	}
+	return nil
+}
+
+// authenticatesFirst returns true if the Credentials are sent in the first message of every connection.
+func (s *Socket) authenticatesFirst() bool {
+	return s.Credentials != nil && s.Credentials.Placement == CredentialsInFirstMessage
+}
+
+// authenticate sends the authentication message of the Credentials on a connection that just opened, and calls opened
+// once the server accepts it. Until then, the Socket doesn't report itself as connected, so that Channels wait to
+// join. If the authentication fails, the connection is closed and retried.
+func (s *Socket) authenticate(opened func()) {
+	s.mu.Lock()
+	s.authenticating = true
+	s.authAttempt++
+	attempt := s.authAttempt
+	s.mu.Unlock()
+
+	var once sync.Once
+	finish := func(err error) {
+		once.Do(func() {
+			s.mu.Lock()
+			current := s.authenticating && s.authAttempt == attempt
+			if current {
+				s.authenticating = false
+			}
+			s.mu.Unlock()
+			if !current {
+				// The connection closed while waiting for the reply
+				return
+			}
+
+			if err != nil {
+				s.Logger.Printf(LogError, "socket", "authentication failed: %v", err)
+				s.noteLastError(err)
+				s.callErrorCallbacks(err)
+				_ = s.Transport.Reconnect()
+				return
+			}
+			opened()
+		})
+	}
+
+	token, err := s.Credentials.Token()
+	if err != nil {
+		finish(fmt.Errorf("credentials: %w", err))
+		return
+	}
+
+	ref := s.MakeRef()
+	s.onControlReply(ref, s.PushTimeout, func(payload any) {
+		reply, _ := payload.(map[string]any)
+		if status, _ := reply["status"].(string); status != "ok" {
+			finish(&ReplyError{Response: reply["response"]})
+			return
+		}
+		finish(nil)
+	})
+	s.Clock.AfterFunc(s.PushTimeout, func() {
+		finish(errors.New("timeout waiting for authentication reply"))
+	})
+
+	_, err = s.pushMessage(Message{Topic: "phoenix", Event: s.Credentials.name(), Payload: map[string]any{"token": token}, Ref: ref}, true)
+	if err != nil {
+		finish(fmt.Errorf("sending authentication: %w", err))
+	}
+}
+
+// isAuthenticating returns true while waiting for the server to accept the authentication message.
+func (s *Socket) isAuthenticating() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authenticating
+}
+
+// forgetAuthentication stops waiting for the reply to the authentication message when the connection closes.
+func (s *Socket) forgetAuthentication() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authenticating = false
+}
@@ -0,0 +1,62 @@
+package phx
+
+import (
+	"container/list"
+	"sync"
+)
+
+// msgIDKey is the key in a payload that conventionally holds a unique id for the message
+const msgIDKey = "msg_id"
+
+// dedupeCache is a bounded LRU of recently seen message ids.
+type dedupeCache struct {
+	mu    sync.Mutex
+	order *list.List
+	seen  map[any]*list.Element
+}
+
+func newDedupeCache() *dedupeCache {
+	return &dedupeCache{
+		order: list.New(),
+		seen:  make(map[any]*list.Element),
+	}
+}
+
+// isDuplicate records the given id and returns true if it was already seen. Only size ids are remembered.
+func (d *dedupeCache) isDuplicate(id any, size int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.seen[id]; ok {
+		d.order.MoveToFront(elem)
+		return true
+	}
+
+	d.seen[id] = d.order.PushFront(id)
+	for d.order.Len() > size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.seen, oldest.Value)
+	}
+	return false
+}
+
+// isDuplicate returns true if DedupeSize is set and the given message carries a "msg_id" that was recently seen.
+func (c *Channel) isDuplicate(msg *Message) bool {
+	if c.DedupeSize <= 0 || msg.Event == string(ReplyEvent) {
+		return false
+	}
+
+	m, ok := msg.Payload.(map[string]any)
+	if !ok {
+		return false
+	}
+	id := m[msgIDKey]
+	switch id.(type) {
+	case string, float64:
+	default:
+		return false
+	}
+
+	return c.dedupe.isDuplicate(id, c.DedupeSize)
+}
@@ -0,0 +1,171 @@
+package phx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// slowConsumerAlpha is the weight of the latest sample in the moving average of handler residence times.
+const slowConsumerAlpha = 0.2
+
+// HandlerTiming describes how quickly a handler registered with Channel.On consumes the messages delivered to it, as
+// returned by Socket.HandlerTimings and passed to Socket.OnSlowConsumer callbacks.
+type HandlerTiming struct {
+	// Topic is the topic of the Channel the handler is bound on.
+	Topic string
+
+	// Event is the event the handler is bound to.
+	Event string
+
+	// Binding is the Ref returned when the handler was registered.
+	Binding Ref
+
+	// Calls is the number of messages the handler has finished handling.
+	Calls uint64
+
+	// InFlight is the number of messages the handler is currently handling. Every message is handled in its own
+	// goroutine, so a handler that is slower than the rate of messages accumulates calls in flight.
+	InFlight int
+
+	// AvgResidence is the moving average of the time from a message being dispatched until its handler returned.
+	AvgResidence time.Duration
+
+	// MaxResidence is the longest time from a message being dispatched until its handler returned.
+	MaxResidence time.Duration
+}
+
+// handlerTimings measures the handlers of a Channel.
+type handlerTimings struct {
+	mu       sync.Mutex
+	timings  map[Ref]*HandlerTiming
+	reported map[Ref]time.Time
+}
+
+// OnSlowConsumer registers the given callback to be called when the average residence time of a handler exceeds
+// SlowConsumerThreshold, naming the handler so that the bottleneck can be found. It is called at most once per
+// SlowConsumerThreshold for each handler, however many messages are received.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnSlowConsumer(callback func(HandlerTiming)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.slowConsumerCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// HandlerTimings returns the timings of every measured handler, slowest first. Handlers are only measured while
+// SlowConsumerThreshold is set.
+func (s *Socket) HandlerTimings() []HandlerTiming {
+	var timings []HandlerTiming
+	for _, channel := range s.channelList() {
+		timings = append(timings, channel.timings.snapshot()...)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		return timings[i].AvgResidence > timings[j].AvgResidence
+	})
+	return timings
+}
+
+// measure wraps the callback of the given binding so that its residence time is recorded, if the Socket measures
+// handlers.
+func (c *Channel) measure(bindingRef Ref, event string, callback func(payload any)) func(payload any) {
+	threshold := c.socket.SlowConsumerThreshold
+	if threshold <= 0 {
+		return callback
+	}
+
+	clock := c.socket.Clock
+	dispatched := clock.Now()
+	c.timings.start(c.topic, event, bindingRef)
+	return func(payload any) {
+		defer func() {
+			now := clock.Now()
+			timing, slow := c.timings.finish(bindingRef, now, now.Sub(dispatched), threshold)
+			if slow {
+				c.socket.reportSlowConsumer(timing)
+			}
+		}()
+		callback(payload)
+	}
+}
+
+func (t *handlerTimings) start(topic string, event string, bindingRef Ref) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timings == nil {
+		t.timings = make(map[Ref]*HandlerTiming)
+		t.reported = make(map[Ref]time.Time)
+	}
+	timing, ok := t.timings[bindingRef]
+	if !ok {
+		timing = &HandlerTiming{Topic: topic, Event: event, Binding: bindingRef}
+		t.timings[bindingRef] = timing
+	}
+	timing.InFlight++
+}
+
+// finish records a handled message and returns true if the handler should be reported as a slow consumer.
+func (t *handlerTimings) finish(bindingRef Ref, now time.Time, residence time.Duration, threshold time.Duration) (HandlerTiming, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timing, ok := t.timings[bindingRef]
+	if !ok {
+		// the binding was removed while the handler was running
+		return HandlerTiming{}, false
+	}
+
+	timing.InFlight--
+	timing.Calls++
+	if timing.Calls == 1 {
+		timing.AvgResidence = residence
+	} else {
+		timing.AvgResidence += time.Duration(slowConsumerAlpha * float64(residence-timing.AvgResidence))
+	}
+	if residence > timing.MaxResidence {
+		timing.MaxResidence = residence
+	}
+
+	if timing.AvgResidence <= threshold {
+		return HandlerTiming{}, false
+	}
+	if now.Sub(t.reported[bindingRef]) < threshold {
+		return HandlerTiming{}, false
+	}
+	t.reported[bindingRef] = now
+	return *timing, true
+}
+
+func (t *handlerTimings) forget(bindingRef Ref) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.timings, bindingRef)
+	delete(t.reported, bindingRef)
+}
+
+func (t *handlerTimings) snapshot() []HandlerTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := make([]HandlerTiming, 0, len(t.timings))
+	for _, timing := range t.timings {
+		timings = append(timings, *timing)
+	}
+	return timings
+}
+
+func (s *Socket) reportSlowConsumer(timing HandlerTiming) {
+	s.Logger.Printf(LogWarning, "socket",
+		"slow consumer: topic=%v event=%v binding=%v avg_residence=%v max_residence=%v in_flight=%v calls=%v",
+		timing.Topic, timing.Event, timing.Binding, timing.AvgResidence, timing.MaxResidence, timing.InFlight, timing.Calls)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cb := range s.slowConsumerCallbacks {
+		cb := cb
+		s.run(func() { cb(timing) })
+	}
+}
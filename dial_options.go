@@ -0,0 +1,54 @@
+package phx
+
+import (
+	"context"
+	"errors"
+)
+
+// DialOptions are what a Websocket asks its Dialer to negotiate with the server, given to Dialer.Dial in its context.
+// Custom Dialers should apply them if possible. See DialOptionsFromContext.
+type DialOptions struct {
+	// EnableCompression asks the server for permessage-deflate compression of frames in both directions.
+	EnableCompression bool
+
+	// Subprotocols are the websocket subprotocols to offer the server, in order of preference.
+	Subprotocols []string
+}
+
+type dialOptionsKey struct{}
+
+// withDialOptions returns a copy of the given context carrying the given DialOptions.
+func withDialOptions(ctx context.Context, options DialOptions) context.Context {
+	return context.WithValue(ctx, dialOptionsKey{}, options)
+}
+
+// DialOptionsFromContext returns the DialOptions in the context given to Dialer.Dial, or the zero DialOptions if there
+// are none.
+func DialOptionsFromContext(ctx context.Context) DialOptions {
+	options, _ := ctx.Value(dialOptionsKey{}).(DialOptions)
+	return options
+}
+
+// SetCompression enables or disables permessage-deflate compression on the Websocket Transport, which the server
+// must also enable, such as with "compress: true" in the websocket options of its endpoint. Large payloads, such as
+// broadcasts of lists, typically shrink several times, at the cost of CPU on both ends. Set it before connecting.
+// Returns an error if the Transport isn't a Websocket.
+func (s *Socket) SetCompression(enabled bool) error {
+	ws, ok := s.Transport.(*Websocket)
+	if !ok {
+		return errors.New("compression requires the Websocket transport")
+	}
+	ws.EnableCompression = enabled
+	return nil
+}
+
+// SetSubprotocols sets the websocket subprotocols that the Websocket Transport offers the server, in order of
+// preference. Set it before connecting. Returns an error if the Transport isn't a Websocket.
+func (s *Socket) SetSubprotocols(subprotocols ...string) error {
+	ws, ok := s.Transport.(*Websocket)
+	if !ok {
+		return errors.New("subprotocols require the Websocket transport")
+	}
+	ws.Subprotocols = subprotocols
+	return nil
+}
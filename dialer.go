@@ -0,0 +1,87 @@
+package phx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// FrameType is the type of websocket frame a message is sent or received in. The values match the websocket opcodes.
+type FrameType int
+
+const (
+	TextFrame   FrameType = 1
+	BinaryFrame FrameType = 2
+)
+
+// The types of the control frames that a WebsocketConn may pass on between the frames of messages.
+const (
+	pingFrame FrameType = 9
+	pongFrame FrameType = 10
+)
+
+// CloseNormalClosure is the websocket close code for a normal closure.
+const CloseNormalClosure = 1000
+
+// CloseGoingAway is the websocket close code for an endpoint going away, such as a server shutting down.
+const CloseGoingAway = 1001
+
+// Dialer opens websocket connections for the Websocket transport. Implement it to use another websocket library, an
+// experimental transport, or a test double. See GorillaDialer for the default implementation.
+type Dialer interface {
+	// Dial opens a websocket connection to the given url, sending the given headers with the upgrade request. The
+	// context's deadline bounds the handshake, and an httptrace.ClientTrace and DialOptions in the context should be
+	// reported to and applied if possible. The response to the upgrade request should be returned when available, even on errors.
+	Dial(ctx context.Context, url string, requestHeader http.Header) (WebsocketConn, *http.Response, error)
+}
+
+// WebsocketConn is a websocket connection as opened by a Dialer. ReadMessage is called from one goroutine and the
+// other methods from another.
+type WebsocketConn interface {
+	// ReadMessage blocks until a data message is received. When the server closes the connection with a close frame,
+	// a *CloseError must be returned.
+	ReadMessage() (FrameType, []byte, error)
+
+	// WriteMessage sends a data message.
+	WriteMessage(frameType FrameType, data []byte) error
+
+	// WriteClose sends a close frame with the given code and text.
+	WriteClose(code int, text string) error
+
+	// Close closes the underlying connection without sending a close frame.
+	Close() error
+}
+
+// WebsocketDeadliner is implemented by WebsocketConns that support deadlines on reading and writing frames, as used by
+// Websocket.SetReadTimeout and Websocket.SetWriteTimeout. A read or write that misses its deadline must fail, and the
+// connection is then reconnected. The zero time clears the deadline.
+type WebsocketDeadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// CloseError is returned by WebsocketConn.ReadMessage when a close frame is received.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Text)
+}
+
+// isCloseError returns true if err is a CloseError with one of the given codes.
+func isCloseError(err error, codes ...int) bool {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	for _, code := range codes {
+		if closeErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package phx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrHeartbeatTimeout is the error of a connection that was dropped because the server didn't reply to a heartbeat.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// DisconnectKind is how a connection ended, as reported in a DisconnectInfo.
+type DisconnectKind int
+
+const (
+	// DisconnectRequested is a connection closed locally with Disconnect or Reconnect.
+	DisconnectRequested DisconnectKind = iota
+
+	// DisconnectServerClosed is a connection the server closed cleanly, such as when shutting down.
+	DisconnectServerClosed
+
+	// DisconnectLost is a connection that ended abruptly, such as from a network error or heartbeat timeout.
+	DisconnectLost
+)
+
+func (k DisconnectKind) String() string {
+	switch k {
+	case DisconnectRequested:
+		return "requested"
+	case DisconnectServerClosed:
+		return "server_closed"
+	case DisconnectLost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// DisconnectInfo describes how a connection ended, as passed to Socket.OnDisconnect callbacks, so that the user can be
+// told "you disconnected" rather than "connection lost".
+type DisconnectInfo struct {
+	// Kind is how the connection ended.
+	Kind DisconnectKind
+
+	// Code is the close code sent by the server, or zero if it didn't send one.
+	Code int
+
+	// Err is the last error on the connection before it ended, or nil if none.
+	Err error
+}
+
+// OnDisconnect registers the given callback to be called whenever the connection ends, like OnClose, with how it
+// ended.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnDisconnect(callback func(DisconnectInfo)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.disconnectCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// noteDisconnectRequested records that the connection is being closed locally.
+func (s *Socket) noteDisconnectRequested() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.disconnectRequested = true
+}
+
+// noteLastError records the latest error on the connection.
+func (s *Socket) noteLastError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+}
+
+// disconnectInfo returns how the connection ended and clears the state for the next connection.
+func (s *Socket) disconnectInfo() DisconnectInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := DisconnectInfo{Kind: DisconnectLost, Err: s.lastErr}
+
+	var closeErr *CloseError
+	if errors.As(s.lastErr, &closeErr) {
+		info.Code = closeErr.Code
+	}
+
+	switch {
+	case s.disconnectRequested:
+		info.Kind = DisconnectRequested
+	case info.Code == CloseNormalClosure || info.Code == CloseGoingAway:
+		info.Kind = DisconnectServerClosed
+	}
+
+	s.disconnectRequested = false
+	s.lastErr = nil
+	return info
+}
+
+// callDisconnectCallbacks calls the OnDisconnect callbacks with how the connection ended.
+func (s *Socket) callDisconnectCallbacks() {
+	info := s.disconnectInfo()
+	s.Logger.Printf(LogInfo, "socket", "Disconnect was %v: %v", info.Kind, info.Err)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cb := range s.disconnectCallbacks {
+		cb := cb
+		s.run(func() { cb(info) })
+	}
+}
+
+type beforeDisconnectHook struct {
+	ref  Ref
+	hook func(ctx context.Context) error
+}
+
+// BeforeDisconnect registers the given hook to be called by Disconnect while the connection is still open, so that
+// final state, such as "user went offline at T", can be pushed before it closes. Hooks are called in the order they
+// were registered with a context that is canceled after BeforeDisconnectTimeout. To be sure a push was delivered, wait
+// for its reply before returning, unless ManualDispatch is set, as replies are then only processed by Poll. Errors are
+// logged, and don't prevent disconnecting.
+// Returns a unique Ref that can be used to cancel this hook via Off.
+func (s *Socket) BeforeDisconnect(hook func(ctx context.Context) error) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.beforeDisconnectHooks = append(s.beforeDisconnectHooks, beforeDisconnectHook{ref: ref, hook: hook})
+	s.mu.Unlock()
+	return ref
+}
+
+// callBeforeDisconnect calls the BeforeDisconnect hooks if the connection is open.
+func (s *Socket) callBeforeDisconnect() {
+	s.mu.RLock()
+	hooks := s.beforeDisconnectHooks
+	s.mu.RUnlock()
+
+	if len(hooks) == 0 || !s.IsConnected() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.BeforeDisconnectTimeout)
+	defer cancel()
+
+	for _, h := range hooks {
+		err := h.hook(ctx)
+		if err != nil {
+			s.Logger.Printf(LogError, "socket", "before disconnect hook failed: %v", err)
+		}
+	}
+}
+
+// offBeforeDisconnect removes the BeforeDisconnect hook with the given ref. Must be called with mu locked.
+func (s *Socket) offBeforeDisconnect(ref Ref) {
+	for i, h := range s.beforeDisconnectHooks {
+		if h.ref == ref {
+			s.beforeDisconnectHooks = append(s.beforeDisconnectHooks[:i:i], s.beforeDisconnectHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// DisconnectGracefully disconnects like Disconnect, but first waits for the messages queued to be sent, including the
+// pushes of the BeforeDisconnect hooks, to be handed to the connection, and for the pushes that were sent to be replied
+// to or time out, so that nothing in flight is lost. If the given context is done first, the Socket disconnects anyway
+// and returns the context's error. Pushes buffered by Channels that aren't joined are not waited for. With
+// ManualDispatch set, replies are only processed by Poll, so keep polling while waiting.
+func (s *Socket) DisconnectGracefully(ctx context.Context) error {
+	var flushErr error
+	err := s.disconnect(func() { flushErr = s.waitFlushed(ctx) }, s.Transport.Disconnect)
+	if err != nil {
+		return err
+	}
+	if flushErr != nil {
+		return fmt.Errorf("disconnected before flushing: %w", flushErr)
+	}
+	return nil
+}
+
+// waitFlushed waits until the send queues are empty and no Push is waiting for a reply, or the given context is done.
+func (s *Socket) waitFlushed(ctx context.Context) error {
+	for !s.isFlushed() {
+		if !sleepClock(s.Clock, flushPollInterval, ctx.Done()) {
+			s.Logger.Printf(LogWarning, "socket", "disconnecting with messages in flight: %v", ctx.Err())
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// isFlushed returns true if the ordering lanes and the Transport's send queue are empty and no Push is waiting for a
+// reply.
+func (s *Socket) isFlushed() bool {
+	if atomic.LoadInt64(&s.laneBytes) > 0 || atomic.LoadInt64(&s.awaitingReplies) > 0 {
+		return false
+	}
+	if sizer, ok := s.Transport.(QueueSizer); ok && sizer.QueuedBytes() > 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package phx
+
+import (
+	"context"
+)
+
+// This file implements the single-threaded mode enabled with Socket.ManualDispatch, for hosts such as game engines and
+// GUI frameworks that require all callbacks to run on one thread, driven by their own event loop.
+
+// run calls the given callback in a new goroutine, or queues it for Poll or ProcessNext when ManualDispatch is set.
+func (s *Socket) run(callback func()) {
+	if !s.ManualDispatch {
+		goLabeled(s.profilerLabels(roleDispatcher), callback)
+		return
+	}
+
+	s.dispatchMu.Lock()
+	s.dispatchQueue = append(s.dispatchQueue, callback)
+	s.dispatchMu.Unlock()
+
+	// Wake up a ProcessNext that is waiting, if any
+	select {
+	case s.dispatchReady <- struct{}{}:
+	default:
+	}
+}
+
+// Poll runs up to max of the callbacks queued while ManualDispatch is set, on the calling goroutine, without blocking.
+// If max is zero or less, all queued callbacks are run. Returns the number of callbacks run.
+//
+// Channels and Pushes process their replies and state changes through callbacks too, so Poll or ProcessNext must be
+// called regularly, and must not be called from within a callback that waits for a reply, such as TypedChannel.Push.
+func (s *Socket) Poll(max int) int {
+	ran := 0
+	for max <= 0 || ran < max {
+		callback, ok := s.nextCallback()
+		if !ok {
+			break
+		}
+		callback()
+		ran++
+	}
+	return ran
+}
+
+// ProcessNext runs the next callback queued while ManualDispatch is set, on the calling goroutine, waiting for one to
+// be queued if there are none. Returns the context's error if it is done before a callback could be run.
+func (s *Socket) ProcessNext(ctx context.Context) error {
+	for {
+		callback, ok := s.nextCallback()
+		if ok {
+			callback()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.dispatchReady:
+		}
+	}
+}
+
+// Pending returns the number of callbacks queued while ManualDispatch is set.
+func (s *Socket) Pending() int {
+	s.dispatchMu.Lock()
+	defer s.dispatchMu.Unlock()
+
+	return len(s.dispatchQueue)
+}
+
+func (s *Socket) nextCallback() (func(), bool) {
+	s.dispatchMu.Lock()
+	defer s.dispatchMu.Unlock()
+
+	if len(s.dispatchQueue) == 0 {
+		return nil, false
+	}
+	callback := s.dispatchQueue[0]
+	s.dispatchQueue[0] = nil
+	s.dispatchQueue = s.dispatchQueue[1:]
+	return callback, true
+}
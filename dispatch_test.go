@@ -0,0 +1,21 @@
+package phx
+
+import (
+	"testing"
+)
+
+func TestMemoryUsageCountsDispatchQueue(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	socket.ManualDispatch = true
+
+	socket.run(func() {})
+	socket.run(func() {})
+	if usage := socket.MemoryUsage(); usage.DispatchQueue != 2*estimatedCallbackSize || usage.Total != usage.DispatchQueue {
+		t.Fatalf("usage %+v, want %v bytes for 2 queued callbacks", usage, 2*estimatedCallbackSize)
+	}
+
+	socket.Poll(0)
+	if usage := socket.MemoryUsage(); usage.DispatchQueue != 0 {
+		t.Errorf("usage %+v counts callbacks that were run", usage)
+	}
+}
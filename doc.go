@@ -1,2 +1,18 @@
 // Package phx is a comprehensive client for Phoenix Channels written for Go applications.
+//
+// # Concurrency
+//
+// The methods of Socket, Channel and Push are safe to call concurrently from any goroutine, and from within any
+// callback, such as joining a Channel from a Socket.OnOpen callback. Callbacks are never called while the library
+// holds a lock, so they may register or cancel callbacks, create and join Channels, and push.
+//
+// The exceptions are:
+//   - Exported fields, such as Socket.Transport, Socket.HeartbeatInterval and Channel.PushTimeout, are configuration.
+//     Set them before calling Socket.Connect or Channel.Join, and don't change them afterwards.
+//   - The exported fields of a Push, such as Ref and Payload, must not be changed after it is created, and Ref must
+//     not be read while the Push is being sent.
+//   - ChannelMiddleware runs in the goroutine that reads from the connection, so it must not wait for anything that
+//     needs a message to be read, such as the reply to a Push.
+//   - With Socket.ManualDispatch, callbacks only run when Poll or ProcessNext is called, so waiting for a reply in a
+//     callback, or in a goroutine that must finish before Poll is called again, deadlocks.
 package phx
@@ -0,0 +1,133 @@
+package phx
+
+// DropReason is why a message was dropped by the library, as reported in a DropEvent.
+type DropReason int
+
+const (
+	// DropDecodeFailure is an inbound message that could not be decoded by the Serializer, or otherwise violates the
+	// protocol, as reported to the OnProtocolError callbacks.
+	DropDecodeFailure DropReason = iota
+
+	// DropUnverified is an inbound message whose signature could not be verified. See Socket.Signer.
+	DropUnverified
+
+	// DropStale is an inbound message for a previous join of its Channel.
+	DropStale
+
+	// DropDuplicate is an inbound message with a recently seen "msg_id". See Channel.DedupeSize.
+	DropDuplicate
+
+	// DropUnhandled is an inbound message that no Channel or binding was interested in.
+	DropUnhandled
+
+	// DropMiddleware is an inbound message that ChannelMiddleware or an Interceptor did not pass on.
+	DropMiddleware
+
+	// DropConflated is an outbound payload that was replaced by a newer one in a LimitedPush.
+	DropConflated
+
+	// DropQueueOverflow is an outbound message that didn't fit in the send queue.
+	DropQueueOverflow
+
+	// DropMemoryPressure is an outbound Push that was shed because the Socket exceeded its MemoryLimit.
+	DropMemoryPressure
+
+	// DropExpired is an outbound Push that timed out while buffered waiting for its Channel to join.
+	DropExpired
+
+	// DropSubscriberFull is an inbound payload that didn't fit in the buffer of a Subscription.
+	DropSubscriberFull
+
+	// DropExportOverflow is an inbound event that didn't fit in the queue of an Exporter.
+	DropExportOverflow
+
+	// DropReleased is an outbound Push that was still buffered waiting for its Channel to join when the join ended, such
+	// as when the Channel was left or removed. See ErrPushReleased.
+	DropReleased
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropDecodeFailure:
+		return "decode_failure"
+	case DropUnverified:
+		return "unverified"
+	case DropStale:
+		return "stale"
+	case DropDuplicate:
+		return "duplicate"
+	case DropUnhandled:
+		return "unhandled"
+	case DropMiddleware:
+		return "middleware"
+	case DropConflated:
+		return "conflated"
+	case DropQueueOverflow:
+		return "queue_overflow"
+	case DropMemoryPressure:
+		return "memory_pressure"
+	case DropExpired:
+		return "expired"
+	case DropSubscriberFull:
+		return "subscriber_full"
+	case DropExportOverflow:
+		return "export_overflow"
+	case DropReleased:
+		return "released"
+	}
+	return "unknown"
+}
+
+// DropEvent describes a message that was dropped, as passed to Socket.OnDrop callbacks.
+type DropEvent struct {
+	// Reason is why the message was dropped.
+	Reason DropReason
+
+	// Topic and Event of the dropped message, if known.
+	Topic string
+	Event string
+
+	// Count is the total number of messages dropped for this Reason by the Socket, including this one.
+	Count uint64
+}
+
+// OnDrop registers the given callback to be called whenever the library drops a message, so that delivery can be
+// accounted for end-to-end. See DropReason for the places messages can be dropped.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnDrop(callback func(DropEvent)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.dropCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// DropCounts returns the number of messages dropped so far for each DropReason.
+func (s *Socket) DropCounts() map[DropReason]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[DropReason]uint64, len(s.dropCounts))
+	for reason, count := range s.dropCounts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// drop counts a dropped message and calls the OnDrop callbacks.
+func (s *Socket) drop(reason DropReason, topic string, event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropCounts[reason]++
+	dropEvent := DropEvent{
+		Reason: reason,
+		Topic:  topic,
+		Event:  event,
+		Count:  s.dropCounts[reason],
+	}
+	for _, cb := range s.dropCallbacks {
+		cb := cb
+		s.run(func() { cb(dropEvent) })
+	}
+}
@@ -26,3 +26,7 @@ const (
 	// HeartBeatEvent is a special message for heartbeats on the special topic "phoenix"
 	HeartBeatEvent Event = "heartbeat"
 )
+
+// joinGiveUpEvent is generated by the client when a Channel exhausts its JoinErrorBudget. Triggers
+// channel.OnJoinGiveUp().
+const joinGiveUpEvent = "phx_join_give_up"
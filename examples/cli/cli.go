@@ -2,45 +2,70 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/nshafer/phx"
+	phx "github.com/ongkong/phxx"
 	"io"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: go run cli.go ws[s]://host[:port]/[path][?key=value]")
+	script := flag.String("script", "", "run the commands of the given YAML scenario file, then exit")
+	filterStr := flag.String("filter", "", "jq-like filter applied to received messages")
+	flag.Usage = func() {
+		fmt.Println("Usage: go run . [-script scenario.yaml] [-filter expr] ws[s]://host[:port]/[path][?key=value]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	urlStr := os.Args[1]
+	urlStr := flag.Arg(0)
 	endPoint, err := url.Parse(urlStr)
 	if err != nil {
 		fmt.Println("Invalid url", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Ready to connect to '%v', 'h' for help, 'q' to exit\n", endPoint)
 
-	socket := phx.NewSocket(endPoint)
-	//socket.Serializer = phx.NewJSONSerializerV1()
-	socket.Logger = phx.NewSimpleLogger(phx.LogInfo)
-	socket.OnOpen(func() {
-		fmt.Println("+ connected")
-	})
-	socket.OnClose(func() {
-		fmt.Println("x disconnected")
-	})
-	socket.OnError(func(err error) {
-		fmt.Printf("! %v\n", err)
-	})
-	socket.OnMessage(func(msg phx.Message) {
-		fmt.Printf("< %+v\n", msg)
-	})
+	r := newREPL(endPoint)
+	if *filterStr != "" {
+		if err := r.run("filter " + *filterStr); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *script != "" {
+		steps, err := loadScenario(*script)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, step := range steps {
+			fmt.Println(">", step)
+			err := r.run(step)
+			if errors.Is(err, errQuit) {
+				return
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
 
-	var channel *phx.Channel
+	fmt.Printf("Ready to connect to '%v', 'h' for help, 'q' to exit\n", endPoint)
 
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -55,209 +80,437 @@ func main() {
 			continue
 		}
 
-		input = strings.Trim(input, " \t\n")
-		cmd, arg, _ := strings.Cut(input, " ")
+		err = r.run(input)
+		if errors.Is(err, errQuit) {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
 
-		switch cmd {
-		case "h":
-			usage()
+var errQuit = errors.New("quit")
 
-		case "q":
-			return
+// waitQueueLength is the number of received messages kept for the 'wait' command.
+const waitQueueLength = 1000
 
-		case "c":
-			if err := socket.Connect(); err != nil {
-				fmt.Println(err)
-			}
+type repl struct {
+	socket  *phx.Socket
+	channel *phx.Channel
 
-		case "d":
-			if err := socket.Disconnect(); err != nil {
-				fmt.Println(err)
+	mu        sync.Mutex
+	filter    *filter
+	presences map[string]map[string]any
+	received  chan phx.Message
+}
+
+func newREPL(endPoint *url.URL) *repl {
+	r := &repl{
+		socket:    phx.NewSocket(endPoint),
+		presences: make(map[string]map[string]any),
+		received:  make(chan phx.Message, waitQueueLength),
+	}
+
+	//r.socket.Serializer = phx.NewJSONSerializerV1()
+	r.socket.Logger = phx.NewSimpleLogger(phx.LogInfo)
+	r.socket.OnOpen(func() {
+		fmt.Println("+ connected")
+	})
+	r.socket.OnClose(func() {
+		fmt.Println("x disconnected")
+	})
+	r.socket.OnError(func(err error) {
+		fmt.Printf("! %v\n", err)
+	})
+	r.socket.OnMessage(r.onMessage)
+	return r
+}
+
+func (r *repl) onMessage(msg phx.Message) {
+	r.trackPresence(msg)
+
+	// Keep the message for 'wait', dropping it if nobody is waiting and the queue is full
+	select {
+	case r.received <- msg:
+	default:
+	}
+
+	r.mu.Lock()
+	f := r.filter
+	r.mu.Unlock()
+
+	if f == nil {
+		fmt.Printf("< %+v\n", msg)
+		return
+	}
+	value, ok := f.apply(messageValue(msg))
+	if !ok {
+		return
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		fmt.Printf("< %v\n", value)
+		return
+	}
+	fmt.Printf("< %s\n", out)
+}
+
+// messageValue converts a message to the generic value that filters are applied to.
+func messageValue(msg phx.Message) any {
+	return map[string]any{
+		"join_ref": msg.JoinRef,
+		"ref":      msg.Ref,
+		"topic":    msg.Topic,
+		"event":    msg.Event,
+		"payload":  msg.Payload,
+	}
+}
+
+// run runs a single command line.
+func (r *repl) run(input string) error {
+	input = strings.Trim(input, " \t\r\n")
+	cmd, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "":
+		return nil
+
+	case "h", "help":
+		usage()
+
+	case "q", "quit":
+		return errQuit
+
+	case "c", "connect":
+		return r.socket.Connect()
+
+	case "d", "disconnect":
+		return r.socket.Disconnect()
+
+	case "r", "reconnect":
+		return r.socket.Reconnect()
+
+	case "s", "status":
+		fmt.Printf("Connected: %v\n", r.socket.IsConnected())
+		fmt.Printf("Connection: %v\n", r.socket.ConnectionState())
+		if r.channel != nil {
+			fmt.Printf("Channel: %v %v\n", r.channel.Topic(), r.channel.State())
+		} else {
+			fmt.Println("Channel: uninitialized")
+		}
+
+	case "ch":
+		return r.createChannel(arg)
+
+	case "rm":
+		if r.channel == nil {
+			return errors.New("cannot remove non-existant channel")
+		}
+		return r.channel.Remove()
+
+	case "j":
+		return r.join()
+
+	case "join":
+		if arg != "" {
+			if err := r.createChannel(arg); err != nil {
+				return err
 			}
+		}
+		return r.join()
+
+	case "l", "leave":
+		return r.leave(nil)
 
-		case "r":
-			if err := socket.Reconnect(); err != nil {
+	case "rj":
+		return r.leave(func() {
+			if err := r.join(); err != nil {
 				fmt.Println(err)
 			}
+		})
 
-		case "s":
-			fmt.Printf("Connected: %v\n", socket.IsConnected())
-			fmt.Printf("Connection: %v\n", socket.ConnectionState())
-			if channel != nil {
-				fmt.Printf("Channel: %v\n", channel.State())
-			} else {
-				fmt.Println("Channel: uninitialized")
-			}
+	case "p", "push":
+		return r.push(arg)
 
-		case "ch":
-			if channel != nil {
-				err := channel.Remove()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-			}
+	case "presence":
+		r.printPresence()
 
-			topic, paramStr, _ := strings.Cut(arg, " ")
-			if topic == "" {
-				usage()
-				continue
-			}
-			paramPairs := strings.Split(paramStr, ",")
-			params := make(map[string]string)
-			for _, pair := range paramPairs {
-				key, value, found := strings.Cut(pair, ":")
-				if found {
-					params[key] = value
-				}
-			}
-			fmt.Printf("Creating channel %v with %v\n", topic, params)
-			channel = socket.Channel(topic, params)
-			channel.On(string(phx.ReplyEvent), func(payload any) {
-				fmt.Println("<-", payload)
-			})
-			channel.OnClose(func(payload any) {
-				fmt.Println("x-", payload)
-			})
-			channel.OnError(func(payload any) {
-				fmt.Println("!-", payload)
-			})
-			channel.On("shout", func(payload any) {
-				fmt.Println("s-", payload)
-			})
-
-		case "rm":
-			if channel != nil {
-				err := channel.Remove()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-			} else {
-				fmt.Println("Cannot remove non-existant channel")
-			}
+	case "f", "filter":
+		return r.setFilter(arg)
 
-		case "j":
-			if channel != nil {
-				join, err := channel.Join()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				join.Receive("ok", func(response any) {
-					fmt.Println("Joined channel:", channel.Topic(), response)
-				})
-				join.Receive("error", func(response any) {
-					fmt.Println("Join error", response)
-				})
-				//fmt.Println("JoinPush", join)
-			} else {
-				fmt.Println("Create a channel first")
-			}
+	case "wait":
+		return r.wait(arg)
 
-		case "l":
-			if channel != nil {
-				leave, err := channel.Leave()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				leave.Receive("ok", func(response any) {
-					fmt.Println("Left channel:", channel.Topic(), response)
-				})
-				leave.Receive("error", func(response any) {
-					fmt.Println("Leave error:", response)
-				})
-			} else {
-				fmt.Println("Create a channel first")
-			}
+	case "sleep":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+
+	case "echo":
+		fmt.Println(arg)
+
+	default:
+		usage()
+		return fmt.Errorf("unknown command '%v'", cmd)
+	}
+	return nil
+}
+
+func (r *repl) createChannel(arg string) error {
+	if r.channel != nil {
+		err := r.channel.Remove()
+		if err != nil {
+			return err
+		}
+	}
+
+	topic, paramStr, _ := strings.Cut(arg, " ")
+	if topic == "" {
+		usage()
+		return errors.New("missing topic")
+	}
+	params := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(paramStr, func(r rune) bool { return r == ',' || r == ' ' }) {
+		key, value, found := strings.Cut(pair, ":")
+		if found {
+			params[key] = value
+		}
+	}
+	fmt.Printf("Creating channel %v with %v\n", topic, params)
+	channel := r.socket.Channel(topic, params)
+	channel.On(string(phx.ReplyEvent), func(payload any) {
+		fmt.Println("<-", payload)
+	})
+	channel.OnClose(func(payload any) {
+		fmt.Println("x-", payload)
+	})
+	channel.OnError(func(payload any) {
+		fmt.Println("!-", payload)
+	})
+	channel.On("shout", func(payload any) {
+		fmt.Println("s-", payload)
+	})
+	r.channel = channel
+	return nil
+}
+
+func (r *repl) join() error {
+	if r.channel == nil {
+		return errors.New("create a channel first")
+	}
+	channel := r.channel
+	join, err := channel.Join()
+	if err != nil {
+		return err
+	}
+	join.Receive("ok", func(response any) {
+		fmt.Println("Joined channel:", channel.Topic(), response)
+	})
+	join.Receive("error", func(response any) {
+		fmt.Println("Join error", response)
+	})
+	return nil
+}
+
+func (r *repl) leave(then func()) error {
+	if r.channel == nil {
+		return errors.New("create a channel first")
+	}
+	channel := r.channel
+	leave, err := channel.Leave()
+	if err != nil {
+		return err
+	}
+	leave.Receive("ok", func(response any) {
+		fmt.Println("Left channel:", channel.Topic(), response)
+		if then != nil {
+			then()
+		}
+	})
+	leave.Receive("error", func(response any) {
+		fmt.Println("Leave error:", response)
+	})
+	return nil
+}
+
+func (r *repl) push(arg string) error {
+	if r.channel == nil {
+		return errors.New("create a channel first")
+	}
+	event, payloadStr, _ := strings.Cut(arg, " ")
+	payloadStr = strings.TrimSpace(payloadStr)
 
-		case "rj":
-			if channel != nil {
-				leave, err := channel.Leave()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-
-				leave.Receive("ok", func(response any) {
-					fmt.Println("Left channel:", channel.Topic(), response)
-					join, err := channel.Join()
-					if err != nil {
-						fmt.Println(err)
-						return
-					}
-					join.Receive("ok", func(response any) {
-						fmt.Println("Joined channel:", channel.Topic(), response)
-					})
-					join.Receive("error", func(response any) {
-						fmt.Println("Join error", response)
-					})
-				})
-			} else {
-				fmt.Println("Create a channel first")
+	var payload any
+	switch {
+	case strings.HasPrefix(payloadStr, "{") || strings.HasPrefix(payloadStr, "["):
+		// JSON payload
+		err := json.Unmarshal([]byte(payloadStr), &payload)
+		if err != nil {
+			return fmt.Errorf("invalid JSON payload: %w", err)
+		}
+	case strings.Contains(payloadStr, ":"):
+		// key:value[,...] payload
+		payloadMap := make(map[string]string)
+		for _, pair := range strings.Split(payloadStr, ",") {
+			key, value, found := strings.Cut(pair, ":")
+			if found {
+				payloadMap[key] = value
 			}
+		}
+		payload = payloadMap
+	default:
+		payload = payloadStr
+	}
 
-		case "p":
-			event, payloadStr, _ := strings.Cut(arg, " ")
-			if channel != nil {
-				var payload any
-
-				// check if payloadStr looks like a map
-				if strings.Contains(payloadStr, ":") {
-					payloadMap := make(map[string]string)
-					payloadPairs := strings.Split(payloadStr, ",")
-					for _, pair := range payloadPairs {
-						key, value, found := strings.Cut(pair, ":")
-						if found {
-							payloadMap[key] = value
-						}
-					}
-					payload = payloadMap
-				} else {
-					payload = payloadStr
-				}
-
-				p, err := channel.Push(event, payload)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				p.Receive("ok", func(response any) {
-					fmt.Println("Push response:", response)
-				})
-				p.Receive("error", func(response any) {
-					fmt.Println("Push error:", response)
-				})
-				p.Receive("timeout", func(response any) {
-					fmt.Println("Push timeout:", response)
-				})
-			} else {
-				fmt.Println("Create a channel first")
+	p, err := r.channel.Push(event, payload)
+	if err != nil {
+		return err
+	}
+	p.Receive("ok", func(response any) {
+		fmt.Println("Push response:", response)
+	})
+	p.Receive("error", func(response any) {
+		fmt.Println("Push error:", response)
+	})
+	p.Receive("timeout", func(response any) {
+		fmt.Println("Push timeout:", response)
+	})
+	return nil
+}
+
+func (r *repl) setFilter(arg string) error {
+	var f *filter
+	if arg != "" && arg != "off" {
+		var err error
+		f, err = parseFilter(arg)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filter = f
+	return nil
+}
+
+// wait blocks until a message with the given event is received, or the timeout expires. The events "ok" and "error"
+// match replies with that status. Messages received since the previous wait are considered too, so that a reply is
+// not missed when it arrives before the wait command runs.
+func (r *repl) wait(arg string) error {
+	event, timeoutStr, _ := strings.Cut(arg, " ")
+	if event == "" {
+		return errors.New("missing event to wait for")
+	}
+	timeout := 10 * time.Second
+	if timeoutStr != "" {
+		var err error
+		timeout, err = time.ParseDuration(strings.TrimSpace(timeoutStr))
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-r.received:
+			if matchesEvent(msg, event) {
+				return nil
 			}
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for '%v'", event)
+		}
+	}
+}
+
+func matchesEvent(msg phx.Message, event string) bool {
+	if msg.Event == event {
+		return true
+	}
+	if msg.Event != string(phx.ReplyEvent) {
+		return false
+	}
+	payload, ok := msg.Payload.(map[string]any)
+	return ok && payload["status"] == event
+}
 
-		default:
-			if cmd != "" {
-				fmt.Println("Unknown command")
-				usage()
-				continue
+// trackPresence keeps the presences of each topic up to date from presence_state and presence_diff events.
+func (r *repl) trackPresence(msg phx.Message) {
+	payload, ok := msg.Payload.(map[string]any)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch msg.Event {
+	case "presence_state":
+		r.presences[msg.Topic] = payload
+	case "presence_diff":
+		state := r.presences[msg.Topic]
+		if state == nil {
+			state = make(map[string]any)
+			r.presences[msg.Topic] = state
+		}
+		if leaves, ok := payload["leaves"].(map[string]any); ok {
+			for key := range leaves {
+				delete(state, key)
 			}
 		}
+		if joins, ok := payload["joins"].(map[string]any); ok {
+			for key, presence := range joins {
+				state[key] = presence
+			}
+		}
+	}
+}
+
+func (r *repl) printPresence() {
+	if r.channel == nil {
+		fmt.Println("Create a channel first")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.presences[r.channel.Topic()]
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("%d present in %v\n", len(keys), r.channel.Topic())
+	for _, key := range keys {
+		fmt.Printf("  %v %v\n", key, state[key])
 	}
 }
 
 func usage() {
 	fmt.Print(`
-q                               quit
-c                               connect
-d                               disconnect
-r                               reconnect
-s                               status
+q, quit                         quit
+c, connect                      connect
+d, disconnect                   disconnect
+r, reconnect                    reconnect
+s, status                       status
 ch topic [key:value[,...]]      create channel
 rm                              remove channel
 j                               join channel
-l                               leave channel
+join topic [key:value[,...]]    create and join channel
+l, leave                        leave channel
 rj                              rejoin channel
-p event [payload]               push event
+p, push event [payload]         push event, payload is key:value[,...], JSON or a string
+presence                        list presences in channel
+f, filter [expr|off]            filter received messages, such as 'select(.event == "shout") | .payload'
+wait event [timeout]            wait for an event, or "ok"/"error" reply (for scripts)
+sleep duration                  sleep, such as 500ms (for scripts)
+echo text                       print text (for scripts)
 `)
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A filter is a small jq-like expression applied to every received message before printing it. It is a pipeline of
+// stages separated by '|'. Each stage is either a path such as '.payload.user.name' or '.payload.items[0]', which
+// replaces the value with the value at that path, or 'select(path == value)' / 'select(path != value)', which drops
+// the message unless the comparison holds. The value compared against is JSON, such as "shout", 1 or true.
+//
+// For example:
+//
+//	select(.event == "shout") | .payload.body
+type filter struct {
+	source string
+	stages []filterStage
+}
+
+type filterStage struct {
+	path []pathElem
+
+	// set for select stages
+	selectOp    string
+	selectValue any
+}
+
+type pathElem struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+func parseFilter(source string) (*filter, error) {
+	f := &filter{source: source}
+	for _, part := range strings.Split(source, "|") {
+		part = strings.TrimSpace(part)
+		stage, err := parseStage(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter stage %q: %w", part, err)
+		}
+		f.stages = append(f.stages, stage)
+	}
+	return f, nil
+}
+
+func parseStage(s string) (filterStage, error) {
+	if strings.HasPrefix(s, "select(") && strings.HasSuffix(s, ")") {
+		expr := strings.TrimSuffix(strings.TrimPrefix(s, "select("), ")")
+		for _, op := range []string{"==", "!="} {
+			lhs, rhs, found := strings.Cut(expr, op)
+			if !found {
+				continue
+			}
+			path, err := parsePath(strings.TrimSpace(lhs))
+			if err != nil {
+				return filterStage{}, err
+			}
+			var value any
+			err = json.Unmarshal([]byte(strings.TrimSpace(rhs)), &value)
+			if err != nil {
+				return filterStage{}, fmt.Errorf("invalid value: %w", err)
+			}
+			return filterStage{path: path, selectOp: op, selectValue: value}, nil
+		}
+		return filterStage{}, fmt.Errorf("select needs == or !=")
+	}
+
+	path, err := parsePath(s)
+	if err != nil {
+		return filterStage{}, err
+	}
+	return filterStage{path: path}, nil
+}
+
+func parsePath(s string) ([]pathElem, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("path must start with '.'")
+	}
+	var path []pathElem
+	for _, part := range strings.Split(s[1:], ".") {
+		if part == "" {
+			continue
+		}
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			path = append(path, pathElem{key: key})
+		}
+		for rest != "" {
+			idx, after, found := strings.Cut(rest, "]")
+			if !found {
+				return nil, fmt.Errorf("missing ']'")
+			}
+			i, err := strconv.Atoi(idx)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q", idx)
+			}
+			path = append(path, pathElem{index: i, isIdx: true})
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return path, nil
+}
+
+// apply runs the filter on the given value and returns the result, or false if the value was not selected.
+func (f *filter) apply(value any) (any, bool) {
+	for _, stage := range f.stages {
+		v := lookup(value, stage.path)
+		switch stage.selectOp {
+		case "==":
+			if !jsonEqual(v, stage.selectValue) {
+				return nil, false
+			}
+		case "!=":
+			if jsonEqual(v, stage.selectValue) {
+				return nil, false
+			}
+		default:
+			value = v
+		}
+	}
+	return value, true
+}
+
+func lookup(value any, path []pathElem) any {
+	for _, elem := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			if elem.isIdx {
+				return nil
+			}
+			value = v[elem.key]
+		case []any:
+			if !elem.isIdx || elem.index < 0 || elem.index >= len(v) {
+				return nil
+			}
+			value = v[elem.index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// jsonEqual compares two values by their JSON encoding, so that numbers compare equal regardless of their Go type.
+func jsonEqual(a any, b any) bool {
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadScenario reads a scenario file, which is a YAML list of REPL commands, optionally under a 'steps' key:
+//
+//	# reproduce a customer issue
+//	steps:
+//	  - connect
+//	  - join room:lobby user_id:42
+//	  - wait ok 5s
+//	  - push new_msg {"body": "hello"}
+//	  - sleep 1s
+//	  - presence
+//
+// Only this subset of YAML is understood: comments, the optional 'steps' key and a sequence of plain or quoted
+// strings.
+func loadScenario(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var steps []string
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		switch {
+		case text == "" || text == "---" || text == "steps:":
+			continue
+		case strings.HasPrefix(text, "- ") || text == "-":
+			step, err := unquote(strings.TrimSpace(strings.TrimPrefix(text, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("%v:%d: %w", path, line, err)
+			}
+			if step != "" {
+				steps = append(steps, step)
+			}
+		default:
+			return nil, fmt.Errorf("%v:%d: expected a '- command' step", path, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// stripComment removes a '#' comment that is outside of quotes.
+func stripComment(s string) string {
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
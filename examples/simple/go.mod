@@ -1,10 +1,9 @@
-module github.com/nshafer/phx/examples/simple
+module github.com/ongkong/phxx/examples/simple
 
 go 1.18
 
-replace github.com/nshafer/phx v0.0.0-unpublished => ../../
+replace github.com/ongkong/phxx v0.0.0-unpublished => ../../
 
-require (
-	github.com/gorilla/websocket v1.5.0 // indirect
-	github.com/nshafer/phx v0.0.0-unpublished // indirect
-)
+require github.com/ongkong/phxx v0.0.0-unpublished
+
+require github.com/gorilla/websocket v1.5.0 // indirect
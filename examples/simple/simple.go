@@ -1,7 +1,7 @@
 package main
 
 import (
-	"github.com/nshafer/phx"
+	phx "github.com/ongkong/phxx"
 	"log"
 	"net/url"
 )
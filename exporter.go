@@ -0,0 +1,312 @@
+package phx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// ExportSink is an external system that an Exporter publishes events to, such as a NATS connection, a Kafka producer,
+// an MQTT client or a Unix socket. Publish is called from one goroutine per Exporter, in the order events arrived.
+type ExportSink interface {
+	Publish(subject string, data []byte) error
+}
+
+// SinkFunc adapts a function to an ExportSink, such as the Publish method of a NATS connection.
+type SinkFunc func(subject string, data []byte) error
+
+func (f SinkFunc) Publish(subject string, data []byte) error {
+	return f(subject, data)
+}
+
+// ExportedEvent is an inbound event as seen by the Subject and Body templates of an Exporter.
+type ExportedEvent struct {
+	Topic   string
+	Event   string
+	Payload any
+
+	// size is the size of the received message, counted in Socket.exportBytes while the event is queued
+	size int
+}
+
+// Exporter mirrors selected inbound events of a Socket to an ExportSink, so that a single client can feed existing
+// pipelines. Add it to a Socket with AddExporter. Events are queued and published in the order they arrived by a
+// goroutine of the Exporter, so that a slow sink doesn't hold up the Socket.
+type Exporter struct {
+	// Sink is where events are published.
+	Sink ExportSink
+
+	// Topics, if set, selects the topics whose events are exported. Defaults to nil, which exports all topics.
+	Topics TopicMatcher
+
+	// Events, if set, lists the events that are exported. Defaults to nil, which exports all events other than
+	// replies and the events of the Channel lifecycle, such as "phx_close".
+	Events []string
+
+	// Subject is a text/template for the subject, topic or key each event is published under, executed with an
+	// ExportedEvent. Defaults to "{{.Topic}}.{{.Event}}". The "replace" function replaces text, such as
+	// {{replace .Topic ":" "/"}} to turn the segments of a topic into the levels of an MQTT topic.
+	Subject string
+
+	// Body is a text/template for the data of each event, executed with an ExportedEvent. The "json" function encodes
+	// a value as JSON, such as {{json .Payload.user}}. Defaults to the payload encoded as JSON.
+	Body string
+
+	// QueueSize is the number of events that may wait to be published. Events that don't fit are dropped with
+	// DropExportOverflow. Defaults to 1000.
+	QueueSize int
+
+	// ErrorFunc, if set, is called with the events that could not be rendered or published, and the error.
+	ErrorFunc func(event ExportedEvent, err error)
+
+	socket  *Socket
+	subject *template.Template
+	body    *template.Template
+	events  map[string]bool
+	queue   chan ExportedEvent
+	once    sync.Once
+}
+
+// exporterFuncs are the functions available to the templates of an Exporter.
+var exporterFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"replace": strings.ReplaceAll,
+}
+
+// AddExporter starts exporting the inbound events selected by the given Exporter. Returns an error if its templates
+// can't be parsed.
+// Returns a unique Ref that can be used to stop the Exporter via Off.
+func (s *Socket) AddExporter(e *Exporter) (Ref, error) {
+	if e.Sink == nil {
+		return 0, errors.New("exporter has no sink")
+	}
+	subject := e.Subject
+	if subject == "" {
+		subject = defaultExportSubject
+	}
+	var err error
+	e.subject, err = template.New("subject").Funcs(exporterFuncs).Parse(subject)
+	if err != nil {
+		return 0, fmt.Errorf("parsing exporter subject: %w", err)
+	}
+	if e.Body != "" {
+		e.body, err = template.New("body").Funcs(exporterFuncs).Parse(e.Body)
+		if err != nil {
+			return 0, fmt.Errorf("parsing exporter body: %w", err)
+		}
+	}
+	if len(e.Events) > 0 {
+		e.events = make(map[string]bool, len(e.Events))
+		for _, event := range e.Events {
+			e.events[event] = true
+		}
+	}
+	queueSize := e.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultExportQueueSize
+	}
+	e.socket = s
+	e.queue = make(chan ExportedEvent, queueSize)
+	goLabeled(s.profilerLabels(roleExporter), e.publish)
+
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.exporters[ref] = e
+	s.mu.Unlock()
+	return ref, nil
+}
+
+// offExporter stops the Exporter with the given ref. Must be called with mu locked.
+func (s *Socket) offExporter(ref Ref) {
+	if e, ok := s.exporters[ref]; ok {
+		delete(s.exporters, ref)
+		e.stop()
+	}
+}
+
+// exportsMessage returns true if an Exporter selects the given message.
+func (s *Socket) exportsMessage(msg *Message) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.exporters {
+		if e.selects(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// export queues the given received message of the given size with the Exporters that select it, and returns true if
+// any did.
+func (s *Socket) export(msg *Message, size int) bool {
+	var exported bool
+	queued := 0
+	overflows := 0
+	s.mu.RLock()
+	for _, e := range s.exporters {
+		if !e.selects(msg) {
+			continue
+		}
+		exported = true
+		select {
+		case e.queue <- ExportedEvent{Topic: msg.Topic, Event: msg.Event, Payload: msg.Payload, size: size}:
+			queued++
+			atomic.AddInt64(&s.exportBytes, int64(size))
+		default:
+			overflows++
+		}
+	}
+	s.mu.RUnlock()
+
+	if queued > 0 {
+		s.checkMemory()
+	}
+	for i := 0; i < overflows; i++ {
+		s.drop(DropExportOverflow, msg.Topic, msg.Event)
+	}
+	return exported
+}
+
+// selects returns true if the given message is one of the events the Exporter exports.
+func (e *Exporter) selects(msg *Message) bool {
+	if e.events != nil {
+		if !e.events[msg.Event] {
+			return false
+		}
+	} else if msg.Topic == "phoenix" || msg.Event == string(ReplyEvent) || isLifecycleEvent(msg.Event) {
+		return false
+	}
+	return e.Topics == nil || e.Topics.MatchTopic(msg.Topic)
+}
+
+// publish publishes the queued events until the Exporter is stopped.
+func (e *Exporter) publish() {
+	for event := range e.queue {
+		atomic.AddInt64(&e.socket.exportBytes, -int64(event.size))
+		subject, data, err := e.render(event)
+		if err == nil {
+			err = e.Sink.Publish(subject, data)
+		}
+		if err != nil {
+			e.socket.Logger.Printf(LogError, "exporter", "could not export '%v' on '%v': %v", event.Event, event.Topic, err)
+			if e.ErrorFunc != nil {
+				e.ErrorFunc(event, err)
+			}
+		}
+	}
+}
+
+// render executes the templates of the Exporter for the given event.
+func (e *Exporter) render(event ExportedEvent) (string, []byte, error) {
+	var subject bytes.Buffer
+	err := e.subject.Execute(&subject, event)
+	if err != nil {
+		return "", nil, fmt.Errorf("rendering subject: %w", err)
+	}
+
+	if e.body == nil {
+		data, err := json.Marshal(event.Payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("encoding payload: %w", err)
+		}
+		return subject.String(), data, nil
+	}
+	var body bytes.Buffer
+	err = e.body.Execute(&body, event)
+	if err != nil {
+		return "", nil, fmt.Errorf("rendering body: %w", err)
+	}
+	return subject.String(), body.Bytes(), nil
+}
+
+func (e *Exporter) stop() {
+	e.once.Do(func() { close(e.queue) })
+}
+
+// LineSink is an ExportSink that writes each event to a Writer as a line of the subject, a space and the data, such as
+// to a file or a pipe. The data must not contain newlines, as is the case for JSON encoded by the default Body.
+type LineSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewLineSink returns a LineSink that writes to the given Writer.
+func NewLineSink(writer io.Writer) *LineSink {
+	return &LineSink{writer: writer}
+}
+
+func (s *LineSink) Publish(subject string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeLine(s.writer, subject, data)
+}
+
+// UnixSocketSink is an ExportSink that writes each event to a Unix socket as a line, like LineSink, for processes on
+// the same host. It connects on the first event, and reconnects on the next event after a write fails.
+type UnixSocketSink struct {
+	// Path is the path of the socket.
+	Path string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewUnixSocketSink returns a UnixSocketSink that writes to the socket at the given path.
+func NewUnixSocketSink(path string) *UnixSocketSink {
+	return &UnixSocketSink{Path: path}
+}
+
+func (s *UnixSocketSink) Publish(subject string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.Dial("unix", s.Path)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	err := writeLine(s.conn, subject, data)
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection to the socket, if any.
+func (s *UnixSocketSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// writeLine writes the subject and data as one line in a single write.
+func writeLine(writer io.Writer, subject string, data []byte) error {
+	line := make([]byte, 0, len(subject)+len(data)+2)
+	line = append(line, subject...)
+	line = append(line, ' ')
+	line = append(line, data...)
+	line = append(line, '\n')
+	_, err := writer.Write(line)
+	return err
+}
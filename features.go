@@ -0,0 +1,126 @@
+package phx
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Features is a set of capabilities that can be enabled per Socket, so that risky ones can be rolled out to some
+// deployments before others. See Socket.Features.
+type Features uint32
+
+const (
+	// FeatureBinaryPayloads allows Channel.PushBinary. Enabled by default.
+	FeatureBinaryPayloads Features = 1 << iota
+
+	// FeatureJoinBatching lets Socket.JoinBatch send its joins in one message. Without it, they are sent one by one.
+	// Enabled by default.
+	FeatureJoinBatching
+
+	// FeatureAdaptiveHeartbeat skips heartbeats while messages are being received, as they show that the connection
+	// is alive, like HeartbeatSkipAfterWrite does for writes. Experimental.
+	FeatureAdaptiveHeartbeat
+
+	// FeatureInboundQueue decodes and dispatches received messages on a goroutine of their own, through a queue of
+	// InboundQueueSize messages, so that the Transport keeps reading while handlers and decoding catch up with a
+	// burst. Experimental.
+	FeatureInboundQueue
+)
+
+// defaultFeatures are the Features of a Socket created by NewSocket, which the library had before they could be
+// disabled.
+const defaultFeatures = FeatureBinaryPayloads | FeatureJoinBatching
+
+var featureNames = []struct {
+	feature Features
+	name    string
+}{
+	{FeatureBinaryPayloads, "binary_payloads"},
+	{FeatureJoinBatching, "join_batching"},
+	{FeatureAdaptiveHeartbeat, "adaptive_heartbeat"},
+	{FeatureInboundQueue, "inbound_queue"},
+}
+
+// Has returns true if all of the given features are enabled.
+func (f Features) Has(features Features) bool {
+	return f&features == features
+}
+
+// String returns the names of the enabled features separated by commas, such as "binary_payloads,join_batching", or
+// "none".
+func (f Features) String() string {
+	var names []string
+	for _, n := range featureNames {
+		if f.Has(n.feature) {
+			names = append(names, n.name)
+			f &^= n.feature
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(f)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// errFeatureDisabled returns the error for using the given feature while it isn't enabled.
+func errFeatureDisabled(feature Features) error {
+	return fmt.Errorf("feature %v is not enabled in Socket.Features", feature)
+}
+
+// inboundFrame is a message received by the Transport, waiting in the inbound queue.
+type inboundFrame struct {
+	data   []byte
+	binary bool
+}
+
+// enqueueInbound puts the given received message in the inbound queue, starting the goroutine that dispatches it
+// unless it is running. Blocks while the queue is full.
+func (s *Socket) enqueueInbound(frame inboundFrame) {
+	s.inboundMu.Lock()
+	if s.inbound == nil {
+		size := s.InboundQueueSize
+		if size <= 0 {
+			size = defaultInboundQueueSize
+		}
+		s.inbound = make(chan inboundFrame, size)
+	}
+	inbound := s.inbound
+	s.inboundMu.Unlock()
+
+	atomic.AddInt64(&s.inboundBytes, int64(len(frame.data)))
+	s.checkMemory()
+	inbound <- frame
+
+	s.inboundMu.Lock()
+	defer s.inboundMu.Unlock()
+	if !s.inboundRunning {
+		s.inboundRunning = true
+		goLabeled(s.profilerLabels(roleDispatcher), func() { s.drainInbound(inbound) })
+	}
+}
+
+// drainInbound dispatches the messages in the inbound queue in order, until it is empty.
+func (s *Socket) drainInbound(inbound chan inboundFrame) {
+	for {
+		select {
+		case frame := <-inbound:
+			atomic.AddInt64(&s.inboundBytes, -int64(len(frame.data)))
+			s.dispatchFrame(frame)
+			continue
+		default:
+		}
+
+		// Stop only if nothing was queued since, as enqueueInbound doesn't start another goroutine while this one runs
+		s.inboundMu.Lock()
+		if len(inbound) == 0 {
+			s.inboundRunning = false
+			s.inboundMu.Unlock()
+			return
+		}
+		s.inboundMu.Unlock()
+	}
+}
@@ -0,0 +1,60 @@
+package phx
+
+import (
+	"context"
+	"errors"
+)
+
+// OnContext will register the given callback for all matching events received on this Channel, like On, but calls it
+// with a context that is canceled when the current join ends, or when the handler runs for longer than the Channel's
+// HandlerTimeout. Once the timeout passes a warning is logged and the handler is abandoned, so a stuck handler never
+// holds up anything waiting for it, but the handler should still return promptly once its context is done.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (c *Channel) OnContext(event string, callback func(ctx context.Context, payload any)) (bindingRef Ref) {
+	return c.On(event, func(payload any) {
+		c.runWithContext(event, func(ctx context.Context) {
+			callback(ctx, payload)
+		})
+	})
+}
+
+// runWithContext runs the given handler with a context tied to the current join and HandlerTimeout, returning once the
+// handler returns or the context is done.
+func (c *Channel) runWithContext(event string, handler func(ctx context.Context)) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.HandlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.HandlerTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	// In single-threaded mode the handler must run on the polling goroutine, so it can't be abandoned
+	if c.socket.ManualDispatch {
+		handler(ctx)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.socket.Logger.Printf(LogWarning, "channel", "handler for '%v' on '%v' exceeded timeout of %v",
+				event, c.topic, c.HandlerTimeout)
+		}
+		return
+	}
+
+	done := c.Done()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		handler(ctx)
+	}()
+
+	select {
+	case <-finished:
+	case <-done:
+		cancel()
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.socket.Logger.Printf(LogWarning, "channel", "handler for '%v' on '%v' exceeded timeout of %v, canceling",
+				event, c.topic, c.HandlerTimeout)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package phx
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxHandshakeBody is the number of bytes of the body of a refused websocket upgrade that are read.
+	maxHandshakeBody = 64 * 1024
+
+	// maxHandshakeSnippet is the number of bytes of the text of that body kept in a HandshakeError.
+	maxHandshakeSnippet = 512
+)
+
+var (
+	htmlTitle    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlHidden   = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlTag      = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlDocStart = regexp.MustCompile(`(?i)^\s*(<!doctype\s+html|<html)`)
+)
+
+// HandshakeError is a websocket upgrade that was refused with an HTTP response, by the server or by something between
+// it and the client. When the response is an HTML page, such as the block page of a corporate proxy or the login page
+// of a captive portal, its title and the start of its text are kept, so that it can be told apart from a refusal by
+// the server.
+type HandshakeError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+
+	// Via is the Via header of the response, which proxies add, if any.
+	Via string
+
+	// HTML is true if the response is an HTML page, which a Phoenix server doesn't send for a refused upgrade.
+	HTML bool
+
+	// Title is the title of the HTML page, if any.
+	Title string
+
+	// Snippet is the start of the text of the response, without HTML markup and with whitespace collapsed, up to 512
+	// bytes.
+	Snippet string
+
+	// Err is the error of the Dialer, such as websocket.ErrBadHandshake.
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "websocket handshake failed with HTTP status %d", e.StatusCode)
+	if e.Via != "" {
+		fmt.Fprintf(&b, " via %v", e.Via)
+	}
+	switch {
+	case e.HTML && e.Title != "":
+		fmt.Fprintf(&b, ", HTML page %q", e.Title)
+	case e.HTML:
+		b.WriteString(", HTML page")
+	}
+	if e.Snippet != "" && e.Snippet != e.Title {
+		fmt.Fprintf(&b, ": %v", e.Snippet)
+	}
+	return b.String()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// newHandshakeError returns the HandshakeError for the given response to a refused upgrade, reading a bounded part of
+// its body.
+func newHandshakeError(resp *http.Response, err error) *HandshakeError {
+	handshakeErr := &HandshakeError{StatusCode: resp.StatusCode, Via: resp.Header.Get("Via"), Err: err}
+	if resp.Body == nil {
+		return handshakeErr
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHandshakeBody))
+	_ = resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	handshakeErr.HTML = mediaType == "text/html" || htmlDocStart.Match(body)
+	text := body
+	if handshakeErr.HTML {
+		if title := htmlTitle.FindSubmatch(body); title != nil {
+			handshakeErr.Title = htmlText(title[1], maxHandshakeSnippet)
+		}
+		text = htmlTag.ReplaceAll(htmlHidden.ReplaceAll(body, nil), []byte(" "))
+	}
+	handshakeErr.Snippet = htmlText(text, maxHandshakeSnippet)
+	return handshakeErr
+}
+
+// htmlText returns the given text with HTML entities unescaped and whitespace collapsed, truncated to max bytes.
+func htmlText(text []byte, max int) string {
+	collapsed := strings.Join(strings.Fields(html.UnescapeString(string(bytes.ToValidUTF8(text, nil)))), " ")
+	if len(collapsed) <= max {
+		return collapsed
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(collapsed[cut]) {
+		cut--
+	}
+	return collapsed[:cut] + "…"
+}
@@ -0,0 +1,91 @@
+package phx
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HeartbeatReply is the reply of the server to a heartbeat, given to the OnHeartbeatReply callbacks.
+type HeartbeatReply struct {
+	// Status is the status of the reply, normally "ok".
+	Status string
+
+	// Response is the response of the reply. Phoenix replies with an empty map, but servers may piggyback health data
+	// on it, or echo a nonce sent by HeartbeatPayloadFunc.
+	Response any
+
+	// RoundTrip is the time from sending the heartbeat until its reply was received.
+	RoundTrip time.Duration
+}
+
+// OnHeartbeatReply registers the given callback to be called with every reply to a heartbeat.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnHeartbeatReply(callback func(HeartbeatReply)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.heartbeatReplyCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// heartbeatPayload returns the payload of the next heartbeat.
+func (s *Socket) heartbeatPayload() any {
+	if s.HeartbeatPayloadFunc == nil {
+		return nil
+	}
+	return s.HeartbeatPayloadFunc()
+}
+
+// callHeartbeatReplyCallbacks calls the OnHeartbeatReply callbacks with the given reply to a heartbeat sent at sentAt.
+func (s *Socket) callHeartbeatReplyCallbacks(msg *Message, sentAt time.Time) {
+	if s.Instrumenter != nil {
+		s.Instrumenter.Observe("phx.heartbeat.rtt", s.Clock.Now().Sub(sentAt).Seconds(), nil)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.heartbeatReplyCallbacks) == 0 {
+		return
+	}
+
+	reply := HeartbeatReply{RoundTrip: s.Clock.Now().Sub(sentAt)}
+	if payload, ok := msg.Payload.(map[string]any); ok {
+		reply.Status, _ = payload["status"].(string)
+		reply.Response = payload["response"]
+	}
+
+	for _, cb := range s.heartbeatReplyCallbacks {
+		cb := cb
+		s.run(func() { cb(reply) })
+	}
+}
+
+// noteWrite records that application data was just written, for HeartbeatSkipAfterWrite.
+func (s *Socket) noteWrite() {
+	if s.HeartbeatSkipAfterWrite > 0 {
+		atomic.StoreInt64(&s.lastWriteAt, s.Clock.Now().UnixNano())
+	}
+}
+
+// wroteRecently returns true if the next heartbeat can be skipped because application data was written within
+// HeartbeatSkipAfterWrite.
+func (s *Socket) wroteRecently() bool {
+	if s.HeartbeatSkipAfterWrite <= 0 {
+		return false
+	}
+	lastWriteAt := atomic.LoadInt64(&s.lastWriteAt)
+	return lastWriteAt != 0 && s.Clock.Now().Sub(time.Unix(0, lastWriteAt)) < s.HeartbeatSkipAfterWrite
+}
+
+// noteRead records that a message was just received.
+func (s *Socket) noteRead() {
+	atomic.StoreInt64(&s.lastReadAt, s.Clock.Now().UnixNano())
+}
+
+// readRecently returns true if a message was received within the last HeartbeatInterval, so that a heartbeat that
+// wasn't replied to yet doesn't mean the connection is dead.
+func (s *Socket) readRecently() bool {
+	lastReadAt := atomic.LoadInt64(&s.lastReadAt)
+	return lastReadAt != 0 && s.Clock.Now().Sub(time.Unix(0, lastReadAt)) < s.HeartbeatInterval
+}
@@ -0,0 +1,135 @@
+package phx
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// errConnectSuperseded ends the phx.connect span of an attempt that neither opened nor failed before the next one.
+	errConnectSuperseded = errors.New("superseded by another connection attempt")
+
+	// errPushResent ends the phx.push span of a push that was sent again before it was replied to.
+	errPushResent = errors.New("push was sent again")
+)
+
+// Instrumenter receives spans and metrics for the operations of a Socket and its Channels, such as to export them with
+// OpenTelemetry, Prometheus or StatsD. Set it with Socket.Instrumenter. The phxotel module provides one for
+// OpenTelemetry. Its methods are called from the goroutines of the Socket, some of them for every message, so they
+// must be safe for concurrent use and must not block.
+//
+// The spans are:
+//
+//   - phx.connect, for each connection attempt, from the start of dialing until the connection opens or fails.
+//     Attributes: "endpoint", "transport" and "features", the enabled Features.
+//   - phx.push, for each push, including joins and leaves, from sending it until its reply or timeout. It ends with a
+//     *ReplyError for an "error" reply, ErrPushTimeout for a timeout, and ErrPushReleased if the join of its Channel
+//     ended first. Attributes: "topic" and "event".
+//
+// The metrics are:
+//
+//   - phx.reconnects, a counter of the connections that reopened after being lost. Attributes: "attempts".
+//   - phx.reconnect.downtime, a histogram of the seconds the connection was down before reopening.
+//   - phx.replies, a counter of the replies to pushes. Attributes: "topic", "event" and "status", which is the status
+//     of the reply or "timeout".
+//   - phx.heartbeat.rtt, a histogram of the seconds from sending a heartbeat until its reply.
+//   - phx.messages.sent and phx.messages.received, counters of messages, and phx.bytes.sent and phx.bytes.received,
+//     counters of their encoded bytes, without attributes.
+type Instrumenter interface {
+	// StartSpan starts the span with the given name and attributes, and returns a function that ends it with the
+	// error the operation failed with, or nil if it succeeded.
+	StartSpan(name string, attrs map[string]any) (end func(err error))
+
+	// Count adds n to the counter with the given name.
+	Count(name string, n int64, attrs map[string]any)
+
+	// Observe records the given value in the histogram with the given name.
+	Observe(name string, value float64, attrs map[string]any)
+}
+
+// startConnectSpan starts the phx.connect span for a connection attempt to the given endpoint.
+func (s *Socket) startConnectSpan(endPoint string) {
+	if s.Instrumenter == nil {
+		return
+	}
+	end := s.Instrumenter.StartSpan("phx.connect", map[string]any{
+		"endpoint":  endPoint,
+		"transport": fmt.Sprintf("%T", s.Transport),
+		"features":  s.Features.String(),
+	})
+
+	s.mu.Lock()
+	previous := s.endConnectSpan
+	s.endConnectSpan = end
+	s.mu.Unlock()
+	if previous != nil {
+		previous(errConnectSuperseded)
+	}
+}
+
+// finishConnectSpan ends the phx.connect span of the current connection attempt, if any, with the given error.
+func (s *Socket) finishConnectSpan(err error) {
+	s.mu.Lock()
+	end := s.endConnectSpan
+	s.endConnectSpan = nil
+	s.mu.Unlock()
+	if end != nil {
+		end(err)
+	}
+}
+
+// instrumentReconnect records a connection that reopened after the given downtime and attempts.
+func (s *Socket) instrumentReconnect(downtime time.Duration, attempts int) {
+	if s.Instrumenter == nil {
+		return
+	}
+	s.Instrumenter.Count("phx.reconnects", 1, map[string]any{"attempts": attempts})
+	s.Instrumenter.Observe("phx.reconnect.downtime", downtime.Seconds(), nil)
+}
+
+// instrumentTraffic counts a message of the given size that was sent or received.
+func (s *Socket) instrumentTraffic(direction string, size int) {
+	if s.Instrumenter == nil {
+		return
+	}
+	s.Instrumenter.Count("phx.messages."+direction, 1, nil)
+	s.Instrumenter.Count("phx.bytes."+direction, int64(size), nil)
+}
+
+// startSpan starts the phx.push span of the Push. Must be called with mu locked.
+func (p *Push) startSpan() {
+	instrumenter := p.channel.socket.Instrumenter
+	if instrumenter == nil {
+		return
+	}
+	if p.endSpan != nil {
+		// Sent again before the previous send was replied to
+		p.endSpan(errPushResent)
+	}
+	p.endSpan = instrumenter.StartSpan("phx.push", map[string]any{"topic": p.channel.topic, "event": p.Event})
+}
+
+// finishSpan ends the phx.push span of the Push for a reply with the given status and response, or a timeout, and
+// counts the reply. Must be called with mu locked.
+func (p *Push) finishSpan(status string, response any) {
+	instrumenter := p.channel.socket.Instrumenter
+	if instrumenter == nil {
+		return
+	}
+	instrumenter.Count("phx.replies", 1, map[string]any{"topic": p.channel.topic, "event": p.Event, "status": status})
+
+	end := p.endSpan
+	p.endSpan = nil
+	if end == nil {
+		return
+	}
+	switch status {
+	case "timeout":
+		end(ErrPushTimeout)
+	case "error":
+		end(&ReplyError{Response: response})
+	default:
+		end(nil)
+	}
+}
@@ -0,0 +1,79 @@
+package phx
+
+import "errors"
+
+// ErrIntercepted is returned when sending a message that an Interceptor dropped.
+var ErrIntercepted = errors.New("message was dropped by an interceptor")
+
+// Direction is whether a Message was received from the server or is being sent to it.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// MessageHandler handles a Message received from or sent to the server. For an outbound message, the error is
+// returned to the sender; for an inbound message, it is logged and the message is dropped.
+type MessageHandler func(dir Direction, msg Message) error
+
+// Interceptor wraps the handling of every Message of a Socket, such as to log, filter, change or measure them. It
+// returns a MessageHandler that calls next to continue, possibly with a changed Message, or returns without calling it
+// to drop the message. next must be called before the handler returns.
+//
+// Inbound messages are intercepted in the Socket's reading goroutine after they were decoded, verified and
+// decompressed, and before they reach the heartbeat, OnMessage callbacks, Exporters and Channels, so the handler
+// should not block. Outbound messages, including joins and heartbeats, are intercepted in the sending goroutine before
+// they are compressed, signed and encoded, and calling next sends them.
+type Interceptor func(next MessageHandler) MessageHandler
+
+// Use adds the given interceptors to this Socket. Interceptors are called in the order they were added, so the first
+// interceptor added sees the message first, and the last one calls the Socket.
+func (s *Socket) Use(interceptors ...Interceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// hasInterceptors returns true if any interceptors were added.
+func (s *Socket) hasInterceptors() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.interceptors) > 0
+}
+
+// intercept passes the given message through the interceptors and then to the given handler. Returns ErrIntercepted
+// if an interceptor dropped it.
+func (s *Socket) intercept(dir Direction, msg Message, handler func(msg Message) error) error {
+	s.mu.RLock()
+	interceptors := s.interceptors
+	s.mu.RUnlock()
+	if len(interceptors) == 0 {
+		return handler(msg)
+	}
+
+	reached := false
+	next := MessageHandler(func(dir Direction, msg Message) error {
+		reached = true
+		return handler(msg)
+	})
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next = interceptors[i](next)
+	}
+	err := next(dir, msg)
+	if err == nil && !reached {
+		return ErrIntercepted
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package phx
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// joinBatchEvent is the event of the message sent by JoinBatch. It isn't part of the Phoenix protocol, so servers need
+// a plugin that handles it.
+const joinBatchEvent = "phx_join_batch"
+
+// JoinBatch joins the given Channels with a single "phx_join_batch" message on the "phoenix" topic instead of one join
+// per Channel, for clients that subscribe to thousands of topics. The payload of the message is
+//
+//	{"joins": [{"topic": topic, "join_ref": joinRef, "ref": ref, "payload": params}, ...]}
+//
+// The server is expected to handle each of the joins as if it had been sent on its own, replying to it on its topic,
+// and to reply "ok" to the batch. Phoenix servers without such a plugin reply to the batch with an error, in which case
+// the joins are sent one by one instead, as they are if the batch can't be sent or the socket isn't connected yet. If
+// the batch isn't replied to at all, each join times out and is retried on its own like any other. Rejoins after the
+// connection is lost are always sent one by one, as are all joins unless Socket.Features has FeatureJoinBatching.
+//
+// Returns the join Push of each Channel, in order. A Channel that can't be joined, such as one that is already joined,
+// is skipped with a nil Push, and the error of the first one is returned.
+func (s *Socket) JoinBatch(channels ...*Channel) ([]*Push, error) {
+	pushes := make([]*Push, len(channels))
+	var firstErr error
+	fail := func(c *Channel, err error) {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("joining '%v': %w", c.topic, err)
+		}
+	}
+
+	if !s.IsConnected() || !s.Features.Has(FeatureJoinBatching) {
+		for i, c := range channels {
+			push, err := c.Join()
+			if err != nil {
+				fail(c, err)
+			}
+			pushes[i] = push
+		}
+		return pushes, firstErr
+	}
+
+	batched := make([]*Push, 0, len(channels))
+	joins := make([]any, 0, len(channels))
+	for i, c := range channels {
+		push, msg, err := c.startBatchedJoin()
+		if err != nil {
+			fail(c, err)
+			continue
+		}
+		pushes[i] = push
+		batched = append(batched, push)
+		joins = append(joins, map[string]any{
+			"topic":    msg.Topic,
+			"join_ref": strconv.FormatUint(uint64(msg.JoinRef), 10),
+			"ref":      strconv.FormatUint(uint64(msg.Ref), 10),
+			"payload":  msg.Payload,
+		})
+	}
+	if len(batched) == 0 {
+		return pushes, firstErr
+	}
+
+	ref := s.MakeRef()
+	s.onControlReply(ref, s.PushTimeout, func(payload any) {
+		reply, _ := payload.(map[string]any)
+		if status, _ := reply["status"].(string); status != "ok" {
+			s.Logger.Printf(LogWarning, "socket", "join batch not supported by server (%v), joining one by one", reply["response"])
+			sendJoinsAlone(batched)
+		}
+	})
+
+	size, err := s.pushMessage(Message{Topic: "phoenix", Event: joinBatchEvent, Payload: map[string]any{"joins": joins}, Ref: ref}, false)
+	if err != nil {
+		s.Logger.Printf(LogWarning, "socket", "could not send join batch (%v), joining one by one", err)
+		sendJoinsAlone(batched)
+		return pushes, firstErr
+	}
+	for _, push := range batched {
+		push.markSent(size / len(batched))
+	}
+
+	return pushes, firstErr
+}
+
+// startBatchedJoin starts joining like Join, and prepares the join Push to be sent as part of a batch.
+func (c *Channel) startBatchedJoin() (*Push, Message, error) {
+	c.joinMu.Lock()
+	defer c.joinMu.Unlock()
+
+	push, err := c.startJoin()
+	if err != nil {
+		return nil, Message{}, err
+	}
+	msg, err := push.prepare()
+	if err != nil {
+		c.setState(ChannelClosed)
+		return nil, Message{}, err
+	}
+	return push, msg, nil
+}
+
+// sendJoinsAlone sends each of the given join Pushes on its own, if its Channel is still waiting for it.
+func sendJoinsAlone(pushes []*Push) {
+	for _, push := range pushes {
+		push.channel.sendJoinAlone(push)
+	}
+}
+
+func (c *Channel) sendJoinAlone(push *Push) {
+	c.joinMu.Lock()
+	defer c.joinMu.Unlock()
+
+	if !c.IsJoining() || c.getJoinPush() != push || !c.socket.IsConnected() {
+		return
+	}
+	err := push.Send()
+	if err != nil {
+		c.socket.Logger.Println(LogError, "channel", "error on join push", err)
+		c.setState(ChannelErrored)
+		c.socket.run(c.rejoinTimer.Run)
+	}
+}
+
+// onControlReply registers the given callback for the reply to the message with the given ref on the "phoenix" topic.
+// The callback is forgotten if there is no reply within the timeout.
+func (s *Socket) onControlReply(ref Ref, timeout time.Duration, callback func(payload any)) {
+	s.mu.Lock()
+	s.controlReplies[ref] = callback
+	s.mu.Unlock()
+
+	s.Clock.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		delete(s.controlReplies, ref)
+		s.mu.Unlock()
+	})
+}
+
+// handleControlReply calls the callback registered with onControlReply for the given reply. Returns false if there is
+// none.
+func (s *Socket) handleControlReply(msg *Message) bool {
+	s.mu.Lock()
+	callback, ok := s.controlReplies[msg.Ref]
+	delete(s.controlReplies, msg.Ref)
+	s.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.run(func() { callback(msg.Payload) })
+	return true
+}
@@ -0,0 +1,95 @@
+package phx
+
+// JoinInterceptor takes part in joining Channels, so that authentication and entitlement layers can be packaged as
+// reusable middleware instead of being repeated in every application. Either function may be nil.
+type JoinInterceptor struct {
+	// Params is called before every join and rejoin with the topic and the params the join would be sent with, and
+	// returns the params to send instead, such as with a freshly issued token added. The given map is a copy and may
+	// be modified. Returning an error aborts the join.
+	Params func(topic string, params map[string]string) (map[string]string, error)
+
+	// Reply is called with the status and response of the reply to a join before the Channel or any Receive handlers
+	// see it, and returns the status and response to use instead. It can, for example, remove credentials from the
+	// response, or turn an "ok" into an "error" when an entitlement is missing.
+	Reply func(topic string, status string, response any) (string, any)
+}
+
+// InterceptJoins adds the given interceptor to the joins of every Channel of this Socket, including Channels that
+// were already created.
+func (s *Socket) InterceptJoins(interceptor JoinInterceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.joinInterceptors = append(s.joinInterceptors, interceptor)
+}
+
+// InterceptJoins adds the given interceptor to the joins of this Channel. Params interceptors are called in the order
+// they were added, starting with those of the Socket, and Reply interceptors in the reverse order, so that the first
+// interceptor added sees the params first and the reply last.
+func (c *Channel) InterceptJoins(interceptor JoinInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.joinInterceptors = append(c.joinInterceptors, interceptor)
+}
+
+// interceptors returns the join interceptors of the Socket followed by those of this Channel.
+func (c *Channel) interceptors() []JoinInterceptor {
+	c.socket.mu.RLock()
+	interceptors := append([]JoinInterceptor(nil), c.socket.joinInterceptors...)
+	c.socket.mu.RUnlock()
+
+	c.mu.RLock()
+	interceptors = append(interceptors, c.joinInterceptors...)
+	c.mu.RUnlock()
+
+	return interceptors
+}
+
+// joinParams returns the params to send a join with after passing them through the Params interceptors.
+func (c *Channel) joinParams() (map[string]string, error) {
+	interceptors := c.interceptors()
+	if len(interceptors) == 0 {
+		return c.params, nil
+	}
+
+	params := make(map[string]string, len(c.params))
+	for key, value := range c.params {
+		params[key] = value
+	}
+	for _, interceptor := range interceptors {
+		if interceptor.Params == nil {
+			continue
+		}
+		var err error
+		params, err = interceptor.Params(c.topic, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// interceptJoinReply passes the given reply payload of a join through the Reply interceptors and returns the payload
+// to use instead.
+func (c *Channel) interceptJoinReply(payload any) any {
+	interceptors := c.interceptors()
+	if len(interceptors) == 0 {
+		return payload
+	}
+
+	m, ok := payload.(map[string]any)
+	if !ok {
+		return payload
+	}
+	status, _ := m["status"].(string)
+	response := m["response"]
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		if interceptors[i].Reply == nil {
+			continue
+		}
+		status, response = interceptors[i].Reply(c.topic, status, response)
+	}
+
+	return map[string]any{"status": status, "response": response}
+}
@@ -0,0 +1,35 @@
+package phx
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// The roles of the goroutines started by Sockets and Transports, given in their "phx.role" pprof label.
+const (
+	roleReader     = "reader"
+	roleWriter     = "writer"
+	roleConnMgr    = "connmgr"
+	roleDispatcher = "dispatcher"
+	roleHeartbeat  = "heartbeat"
+	roleReconnect  = "reconnect"
+	roleExporter   = "exporter"
+)
+
+// goLabeled calls the given function in a new goroutine with the given pprof labels, so that CPU and blocking profiles
+// attribute its time to this client instead of an anonymous goroutine. Goroutines it starts inherit the labels.
+func goLabeled(labels pprof.LabelSet, fn func()) {
+	go pprof.Do(context.Background(), labels, func(context.Context) { fn() })
+}
+
+// implements TransportHandler
+
+// profilerLabels returns the pprof labels of the goroutines with the given role started for this Socket: "phx.socket"
+// with its Name, or the host of its EndPoint if it has none, and "phx.role" with the role.
+func (s *Socket) profilerLabels(role string) pprof.LabelSet {
+	name := s.Name
+	if endPoint, _ := s.endPoint(); name == "" && endPoint != nil {
+		name = endPoint.Host
+	}
+	return pprof.Labels("phx.socket", name, "phx.role", role)
+}
@@ -0,0 +1,87 @@
+package phx
+
+import (
+	"sync/atomic"
+)
+
+// sendLane is a queue of messages sharing an ordering key, which are sent in order by their own goroutine.
+type sendLane struct {
+	queue   []laneMessage
+	running bool
+}
+
+type laneMessage struct {
+	data    []byte
+	binary  bool
+	onError func(error)
+}
+
+// pushMessageInLane passes the given message through the interceptors, encodes it and queues it in the lane for the given ordering key. Messages with the
+// same key are handed to the Transport in order, but messages with different keys are handed over independently, so a
+// key that is blocked, such as by a full send queue, doesn't hold up the others. Errors from the Transport are passed
+// to onError. Returns the size of the encoded message.
+func (s *Socket) pushMessageInLane(msg Message, key string, onError func(error)) (int, error) {
+	size := 0
+	err := s.intercept(Outbound, msg, func(msg Message) error {
+		var err error
+		size, err = s.queueInLane(msg, key, onError)
+		return err
+	})
+	return size, err
+}
+
+// queueInLane encodes the given message and queues it in the lane for the given ordering key.
+func (s *Socket) queueInLane(msg Message, key string, onError func(error)) (int, error) {
+	data, binary, err := s.encode(&msg)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	lane, exists := s.lanes[key]
+	if !exists {
+		lane = &sendLane{}
+		s.lanes[key] = lane
+	}
+	lane.queue = append(lane.queue, laneMessage{data: data, binary: binary, onError: onError})
+	start := !lane.running
+	lane.running = true
+	s.mu.Unlock()
+
+	atomic.AddInt64(&s.laneBytes, int64(len(data)))
+	s.checkMemory()
+
+	if start {
+		goLabeled(s.profilerLabels(roleWriter), func() { s.drainLane(key, lane) })
+	}
+
+	s.Logger.Printf(LogDebug, "socket", "Queued message in lane '%v' %+v", key, msg)
+	return len(data), nil
+}
+
+// drainLane sends the messages in the given lane until it is empty, then removes the lane.
+func (s *Socket) drainLane(key string, lane *sendLane) {
+	for {
+		s.mu.Lock()
+		if len(lane.queue) == 0 {
+			lane.running = false
+			delete(s.lanes, key)
+			s.mu.Unlock()
+			return
+		}
+		msg := lane.queue[0]
+		lane.queue = lane.queue[1:]
+		s.mu.Unlock()
+		atomic.AddInt64(&s.laneBytes, -int64(len(msg.data)))
+
+		err := s.send(msg.data, msg.binary, false)
+		if err != nil {
+			s.Logger.Printf(LogError, "socket", "error sending message in lane '%v': %v", key, err)
+			if msg.onError != nil {
+				msg.onError(err)
+			}
+			continue
+		}
+		s.noteWrite()
+	}
+}
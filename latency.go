@@ -0,0 +1,125 @@
+package phx
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencySubBucketBits sets the precision of LatencyHistogram: each power of two is split into 2^(bits-1) buckets, so
+// values are recorded with a relative error of at most 1/16.
+const latencySubBucketBits = 5
+
+// LatencyHistogram is a snapshot of the distribution of latencies, recorded in microseconds in log-linear buckets
+// like an HDR histogram, so that percentiles stay accurate to a few percent across any range of values while taking
+// little memory.
+type LatencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// Count returns the number of recorded latencies.
+func (h LatencyHistogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns the lowest recorded latency.
+func (h LatencyHistogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the highest recorded latency.
+func (h LatencyHistogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the average of the recorded latencies.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns the latency below which the given percentage of the recorded latencies fall, such as 99 for the
+// 99th percentile.
+func (h LatencyHistogram) Percentile(percent float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if percent <= 0 {
+		return h.min
+	}
+
+	rank := uint64(math.Ceil(percent / 100 * float64(h.count)))
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			value := time.Duration(latencyBucketHigh(i)) * time.Microsecond
+			if value > h.max {
+				return h.max
+			}
+			if value < h.min {
+				return h.min
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+// record adds the given latency to the histogram.
+func (h *LatencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	i := latencyBucket(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		counts := make([]uint64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// clone returns a copy of the histogram that doesn't change when the original does.
+func (h LatencyHistogram) clone() LatencyHistogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+// latencyBucket returns the index of the bucket for the given value. Values below 2^bits each have their own bucket,
+// and above that each power of two has 2^(bits-1) buckets.
+func latencyBucket(value uint64) int {
+	if value < 1<<latencySubBucketBits {
+		return int(value)
+	}
+	shift := bits.Len64(value) - latencySubBucketBits
+	mantissa := value >> shift
+	return 1<<latencySubBucketBits + (shift-1)<<(latencySubBucketBits-1) + int(mantissa) - 1<<(latencySubBucketBits-1)
+}
+
+// latencyBucketHigh returns the highest value of the bucket with the given index.
+func latencyBucketHigh(i int) uint64 {
+	if i < 1<<latencySubBucketBits {
+		return uint64(i)
+	}
+	i -= 1 << latencySubBucketBits
+	shift := i>>(latencySubBucketBits-1) + 1
+	mantissa := uint64(i&(1<<(latencySubBucketBits-1)-1)) + 1<<(latencySubBucketBits-1)
+	return (mantissa+1)<<shift - 1
+}
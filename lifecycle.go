@@ -0,0 +1,105 @@
+package phx
+
+// Done returns a channel that is closed when the current join of this Channel ends, because it was left, closed by the
+// server, gave up joining or was removed. Once it is closed, no more bindings are called for events from the server,
+// even if they were already received, and the Channel's timers are stopped. Its pushes that were waiting for replies,
+// or buffered until it was joined, are released: their "timeout" callbacks are called right away, and Await returns
+// ErrPushReleased. Joining again starts a new Done channel.
+func (c *Channel) Done() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.done
+}
+
+// isDone returns true if the given Done channel is closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// renewDone starts a new Done channel if the current one is closed, such as when joining again after leaving.
+func (c *Channel) renewDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if isDone(c.done) {
+		c.done = make(chan struct{})
+	}
+}
+
+// release ends the current join of this Channel by closing the Done channel and releasing everything tied to it:
+// buffered pushes, pending replies, limited pushes and scheduled pushes. See Push.release.
+func (c *Channel) release() {
+	c.rejoinTimer.Reset()
+	c.releaseJoin()
+}
+
+// releaseJoin releases like release, without resetting the rejoinTimer, so that it may be called with joinMu locked.
+func (c *Channel) releaseJoin() {
+	buffer := c.takePushBuffer()
+
+	c.mu.Lock()
+	if !isDone(c.done) {
+		close(c.done)
+	}
+	pending := make([]*Push, 0, len(c.pending))
+	for push := range c.pending {
+		pending = append(pending, push)
+	}
+	limiters := c.limiters
+	c.limiters = make(map[limiterKey]*LimitedPush)
+	scheduled := c.scheduled
+	c.scheduled = make(map[*ScheduledPush]struct{})
+	c.mu.Unlock()
+
+	for _, lp := range limiters {
+		lp.Stop()
+	}
+	for sp := range scheduled {
+		sp.Cancel()
+	}
+	for _, push := range buffer {
+		// The buffered pushes are lost, either released now or already timed out while waiting for the join
+		reason := DropReleased
+		if !push.release(true) {
+			reason = DropExpired
+		}
+		c.socket.drop(reason, c.topic, push.Event)
+	}
+	for _, push := range pending {
+		push.release(false)
+	}
+
+	// Bindings with a ref are waiting for replies to pushes, which will never be processed now
+	c.bindingsMu.Lock()
+	for ref, binding := range c.bindings {
+		if binding.ref != 0 {
+			delete(c.bindings, ref)
+		}
+	}
+	c.bindingsMu.Unlock()
+}
+
+// trackPending adds the given Push to the pushes waiting for replies, which are released when the join ends, or removes
+// it once it is settled.
+func (c *Channel) trackPending(push *Push, pending bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pending {
+		c.pending[push] = struct{}{}
+	} else {
+		delete(c.pending, push)
+	}
+}
+
+// isLifecycleEvent returns true for the events that report the end of a join, whose bindings must still be called
+// after the Done channel is closed.
+func isLifecycleEvent(event string) bool {
+	return event == string(CloseEvent) || event == string(ErrorEvent) || event == joinGiveUpEvent
+}
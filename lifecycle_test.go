@@ -0,0 +1,81 @@
+package phx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+	"github.com/ongkong/phxx/phxtest"
+)
+
+func noReply(msg phxtest.ServerMessage) phxtest.Reply {
+	return phxtest.Reply{NoReply: true}
+}
+
+func TestChannelLeaveReleasesPendingPushes(t *testing.T) {
+	server, _, channel := joinedChannel(t, "room:1")
+	server.Handle("room:*", "slow", noReply)
+	channel.PushTimeout = time.Minute
+
+	push, err := channel.Push("slow", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitFor("room:1", "slow", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	timeouts := make(chan struct{}, 1)
+	push.OnTimeout(func() { timeouts <- struct{}{} })
+
+	if _, err := channel.Leave(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := push.AwaitCtx(ctx); !errors.Is(err, phx.ErrPushReleased) {
+		t.Fatalf("got %v, want ErrPushReleased", err)
+	}
+	receive(t, timeouts)
+	if !push.IsReleased() || !push.IsTimedOut() {
+		t.Errorf("push released %v, timed out %v, want both", push.IsReleased(), push.IsTimedOut())
+	}
+}
+
+func TestChannelLeaveReleasesBufferedPushes(t *testing.T) {
+	server := phxtest.NewServer()
+	server.Handle("room:*", string(phx.JoinEvent), noReply)
+	socket, _ := server.NewSocket()
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	drops := make(chan phx.DropEvent, 1)
+	socket.OnDrop(func(drop phx.DropEvent) { drops <- drop })
+	channel := socket.Channel("room:1", nil)
+	channel.PushTimeout = time.Minute
+	if _, err := channel.Join(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Still joining, so the push is buffered until the join is replied to, which it never is
+	push, err := channel.Push("new_msg", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if push.IsSent() {
+		t.Fatal("push was sent before joining")
+	}
+	if _, err := channel.Leave(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := push.Await(time.Second); !errors.Is(err, phx.ErrPushReleased) {
+		t.Fatalf("got %v, want ErrPushReleased", err)
+	}
+	drop := receive(t, drops)
+	if drop.Reason != phx.DropReleased || drop.Topic != "room:1" || drop.Event != "new_msg" {
+		t.Errorf("dropped %+v, want new_msg on room:1 released", drop)
+	}
+}
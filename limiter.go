@@ -0,0 +1,161 @@
+package phx
+
+import (
+	"sync"
+	"time"
+)
+
+// LimitedPush collapses rapid pushes of one event on a Channel, such as "typing" notifications or cursor moves, into
+// at most one push per interval with the latest payload. Create one with Channel.Debounce or Channel.Throttle.
+type LimitedPush struct {
+	mu         sync.Mutex
+	channel    *Channel
+	event      string
+	interval   time.Duration
+	debounce   bool
+	timer      Timer
+	generation uint64
+	pending    any
+	hasPending bool
+}
+
+// limiterKey identifies a LimitedPush of a Channel, so that pushes of one event debounced or throttled with different
+// intervals don't share a LimitedPush.
+type limiterKey struct {
+	event    string
+	interval time.Duration
+	debounce bool
+}
+
+// Debounce returns a LimitedPush for the given event that only pushes once no new payload has been given for the
+// interval, with the latest payload. Calling it again for the same event and interval returns the same LimitedPush.
+func (c *Channel) Debounce(event string, interval time.Duration) *LimitedPush {
+	return c.limitedPush(event, interval, true)
+}
+
+// Throttle returns a LimitedPush for the given event that pushes the first payload right away, and then at most once
+// per interval with the latest payload. Calling it again for the same event and interval returns the same
+// LimitedPush.
+func (c *Channel) Throttle(event string, interval time.Duration) *LimitedPush {
+	return c.limitedPush(event, interval, false)
+}
+
+func (c *Channel) limitedPush(event string, interval time.Duration, debounce bool) *LimitedPush {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := limiterKey{event: event, interval: interval, debounce: debounce}
+	lp, exists := c.limiters[key]
+	if exists {
+		return lp
+	}
+
+	lp = &LimitedPush{
+		channel:  c,
+		event:    event,
+		interval: interval,
+		debounce: debounce,
+	}
+	c.limiters[key] = lp
+	return lp
+}
+
+// Push gives a new payload to be pushed, replacing any payload not yet pushed.
+func (lp *LimitedPush) Push(payload any) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	if lp.hasPending {
+		lp.channel.socket.drop(DropConflated, lp.channel.topic, lp.event)
+	}
+
+	if lp.debounce {
+		lp.pending = payload
+		lp.hasPending = true
+		lp.stopTimer()
+		lp.startTimer()
+		return
+	}
+
+	if lp.timer != nil {
+		// Within the interval, so wait for the timer to push the latest payload
+		lp.pending = payload
+		lp.hasPending = true
+		return
+	}
+
+	lp.send(payload)
+	lp.startTimer()
+}
+
+// Flush pushes any pending payload right away.
+func (lp *LimitedPush) Flush() {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	lp.stopTimer()
+	if lp.hasPending {
+		lp.send(lp.pending)
+		lp.pending = nil
+		lp.hasPending = false
+	}
+}
+
+// Stop discards any pending payload without pushing it.
+func (lp *LimitedPush) Stop() {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	lp.stopTimer()
+	lp.pending = nil
+	lp.hasPending = false
+}
+
+// startTimer starts the timer of a new interval. Must be called with lp.mu held.
+func (lp *LimitedPush) startTimer() {
+	lp.generation++
+	generation := lp.generation
+	lp.timer = lp.channel.socket.Clock.AfterFunc(lp.interval, func() { lp.fire(generation) })
+}
+
+// stopTimer stops the timer of the current interval, if any. A timer that already fired but is still waiting for
+// lp.mu finds that its generation is no longer current, and does nothing. Must be called with lp.mu held.
+func (lp *LimitedPush) stopTimer() {
+	if lp.timer != nil {
+		lp.timer.Stop()
+		lp.timer = nil
+	}
+	lp.generation++
+}
+
+// fire is called by the timer of the given generation at the end of an interval. It runs in the Timer's goroutine.
+func (lp *LimitedPush) fire(generation uint64) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	if generation != lp.generation {
+		// Stopped or replaced by a newer timer while we were waiting for the lock
+		return
+	}
+
+	lp.timer = nil
+	if !lp.hasPending {
+		return
+	}
+
+	lp.send(lp.pending)
+	lp.pending = nil
+	lp.hasPending = false
+
+	if !lp.debounce {
+		// Start a new interval since we just pushed
+		lp.startTimer()
+	}
+}
+
+func (lp *LimitedPush) send(payload any) {
+	_, err := lp.channel.Push(lp.event, payload)
+	if err != nil {
+		lp.channel.socket.Logger.Printf(LogError, "channel", "error sending limited push '%v' on '%v': %v", lp.event, lp.channel.topic, err)
+	}
+}
@@ -0,0 +1,43 @@
+package phx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceIgnoresStaleTimer(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	channel := socket.Channel("room:1", nil)
+	lp := channel.Debounce("typing", time.Minute)
+	defer lp.Stop()
+
+	lp.Push(1)
+	lp.mu.Lock()
+	stale := lp.generation
+	lp.mu.Unlock()
+
+	// A timer replaced by the next Push that fired anyway, and was waiting for the lock
+	lp.Push(2)
+	lp.fire(stale)
+
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if !lp.hasPending || lp.pending != 2 || lp.timer == nil {
+		t.Errorf("stale timer pushed: pending %v %v, timer %v", lp.hasPending, lp.pending, lp.timer)
+	}
+}
+
+func TestLimitedPushKeyedByModeAndInterval(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	channel := socket.Channel("room:1", nil)
+
+	if channel.Debounce("typing", time.Second) != channel.Debounce("typing", time.Second) {
+		t.Error("got a different LimitedPush for the same event and interval")
+	}
+	if channel.Debounce("typing", time.Second) == channel.Debounce("typing", time.Minute) {
+		t.Error("got the same LimitedPush for another interval")
+	}
+	if channel.Debounce("typing", time.Second) == channel.Throttle("typing", time.Second) {
+		t.Error("got the same LimitedPush for Throttle and Debounce")
+	}
+}
@@ -0,0 +1,124 @@
+package phx
+
+import (
+	"sync"
+)
+
+// LiveViewParams are what a LiveView is joined with, taken from the page the server rendered for it.
+type LiveViewParams struct {
+	// URL is the URL of the page.
+	URL string
+
+	// Session and Static are the signed "data-phx-session" and "data-phx-static" attributes of the LiveView's container.
+	Session string
+	Static  string
+
+	// Params are the connect params, such as "_csrf_token" from the page's csrf-token meta tag. "_mounts" is added
+	// with the number of times the LiveView was joined before.
+	Params map[string]any
+}
+
+// LiveView joins a Phoenix LiveView over a Socket, side by side with plain Channels and Absinthe subscriptions, as a
+// headless client for testing or automating LiveViews. It doesn't render, but gives the rendered tree and every diff
+// the server sends to the OnDiff callbacks, and sends events with PushEvent.
+type LiveView struct {
+	// Channel is the "lv:" Channel of the LiveView, which must be joined with Join.
+	Channel *Channel
+
+	mu            sync.Mutex
+	mounts        int
+	diffCallbacks map[Ref]func(diff map[string]any)
+}
+
+// NewLiveView creates a LiveView for the container with the given id, which is the "id" attribute of the element with
+// "data-phx-main" or "data-phx-session" on the page.
+func NewLiveView(socket *Socket, id string, params LiveViewParams) *LiveView {
+	lv := &LiveView{
+		Channel:       socket.Channel("lv:"+id, nil),
+		diffCallbacks: make(map[Ref]func(diff map[string]any)),
+	}
+	lv.Channel.joinPayload = func(map[string]string) any {
+		lv.mu.Lock()
+		connectParams := make(map[string]any, len(params.Params)+1)
+		for key, value := range params.Params {
+			connectParams[key] = value
+		}
+		connectParams["_mounts"] = lv.mounts
+		lv.mounts++
+		lv.mu.Unlock()
+
+		return map[string]any{
+			"url":     params.URL,
+			"session": params.Session,
+			"static":  params.Static,
+			"params":  connectParams,
+		}
+	}
+	lv.Channel.OnJoin(func(response any) {
+		reply, _ := response.(map[string]any)
+		lv.notifyDiff(reply["rendered"])
+	})
+	lv.Channel.On("diff", lv.notifyDiff)
+	return lv
+}
+
+// Join joins the LiveView, which mounts it on the server.
+func (lv *LiveView) Join() (*Push, error) {
+	return lv.Channel.Join()
+}
+
+// OnDiff registers the given callback to be called with the rendered tree when the LiveView joins, and with every diff
+// to it that the server sends, whether on its own or in the reply to an event.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (lv *LiveView) OnDiff(callback func(diff map[string]any)) Ref {
+	ref := lv.Channel.refGenerator.nextRef()
+	lv.mu.Lock()
+	lv.diffCallbacks[ref] = callback
+	lv.mu.Unlock()
+	return ref
+}
+
+// Off removes the callback for the given Ref, as returned by OnDiff.
+func (lv *LiveView) Off(ref Ref) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	delete(lv.diffCallbacks, ref)
+}
+
+// PushEvent sends an event to the LiveView, as a phx-click, phx-submit or similar binding would, where eventType is
+// the type of the binding, such as "click" or "form", and value is its value. A diff in the reply is given to the
+// OnDiff callbacks.
+func (lv *LiveView) PushEvent(eventType string, event string, value any) (*Push, error) {
+	push, err := lv.Channel.Push("event", map[string]any{
+		"type":  eventType,
+		"event": event,
+		"value": value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	push.Receive("ok", func(response any) {
+		reply, _ := response.(map[string]any)
+		lv.notifyDiff(reply["diff"])
+	})
+	return push, nil
+}
+
+func (lv *LiveView) notifyDiff(payload any) {
+	diff, ok := payload.(map[string]any)
+	if !ok {
+		return
+	}
+
+	lv.mu.Lock()
+	callbacks := make([]func(map[string]any), 0, len(lv.diffCallbacks))
+	for _, cb := range lv.diffCallbacks {
+		callbacks = append(callbacks, cb)
+	}
+	lv.mu.Unlock()
+
+	for _, cb := range callbacks {
+		cb(diff)
+	}
+}
@@ -3,6 +3,7 @@ package phx
 import (
 	"fmt"
 	"log"
+	"strings"
 )
 
 type LoggerLevel int
@@ -34,6 +35,37 @@ type Logger interface {
 	Printf(level LoggerLevel, kind string, format string, v ...any)
 }
 
+// FieldLogger is a Logger that also takes messages with structured fields, given as alternating keys and values like
+// log/slog. The library logs the details of connection and transport events as fields to Loggers that implement it,
+// and formats them into the message for those that don't. See NewSlogLogger.
+type FieldLogger interface {
+	Logger
+	Log(level LoggerLevel, kind string, msg string, fields ...any)
+}
+
+// logFields logs the given message and fields to the given Logger, as fields if it is a FieldLogger.
+func logFields(logger Logger, level LoggerLevel, kind string, msg string, fields ...any) {
+	if fl, ok := logger.(FieldLogger); ok {
+		fl.Log(level, kind, msg, fields...)
+		return
+	}
+	logger.Print(level, kind, formatFields(msg, fields))
+}
+
+// formatFields formats the given message and fields as "msg key=value key=value".
+func formatFields(msg string, fields []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", fields[i])
+		}
+	}
+	return b.String()
+}
+
 // NoopLogger is a logger that does nothing
 type NoopLogger int
 
@@ -44,6 +76,7 @@ func NewNoopLogger() *NoopLogger {
 func (l *NoopLogger) Print(_ LoggerLevel, _ string, _ ...any)            {}
 func (l *NoopLogger) Println(_ LoggerLevel, _ string, _ ...any)          {}
 func (l *NoopLogger) Printf(_ LoggerLevel, _ string, _ string, _ ...any) {}
+func (l *NoopLogger) Log(_ LoggerLevel, _ string, _ string, _ ...any)    {}
 
 // CustomLogger is a logger that logs to the given log.Logger if the message is >= logLevel
 type CustomLogger struct {
@@ -84,6 +117,12 @@ func (l *CustomLogger) Printf(level LoggerLevel, kind string, format string, v .
 	}
 }
 
+func (l *CustomLogger) Log(level LoggerLevel, kind string, msg string, fields ...any) {
+	if level >= l.logLevel {
+		l.logger.Print(l.formatLevel(level), " ", l.formatKind(kind), " ", formatFields(msg, fields))
+	}
+}
+
 // NewSimpleLogger returns a CustomLogger that uses the 'log' package's DefaultLogger to log messages above the given logLevel
 func NewSimpleLogger(logLevel LoggerLevel) *CustomLogger {
 	return &CustomLogger{
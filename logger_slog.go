@@ -0,0 +1,66 @@
+//go:build go1.21
+
+package phx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// SlogLogger is a FieldLogger that logs to a log/slog Logger, with the kind of each message as the "kind" attribute.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a SlogLogger that logs to the given slog Logger, or to slog.Default() if nil.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Print(level LoggerLevel, kind string, v ...any) {
+	if l.enabled(level) {
+		l.logger.Log(context.Background(), slogLevel(level), fmt.Sprint(v...), "kind", kind)
+	}
+}
+
+func (l *SlogLogger) Println(level LoggerLevel, kind string, v ...any) {
+	if l.enabled(level) {
+		msg := fmt.Sprintln(v...)
+		l.logger.Log(context.Background(), slogLevel(level), msg[:len(msg)-1], "kind", kind)
+	}
+}
+
+func (l *SlogLogger) Printf(level LoggerLevel, kind string, format string, v ...any) {
+	if l.enabled(level) {
+		msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+		l.logger.Log(context.Background(), slogLevel(level), msg, "kind", kind)
+	}
+}
+
+func (l *SlogLogger) Log(level LoggerLevel, kind string, msg string, fields ...any) {
+	if l.enabled(level) {
+		l.logger.Log(context.Background(), slogLevel(level), msg, append([]any{"kind", kind}, fields...)...)
+	}
+}
+
+func (l *SlogLogger) enabled(level LoggerLevel) bool {
+	return l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+// slogLevel returns the slog level for the given LoggerLevel.
+func slogLevel(level LoggerLevel) slog.Level {
+	switch level {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogInfo:
+		return slog.LevelInfo
+	case LogWarning:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
@@ -0,0 +1,517 @@
+package phx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errLongPollReconnect ends a LongPoll session when Reconnect is called.
+var errLongPollReconnect = errors.New("reconnect requested")
+
+// LongPoll is a Transport that connects to the server via Phoenix's long polling transport, for environments where
+// websockets are blocked, such as by corporate proxies. Use it by setting Socket.Transport to NewLongPoll(socket).
+//
+// The session is opened with a poll that the server answers with a token, which identifies the session in every
+// request after it. Messages from the server are received by polling, with one poll open at all times, and messages to
+// the server are sent with POSTs, batching the messages queued while the previous POST was in flight as
+// newline-delimited JSON, which needs Phoenix 1.7.2 or later. When the session ends, a new one is opened with the same
+// backoff as a Websocket.
+type LongPoll struct {
+	// Client makes the HTTP requests. Defaults to http.DefaultClient.
+	Client  *http.Client
+	Handler TransportHandler
+
+	// PollTimeout is how long a poll may take before it is considered lost. It must be longer than the time the server
+	// holds polls open, which is 10 seconds by default in Phoenix. Defaults to 20 seconds.
+	PollTimeout time.Duration
+
+	endPoint       *url.URL
+	requestHeader  http.Header
+	connectTimeout time.Duration
+	target         *url.URL
+	targetHeader   http.Header
+	token          string
+	done           chan struct{}
+	reconnect      chan struct{}
+	send           chan []byte
+	sendPolicy     OverflowPolicy
+	cancelPoll     context.CancelFunc
+	mu             sync.RWMutex
+	started        bool
+	open           bool
+	wasOpen        bool        // whether a session opened since Connect, so that losing it is reported as reconnecting
+	ready          readySignal // closed while connected, guarded by mu
+	queuedBytes    int64
+}
+
+// longPollResponse is the body of the server's responses to polls and POSTs.
+type longPollResponse struct {
+	Status   int               `json:"status"`
+	Token    string            `json:"token"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+func NewLongPoll(handler TransportHandler) *LongPoll {
+	return &LongPoll{
+		Client:      http.DefaultClient,
+		Handler:     handler,
+		PollTimeout: defaultLongPollTimeout,
+	}
+}
+
+// implements Transport
+
+func (l *LongPoll) Connect(endPoint *url.URL, requestHeader http.Header, connectTimeout time.Duration) error {
+	if l.isStarted() {
+		return errors.New("connect was already called")
+	}
+
+	newEndpoint, err := longPollEndpoint(endPoint)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.endPoint = newEndpoint
+	l.requestHeader = requestHeader
+	l.connectTimeout = connectTimeout
+	l.done = make(chan struct{})
+	// reconnect is buffered, so that asking for a reconnect never waits for the connectionManager
+	l.reconnect = make(chan struct{}, 1)
+	size, policy := l.Handler.sendQueue()
+	l.send = make(chan []byte, size)
+	l.sendPolicy = policy
+	l.started = true
+	l.wasOpen = false
+	l.ready.set(l.started && l.open)
+	l.mu.Unlock()
+	atomic.StoreInt64(&l.queuedBytes, 0)
+	l.Handler.onConnStateChange()
+
+	done, reconnect, send := l.done, l.reconnect, l.send
+	goLabeled(l.Handler.profilerLabels(roleConnMgr), func() { l.connectionManager(done, reconnect) })
+	goLabeled(l.Handler.profilerLabels(roleWriter), func() { l.connectionWriter(done, send) })
+	return nil
+}
+
+// SwitchEndpoint implements EndpointSwitcher, connecting to the given endpoint from the next session.
+func (l *LongPoll) SwitchEndpoint(endPoint *url.URL, requestHeader http.Header) error {
+	newEndpoint, err := longPollEndpoint(endPoint)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.endPoint = newEndpoint
+	l.requestHeader = requestHeader
+	return nil
+}
+
+// longPollEndpoint returns the long polling url to connect to for the given endpoint.
+func longPollEndpoint(endPoint *url.URL) (*url.URL, error) {
+	// Copy the passed in endpoint so we can modify it
+	newEndpoint := *endPoint
+
+	newEndpoint.Path = path.Join(newEndpoint.Path, "longpoll")
+
+	switch newEndpoint.Scheme {
+	case "":
+		if newEndpoint.Port() == "443" {
+			newEndpoint.Scheme = "https"
+		} else {
+			newEndpoint.Scheme = "http"
+		}
+	case "ws":
+		newEndpoint.Scheme = "http"
+	case "wss":
+		newEndpoint.Scheme = "https"
+	}
+
+	if newEndpoint.Scheme != "http" && newEndpoint.Scheme != "https" {
+		return nil, errors.New("invalid scheme for longpoll transport, must be 'http://' or 'https://'")
+	}
+
+	return &newEndpoint, nil
+}
+
+func (l *LongPoll) Disconnect() error {
+	defer l.Handler.onConnStateChange()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return errors.New("not connected")
+	}
+
+	// Phoenix has no way to end a session, so stop polling and let it expire on the server
+	l.started = false
+	l.ready.set(false)
+	close(l.done)
+	if l.cancelPoll != nil {
+		l.cancelPoll()
+	}
+	return nil
+}
+
+func (l *LongPoll) Reconnect() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return errors.New("not connected")
+	}
+
+	l.requestReconnect()
+	return nil
+}
+
+func (l *LongPoll) IsConnected() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.started && l.open
+}
+
+func (l *LongPoll) ConnectionState() ConnectionState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if !l.started {
+		return ConnectionClosed
+	} else if l.open {
+		return ConnectionOpen
+	} else if l.wasOpen {
+		return ConnectionReconnecting
+	} else {
+		return ConnectionConnecting
+	}
+}
+
+func (l *LongPoll) Send(msg []byte) error {
+	l.mu.RLock()
+	started, send, policy, done := l.started, l.send, l.sendPolicy, l.done
+	l.mu.RUnlock()
+
+	if !started {
+		return errors.New("cannot Send when not connected or connecting")
+	}
+
+	atomic.AddInt64(&l.queuedBytes, int64(len(msg)))
+	return offerQueue(send, msg, policy, done, func(dropped []byte) {
+		atomic.AddInt64(&l.queuedBytes, -int64(len(dropped)))
+	}, l.Handler.onSendQueueFull)
+}
+
+// QueuedBytes implements QueueSizer, returning the bytes waiting to be sent.
+func (l *LongPoll) QueuedBytes() int64 {
+	return atomic.LoadInt64(&l.queuedBytes)
+}
+
+// requestReconnect ends the current session, if any, so that a new one is opened. Must be called with mu locked.
+func (l *LongPoll) requestReconnect() {
+	select {
+	case l.reconnect <- struct{}{}:
+	default:
+	}
+	if l.cancelPoll != nil {
+		l.cancelPoll()
+	}
+}
+
+func (l *LongPoll) connectionManager(done chan struct{}, reconnect chan struct{}) {
+	tries := 0
+	backoff := func() {
+		tries++
+		sleepClock(l.Handler.clock(), l.Handler.reconnectAfter(tries), done)
+	}
+
+	for !isDone(done) {
+		// Forget any reconnect asked for while there was no session
+		select {
+		case <-reconnect:
+		default:
+		}
+
+		messages, err := l.openSession()
+		if err != nil {
+			if isDone(done) {
+				return
+			}
+			l.Handler.onConnError(err)
+			backoff()
+			continue
+		}
+
+		connectedAt := l.Handler.clock().Now()
+		l.setOpen(true)
+		l.Handler.onConnOpen()
+		l.receive(messages)
+
+		err = l.pollUntilClosed(done, reconnect)
+
+		l.setOpen(false)
+		if isDone(done) {
+			l.Handler.onConnClose()
+			return
+		}
+		if err != errLongPollReconnect {
+			l.Handler.onReadError(err)
+		}
+		l.Handler.onConnClose()
+
+		// Only start the tries over once the session has proven to be stable, like Websocket
+		if l.Handler.clock().Now().Sub(connectedAt) >= l.Handler.reconnectStableAfter() {
+			tries = 0
+		} else {
+			backoff()
+		}
+	}
+}
+
+// openSession starts a new session, returning any messages the server sent with it.
+func (l *LongPoll) openSession() ([]json.RawMessage, error) {
+	l.mu.RLock()
+	endPoint, requestHeader, timeout := l.endPoint, l.requestHeader, l.connectTimeout
+	l.mu.RUnlock()
+
+	target, header, err := l.Handler.connectTarget(endPoint, requestHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.target = target
+	l.targetHeader = header
+	l.token = ""
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := l.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A new session is answered with 410 Gone and the token of the session
+	if isUnauthorized(resp.Status) {
+		return nil, fmt.Errorf("longpoll session refused with status %d: %w", resp.Status, ErrUnauthorized)
+	}
+	if (resp.Status != http.StatusGone && resp.Status != http.StatusOK) || resp.Token == "" {
+		return nil, fmt.Errorf("longpoll session refused with status %d", resp.Status)
+	}
+
+	l.setToken(resp.Token)
+	return resp.Messages, nil
+}
+
+// pollUntilClosed polls for messages until the session ends, returning why.
+func (l *LongPoll) pollUntilClosed(done chan struct{}, reconnect chan struct{}) error {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), l.PollTimeout)
+		l.mu.Lock()
+		l.cancelPoll = cancel
+		l.mu.Unlock()
+
+		resp, err := l.request(ctx, http.MethodGet, nil)
+
+		l.mu.Lock()
+		l.cancelPoll = nil
+		l.mu.Unlock()
+		cancel()
+
+		if isDone(done) {
+			return nil
+		}
+		select {
+		case <-reconnect:
+			return errLongPollReconnect
+		default:
+		}
+		if err != nil {
+			return err
+		}
+
+		switch resp.Status {
+		case http.StatusOK:
+			if resp.Token != "" {
+				l.setToken(resp.Token)
+			}
+			l.receive(resp.Messages)
+		case http.StatusNoContent:
+			// The poll ended without messages
+		case http.StatusGone:
+			return errors.New("longpoll session expired")
+		default:
+			return fmt.Errorf("longpoll poll failed with status %d", resp.Status)
+		}
+	}
+}
+
+// receive passes the given messages from the server to the Handler.
+func (l *LongPoll) receive(messages []json.RawMessage) {
+	for _, msg := range messages {
+		// Messages are normally already encoded by the server's serializer, and sent as JSON strings
+		var data string
+		if err := json.Unmarshal(msg, &data); err == nil {
+			l.Handler.onConnMessage([]byte(data))
+		} else {
+			l.Handler.onConnMessage(msg)
+		}
+	}
+}
+
+func (l *LongPoll) connectionWriter(done chan struct{}, send chan []byte) {
+	for {
+		var batch [][]byte
+		select {
+		case <-done:
+			return
+		case data := <-send:
+			batch = append(batch, data)
+		}
+
+		// Send everything that was queued while waiting, in one request
+	gather:
+		for len(batch) < longPollBatchSize {
+			select {
+			case data := <-send:
+				batch = append(batch, data)
+			default:
+				break gather
+			}
+		}
+
+		// If there are messages to send, but there is no session, then wait until there is
+		l.mu.Lock()
+		ready := l.ready.wait()
+		l.mu.Unlock()
+		select {
+		case <-done:
+			return
+		case <-ready:
+		}
+
+		size := 0
+		for _, data := range batch {
+			size += len(data)
+		}
+		atomic.AddInt64(&l.queuedBytes, -int64(size))
+
+		err := l.post(batch)
+		if err != nil && !isDone(done) {
+			l.Handler.onWriteError(err)
+			l.mu.Lock()
+			l.requestReconnect()
+			l.mu.Unlock()
+		}
+	}
+}
+
+// post sends the given messages to the server.
+func (l *LongPoll) post(batch [][]byte) error {
+	l.mu.RLock()
+	timeout := l.connectTimeout
+	l.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := l.request(ctx, http.MethodPost, bytes.Join(batch, []byte("\n")))
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusOK {
+		return fmt.Errorf("longpoll send failed with status %d", resp.Status)
+	}
+	return nil
+}
+
+// request makes a request to the session, with the given body if it is a POST.
+func (l *LongPoll) request(ctx context.Context, method string, body []byte) (*longPollResponse, error) {
+	l.mu.RLock()
+	target := *l.target
+	header := l.targetHeader.Clone()
+	token := l.token
+	l.mu.RUnlock()
+
+	if token != "" {
+		query := target.Query()
+		query.Set("token", token)
+		target.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	httpResp, err := l.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if isUnauthorized(httpResp.StatusCode) {
+		return nil, fmt.Errorf("longpoll request failed with HTTP status %d: %w", httpResp.StatusCode, ErrUnauthorized)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("longpoll request failed with HTTP status %d: %s", httpResp.StatusCode, strconv.Quote(string(data)))
+	}
+
+	var resp longPollResponse
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longpoll response: %w", err)
+	}
+	return &resp, nil
+}
+
+func (l *LongPoll) isStarted() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.started
+}
+
+func (l *LongPoll) setOpen(open bool) {
+	defer l.Handler.onConnStateChange()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.open = open
+	if open {
+		l.wasOpen = true
+	}
+	l.ready.set(l.started && l.open)
+}
+
+func (l *LongPoll) setToken(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.token = token
+}
@@ -0,0 +1,275 @@
+package phx_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// longPollServer is a fake Phoenix long polling endpoint. It opens a session for every request without a current
+// token, replies ok to joins, and answers polls with the queued messages, or with 204 No Content after a while.
+type longPollServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sessions int
+	token    string
+	expired  bool
+	outbox   []string
+	posts    [][]string
+	holdPost chan struct{}
+}
+
+func newLongPollServer(t *testing.T) *longPollServer {
+	s := &longPollServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// socket returns a Socket that connects to the server with LongPoll.
+func (s *longPollServer) socket(t *testing.T) *phx.Socket {
+	endPoint, err := url.Parse(s.URL + "/socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := phx.NewSocket(endPoint)
+	socket.Transport = phx.NewLongPoll(socket)
+	socket.ReconnectAfterFunc = func(tries int) time.Duration { return time.Millisecond }
+	t.Cleanup(func() { _ = socket.Disconnect() })
+	return socket
+}
+
+func (s *longPollServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/socket/longpoll" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	token := r.URL.Query().Get("token")
+	if token == "" || token != s.token {
+		// A new session is answered with 410 Gone and its token
+		s.sessions++
+		s.token = fmt.Sprintf("session-%d", s.sessions)
+		s.expired = false
+		token = s.token
+		s.mu.Unlock()
+		s.respond(w, map[string]any{"status": http.StatusGone, "token": token})
+		return
+	}
+	if s.expired {
+		s.mu.Unlock()
+		s.respond(w, map[string]any{"status": http.StatusGone})
+		return
+	}
+	hold := s.holdPost
+	s.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		s.post(w, r, hold)
+		return
+	}
+	s.poll(w, r, token)
+}
+
+func (s *longPollServer) post(w http.ResponseWriter, r *http.Request, hold chan struct{}) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if hold != nil {
+		<-hold
+	}
+
+	lines := strings.Split(string(body), "\n")
+	s.mu.Lock()
+	s.posts = append(s.posts, lines)
+	for _, line := range lines {
+		var msg []any
+		if err := json.Unmarshal([]byte(line), &msg); err != nil || len(msg) != 5 {
+			continue
+		}
+		if msg[3] == string(phx.JoinEvent) {
+			s.queue(msg[0], msg[1], msg[2], string(phx.ReplyEvent), map[string]any{"status": "ok", "response": map[string]any{}})
+		}
+	}
+	s.mu.Unlock()
+	s.respond(w, map[string]any{"status": http.StatusOK})
+}
+
+func (s *longPollServer) poll(w http.ResponseWriter, r *http.Request, token string) {
+	for i := 0; i < 10; i++ {
+		s.mu.Lock()
+		messages := s.outbox
+		s.outbox = nil
+		s.mu.Unlock()
+		if len(messages) > 0 {
+			s.respond(w, map[string]any{"status": http.StatusOK, "token": token, "messages": messages})
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	s.respond(w, map[string]any{"status": http.StatusNoContent, "token": token})
+}
+
+// queue queues a message for the next poll, encoded as a JSON string like Phoenix's V2 serializer. Must be called with
+// mu locked.
+func (s *longPollServer) queue(joinRef any, ref any, topic any, event string, payload any) {
+	data, _ := json.Marshal([]any{joinRef, ref, topic, event, payload})
+	s.outbox = append(s.outbox, string(data))
+}
+
+func (s *longPollServer) respond(w http.ResponseWriter, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *longPollServer) sessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions
+}
+
+// connectLongPoll connects the given Socket and waits for its session to open.
+func connectLongPoll(t *testing.T, socket *phx.Socket) {
+	t.Helper()
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the session to open", socket.IsConnected)
+}
+
+func TestLongPollOpensSession(t *testing.T) {
+	server := newLongPollServer(t)
+	socket := server.socket(t)
+	connectLongPoll(t, socket)
+
+	channel := socket.Channel("room:1", nil)
+	join, err := channel.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := join.Await(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Polls that end with 204 No Content keep the session
+	time.Sleep(100 * time.Millisecond)
+	if sessions := server.sessionCount(); sessions != 1 || !socket.IsConnected() {
+		t.Errorf("opened %v sessions, connected %v, want 1 session that stays open", sessions, socket.IsConnected())
+	}
+}
+
+func TestLongPollReceivesPolledMessages(t *testing.T) {
+	server := newLongPollServer(t)
+	socket := server.socket(t)
+	connectLongPoll(t, socket)
+	channel := socket.Channel("room:1", nil)
+	received := make(chan string, 2)
+	channel.On("new_msg", func(payload any) { received <- "new_msg" })
+	channel.On("edit_msg", func(payload any) { received <- "edit_msg" })
+	join, err := channel.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := join.Await(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are answered to the same poll
+	server.mu.Lock()
+	server.queue(nil, nil, "room:1", "new_msg", map[string]any{"body": "hi"})
+	server.queue(nil, nil, "room:1", "edit_msg", map[string]any{"body": "hello"})
+	server.mu.Unlock()
+
+	// Callbacks run in goroutines of their own, so they may be called in any order
+	events := map[string]bool{receive(t, received): true, receive(t, received): true}
+	if !events["new_msg"] || !events["edit_msg"] {
+		t.Errorf("received %v, want new_msg and edit_msg", events)
+	}
+}
+
+func TestLongPollBatchesPosts(t *testing.T) {
+	server := newLongPollServer(t)
+	socket := server.socket(t)
+	connectLongPoll(t, socket)
+	channel := socket.Channel("room:1", nil)
+	join, err := channel.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := join.Await(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the first POST, so that the pushes after it are queued while it is in flight
+	hold := make(chan struct{})
+	server.mu.Lock()
+	server.holdPost = hold
+	sent := len(server.posts)
+	server.mu.Unlock()
+
+	for i := 0; i < 4; i++ {
+		if _, err := channel.Push("new_msg", map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(hold)
+
+	var posts [][]string
+	eventually(t, "the pushes to be posted", func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		posts = server.posts[sent:]
+		lines := 0
+		for _, post := range posts {
+			lines += len(post)
+		}
+		return lines == 4
+	})
+	if len(posts) > 2 {
+		t.Errorf("posted %v, want the pushes queued during the first POST batched in one", posts)
+	}
+	n := 0
+	for _, post := range posts {
+		for _, line := range post {
+			if want := fmt.Sprintf(`{"n":%d}]`, n); !strings.HasSuffix(line, want) {
+				t.Errorf("posted %v as push %v, want it to end with %v", line, n, want)
+			}
+			n++
+		}
+	}
+}
+
+func TestLongPollSessionExpiry(t *testing.T) {
+	server := newLongPollServer(t)
+	socket := server.socket(t)
+	connectLongPoll(t, socket)
+	closed := make(chan struct{}, 1)
+	socket.OnClose(func() { closed <- struct{}{} })
+
+	server.mu.Lock()
+	server.expired = true
+	server.mu.Unlock()
+
+	receive(t, closed)
+	eventually(t, "a new session to open", func() bool { return server.sessionCount() == 2 && socket.IsConnected() })
+}
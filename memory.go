@@ -0,0 +1,138 @@
+package phx
+
+import (
+	"sync/atomic"
+)
+
+// MemoryUsage is the number of bytes held in the internal buffers of a Socket, as returned by Socket.MemoryUsage and
+// passed to Socket.OnMemoryPressure callbacks.
+type MemoryUsage struct {
+	// Limit is the Socket's MemoryLimit.
+	Limit int64
+
+	// Total is the sum of all the buffers below.
+	Total int64
+
+	// SendQueue is the bytes waiting in the Transport's send queue, if it is a QueueSizer.
+	SendQueue int64
+
+	// PushBuffers is the estimated bytes of the pushes that Channels are holding until they are joined.
+	PushBuffers int64
+
+	// Lanes is the bytes waiting in ordering lanes. See Push.OrderingKey.
+	Lanes int64
+
+	// InboundQueue is the bytes of the received messages waiting to be dispatched with FeatureInboundQueue, including
+	// those waiting for room in the queue.
+	InboundQueue int64
+
+	// DispatchQueue is the estimated bytes of the callbacks queued for Poll and ProcessNext when ManualDispatch is set,
+	// counting each at a fixed size, as what they capture can't be measured.
+	DispatchQueue int64
+
+	// ExportQueues is the bytes of the received messages waiting to be published by Exporters, by their received
+	// size.
+	ExportQueues int64
+
+	// PendingPushes is the bytes of the sent pushes that are waiting for a reply, by their encoded size, as their
+	// payloads are kept until then.
+	PendingPushes int64
+}
+
+// QueueSizer is implemented by Transports that can report how many bytes are waiting in their send queue.
+type QueueSizer interface {
+	QueuedBytes() int64
+}
+
+// OnMemoryPressure registers the given callback to be called when the internal buffers of the Socket grow past its
+// MemoryLimit, so the application can shed its own state too.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnMemoryPressure(callback func(MemoryUsage)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.memoryPressureCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// MemoryUsage returns the number of bytes currently held in the internal buffers.
+func (s *Socket) MemoryUsage() MemoryUsage {
+	usage := MemoryUsage{
+		Limit:         s.MemoryLimit,
+		PushBuffers:   atomic.LoadInt64(&s.pushBufferBytes),
+		Lanes:         atomic.LoadInt64(&s.laneBytes),
+		InboundQueue:  atomic.LoadInt64(&s.inboundBytes),
+		DispatchQueue: int64(s.Pending()) * estimatedCallbackSize,
+		ExportQueues:  atomic.LoadInt64(&s.exportBytes),
+		PendingPushes: atomic.LoadInt64(&s.pendingPushBytes),
+	}
+	if sizer, ok := s.Transport.(QueueSizer); ok {
+		usage.SendQueue = sizer.QueuedBytes()
+	}
+	usage.Total = usage.SendQueue + usage.PushBuffers + usage.Lanes + usage.InboundQueue + usage.DispatchQueue +
+		usage.ExportQueues + usage.PendingPushes
+	return usage
+}
+
+// checkMemory is called whenever a buffer grows. If the MemoryLimit is exceeded, the OnMemoryPressure callbacks are
+// called, once per episode of pressure, and the oldest buffered pushes are dropped until usage is under the limit.
+func (s *Socket) checkMemory() {
+	if s.MemoryLimit <= 0 {
+		return
+	}
+
+	usage := s.MemoryUsage()
+	if usage.Total <= s.MemoryLimit {
+		atomic.StoreInt32(&s.memoryPressure, 0)
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&s.memoryPressure, 0, 1) {
+		s.Logger.Printf(LogWarning, "socket", "memory limit exceeded: %+v", usage)
+		s.mu.RLock()
+		for _, cb := range s.memoryPressureCallbacks {
+			cb := cb
+			s.run(func() { cb(usage) })
+		}
+		s.mu.RUnlock()
+	}
+
+	// Shed buffered pushes, which are the only buffers we can drop without breaking the connection
+	excess := usage.Total - s.MemoryLimit
+	for _, channel := range s.channelList() {
+		if excess <= 0 {
+			break
+		}
+		excess -= channel.shedPushBuffer(excess)
+	}
+}
+
+// shedPushBuffer drops the oldest buffered pushes until at least the given number of bytes is freed, or the buffer is
+// empty. Each dropped push times out right away, so that whoever is waiting for its reply isn't left hanging.
+// Returns the number of bytes freed.
+func (c *Channel) shedPushBuffer(bytes int64) int64 {
+	c.mu.Lock()
+	var freed int64
+	var shed []*Push
+	for len(c.pushBuffer) > 0 && freed < bytes {
+		push := c.pushBuffer[0]
+		c.pushBuffer = c.pushBuffer[1:]
+		freed += int64(push.bufferedSize)
+		shed = append(shed, push)
+	}
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.socket.pushBufferBytes, -freed)
+	for _, push := range shed {
+		// Only time out pushes whose timer we stopped, the others already timed out or are about to
+		push.mu.Lock()
+		pending := !push.timedOut && (push.timeoutTimer == nil || push.timeoutTimer.Stop())
+		push.timeoutTimer = nil
+		push.mu.Unlock()
+		c.socket.drop(DropMemoryPressure, c.topic, push.Event)
+		if pending {
+			push.timeout()
+		}
+	}
+	return freed
+}
@@ -0,0 +1,69 @@
+package phx_test
+
+import (
+	"testing"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// blockingSink is an ExportSink whose Publish waits until it is released.
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s blockingSink) Publish(subject string, data []byte) error {
+	<-s.release
+	return nil
+}
+
+// eventually waits for the given condition to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryUsageCountsPendingPushes(t *testing.T) {
+	server, socket, channel := joinedChannel(t, "room:1")
+	server.Handle("room:*", "slow", noReply)
+	channel.PushTimeout = time.Minute
+
+	if _, err := channel.Push("slow", map[string]any{"body": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitFor("room:1", "slow", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	usage := socket.MemoryUsage()
+	if usage.PendingPushes <= 0 || usage.Total < usage.PendingPushes {
+		t.Fatalf("usage %+v doesn't count the push waiting for a reply", usage)
+	}
+
+	if _, err := channel.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the released push to be uncounted", func() bool { return socket.MemoryUsage().PendingPushes == 0 })
+}
+
+func TestMemoryUsageCountsExportQueues(t *testing.T) {
+	server, socket, _ := joinedChannel(t, "room:1")
+	sink := blockingSink{release: make(chan struct{})}
+	if _, err := socket.AddExporter(&phx.Exporter{Sink: sink}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first event is taken by the publishing goroutine, the others wait in the queue
+	for i := 0; i < 3; i++ {
+		server.Broadcast("room:1", "new_msg", map[string]any{"n": i})
+	}
+	eventually(t, "the events to be queued", func() bool { return socket.MemoryUsage().ExportQueues > 0 })
+
+	close(sink.release)
+	eventually(t, "the events to be published", func() bool { return socket.MemoryUsage().ExportQueues == 0 })
+}
@@ -0,0 +1,408 @@
+package phx
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MemoryServer is the server side of a MemoryTransport, such as the fake Phoenix server in the phxtest package.
+type MemoryServer interface {
+	// Accept is called when a client connects. Returning an error refuses the connection, which the client retries
+	// like any other failed connection attempt.
+	Accept(conn *MemoryConn, endPoint *url.URL, requestHeader http.Header) error
+
+	// Receive is called with every message the client sends on the connection.
+	Receive(conn *MemoryConn, data []byte)
+}
+
+// MemoryTransport is a Transport that connects to a MemoryServer in the same process, instead of over the network,
+// for fast and deterministic tests and simulations.
+type MemoryTransport struct {
+	Handler TransportHandler
+	Server  MemoryServer
+
+	// Clock is used to delay reconnections. Defaults to the real clock.
+	Clock Clock
+
+	// ManualDelivery, if set, holds messages in both directions until Deliver is called, instead of delivering them
+	// in a background goroutine, so that the order of events is under the control of the caller.
+	ManualDelivery bool
+
+	mu              sync.Mutex
+	state           ConnectionState
+	reconnecting    bool // whether the transport is connecting again after a lost connection or failed dial
+	conn            *MemoryConn
+	endPoint        *url.URL
+	requestHeader   http.Header
+	connectionTries int
+	connectedAt     time.Time
+	reconnectTimer  Timer
+	backlog         [][]byte
+	queue           []memoryDelivery
+	ready           chan struct{}
+	done            chan struct{}
+}
+
+// memoryDelivery is a message waiting to be delivered in one direction of a MemoryConn.
+type memoryDelivery struct {
+	conn     *MemoryConn
+	toServer bool
+	data     []byte
+	binary   bool
+	closeErr error
+}
+
+// MemoryConn is one connection of a MemoryTransport, as seen by the MemoryServer.
+type MemoryConn struct {
+	transport *MemoryTransport
+	mu        sync.Mutex
+	closed    bool
+}
+
+func NewMemoryTransport(handler TransportHandler, server MemoryServer) *MemoryTransport {
+	return &MemoryTransport{
+		Handler: handler,
+		Server:  server,
+		Clock:   NewRealClock(),
+		state:   ConnectionClosed,
+	}
+}
+
+// implements Transport
+
+func (t *MemoryTransport) Connect(endPoint *url.URL, requestHeader http.Header, connectTimeout time.Duration) error {
+	t.mu.Lock()
+	if t.state != ConnectionClosed {
+		t.mu.Unlock()
+		return errors.New("connect was already called")
+	}
+	t.endPoint = endPoint
+	t.requestHeader = requestHeader
+	t.connectionTries = 0
+	t.state = ConnectionConnecting
+	t.reconnecting = false
+	t.ready = make(chan struct{}, 1)
+	t.done = make(chan struct{})
+	manual := t.ManualDelivery
+	t.mu.Unlock()
+	t.Handler.onConnStateChange()
+
+	if !manual {
+		goLabeled(t.Handler.profilerLabels(roleReader), t.deliverer)
+	}
+	t.dial()
+	return nil
+}
+
+func (t *MemoryTransport) Disconnect() error {
+	t.mu.Lock()
+	if t.state == ConnectionClosed {
+		t.mu.Unlock()
+		return errors.New("not connected")
+	}
+	t.state = ConnectionClosing
+	if t.reconnectTimer != nil {
+		t.reconnectTimer.Stop()
+		t.reconnectTimer = nil
+	}
+	conn := t.conn
+	t.conn = nil
+	t.backlog = nil
+	t.mu.Unlock()
+	t.Handler.onConnStateChange()
+
+	if conn != nil {
+		conn.markClosed()
+		t.Handler.onConnClose()
+	}
+
+	t.mu.Lock()
+	t.state = ConnectionClosed
+	close(t.done)
+	t.mu.Unlock()
+	t.Handler.onConnStateChange()
+	return nil
+}
+
+func (t *MemoryTransport) Reconnect() error {
+	t.mu.Lock()
+	if t.state == ConnectionClosed {
+		t.mu.Unlock()
+		return errors.New("not connected")
+	}
+	t.mu.Unlock()
+
+	t.lose(nil)
+	return nil
+}
+
+func (t *MemoryTransport) IsConnected() bool {
+	return t.ConnectionState() == ConnectionOpen
+}
+
+func (t *MemoryTransport) ConnectionState() ConnectionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == ConnectionConnecting && t.reconnecting {
+		return ConnectionReconnecting
+	}
+	return t.state
+}
+
+func (t *MemoryTransport) Send(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.state {
+	case ConnectionOpen:
+		t.enqueue(memoryDelivery{conn: t.conn, toServer: true, data: data})
+	case ConnectionConnecting:
+		t.backlog = append(t.backlog, data)
+	default:
+		return errors.New("cannot Send when not connected or connecting")
+	}
+	return nil
+}
+
+// Deliver delivers the messages waiting in both directions, in the order they were sent, on the calling goroutine.
+// Returns the number of messages delivered. Only needed with ManualDelivery.
+func (t *MemoryTransport) Deliver() int {
+	delivered := 0
+	for {
+		d, ok := t.next()
+		if !ok {
+			return delivered
+		}
+		t.deliver(d)
+		delivered++
+	}
+}
+
+// Pending returns the number of messages waiting to be delivered in both directions.
+func (t *MemoryTransport) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.queue)
+}
+
+// Send sends a message from the server to the client.
+func (c *MemoryConn) Send(data []byte) error {
+	if c.isClosed() {
+		return errors.New("connection is closed")
+	}
+
+	c.transport.mu.Lock()
+	defer c.transport.mu.Unlock()
+
+	c.transport.enqueue(memoryDelivery{conn: c, data: data})
+	return nil
+}
+
+// SendBinary sends a message from the server to the client in a binary frame.
+func (c *MemoryConn) SendBinary(data []byte) error {
+	if c.isClosed() {
+		return errors.New("connection is closed")
+	}
+
+	c.transport.mu.Lock()
+	defer c.transport.mu.Unlock()
+
+	c.transport.enqueue(memoryDelivery{conn: c, data: data, binary: true})
+	return nil
+}
+
+// Close closes the connection from the server side with the given close code, such as CloseGoingAway, after any
+// messages already sent. The client reconnects as it would to a real server.
+func (c *MemoryConn) Close(code int, text string) {
+	c.Drop(&CloseError{Code: code, Text: text})
+}
+
+// Drop abruptly ends the connection with the given error, after any messages already sent, as if the network failed.
+func (c *MemoryConn) Drop(err error) {
+	if c.isClosed() {
+		return
+	}
+
+	c.transport.mu.Lock()
+	defer c.transport.mu.Unlock()
+
+	if err == nil {
+		err = errors.New("connection dropped")
+	}
+	c.transport.enqueue(memoryDelivery{conn: c, closeErr: err})
+}
+
+// IsClosed returns true once the connection has ended.
+func (c *MemoryConn) IsClosed() bool {
+	return c.isClosed()
+}
+
+func (c *MemoryConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+func (c *MemoryConn) markClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wasClosed := c.closed
+	c.closed = true
+	return !wasClosed
+}
+
+// dial offers a new connection to the server, retrying after a delay if it is refused.
+func (t *MemoryTransport) dial() {
+	t.mu.Lock()
+	if t.state != ConnectionConnecting {
+		t.mu.Unlock()
+		return
+	}
+	t.reconnectTimer = nil
+	endPoint, requestHeader := t.endPoint, t.requestHeader
+	t.mu.Unlock()
+
+	conn := &MemoryConn{transport: t}
+	endPoint, requestHeader, err := t.Handler.connectTarget(endPoint, requestHeader)
+	if err == nil {
+		err = t.Server.Accept(conn, endPoint, requestHeader)
+	}
+	if err != nil {
+		conn.markClosed()
+		t.Handler.onConnError(err)
+		t.scheduleDial(true)
+		t.Handler.onConnStateChange()
+		return
+	}
+
+	t.mu.Lock()
+	if t.state != ConnectionConnecting {
+		t.mu.Unlock()
+		conn.markClosed()
+		return
+	}
+	t.conn = conn
+	t.state = ConnectionOpen
+	t.reconnecting = false
+	t.connectedAt = t.Clock.Now()
+	backlog := t.backlog
+	t.backlog = nil
+	for _, data := range backlog {
+		t.enqueue(memoryDelivery{conn: conn, toServer: true, data: data})
+	}
+	t.mu.Unlock()
+
+	t.Handler.onConnStateChange()
+	t.Handler.onConnOpen()
+}
+
+// scheduleDial dials again after the delay given by the handler for the number of tries.
+func (t *MemoryTransport) scheduleDial(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != ConnectionConnecting {
+		return
+	}
+	t.reconnecting = true
+
+	// Like the Websocket transport, only start the tries over once a connection has proven to be stable
+	if !failed && t.Clock.Now().Sub(t.connectedAt) >= t.Handler.reconnectStableAfter() {
+		t.connectionTries = 0
+		t.reconnectTimer = t.Clock.AfterFunc(0, t.dial)
+		return
+	}
+	t.connectionTries++
+	t.reconnectTimer = t.Clock.AfterFunc(t.Handler.reconnectAfter(t.connectionTries), t.dial)
+}
+
+// lose ends the current connection, reporting the given error if not nil, and starts reconnecting.
+func (t *MemoryTransport) lose(err error) {
+	t.mu.Lock()
+	if t.state != ConnectionOpen {
+		t.mu.Unlock()
+		return
+	}
+	conn := t.conn
+	t.conn = nil
+	t.state = ConnectionConnecting
+	t.reconnecting = true
+	t.mu.Unlock()
+	t.Handler.onConnStateChange()
+
+	conn.markClosed()
+	if err != nil {
+		t.Handler.onReadError(err)
+	}
+	t.Handler.onConnClose()
+	t.scheduleDial(false)
+}
+
+// enqueue adds a delivery to the queue. Must be called with t.mu held.
+func (t *MemoryTransport) enqueue(d memoryDelivery) {
+	t.queue = append(t.queue, d)
+	if t.ready != nil {
+		select {
+		case t.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *MemoryTransport) next() (memoryDelivery, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.queue) == 0 {
+		return memoryDelivery{}, false
+	}
+	d := t.queue[0]
+	t.queue[0] = memoryDelivery{}
+	t.queue = t.queue[1:]
+	return d, true
+}
+
+func (t *MemoryTransport) deliver(d memoryDelivery) {
+	t.mu.Lock()
+	current := d.conn == t.conn
+	t.mu.Unlock()
+
+	// Anything for a connection that has since ended is lost, as it would be on the network
+	if !current || d.conn.isClosed() {
+		return
+	}
+
+	switch {
+	case d.closeErr != nil:
+		t.lose(d.closeErr)
+	case d.toServer:
+		t.Server.Receive(d.conn, d.data)
+	case d.binary:
+		t.Handler.onConnBinaryMessage(d.data)
+	default:
+		t.Handler.onConnMessage(d.data)
+	}
+}
+
+// deliverer delivers messages in the background when ManualDelivery is not set.
+func (t *MemoryTransport) deliverer() {
+	t.mu.Lock()
+	ready, done := t.ready, t.done
+	t.mu.Unlock()
+
+	for {
+		t.Deliver()
+		select {
+		case <-done:
+			return
+		case <-ready:
+		}
+	}
+}
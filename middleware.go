@@ -0,0 +1,42 @@
+package phx
+
+// ChannelMiddleware is called for every message received on a Channel before any bindings are triggered. It must call
+// next to continue processing, possibly with a modified Message, or return without calling it to drop the message.
+// next must be called before the middleware returns. Middleware runs in the Socket's reading goroutine, so it should not block.
+type ChannelMiddleware func(msg Message, next func(msg Message))
+
+// Use adds the given middleware to this Channel. Middleware is called in the order it was added, so the first
+// middleware added sees the message first, and the last one calls the bindings.
+func (c *Channel) Use(middleware ...ChannelMiddleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// dispatch passes the given message through the middleware and then triggers the bindings.
+func (c *Channel) dispatch(msg Message) {
+	c.mu.RLock()
+	middleware := c.middleware
+	c.mu.RUnlock()
+
+	reached := false
+	next := func(msg Message) {
+		reached = true
+		if c.trigger(msg.Event, msg.Ref, msg.Payload) == 0 {
+			c.socket.drop(DropUnhandled, msg.Topic, msg.Event)
+		}
+		c.autoAck(msg)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		mw, inner := middleware[i], next
+		next = func(msg Message) {
+			mw(msg, inner)
+		}
+	}
+	next(msg)
+
+	if !reached {
+		c.socket.drop(DropMiddleware, msg.Topic, msg.Event)
+	}
+}
@@ -0,0 +1,134 @@
+package phx_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+	"github.com/ongkong/phxx/phxtest"
+)
+
+// joinedChannel returns a Channel joined to the given topic on a fake server.
+func joinedChannel(t *testing.T, topic string) (*phxtest.Server, *phx.Socket, *phx.Channel) {
+	t.Helper()
+	server := phxtest.NewServer()
+	socket, _ := server.NewSocket()
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	channel := socket.Channel(topic, nil)
+	join, err := channel.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := join.Await(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return server, socket, channel
+}
+
+// receive waits for a value from the given channel.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+		panic("unreachable")
+	}
+}
+
+func TestChannelMiddlewareOrder(t *testing.T) {
+	server, _, channel := joinedChannel(t, "room:1")
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, name)
+	}
+	// Replace the payload rather than look into it, as it is only a map with the JSON serializers
+	channel.Use(func(msg phx.Message, next func(msg phx.Message)) {
+		record("first")
+		msg.Payload = []string{"first"}
+		next(msg)
+	}, func(msg phx.Message, next func(msg phx.Message)) {
+		record("second")
+		msg.Payload = append(msg.Payload.([]string), "second")
+		next(msg)
+	})
+	channel.Use(func(msg phx.Message, next func(msg phx.Message)) {
+		record("third")
+		next(msg)
+	})
+
+	payloads := make(chan any, 1)
+	channel.On("new_msg", func(payload any) {
+		record("binding")
+		payloads <- payload
+	})
+	server.Broadcast("room:1", "new_msg", map[string]any{"body": "hi"})
+
+	if payload, want := receive(t, payloads), []string{"first", "second"}; !reflect.DeepEqual(payload, want) {
+		t.Errorf("payload %v, want %v", payload, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"first", "second", "third", "binding"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("called %v, want %v", calls, want)
+	}
+}
+
+func TestChannelMiddlewareShortCircuit(t *testing.T) {
+	server, socket, channel := joinedChannel(t, "room:1")
+
+	drops := make(chan phx.DropEvent, 1)
+	socket.OnDrop(func(drop phx.DropEvent) {
+		if drop.Reason == phx.DropMiddleware {
+			drops <- drop
+		}
+	})
+
+	var mu sync.Mutex
+	innerCalls := 0
+	channel.Use(func(msg phx.Message, next func(msg phx.Message)) {
+		if msg.Event == "secret" {
+			return
+		}
+		next(msg)
+	}, func(msg phx.Message, next func(msg phx.Message)) {
+		mu.Lock()
+		innerCalls++
+		mu.Unlock()
+		next(msg)
+	})
+
+	secrets := make(chan any, 1)
+	channel.On("secret", func(payload any) { secrets <- payload })
+	publics := make(chan any, 1)
+	channel.On("public", func(payload any) { publics <- payload })
+
+	server.Broadcast("room:1", "secret", map[string]any{})
+	drop := receive(t, drops)
+	if drop.Topic != "room:1" || drop.Event != "secret" {
+		t.Errorf("dropped %v on %v, want secret on room:1", drop.Event, drop.Topic)
+	}
+
+	// Messages are processed in order, so once the next one arrives the dropped one can't have reached its binding
+	server.Broadcast("room:1", "public", map[string]any{})
+	receive(t, publics)
+	select {
+	case <-secrets:
+		t.Error("binding was called for a message that middleware dropped")
+	default:
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if innerCalls != 1 {
+		t.Errorf("inner middleware called %d times, want 1", innerCalls)
+	}
+}
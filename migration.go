@@ -0,0 +1,122 @@
+package phx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// PayloadMigrator upgrades the payload of an event from one version of its schema to the next. It is given a shallow
+// copy of the payload, which it may change and return, but whose nested values it must not change in place.
+type PayloadMigrator func(payload map[string]any) (map[string]any, error)
+
+type payloadMigration struct {
+	event        string
+	versionField string
+	from         int
+	migrator     PayloadMigrator
+}
+
+// Migrate registers the given migrator to upgrade the payloads of the given event whose versionField is from, or is
+// missing if from is 0, to version from+1, before the handlers registered with On and TypedChannel.OnEvent decode them.
+// The Socket sets the versionField of the migrated payload to from+1, and applies the migrator for that version next,
+// so that registering one migrator per version upgrades payloads of any version to the latest. This smooths rolling
+// upgrades, during which the server sends both old and new schemas. Payloads that fail to migrate are reported to the
+// OnDecodeError callbacks of their Channel.
+// Returns a unique Ref that can be used to remove this migrator via Off.
+func (s *Socket) Migrate(event string, versionField string, from int, migrator PayloadMigrator) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.migrations[ref] = payloadMigration{event: event, versionField: versionField, from: from, migrator: migrator}
+	s.mu.Unlock()
+	return ref
+}
+
+// migratePayload applies the migrators registered for the given event to the given payload, and returns the migrated
+// payload.
+func (s *Socket) migratePayload(event string, payload any) (any, error) {
+	s.mu.RLock()
+	var migrations []payloadMigration
+	for _, m := range s.migrations {
+		if m.event == event {
+			migrations = append(migrations, m)
+		}
+	}
+	s.mu.RUnlock()
+	if len(migrations) == 0 {
+		return payload, nil
+	}
+
+	fields, ok := payload.(map[string]any)
+	if !ok {
+		return payload, nil
+	}
+	copied := false
+	for {
+		migration, found, err := nextMigration(migrations, fields)
+		if err != nil || !found {
+			return fields, err
+		}
+		if !copied {
+			// Other handlers of the event are given the same payload
+			fields = shallowCopy(fields)
+			copied = true
+		}
+
+		migrated, err := migration.migrator(fields)
+		if err != nil {
+			return nil, fmt.Errorf("migrating '%v' from version %v: %w", event, migration.from, err)
+		}
+		if migrated == nil {
+			migrated = make(map[string]any)
+		}
+		migrated[migration.versionField] = migration.from + 1
+		fields = migrated
+	}
+}
+
+// nextMigration returns the migration that applies to the version of the given payload, if any.
+func nextMigration(migrations []payloadMigration, payload map[string]any) (payloadMigration, bool, error) {
+	for _, m := range migrations {
+		version, err := payloadVersion(payload[m.versionField])
+		if err != nil {
+			return payloadMigration{}, false, fmt.Errorf("version field '%v': %w", m.versionField, err)
+		}
+		if version == m.from {
+			return m, true, nil
+		}
+	}
+	return payloadMigration{}, false, nil
+}
+
+// payloadVersion returns the version in the given value of a version field, which is 0 if it is missing.
+func payloadVersion(value any) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		return int(v), nil
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("%v is not a version", value)
+}
+
+func shallowCopy(m map[string]any) map[string]any {
+	copied := make(map[string]any, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,45 @@
+package phx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// DefaultMountPoints are the paths Phoenix endpoints commonly mount their websockets at: the default user socket, and
+// the LiveView socket. Set them as Websocket.MountPoints to probe them when the configured endpoint is not found.
+var DefaultMountPoints = []string{"/socket/websocket", "/live/websocket"}
+
+// dialMountPoints dials the MountPoints of the Websocket in turn after dialing the given endpoint failed with the given
+// error, if the endpoint was not found. The first that connects is used from then on, and is logged so that the
+// endpoint can be fixed. Returns the given error if there are no MountPoints, or none of them connected.
+func (w *Websocket) dialMountPoints(ctx context.Context, endPoint *url.URL, requestHeader http.Header, err error) (WebsocketConn, *http.Response, error) {
+	var handshakeErr *HandshakeError
+	if len(w.MountPoints) == 0 || !errors.As(err, &handshakeErr) || handshakeErr.StatusCode != http.StatusNotFound {
+		return nil, nil, err
+	}
+
+	dialer := w.getDialer()
+	for _, mountPoint := range w.MountPoints {
+		if mountPoint == endPoint.Path {
+			continue
+		}
+		probe := *endPoint
+		probe.Path = mountPoint
+		conn, resp, probeErr := dialer.Dial(ctx, probe.String(), requestHeader)
+		if probeErr != nil {
+			w.log().Printf(LogDebug, "websocket", "mount point '%v' failed: %v", mountPoint, probeErr)
+			continue
+		}
+
+		w.log().Printf(LogWarning, "websocket", "endpoint '%v' was not found, connected to mount point '%v' instead",
+			endPoint.Path, mountPoint)
+		configured, configuredHeader := w.getEndpoint()
+		found := *configured
+		found.Path = mountPoint
+		w.setEndpoint(&found, configuredHeader)
+		return conn, resp, nil
+	}
+	return nil, nil, err
+}
@@ -0,0 +1,221 @@
+package phx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MQTTQoS is the quality of service of an MQTT message.
+type MQTTQoS byte
+
+const (
+	// MQTTAtMostOnce delivers a message at most once, without acknowledgement.
+	MQTTAtMostOnce MQTTQoS = iota
+
+	// MQTTAtLeastOnce delivers a message until it is acknowledged, so it may be delivered more than once.
+	MQTTAtLeastOnce
+
+	// MQTTExactlyOnce delivers a message exactly once.
+	MQTTExactlyOnce
+)
+
+// MQTTClient is a connection to an MQTT broker, as used by an MQTTBridge. This package doesn't depend on an MQTT
+// library, so implement it with a small adapter for the client in use, such as Eclipse Paho.
+type MQTTClient interface {
+	// Publish publishes the given payload to the given topic.
+	Publish(topic string, qos MQTTQoS, retained bool, payload []byte) error
+
+	// Subscribe subscribes to the given topic filter, and calls the given handler with every message received for it.
+	// If the handler returns an error, the message must not be acknowledged, so that the broker delivers it again.
+	Subscribe(filter string, qos MQTTQoS, handler func(topic string, payload []byte) error) error
+
+	// Unsubscribe unsubscribes from the given topic filter.
+	Unsubscribe(filter string) error
+}
+
+// MQTTRoute maps a Phoenix topic to MQTT topics in either or both directions.
+//
+// Use different MQTT topics for each direction when the server broadcasts what is pushed to it, or the broadcasts are
+// published back to the MQTT topic they came from.
+type MQTTRoute struct {
+	// Topic is the Phoenix topic, whose Channel the MQTTBridge joins with Params.
+	Topic  string
+	Params map[string]string
+
+	// PublishTo, if set, publishes the events of the Channel to MQTT. It is a text/template for the MQTT topic of
+	// each event, executed with an ExportedEvent, such as `devices/{{replace .Topic ":" "/"}}/{{.Event}}`.
+	PublishTo string
+
+	// Events, if set, lists the events that are published. Defaults to nil, which publishes all events other than
+	// replies and the events of the Channel lifecycle.
+	Events []string
+
+	// Body is a text/template for the MQTT payload of each event, as for Exporter.Body. Defaults to the payload
+	// encoded as JSON.
+	Body string
+
+	// Retain publishes the events as retained messages, so that new subscribers receive the last one.
+	Retain bool
+
+	// SubscribeTo, if set, subscribes to the given MQTT topic filter and pushes its messages to the Channel.
+	SubscribeTo string
+
+	// Transform returns the event and payload to push for a message received from MQTT. Defaults to the last level
+	// of the MQTT topic as the event, and the message decoded as JSON as the payload.
+	Transform func(topic string, payload []byte) (event string, pushPayload any, err error)
+
+	// QoS is the quality of service of the published events and of the subscription. Phoenix events are delivered
+	// at most once, so publishing with a higher QoS only covers the hop to the broker. Messages received with a QoS
+	// above MQTTAtMostOnce are only acknowledged once the server replies "ok" to their Push, so that the broker
+	// delivers them again if the server fails to handle them.
+	QoS MQTTQoS
+}
+
+// MQTTBridge maps Phoenix topics and events to MQTT topics in both directions, so that devices that speak MQTT can
+// interoperate with Phoenix backends. Events of the Channels are published with Exporters, and messages received
+// from MQTT are pushed to the Channels.
+type MQTTBridge struct {
+	// Socket is the Socket the Channels are joined on.
+	Socket *Socket
+
+	// Client is the connection to the MQTT broker.
+	Client MQTTClient
+
+	mu     sync.Mutex
+	routes []*mqttRoute
+	closed bool
+}
+
+// mqttRoute is an MQTTRoute that was added to an MQTTBridge.
+type mqttRoute struct {
+	MQTTRoute
+	channel     *Channel
+	exporterRef Ref
+}
+
+// NewMQTTBridge creates an MQTTBridge between the given Socket and MQTT client.
+func NewMQTTBridge(socket *Socket, client MQTTClient) *MQTTBridge {
+	return &MQTTBridge{Socket: socket, Client: client}
+}
+
+// Route starts bridging the given route, joining its Channel if needed and subscribing to its MQTT topic filter.
+// Returns an error if the templates of the route can't be parsed or the subscription fails.
+func (b *MQTTBridge) Route(route MQTTRoute) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return errors.New("mqtt bridge is closed")
+	}
+
+	r := &mqttRoute{MQTTRoute: route, channel: b.Socket.Channel(route.Topic, route.Params)}
+	if route.PublishTo != "" {
+		ref, err := b.Socket.AddExporter(&Exporter{
+			Sink:    SinkFunc(r.publisher(b.Client)),
+			Topics:  ExactTopic(route.Topic),
+			Events:  route.Events,
+			Subject: route.PublishTo,
+			Body:    route.Body,
+			ErrorFunc: func(event ExportedEvent, err error) {
+				b.Socket.Logger.Printf(LogError, "mqtt", "could not publish '%v' on '%v': %v", event.Event, event.Topic, err)
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("mqtt route for '%v': %w", route.Topic, err)
+		}
+		r.exporterRef = ref
+	}
+	if route.SubscribeTo != "" {
+		err := b.Client.Subscribe(route.SubscribeTo, route.QoS, r.receive)
+		if err != nil {
+			if r.exporterRef != 0 {
+				b.Socket.Off(r.exporterRef)
+			}
+			return fmt.Errorf("subscribing to '%v': %w", route.SubscribeTo, err)
+		}
+	}
+
+	b.mu.Lock()
+	b.routes = append(b.routes, r)
+	b.mu.Unlock()
+
+	if r.channel.IsClosed() || r.channel.IsErrored() {
+		_, err := r.channel.Join()
+		if err != nil {
+			return fmt.Errorf("joining '%v': %w", route.Topic, err)
+		}
+	}
+	return nil
+}
+
+// Close stops bridging all routes, unsubscribing from their MQTT topic filters. The Channels stay joined.
+func (b *MQTTBridge) Close() error {
+	b.mu.Lock()
+	routes := b.routes
+	b.routes = nil
+	b.closed = true
+	b.mu.Unlock()
+
+	var errs []string
+	for _, r := range routes {
+		if r.exporterRef != 0 {
+			b.Socket.Off(r.exporterRef)
+		}
+		if r.SubscribeTo != "" {
+			err := b.Client.Unsubscribe(r.SubscribeTo)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("unsubscribing from '%v': %v", r.SubscribeTo, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// publisher returns a function that publishes events of the route with the given client.
+func (r *mqttRoute) publisher(client MQTTClient) func(subject string, data []byte) error {
+	return func(subject string, data []byte) error {
+		return client.Publish(subject, r.QoS, r.Retain, data)
+	}
+}
+
+// receive pushes a message received from MQTT to the Channel of the route. Above MQTTAtMostOnce, it waits for the
+// server to reply, and returns an error unless it replies "ok", so that the message isn't acknowledged.
+func (r *mqttRoute) receive(topic string, payload []byte) error {
+	transform := r.Transform
+	if transform == nil {
+		transform = transformMQTTMessage
+	}
+	event, pushPayload, err := transform(topic, payload)
+	if err != nil {
+		r.channel.socket.Logger.Printf(LogError, "mqtt", "could not transform message on '%v': %v", topic, err)
+		return err
+	}
+
+	push, err := r.channel.Push(event, pushPayload)
+	if err != nil {
+		r.channel.socket.Logger.Printf(LogError, "mqtt", "could not push message on '%v' to '%v': %v", topic, r.Topic, err)
+		return err
+	}
+	if r.QoS == MQTTAtMostOnce {
+		return nil
+	}
+	_, err = push.Await(0)
+	return err
+}
+
+// transformMQTTMessage pushes a message received from MQTT as the event named by the last level of its topic, with the
+// message decoded as JSON, or as a string if it isn't JSON.
+func transformMQTTMessage(topic string, payload []byte) (string, any, error) {
+	event := topic[strings.LastIndexByte(topic, '/')+1:]
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return event, string(payload), nil
+	}
+	return event, decoded, nil
+}
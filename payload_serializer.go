@@ -0,0 +1,90 @@
+package phx
+
+import (
+	"fmt"
+)
+
+// PayloadSerializer encodes and decodes the payloads of a Channel to and from bytes, such as with Protocol Buffers or
+// MessagePack, so that Channels with very different payloads can share a connection with those using the Socket's
+// Serializer. See Channel.PayloadSerializer.
+type PayloadSerializer interface {
+	// Marshal encodes the payload of a push.
+	Marshal(payload any) ([]byte, error)
+
+	// Unmarshal decodes the payload of a message received in a binary frame.
+	Unmarshal(data []byte) (any, error)
+}
+
+type payloadSerializerRule struct {
+	topics     TopicMatcher
+	serializer PayloadSerializer
+}
+
+// SetPayloadSerializer sets the PayloadSerializer of the Channels created from now on for the topics matching the
+// given TopicMatcher, such as GlobTopic("telemetry:*"), while other Channels keep using the Serializer. Rules set later
+// take precedence, and a nil serializer keeps the Serializer for the matching topics.
+func (s *Socket) SetPayloadSerializer(topics TopicMatcher, serializer PayloadSerializer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.payloadSerializers = append(s.payloadSerializers, payloadSerializerRule{topics: topics, serializer: serializer})
+}
+
+// payloadSerializerFor returns the PayloadSerializer of new Channels for the given topic, if any.
+func (s *Socket) payloadSerializerFor(topic string) PayloadSerializer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.payloadSerializers) - 1; i >= 0; i-- {
+		if rule := s.payloadSerializers[i]; rule.topics.MatchTopic(topic) {
+			return rule.serializer
+		}
+	}
+	return nil
+}
+
+// marshalPayload encodes the given payload of a push with the PayloadSerializer, to be sent in a binary frame.
+func (c *Channel) marshalPayload(serializer PayloadSerializer, payload any) (binaryPayload, error) {
+	data, err := serializer.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+	return binaryPayload(data), nil
+}
+
+// unmarshalMessage returns a copy of the given message with its payload, or the response of a reply, decoded with the
+// PayloadSerializer if it was received in a binary frame. Returns the message itself if there is nothing to decode.
+// A reply whose response can't be decoded keeps it as bytes, so that its Push still completes.
+func (c *Channel) unmarshalMessage(msg *Message) (*Message, error) {
+	serializer := c.PayloadSerializer
+	if serializer == nil {
+		return msg, nil
+	}
+
+	if data, ok := msg.Payload.([]byte); ok {
+		payload, err := serializer.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		decoded := *msg
+		decoded.Payload = payload
+		return &decoded, nil
+	}
+
+	reply, ok := msg.Payload.(map[string]any)
+	if !ok || msg.Event != string(ReplyEvent) {
+		return msg, nil
+	}
+	data, ok := reply["response"].([]byte)
+	if !ok {
+		return msg, nil
+	}
+	response, err := serializer.Unmarshal(data)
+	if err != nil {
+		c.reportDecodeError(msg.Event, data, err)
+		return msg, nil
+	}
+	decoded := *msg
+	decoded.Payload = map[string]any{"status": reply["status"], "response": response}
+	return &decoded, nil
+}
@@ -0,0 +1,164 @@
+package phx
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPeerTimeout is given to a PushTo callback when the addressed peer doesn't reply within Peers.ReplyTimeout.
+var ErrPeerTimeout = errors.New("timeout waiting for peer reply")
+
+// peerReplyEvent is the event that carries the replies of peers.
+const peerReplyEvent = "peer_reply"
+
+// Peers sends pushes to other clients on a Channel's topic, addressed by their presence key, and correlates their
+// replies. It relies on the conventional relay on the server: an event whose payload is
+//
+//	{"to": key, "from": key, "peer_ref": ref, "payload": payload}
+//
+// is forwarded to the clients tracked under the "to" key, which reply by pushing a "peer_reply" event with the same
+// envelope, addressed back to the "from" key.
+type Peers struct {
+	// Key is the presence key of this client, sent as "from" so that peers can address their replies to it, and
+	// used to ignore pushes relayed to other keys.
+	Key string
+
+	// ReplyTimeout is how long PushTo waits for the reply of the peer. Defaults to the Channel's PushTimeout.
+	ReplyTimeout time.Duration
+
+	presence     *Presence
+	mu           sync.Mutex
+	pending      map[string]func(response any, err error)
+	replyBinding Ref
+}
+
+// NewPeers creates Peers for the Channel of the given Presence, for the client tracked under the given key.
+func NewPeers(presence *Presence, key string) *Peers {
+	p := &Peers{
+		Key:          key,
+		ReplyTimeout: presence.channel.PushTimeout,
+		presence:     presence,
+		pending:      make(map[string]func(response any, err error)),
+	}
+	p.replyBinding = presence.channel.On(peerReplyEvent, p.onReply)
+	return p
+}
+
+// PushTo pushes the given event and payload to the client with the given presence key, and calls the given callback
+// once with the reply of the peer. The callback is called with a *ReplyError if the server or the peer replies with
+// an error, ErrPushTimeout if the server doesn't accept the push in time, ErrPushReleased if the Channel's join ends
+// first, or ErrPeerTimeout if the peer doesn't reply within ReplyTimeout. Returns an error without pushing if the key
+// isn't present.
+func (p *Peers) PushTo(key string, event string, payload any, callback func(response any, err error)) (*Push, error) {
+	if _, present := p.presence.State()[key]; !present {
+		return nil, fmt.Errorf("peer %q is not present", key)
+	}
+
+	socket := p.presence.channel.socket
+	ref := newUUID(socket.random())
+	var once sync.Once
+	var timer Timer
+	finish := func(response any, err error) {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.pending, ref)
+			p.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			callback(response, err)
+		})
+	}
+
+	p.mu.Lock()
+	p.pending[ref] = finish
+	timer = socket.Clock.AfterFunc(p.ReplyTimeout, func() { finish(nil, ErrPeerTimeout) })
+	p.mu.Unlock()
+
+	push, err := p.presence.channel.Push(event, map[string]any{
+		"to":       key,
+		"from":     p.Key,
+		"peer_ref": ref,
+		"payload":  payload,
+	})
+	if err != nil {
+		finish(nil, err)
+		return nil, err
+	}
+	push.Receive("error", func(response any) {
+		finish(nil, &ReplyError{Response: response})
+	})
+	push.Receive("timeout", func(response any) {
+		finish(nil, push.timeoutErr())
+	})
+	return push, nil
+}
+
+// Handle registers the given handler for the given event when it is pushed to this client by a peer. The handler is
+// called with the key of the peer, and what it returns is pushed back to the peer as the reply. An error is sent as
+// {"error": message}, which the peer receives as a *ReplyError.
+// Returns a unique Ref that can be used to cancel this handler via Channel.Off.
+func (p *Peers) Handle(event string, handler func(from string, payload any) (any, error)) Ref {
+	return p.presence.channel.On(event, func(payload any) {
+		envelope, ok := p.envelope(payload)
+		if !ok {
+			return
+		}
+		from, _ := envelope["from"].(string)
+
+		reply := map[string]any{
+			"to":       from,
+			"from":     p.Key,
+			"peer_ref": envelope["peer_ref"],
+		}
+		response, err := handler(from, envelope["payload"])
+		if err != nil {
+			reply["error"] = err.Error()
+		} else {
+			reply["payload"] = response
+		}
+
+		_, err = p.presence.channel.Push(peerReplyEvent, reply)
+		if err != nil {
+			p.presence.channel.socket.Logger.Printf(LogError, "peers", "error replying to '%v' from '%v': %v", event, from, err)
+		}
+	})
+}
+
+// Stop stops listening for the replies of peers. Pending PushTo callbacks are still called when they time out.
+func (p *Peers) Stop() {
+	p.presence.channel.Off(p.replyBinding)
+}
+
+func (p *Peers) onReply(payload any) {
+	envelope, ok := p.envelope(payload)
+	if !ok {
+		return
+	}
+	ref, _ := envelope["peer_ref"].(string)
+
+	p.mu.Lock()
+	finish, ok := p.pending[ref]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if message, failed := envelope["error"]; failed {
+		finish(nil, &ReplyError{Response: message})
+		return
+	}
+	finish(envelope["payload"], nil)
+}
+
+// envelope returns the given payload as an envelope if it is addressed to this client.
+func (p *Peers) envelope(payload any) (map[string]any, bool) {
+	envelope, ok := payload.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	to, _ := envelope["to"].(string)
+	return envelope, to == p.Key
+}
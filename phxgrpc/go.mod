@@ -0,0 +1,19 @@
+module github.com/ongkong/phxx/phxgrpc
+
+go 1.22
+
+replace github.com/ongkong/phxx v0.0.0-unpublished => ../
+
+require (
+	github.com/ongkong/phxx v0.0.0-unpublished
+	google.golang.org/grpc v1.67.1
+	google.golang.org/protobuf v1.36.6
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+)
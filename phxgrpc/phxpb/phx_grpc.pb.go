@@ -0,0 +1,171 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: phx.proto
+
+// The internal API served by phxgrpc.Server, which lets services that don't speak the Phoenix protocol consume Phoenix
+// Channels through a phx.Socket. Payloads are JSON. The Go code generated from this file is in phxpb, see the
+// go:generate directive in server.go to regenerate it.
+
+package phxpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Channels_Subscribe_FullMethodName = "/phx.v1.Channels/Subscribe"
+	Channels_Push_FullMethodName      = "/phx.v1.Channels/Push"
+)
+
+// ChannelsClient is the client API for Channels service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ChannelsClient interface {
+	// Subscribe joins the topic, if it isn't already, and streams its events until the call is canceled.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// Push pushes an event to the topic and returns the server's reply.
+	Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushReply, error)
+}
+
+type channelsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChannelsClient(cc grpc.ClientConnInterface) ChannelsClient {
+	return &channelsClient{cc}
+}
+
+func (c *channelsClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Channels_ServiceDesc.Streams[0], Channels_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Channels_SubscribeClient = grpc.ServerStreamingClient[Event]
+
+func (c *channelsClient) Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*PushReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PushReply)
+	err := c.cc.Invoke(ctx, Channels_Push_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChannelsServer is the server API for Channels service.
+// All implementations must embed UnimplementedChannelsServer
+// for forward compatibility.
+type ChannelsServer interface {
+	// Subscribe joins the topic, if it isn't already, and streams its events until the call is canceled.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error
+	// Push pushes an event to the topic and returns the server's reply.
+	Push(context.Context, *PushRequest) (*PushReply, error)
+	mustEmbedUnimplementedChannelsServer()
+}
+
+// UnimplementedChannelsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChannelsServer struct{}
+
+func (UnimplementedChannelsServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedChannelsServer) Push(context.Context, *PushRequest) (*PushReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedChannelsServer) mustEmbedUnimplementedChannelsServer() {}
+func (UnimplementedChannelsServer) testEmbeddedByValue()                  {}
+
+// UnsafeChannelsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChannelsServer will
+// result in compilation errors.
+type UnsafeChannelsServer interface {
+	mustEmbedUnimplementedChannelsServer()
+}
+
+func RegisterChannelsServer(s grpc.ServiceRegistrar, srv ChannelsServer) {
+	// If the following call pancis, it indicates UnimplementedChannelsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Channels_ServiceDesc, srv)
+}
+
+func _Channels_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChannelsServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Channels_SubscribeServer = grpc.ServerStreamingServer[Event]
+
+func _Channels_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChannelsServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Channels_Push_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChannelsServer).Push(ctx, req.(*PushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Channels_ServiceDesc is the grpc.ServiceDesc for Channels service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Channels_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "phx.v1.Channels",
+	HandlerType: (*ChannelsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _Channels_Push_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Channels_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "phx.proto",
+}
@@ -0,0 +1,93 @@
+package phxgrpc
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/ongkong/phxx/phxgrpc --go-grpc_out=. --go-grpc_opt=module=github.com/ongkong/phxx/phxgrpc phx.proto
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	phx "github.com/ongkong/phxx"
+	"github.com/ongkong/phxx/phxgrpc/phxpb"
+)
+
+// Server serves a Service over gRPC as the Channels service of phx.proto.
+type Server struct {
+	phxpb.UnimplementedChannelsServer
+
+	// Service is the Service the calls are handled by.
+	Service *Service
+}
+
+// NewServer creates a Server for the given Service.
+func NewServer(service *Service) *Server {
+	return &Server{Service: service}
+}
+
+// Register registers a Server for the given Service with the given gRPC server, such as a *grpc.Server:
+//
+//	server := grpc.NewServer()
+//	phxgrpc.Register(server, phxgrpc.NewService(socket))
+//	err := server.Serve(listener)
+func Register(registrar grpc.ServiceRegistrar, service *Service) {
+	phxpb.RegisterChannelsServer(registrar, NewServer(service))
+}
+
+// Subscribe implements Channels.Subscribe with Service.Subscribe.
+func (s *Server) Subscribe(req *phxpb.SubscribeRequest, stream grpc.ServerStreamingServer[phxpb.Event]) error {
+	err := s.Service.Subscribe(&SubscribeRequest{
+		Topic:  req.GetTopic(),
+		Params: req.GetParams(),
+		Events: req.GetEvents(),
+	}, eventStream{stream: stream})
+	return statusError(err)
+}
+
+// Push implements Channels.Push with Service.Push.
+func (s *Server) Push(ctx context.Context, req *phxpb.PushRequest) (*phxpb.PushReply, error) {
+	reply, err := s.Service.Push(ctx, &PushRequest{
+		Topic:   req.GetTopic(),
+		Params:  req.GetParams(),
+		Event:   req.GetEvent(),
+		Payload: req.GetPayload(),
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &phxpb.PushReply{Status: reply.Status, Response: reply.Response}, nil
+}
+
+// eventStream is the EventStream of a gRPC Subscribe call.
+type eventStream struct {
+	stream grpc.ServerStreamingServer[phxpb.Event]
+}
+
+func (s eventStream) Context() context.Context {
+	return s.stream.Context()
+}
+
+func (s eventStream) Send(event *Event) error {
+	return s.stream.Send(&phxpb.Event{Topic: event.Topic, Event: event.Event, Payload: event.Payload})
+}
+
+// statusError converts the given error of the Service to a gRPC status error with a fitting code.
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrConsumerBehind):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, phx.ErrPushTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, phx.ErrPushReleased):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -0,0 +1,92 @@
+package phxgrpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	phx "github.com/ongkong/phxx"
+	"github.com/ongkong/phxx/phxgrpc"
+	"github.com/ongkong/phxx/phxgrpc/phxpb"
+	"github.com/ongkong/phxx/phxtest"
+)
+
+// serve serves a Service for a Socket connected to a fake Phoenix server over an in-memory gRPC connection.
+func serve(t *testing.T) (*phxtest.Server, phxpb.ChannelsClient) {
+	t.Helper()
+	server := phxtest.NewServer()
+	socket, _ := server.NewSocket()
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Disconnect() })
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	phxgrpc.Register(grpcServer, phxgrpc.NewService(socket))
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return server, phxpb.NewChannelsClient(conn)
+}
+
+func TestServerSubscribe(t *testing.T) {
+	server, client := serve(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Subscribe(ctx, &phxpb.SubscribeRequest{Topic: "room:1", Events: []string{"new_msg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitFor("room:1", string(phx.JoinEvent), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	server.Broadcast("room:1", "ignored", map[string]any{})
+	server.Broadcast("room:1", "new_msg", map[string]any{"body": "hi"})
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.GetTopic() != "room:1" || event.GetEvent() != "new_msg" || string(event.GetPayload()) != `{"body":"hi"}` {
+		t.Errorf("received %v", event)
+	}
+}
+
+func TestServerPush(t *testing.T) {
+	server, client := serve(t)
+	server.Handle("room:*", "ping", func(msg phxtest.ServerMessage) phxtest.Reply {
+		return phxtest.Reply{Response: map[string]any{"pong": true}}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := client.Push(ctx, &phxpb.PushRequest{Topic: "room:1", Event: "ping", Payload: []byte(`{"n":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.GetStatus() != "ok" || string(reply.GetResponse()) != `{"pong":true}` {
+		t.Errorf("replied %v", reply)
+	}
+
+	_, err = client.Push(ctx, &phxpb.PushRequest{Topic: "room:1"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("pushing without an event: code %v, want %v", code, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,198 @@
+// Package phxgrpc serves the events of a phx.Socket over gRPC, so that services that aren't written in Go, or don't
+// speak the Phoenix protocol, can subscribe to Phoenix Channels and push to them through a stable internal API. It is
+// its own module, so that the phx module doesn't depend on gRPC.
+//
+// The API is the Channels service described in phx.proto, whose generated code is in the phxpb package. Service
+// implements it on plain Go types, and Server serves a Service over gRPC:
+//
+//	server := grpc.NewServer()
+//	phxgrpc.Register(server, phxgrpc.NewService(socket))
+//	err := server.Serve(listener)
+//
+// The same Service can back any other transport, such as HTTP with JSON.
+package phxgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	phx "github.com/ongkong/phxx"
+)
+
+const defaultBufferSize = 256
+
+// ErrConsumerBehind ends a subscription whose consumer fell more than Service.BufferSize events behind.
+var ErrConsumerBehind = errors.New("consumer fell behind")
+
+// ErrInvalidRequest is returned for a request that is missing required fields or has a payload that isn't JSON.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// SubscribeRequest is the request of Channels.Subscribe.
+type SubscribeRequest struct {
+	// Topic is the topic to stream the events of, which is joined with Params unless it already is.
+	Topic  string
+	Params map[string]string
+
+	// Events are the events to stream. Empty streams all events other than replies and those of the Channel
+	// lifecycle.
+	Events []string
+}
+
+// Event is an event streamed by Channels.Subscribe.
+type Event struct {
+	Topic string
+	Event string
+
+	// Payload is the payload encoded as JSON.
+	Payload []byte
+}
+
+// PushRequest is the request of Channels.Push.
+type PushRequest struct {
+	// Topic is the topic to push to, which is joined with Params unless it already is.
+	Topic  string
+	Params map[string]string
+
+	Event string
+
+	// Payload is the payload encoded as JSON.
+	Payload []byte
+}
+
+// PushReply is the reply of Channels.Push.
+type PushReply struct {
+	// Status is the status of the server's reply, such as "ok" or "error".
+	Status string
+
+	// Response is the response of the server's reply encoded as JSON.
+	Response []byte
+}
+
+// EventStream is the server side of a Channels.Subscribe stream.
+type EventStream interface {
+	Context() context.Context
+	Send(*Event) error
+}
+
+// Service implements the Channels service of phx.proto on a Socket.
+type Service struct {
+	// Socket is the Socket the Channels are joined on.
+	Socket *phx.Socket
+
+	// BufferSize is the number of events that may wait to be sent on each stream. A stream whose consumer falls
+	// further behind ends with ErrConsumerBehind, so that it doesn't hold up the Socket. Defaults to 256.
+	BufferSize int
+}
+
+// NewService creates a Service for the given Socket.
+func NewService(socket *phx.Socket) *Service {
+	return &Service{Socket: socket, BufferSize: defaultBufferSize}
+}
+
+// Subscribe joins the requested topic, unless it already is, and sends its events on the given stream until the
+// stream's context is done or the consumer falls behind.
+func (s *Service) Subscribe(req *SubscribeRequest, stream EventStream) error {
+	if req.Topic == "" {
+		return fmt.Errorf("%w: topic is required", ErrInvalidRequest)
+	}
+	bufferSize := s.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	events := make(chan *Event, bufferSize)
+	behind := make(chan struct{})
+	closedBehind := false
+	ref, err := s.Socket.AddExporter(&phx.Exporter{
+		Topics:  phx.ExactTopic(req.Topic),
+		Events:  req.Events,
+		Subject: "{{.Event}}",
+		Sink: phx.SinkFunc(func(event string, data []byte) error {
+			select {
+			case events <- &Event{Topic: req.Topic, Event: event, Payload: data}:
+				return nil
+			default:
+				// The Exporter publishes from one goroutine, so this can't race
+				if !closedBehind {
+					closedBehind = true
+					close(behind)
+				}
+				return ErrConsumerBehind
+			}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Socket.Off(ref)
+
+	err = s.join(req.Topic, req.Params)
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-behind:
+			return ErrConsumerBehind
+		case event := <-events:
+			err := stream.Send(event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Push joins the requested topic, unless it already is, pushes the requested event and returns the server's reply.
+// An "error" reply is returned as a PushReply, not an error.
+func (s *Service) Push(ctx context.Context, req *PushRequest) (*PushReply, error) {
+	if req.Topic == "" || req.Event == "" {
+		return nil, fmt.Errorf("%w: topic and event are required", ErrInvalidRequest)
+	}
+	var payload any
+	if len(req.Payload) > 0 {
+		err := json.Unmarshal(req.Payload, &payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decoding payload: %v", ErrInvalidRequest, err)
+		}
+	}
+
+	err := s.join(req.Topic, req.Params)
+	if err != nil {
+		return nil, err
+	}
+	push, err := s.Socket.Channel(req.Topic, req.Params).Push(req.Event, payload)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := push.AwaitCtx(ctx)
+	var replyErr *phx.ReplyError
+	if err != nil && !errors.As(err, &replyErr) {
+		return nil, err
+	}
+
+	response, err := json.Marshal(reply.Response)
+	if err != nil {
+		return nil, fmt.Errorf("encoding response: %w", err)
+	}
+	return &PushReply{Status: reply.Status, Response: response}, nil
+}
+
+// join joins the Channel of the given topic, unless it already is joined or joining.
+func (s *Service) join(topic string, params map[string]string) error {
+	channel := s.Socket.Channel(topic, params)
+	if !channel.IsClosed() {
+		return nil
+	}
+	_, err := channel.Join()
+	if err != nil {
+		return fmt.Errorf("joining '%v': %w", topic, err)
+	}
+	return nil
+}
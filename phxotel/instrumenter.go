@@ -0,0 +1,145 @@
+// Package phxotel exports the spans and metrics of a phx.Socket with OpenTelemetry.
+//
+// It is a separate module, so that phx itself doesn't depend on OpenTelemetry. Set an Instrumenter as
+// Socket.Instrumenter:
+//
+//	socket.Instrumenter = phxotel.NewInstrumenter(tracerProvider, meterProvider)
+package phxotel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// ScopeName is the name of the instrumentation scope of the tracer and meter of an Instrumenter.
+const ScopeName = "github.com/ongkong/phxx/phxotel"
+
+// units are the units of the metrics of a Socket, as documented by phx.Instrumenter.
+var units = map[string]string{
+	"phx.reconnect.downtime": "s",
+	"phx.heartbeat.rtt":      "s",
+	"phx.bytes.sent":         "By",
+	"phx.bytes.received":     "By",
+}
+
+// Instrumenter is a phx.Instrumenter that records the spans of a Socket as OpenTelemetry spans, and its counters and
+// histograms as OpenTelemetry Int64Counters and Float64Histograms of the same names. Spans that end with an error
+// have their status set to Error and the error recorded.
+//
+// The spans of a Socket are not started from a context, so they are root spans.
+type Instrumenter struct {
+	tracer trace.Tracer
+	meter  metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+}
+
+var _ phx.Instrumenter = (*Instrumenter)(nil)
+
+// NewInstrumenter creates an Instrumenter that records spans with the given TracerProvider and metrics with the given
+// MeterProvider. A nil provider defaults to the global one of the otel package.
+func NewInstrumenter(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) *Instrumenter {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	return &Instrumenter{
+		tracer:     tracerProvider.Tracer(ScopeName),
+		meter:      meterProvider.Meter(ScopeName),
+		counters:   make(map[string]metric.Int64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+	}
+}
+
+// StartSpan implements phx.Instrumenter.
+func (i *Instrumenter) StartSpan(name string, attrs map[string]any) func(err error) {
+	_, span := i.tracer.Start(context.Background(), name, trace.WithAttributes(attributes(attrs)...))
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// Count implements phx.Instrumenter.
+func (i *Instrumenter) Count(name string, n int64, attrs map[string]any) {
+	i.counter(name).Add(context.Background(), n, metric.WithAttributes(attributes(attrs)...))
+}
+
+// Observe implements phx.Instrumenter.
+func (i *Instrumenter) Observe(name string, value float64, attrs map[string]any) {
+	i.histogram(name).Record(context.Background(), value, metric.WithAttributes(attributes(attrs)...))
+}
+
+// counter returns the counter with the given name, creating it the first time it is used.
+func (i *Instrumenter) counter(name string) metric.Int64Counter {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	counter, ok := i.counters[name]
+	if !ok {
+		var err error
+		counter, err = i.meter.Int64Counter(name, metric.WithUnit(units[name]))
+		if err != nil {
+			// The meter still returns a usable, if possibly no-op, counter
+			otel.Handle(err)
+		}
+		i.counters[name] = counter
+	}
+	return counter
+}
+
+// histogram returns the histogram with the given name, creating it the first time it is used.
+func (i *Instrumenter) histogram(name string) metric.Float64Histogram {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	histogram, ok := i.histograms[name]
+	if !ok {
+		var err error
+		histogram, err = i.meter.Float64Histogram(name, metric.WithUnit(units[name]))
+		if err != nil {
+			otel.Handle(err)
+		}
+		i.histograms[name] = histogram
+	}
+	return histogram
+}
+
+// attributes converts the attributes given by a Socket to OpenTelemetry attributes. Values of other types than
+// strings, integers, floats and bools are formatted with fmt.
+func attributes(attrs map[string]any) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(key, v))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,119 @@
+package phxotel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	phx "github.com/ongkong/phxx"
+	"github.com/ongkong/phxx/phxotel"
+	"github.com/ongkong/phxx/phxtest"
+)
+
+func TestInstrumenter(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	instrumenter := phxotel.NewInstrumenter(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	)
+
+	server := phxtest.NewServer()
+	server.Handle("room:*", "refused", func(msg phxtest.ServerMessage) phxtest.Reply {
+		return phxtest.Reply{Status: "error"}
+	})
+	socket, _ := server.NewSocket()
+	socket.Instrumenter = instrumenter
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = socket.Disconnect() }()
+
+	channel := socket.Channel("room:1", nil)
+	join, err := channel.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := join.Await(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	push, err := channel.Push("refused", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := push.Await(time.Second); err == nil {
+		t.Fatal("push was not refused")
+	}
+
+	var pushes []sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == "phx.push" {
+			pushes = append(pushes, span)
+		}
+	}
+	if len(pushes) != 2 {
+		t.Fatalf("got %v phx.push spans, want 2", len(pushes))
+	}
+	refused := pushes[1]
+	if !hasAttribute(refused.Attributes(), attribute.String("event", "refused")) {
+		t.Errorf("phx.push span has attributes %v, want event refused", refused.Attributes())
+	}
+	if refused.Status().Code != codes.Error {
+		t.Errorf("phx.push span of a refused push has status %v, want Error", refused.Status())
+	}
+	if !hasAttribute(pushes[0].Attributes(), attribute.String("event", string(phx.JoinEvent))) {
+		t.Errorf("phx.push span has attributes %v, want event %v", pushes[0].Attributes(), phx.JoinEvent)
+	}
+	if pushes[0].Status().Code == codes.Error {
+		t.Errorf("phx.push span of the join has status %v", pushes[0].Status())
+	}
+
+	var metrics metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	replies := findSum(t, metrics, "phx.replies")
+	statuses := make(map[string]int64)
+	for _, point := range replies.DataPoints {
+		status, _ := point.Attributes.Value("status")
+		statuses[status.AsString()] += point.Value
+	}
+	if statuses["ok"] != 1 || statuses["error"] != 1 {
+		t.Errorf("phx.replies by status %v, want 1 ok and 1 error", statuses)
+	}
+	if sent := findSum(t, metrics, "phx.messages.sent"); len(sent.DataPoints) != 1 || sent.DataPoints[0].Value < 2 {
+		t.Errorf("phx.messages.sent %+v, want at least the join and the push", sent.DataPoints)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
+
+func findSum(t *testing.T, metrics metricdata.ResourceMetrics, name string) metricdata.Sum[int64] {
+	t.Helper()
+	for _, scope := range metrics.ScopeMetrics {
+		if scope.Scope.Name != phxotel.ScopeName {
+			continue
+		}
+		for _, m := range scope.Metrics {
+			if m.Name == name {
+				return m.Data.(metricdata.Sum[int64])
+			}
+		}
+	}
+	t.Fatalf("no %v metric", name)
+	return metricdata.Sum[int64]{}
+}
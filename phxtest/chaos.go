@@ -0,0 +1,65 @@
+package phxtest
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Chaos is the faults injected into a Simulation. Each rate is the probability of the fault in any step.
+type Chaos struct {
+	// DropRate is the rate at which the server abruptly drops every connection.
+	DropRate float64
+
+	// CloseRate is the rate at which the server cleanly closes every connection, such as for a deploy.
+	CloseRate float64
+
+	// RefuseRate is the rate at which the server starts refusing connections. Once refusing, it accepts connections
+	// again at the same rate.
+	RefuseRate float64
+
+	// StallRate is the rate at which nothing is delivered for a step, so that pushes and heartbeats may time out.
+	StallRate float64
+}
+
+// DefaultChaos is a moderate amount of chaos.
+var DefaultChaos = Chaos{
+	DropRate:   0.02,
+	CloseRate:  0.01,
+	RefuseRate: 0.01,
+	StallRate:  0.02,
+}
+
+// chaosMonkey injects faults from a Chaos with a seeded random source.
+type chaosMonkey struct {
+	chaos    Chaos
+	rand     *rand.Rand
+	server   *Server
+	refusing bool
+}
+
+// step injects the faults for one step. Returns true if delivery should stall for this step.
+func (m *chaosMonkey) step() bool {
+	if m.roll(m.chaos.DropRate) {
+		m.server.DropAll()
+	}
+	if m.roll(m.chaos.CloseRate) {
+		m.server.CloseAll(closeGoingAway)
+	}
+	if m.roll(m.chaos.RefuseRate) {
+		m.refusing = !m.refusing
+		m.server.SetRefusing(m.refusing)
+	}
+	return m.roll(m.chaos.StallRate)
+}
+
+func (m *chaosMonkey) roll(rate float64) bool {
+	return rate > 0 && m.rand.Float64() < rate
+}
+
+// duration returns a random duration up to max.
+func (m *chaosMonkey) duration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(m.rand.Int63n(int64(max)))
+}
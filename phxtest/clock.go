@@ -0,0 +1,151 @@
+package phxtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ongkong/phxx"
+)
+
+// FakeClock is a phx.Clock whose time only moves when Advance is called, firing the timers that come due in order.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	seq    uint64
+}
+
+// NewFakeClock creates a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+type fakeTimer struct {
+	clock   *FakeClock
+	when    time.Time
+	seq     uint64
+	f       func()
+	c       chan time.Time
+	stopped bool
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) phx.Timer {
+	return c.addTimer(d, f, nil)
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) phx.Timer {
+	return c.addTimer(d, nil, make(chan time.Time, 1))
+}
+
+func (c *FakeClock) addTimer(d time.Duration, f func(), ch chan time.Time) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f, c: ch}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by the given duration, firing every timer that comes due on the calling goroutine,
+// in the order they come due. Timers created by the fired timers are fired too if they come due within the duration.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		t := c.nextDue(target)
+		if t == nil {
+			break
+		}
+		if t.f != nil {
+			t.f()
+		} else {
+			select {
+			case t.c <- t.when:
+			default:
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.now = target
+	c.mu.Unlock()
+}
+
+// NextTimer returns the time the next timer is due, or false if there are no timers.
+func (c *FakeClock) NextTimer() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sortTimers()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// Timers returns the number of timers waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// nextDue removes and returns the earliest timer due at or before target, moving the time to when it is due.
+func (c *FakeClock) nextDue(target time.Time) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sortTimers()
+	if len(c.timers) == 0 || c.timers[0].when.After(target) {
+		return nil
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	t.stopped = true
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	return t
+}
+
+func (c *FakeClock) sortTimers() {
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].when.Equal(c.timers[j].when) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,5 @@
+// Package phxtest provides fakes for testing code that uses the phx package without a real Phoenix server: a fake
+// clock to control time, a fake Phoenix server that a Socket connects to through a phx.MemoryTransport, whose replies
+// can be scripted per topic and event, and a Simulation harness that combines them with seeded chaos to check the invariants of the client over many virtual
+// hours of connects, disconnects, joins and pushes.
+package phxtest
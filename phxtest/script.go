@@ -0,0 +1,108 @@
+package phxtest
+
+import (
+	"fmt"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// Reply is what a handler registered with Server.Handle replies to a message.
+type Reply struct {
+	// Status is the status of the reply, such as "ok" or "error". Defaults to "ok". A join that isn't replied to with
+	// "ok" doesn't join the topic.
+	Status string
+
+	// Response is the response of the reply. Defaults to an empty map.
+	Response any
+
+	// NoReply doesn't reply at all, like a handle_in that returns {:noreply, socket}, so that the push times out.
+	NoReply bool
+}
+
+type handler struct {
+	topic   phx.GlobTopic
+	event   string
+	handler func(msg ServerMessage) Reply
+}
+
+// Handle scripts the reply to the messages clients send with the given event to the topics matching the given pattern,
+// instead of replying "ok", such as to reject joins with phx.JoinEvent or to reply to pushes with data. The pattern
+// is matched like a phx.GlobTopic, such as "room:*". Handlers are called without the server's lock held, so they may
+// call Broadcast, such as to fan a push out to other clients. Pushes are only given to handlers for topics the client
+// has joined; others are replied to with an error, like Phoenix does. Handlers registered later take precedence.
+func (s *Server) Handle(topicPattern string, event string, handle func(msg ServerMessage) Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers = append([]handler{{topic: phx.GlobTopic(topicPattern), event: event, handler: handle}}, s.handlers...)
+}
+
+// WaitFor waits until the server has received a message with the given topic and event, and returns the first one.
+// Returns an error if none arrives within the given timeout.
+func (s *Server) WaitFor(topic string, event string, timeout time.Duration) (ServerMessage, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		for _, msg := range s.received {
+			if msg.Topic == topic && msg.Event == event {
+				s.mu.Unlock()
+				return msg, nil
+			}
+		}
+		if s.receivedSignal == nil {
+			s.receivedSignal = make(chan struct{})
+		}
+		signal := s.receivedSignal
+		s.mu.Unlock()
+
+		select {
+		case <-signal:
+		case <-deadline.C:
+			return ServerMessage{}, fmt.Errorf("no '%v' on '%v' received within %v", event, topic, timeout)
+		}
+	}
+}
+
+// handlerFor returns the handler for the given message, if any. Must be called with mu locked.
+func (s *Server) handlerFor(msg ServerMessage) func(msg ServerMessage) Reply {
+	if msg.Topic == "phoenix" {
+		return nil
+	}
+	for _, h := range s.handlers {
+		if h.event == msg.Event && h.topic.MatchTopic(msg.Topic) {
+			return h.handler
+		}
+	}
+	return nil
+}
+
+// handle replies to the given message with the given handler, recording a join if it was accepted.
+func (s *Server) handle(conn *phx.MemoryConn, sc *serverConn, msg ServerMessage, handle func(msg ServerMessage) Reply) {
+	reply := handle(msg)
+	if reply.Status == "" {
+		reply.Status = "ok"
+	}
+	if reply.Response == nil {
+		reply.Response = map[string]any{}
+	}
+
+	if msg.Event == string(phx.JoinEvent) && reply.Status == "ok" {
+		s.mu.Lock()
+		s.recordJoin(sc, msg)
+		s.mu.Unlock()
+	}
+	if !reply.NoReply {
+		s.reply(conn, msg, reply.Status, reply.Response)
+	}
+}
+
+// notifyReceived wakes up the calls to WaitFor. Must be called with mu locked.
+func (s *Server) notifyReceived() {
+	if s.receivedSignal != nil {
+		close(s.receivedSignal)
+		s.receivedSignal = nil
+	}
+}
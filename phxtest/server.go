@@ -0,0 +1,338 @@
+package phxtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// closeUnsupportedData is the websocket close code for a message that could not be understood.
+const closeUnsupportedData = 1003
+
+// ErrRefused is returned to clients connecting while a Server is refusing connections.
+var ErrRefused = errors.New("connection refused")
+
+// ServerMessage is a message received by a Server.
+type ServerMessage struct {
+	JoinRef string
+	Ref     string
+	Topic   string
+	Event   string
+	Payload any
+}
+
+// Server is a fake Phoenix server for Sockets using a phx.MemoryTransport. It speaks the V2 JSON protocol, accepts
+// connections unless told to refuse them, replies "ok" to heartbeats, joins, leaves and pushes unless scripted
+// otherwise with Handle, and records every message it receives so tests can assert on them.
+type Server struct {
+	mu             sync.Mutex
+	conns          map[*phx.MemoryConn]*serverConn
+	received       []ServerMessage
+	duplicateJoins []string
+	refusing       bool
+	joinBatching   bool
+	authToken      string
+	handlers       []handler
+	receivedSignal chan struct{} // closed when a message is received, for WaitFor
+}
+
+// serverConn is the state of one client connection.
+type serverConn struct {
+	// joined maps the topics joined on the connection to their join refs
+	joined map[string]string
+
+	// authenticated is set once the connection sent the token required by SetAuthToken
+	authenticated bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		conns: make(map[*phx.MemoryConn]*serverConn),
+	}
+}
+
+// NewSocket creates a Socket that connects to the server through a phx.MemoryTransport.
+func (s *Server) NewSocket() (*phx.Socket, *phx.MemoryTransport) {
+	socket := phx.NewSocket(&url.URL{Scheme: "ws", Host: "phxtest", Path: "/socket"})
+	transport := phx.NewMemoryTransport(socket, s)
+	socket.Transport = transport
+	return socket, transport
+}
+
+// SetRefusing sets whether new connections are refused.
+func (s *Server) SetRefusing(refusing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refusing = refusing
+}
+
+// SetJoinBatching sets whether "phx_join_batch" messages sent by Socket.JoinBatch are fanned out to their topics, like
+// a server with a join batching plugin. Otherwise they are replied to with an error, like a plain Phoenix server.
+func (s *Server) SetJoinBatching(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.joinBatching = enabled
+}
+
+// SetAuthToken sets the token that connections must send in a "phx_auth" message, as sent for phx.Credentials placed
+// in the first message, before they can join topics. Joins on connections that haven't authenticated are replied to
+// with an error. An empty token, the default, lets every connection join.
+func (s *Server) SetAuthToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authToken = token
+}
+
+// Accept implements phx.MemoryServer.
+func (s *Server) Accept(conn *phx.MemoryConn, endPoint *url.URL, requestHeader http.Header) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refusing {
+		return ErrRefused
+	}
+	s.conns[conn] = &serverConn{joined: make(map[string]string)}
+	return nil
+}
+
+// Receive implements phx.MemoryServer.
+func (s *Server) Receive(conn *phx.MemoryConn, data []byte) {
+	msg, err := decodeMessage(data)
+	if err != nil {
+		conn.Close(closeUnsupportedData, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, msg)
+	s.notifyReceived()
+	sc, ok := s.conns[conn]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	switch {
+	case msg.Topic == "phoenix" && msg.Event == string(phx.HeartBeatEvent):
+	case msg.Topic == "phoenix" && msg.Event == "phx_auth":
+		payload, _ := msg.Payload.(map[string]any)
+		if token, _ := payload["token"].(string); token != s.authToken {
+			s.mu.Unlock()
+			s.reply(conn, msg, "error", map[string]any{"reason": "invalid token"})
+			return
+		}
+		sc.authenticated = true
+	case s.authToken != "" && !sc.authenticated && msg.Event == string(phx.JoinEvent):
+		s.mu.Unlock()
+		s.reply(conn, msg, "error", map[string]any{"reason": "unauthenticated"})
+		return
+	case msg.Topic == "phoenix" && msg.Event == "phx_join_batch":
+		if !s.joinBatching {
+			s.mu.Unlock()
+			s.reply(conn, msg, "error", map[string]any{"reason": "unmatched topic"})
+			return
+		}
+		joins := batchedJoins(msg)
+		for _, join := range joins {
+			s.recordJoin(sc, join)
+		}
+		s.mu.Unlock()
+
+		s.reply(conn, msg, "ok", map[string]any{})
+		for _, join := range joins {
+			s.reply(conn, join, "ok", map[string]any{})
+		}
+		return
+	case s.handlerFor(msg) != nil && (msg.Event == string(phx.JoinEvent) || sc.joined[msg.Topic] == msg.JoinRef):
+		handle := s.handlerFor(msg)
+		s.mu.Unlock()
+		s.handle(conn, sc, msg, handle)
+		return
+	case msg.Event == string(phx.JoinEvent):
+		s.recordJoin(sc, msg)
+	case msg.Event == string(phx.LeaveEvent):
+		delete(sc.joined, msg.Topic)
+	default:
+		if sc.joined[msg.Topic] != msg.JoinRef {
+			// Phoenix replies to pushes on topics that aren't joined with an error
+			s.mu.Unlock()
+			s.reply(conn, msg, "error", map[string]any{"reason": "unmatched topic"})
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	s.reply(conn, msg, "ok", map[string]any{})
+}
+
+// Broadcast sends an event to every connection that joined the topic.
+func (s *Server) Broadcast(topic string, event string, payload any) {
+	s.mu.Lock()
+	type target struct {
+		conn    *phx.MemoryConn
+		joinRef string
+	}
+	var targets []target
+	for conn, sc := range s.conns {
+		if joinRef, ok := sc.joined[topic]; ok && !conn.IsClosed() {
+			targets = append(targets, target{conn, joinRef})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		s.send(t.conn, ServerMessage{JoinRef: t.joinRef, Topic: topic, Event: event, Payload: payload})
+	}
+}
+
+// Inject sends the given raw frame to every connection, in a binary frame if binary is set, such as to test how a
+// client copes with malformed messages.
+func (s *Server) Inject(data []byte, binary bool) {
+	s.mu.Lock()
+	conns := make([]*phx.MemoryConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		if binary {
+			_ = conn.SendBinary(data)
+		} else {
+			_ = conn.Send(data)
+		}
+	}
+}
+
+// DropAll abruptly ends every connection, as if the network failed.
+func (s *Server) DropAll() {
+	for _, conn := range s.takeConns() {
+		conn.Drop(errors.New("connection dropped by phxtest server"))
+	}
+}
+
+// CloseAll cleanly closes every connection with the given close code, such as phx.CloseGoingAway.
+func (s *Server) CloseAll(code int) {
+	for _, conn := range s.takeConns() {
+		conn.Close(code, "")
+	}
+}
+
+// Received returns every message the server has received, in order.
+func (s *Server) Received() []ServerMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ServerMessage(nil), s.received...)
+}
+
+// DuplicateJoins returns the topics that were joined again on a connection while already joined on it, which a
+// correct client never does.
+func (s *Server) DuplicateJoins() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.duplicateJoins...)
+}
+
+// Connections returns the number of open connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		if conn.IsClosed() {
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns)
+}
+
+// recordJoin records that the connection joined the topic of the given join. Must be called with mu locked.
+func (s *Server) recordJoin(sc *serverConn, join ServerMessage) {
+	if _, joined := sc.joined[join.Topic]; joined {
+		s.duplicateJoins = append(s.duplicateJoins, join.Topic)
+	}
+	sc.joined[join.Topic] = join.JoinRef
+}
+
+func (s *Server) takeConns() []*phx.MemoryConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*phx.MemoryConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.conns = make(map[*phx.MemoryConn]*serverConn)
+	return conns
+}
+
+func (s *Server) reply(conn *phx.MemoryConn, msg ServerMessage, status string, response any) {
+	s.send(conn, ServerMessage{
+		JoinRef: msg.JoinRef,
+		Ref:     msg.Ref,
+		Topic:   msg.Topic,
+		Event:   string(phx.ReplyEvent),
+		Payload: map[string]any{"status": status, "response": response},
+	})
+}
+
+func (s *Server) send(conn *phx.MemoryConn, msg ServerMessage) {
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return
+	}
+	_ = conn.Send(data)
+}
+
+// batchedJoins returns the joins in the payload of a "phx_join_batch" message as the messages they stand for.
+func batchedJoins(msg ServerMessage) []ServerMessage {
+	payload, _ := msg.Payload.(map[string]any)
+	entries, _ := payload["joins"].([]any)
+	joins := make([]ServerMessage, 0, len(entries))
+	for _, entry := range entries {
+		join, _ := entry.(map[string]any)
+		joinRef, _ := join["join_ref"].(string)
+		ref, _ := join["ref"].(string)
+		topic, _ := join["topic"].(string)
+		joins = append(joins, ServerMessage{JoinRef: joinRef, Ref: ref, Topic: topic, Event: string(phx.JoinEvent), Payload: join["payload"]})
+	}
+	return joins
+}
+
+// decodeMessage decodes a V2 JSON message: [join_ref, ref, topic, event, payload].
+func decodeMessage(data []byte) (ServerMessage, error) {
+	var parts []any
+	err := json.Unmarshal(data, &parts)
+	if err != nil {
+		return ServerMessage{}, err
+	}
+	if len(parts) != 5 {
+		return ServerMessage{}, fmt.Errorf("expected 5 elements, got %d", len(parts))
+	}
+	joinRef, _ := parts[0].(string)
+	ref, _ := parts[1].(string)
+	topic, _ := parts[2].(string)
+	event, _ := parts[3].(string)
+	return ServerMessage{JoinRef: joinRef, Ref: ref, Topic: topic, Event: event, Payload: parts[4]}, nil
+}
+
+// encodeMessage encodes a V2 JSON message, with empty refs as null.
+func encodeMessage(msg ServerMessage) ([]byte, error) {
+	nullable := func(ref string) any {
+		if ref == "" {
+			return nil
+		}
+		return ref
+	}
+	return json.Marshal([]any{nullable(msg.JoinRef), nullable(msg.Ref), msg.Topic, msg.Event, msg.Payload})
+}
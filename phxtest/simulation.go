@@ -0,0 +1,267 @@
+package phxtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// closeGoingAway is the websocket close code sent by a server that is shutting down.
+const closeGoingAway = 1001
+
+// maxSettleRounds bounds the rounds of delivering messages and running callbacks in one step, in case the client and
+// server keep each other busy forever.
+const maxSettleRounds = 10000
+
+// Simulation runs a Socket against a Server over a MemoryTransport with a FakeClock, performing random connects,
+// disconnects, joins, leaves and pushes while Chaos injects faults, and then checks the invariants of the client:
+//
+//   - every push that was acknowledged with an "ok" reply was received by the server, and only acknowledged once
+//   - no topic is joined again on a connection that already joined it
+//
+// Every random choice comes from Seed, and callbacks and deliveries all run on the goroutine calling Run, so a failing
+// seed can be replayed. Only the heartbeat runs on its own goroutine, so its exact timing may vary.
+//
+// A typical test runs many virtual hours:
+//
+//	func TestSimulation(t *testing.T) {
+//		for seed := int64(1); seed <= 20; seed++ {
+//			sim := phxtest.NewSimulation(seed)
+//			if _, err := sim.Run(); err != nil {
+//				t.Fatalf("seed %d: %v", seed, err)
+//			}
+//		}
+//	}
+type Simulation struct {
+	// Seed seeds every random choice.
+	Seed int64
+
+	// Steps is the number of random actions to perform.
+	Steps int
+
+	// MaxStep is the most virtual time that passes between actions. The virtual time simulated is on average
+	// Steps * MaxStep / 2.
+	MaxStep time.Duration
+
+	// Topics are the topics that are joined and pushed to.
+	Topics []string
+
+	// Chaos is the faults to inject.
+	Chaos Chaos
+
+	// JoinOnOpen, if set, also joins every topic from a Socket.OnOpen callback whenever the connection opens, like
+	// applications that set up their Channels in OnOpen, so that those joins race with the rejoins after reconnects.
+	JoinOnOpen bool
+
+	// Logger, if set, is used by the simulated Socket.
+	Logger phx.Logger
+}
+
+// SimulationResult summarizes a Simulation run.
+type SimulationResult struct {
+	// VirtualTime is the virtual time that passed.
+	VirtualTime time.Duration
+
+	// Pushes is the number of pushes made.
+	Pushes int
+
+	// Acked is the number of pushes acknowledged with an "ok" reply.
+	Acked int
+
+	// Violations describes every broken invariant.
+	Violations []string
+}
+
+// NewSimulation creates a Simulation with the given seed and defaults of 10,000 steps of up to a minute, over three
+// topics, with DefaultChaos.
+func NewSimulation(seed int64) *Simulation {
+	return &Simulation{
+		Seed:    seed,
+		Steps:   10000,
+		MaxStep: time.Minute,
+		Topics:  []string{"room:a", "room:b", "room:c"},
+		Chaos:   DefaultChaos,
+	}
+}
+
+// simulation is the state of a running Simulation.
+type simulation struct {
+	*Simulation
+	rand      *rand.Rand
+	clock     *FakeClock
+	server    *Server
+	socket    *phx.Socket
+	transport *phx.MemoryTransport
+	monkey    *chaosMonkey
+	channels  map[string]*phx.Channel
+	pushes    int
+	acked     map[string]int
+}
+
+// Run runs the simulation and returns its result, with an error if any invariant was broken.
+func (sim *Simulation) Run() (*SimulationResult, error) {
+	if len(sim.Topics) == 0 {
+		return nil, errors.New("no topics to simulate")
+	}
+
+	s := sim.start()
+	start := s.clock.Now()
+
+	for step := 0; step < sim.Steps; step++ {
+		s.act()
+		stall := s.monkey.step()
+		if !stall {
+			s.settle()
+		}
+		s.clock.Advance(s.monkey.duration(sim.MaxStep))
+		if !stall {
+			s.settle()
+		}
+	}
+
+	// Let every outstanding reply arrive and every timer run out before checking
+	s.monkey.chaos = Chaos{}
+	s.server.SetRefusing(false)
+	for i := 0; i < 10; i++ {
+		s.settle()
+		s.clock.Advance(sim.MaxStep)
+	}
+	s.settle()
+	_ = s.socket.Disconnect()
+	s.clock.Advance(time.Hour)
+	s.socket.Poll(0)
+
+	result := &SimulationResult{
+		VirtualTime: s.clock.Now().Sub(start),
+		Pushes:      s.pushes,
+		Acked:       len(s.acked),
+		Violations:  s.check(),
+	}
+	if len(result.Violations) > 0 {
+		return result, fmt.Errorf("%d invariants broken: %v", len(result.Violations), strings.Join(result.Violations, "; "))
+	}
+	return result, nil
+}
+
+// start sets up the client and server of a simulation.
+func (sim *Simulation) start() *simulation {
+	r := rand.New(rand.NewSource(sim.Seed))
+	s := &simulation{
+		Simulation: sim,
+		rand:       r,
+		clock:      NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+		server:     NewServer(),
+		channels:   make(map[string]*phx.Channel),
+		acked:      make(map[string]int),
+	}
+	s.monkey = &chaosMonkey{chaos: sim.Chaos, rand: r, server: s.server}
+
+	s.socket, s.transport = s.server.NewSocket()
+	s.socket.Clock = s.clock
+	// A source of its own, so that the jitter of reconnects doesn't shift the actions and chaos of the seed
+	s.socket.Rand = rand.New(rand.NewSource(sim.Seed))
+	s.socket.ManualDispatch = true
+	if sim.Logger != nil {
+		s.socket.Logger = sim.Logger
+	}
+	s.transport.Clock = s.clock
+	s.transport.ManualDelivery = true
+
+	if sim.JoinOnOpen {
+		s.socket.OnOpen(func() {
+			for _, topic := range sim.Topics {
+				_, _ = s.channel(topic).Join()
+			}
+		})
+	}
+
+	return s
+}
+
+// channel returns the simulated Channel for the given topic, creating it if needed.
+func (s *simulation) channel(topic string) *phx.Channel {
+	channel := s.channels[topic]
+	if channel == nil || channel.IsRemoved() {
+		channel = s.socket.Channel(topic, nil)
+		s.channels[topic] = channel
+	}
+	return channel
+}
+
+// act performs one random action.
+func (s *simulation) act() {
+	topic := s.Topics[s.rand.Intn(len(s.Topics))]
+
+	switch n := s.rand.Intn(100); {
+	case n < 5:
+		_ = s.socket.Connect()
+	case n < 7:
+		_ = s.socket.Disconnect()
+	case n < 20:
+		_, _ = s.channel(topic).Join()
+	case n < 25:
+		if channel := s.channels[topic]; channel != nil {
+			_, _ = channel.Leave()
+		}
+	case n < 27:
+		if channel := s.channels[topic]; channel != nil && channel.Remove() == nil {
+			delete(s.channels, topic)
+		}
+	default:
+		if channel := s.channels[topic]; channel != nil && !channel.IsRemoved() {
+			s.push(channel)
+		}
+	}
+}
+
+func (s *simulation) push(channel *phx.Channel) {
+	s.pushes++
+	id := fmt.Sprintf("%v-%d", channel.Topic(), s.pushes)
+	push, err := channel.Push("sim", map[string]any{"sim_id": id})
+	if err != nil {
+		return
+	}
+	push.Receive("ok", func(response any) {
+		s.acked[id]++
+	})
+}
+
+// settle delivers messages and runs callbacks until there is nothing left to do.
+func (s *simulation) settle() {
+	for i := 0; i < maxSettleRounds; i++ {
+		if s.transport.Deliver()+s.socket.Poll(0) == 0 {
+			return
+		}
+	}
+}
+
+// check returns the invariants that were broken.
+func (s *simulation) check() []string {
+	var violations []string
+
+	received := make(map[string]bool)
+	for _, msg := range s.server.Received() {
+		if payload, ok := msg.Payload.(map[string]any); ok {
+			if id, ok := payload["sim_id"].(string); ok {
+				received[id] = true
+			}
+		}
+	}
+	for id, count := range s.acked {
+		if !received[id] {
+			violations = append(violations, fmt.Sprintf("push %v was acknowledged but never received", id))
+		}
+		if count > 1 {
+			violations = append(violations, fmt.Sprintf("push %v was acknowledged %d times", id, count))
+		}
+	}
+
+	for _, topic := range s.server.DuplicateJoins() {
+		violations = append(violations, fmt.Sprintf("topic %v was joined twice on one connection", topic))
+	}
+	return violations
+}
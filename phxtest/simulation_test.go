@@ -0,0 +1,34 @@
+package phxtest_test
+
+import (
+	"testing"
+
+	"github.com/ongkong/phxx/phxtest"
+)
+
+func TestSimulation(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		sim := phxtest.NewSimulation(seed)
+		result, err := sim.Run()
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if result.Pushes == 0 || result.Acked == 0 {
+			t.Fatalf("seed %d: %d pushes were made and %d acknowledged", seed, result.Pushes, result.Acked)
+		}
+	}
+}
+
+func TestSimulationJoinOnOpen(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		sim := phxtest.NewSimulation(seed)
+		sim.JoinOnOpen = true
+		result, err := sim.Run()
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if result.Pushes == 0 {
+			t.Fatalf("seed %d: no pushes were made", seed)
+		}
+	}
+}
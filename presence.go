@@ -0,0 +1,408 @@
+package phx
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	presenceStateEvent = "presence_state"
+	presenceDiffEvent  = "presence_diff"
+)
+
+// PresenceEntry is the presence of one key, such as a user, who may be present several times, such as once per
+// device. Each time is described by a meta, a map with the fields given to Presence.track on the server, identified by
+// its "phx_ref".
+type PresenceEntry struct {
+	Metas []map[string]any `json:"metas"`
+}
+
+// PresenceState maps the keys of a topic's presences to their entries, like the payload of a "presence_state" event.
+type PresenceState map[string]PresenceEntry
+
+// PresenceDiff is a change to the presences of a topic, as sent by the server in a "presence_diff" event, along with
+// the state before and after it was applied.
+type PresenceDiff struct {
+	// Joins has the metas that joined, by key.
+	Joins PresenceState
+
+	// Leaves has the metas that left, by key.
+	Leaves PresenceState
+
+	// Before and After are snapshots of the state before and after the diff was applied. They must not be modified.
+	Before PresenceState
+	After  PresenceState
+
+	// Rejoined is true for a diff that wasn't sent by the server, but reconciles the state from before the Channel
+	// rejoined with the state sent on rejoin, so that it has the joins and leaves missed while disconnected.
+	Rejoined bool
+}
+
+// PresenceListing is the presence of one key, as returned by Presence.List.
+type PresenceListing struct {
+	Key   string
+	Metas []map[string]any
+}
+
+// Presence keeps the state of the presences of a Channel's topic in sync from the "presence_state" and "presence_diff"
+// events sent by Phoenix.Presence on the server, like the Presence of phoenix.js. Create one with NewPresence before
+// joining the Channel.
+type Presence struct {
+	channel        *Channel
+	mu             sync.Mutex
+	state          PresenceState
+	joinRef        Ref
+	pendingDiffs   []PresenceDiff
+	diffCallbacks  map[Ref]func(PresenceDiff)
+	joinCallbacks  map[Ref]func(key string, current PresenceEntry, joined PresenceEntry)
+	leaveCallbacks map[Ref]func(key string, current PresenceEntry, left PresenceEntry)
+	syncCallbacks  map[Ref]func()
+	bindingRefs    []Ref
+	queue          []func()
+	notifying      bool
+}
+
+// NewPresence creates a Presence that tracks the presences of the given Channel.
+func NewPresence(channel *Channel) *Presence {
+	p := &Presence{
+		channel:        channel,
+		state:          make(PresenceState),
+		diffCallbacks:  make(map[Ref]func(PresenceDiff)),
+		joinCallbacks:  make(map[Ref]func(key string, current PresenceEntry, joined PresenceEntry)),
+		leaveCallbacks: make(map[Ref]func(key string, current PresenceEntry, left PresenceEntry)),
+		syncCallbacks:  make(map[Ref]func()),
+	}
+	// The events are handled in the order they arrive, as each diff applies to the state left by the previous one
+	p.bindingRefs = []Ref{
+		channel.onInline(presenceStateEvent, p.onState),
+		channel.onInline(presenceDiffEvent, p.onDiff),
+	}
+	return p
+}
+
+// State returns a snapshot of the current presences.
+func (p *Presence) State() PresenceState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state.clone()
+}
+
+// List returns the current presences, sorted by key.
+func (p *Presence) List() []PresenceListing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]PresenceListing, 0, len(p.state))
+	for _, key := range p.state.keys() {
+		list = append(list, PresenceListing{Key: key, Metas: append([]map[string]any(nil), p.state[key].Metas...)})
+	}
+	return list
+}
+
+// OnDiff registers the given callback to be called with every diff sent by the server, once it has been applied.
+// Callbacks are called in the order the diffs were applied. Diffs received while the Channel is rejoining are applied
+// once the state for the new join arrives. When the Channel rejoins, the callbacks are also called with a diff marked
+// Rejoined for the changes between the state before and the state sent on rejoin, if there are any.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (p *Presence) OnDiff(callback func(diff PresenceDiff)) Ref {
+	ref := p.channel.refGenerator.nextRef()
+	p.mu.Lock()
+	p.diffCallbacks[ref] = callback
+	p.mu.Unlock()
+	return ref
+}
+
+// OnJoin registers the given callback to be called for each key that gains metas, whether from a diff or from the state
+// sent on join. It is given the entry of the key before the join, which has no metas if the key wasn't present, and the
+// metas that joined.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (p *Presence) OnJoin(callback func(key string, current PresenceEntry, joined PresenceEntry)) Ref {
+	ref := p.channel.refGenerator.nextRef()
+	p.mu.Lock()
+	p.joinCallbacks[ref] = callback
+	p.mu.Unlock()
+	return ref
+}
+
+// OnLeave registers the given callback to be called for each key that loses metas, whether from a diff or from the
+// state sent on join. It is given the metas the key has left, which are none if it is no longer present, and the metas
+// that left.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (p *Presence) OnLeave(callback func(key string, current PresenceEntry, left PresenceEntry)) Ref {
+	ref := p.channel.refGenerator.nextRef()
+	p.mu.Lock()
+	p.leaveCallbacks[ref] = callback
+	p.mu.Unlock()
+	return ref
+}
+
+// OnSync registers the given callback to be called whenever the state has been synced with the server, after the state
+// sent on join and after each diff, once the OnJoin and OnLeave callbacks for it have been called.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (p *Presence) OnSync(callback func()) Ref {
+	ref := p.channel.refGenerator.nextRef()
+	p.mu.Lock()
+	p.syncCallbacks[ref] = callback
+	p.mu.Unlock()
+	return ref
+}
+
+// Off removes the callback for the given Ref, as returned by OnDiff, OnJoin, OnLeave or OnSync.
+func (p *Presence) Off(ref Ref) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.diffCallbacks, ref)
+	delete(p.joinCallbacks, ref)
+	delete(p.leaveCallbacks, ref)
+	delete(p.syncCallbacks, ref)
+}
+
+// Stop stops tracking the presences of the Channel.
+func (p *Presence) Stop() {
+	for _, ref := range p.bindingRefs {
+		p.channel.Off(ref)
+	}
+}
+
+func (p *Presence) onState(payload any) {
+	var state PresenceState
+	err := p.channel.socket.Codec.Decode(payload, &state)
+	if err != nil {
+		p.channel.socket.Logger.Printf(LogError, "presence", "could not decode presence state on '%v': %v", p.channel.topic, err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rejoined := p.joinRef != 0
+	p.joinRef = p.channel.JoinRef()
+	before := p.state
+	var joins, leaves PresenceState
+	p.state, joins, leaves = syncPresenceState(p.state, state, p.notifyJoin, p.notifyLeave)
+	if rejoined && (len(joins) > 0 || len(leaves) > 0) {
+		p.notifyDiff(PresenceDiff{Joins: joins, Leaves: leaves, Before: before, After: p.state.clone(), Rejoined: true})
+	}
+	for _, diff := range p.pendingDiffs {
+		p.applyDiff(diff)
+	}
+	p.pendingDiffs = nil
+	p.notifySync()
+}
+
+func (p *Presence) onDiff(payload any) {
+	var wire struct {
+		Joins  PresenceState `json:"joins"`
+		Leaves PresenceState `json:"leaves"`
+	}
+	err := p.channel.socket.Codec.Decode(payload, &wire)
+	if err != nil {
+		p.channel.socket.Logger.Printf(LogError, "presence", "could not decode presence diff on '%v': %v", p.channel.topic, err)
+		return
+	}
+	diff := PresenceDiff{Joins: wire.Joins, Leaves: wire.Leaves}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Diffs for a join whose state hasn't arrived yet are applied once it does
+	if p.joinRef == 0 || p.joinRef != p.channel.JoinRef() {
+		p.pendingDiffs = append(p.pendingDiffs, diff)
+		return
+	}
+	p.applyDiff(diff)
+	p.notifySync()
+}
+
+// applyDiff applies the given diff to the state and notifies the OnJoin, OnLeave and OnDiff callbacks. Must be called
+// with mu locked.
+func (p *Presence) applyDiff(diff PresenceDiff) {
+	diff.Before = p.state.clone()
+	syncPresenceDiff(p.state, diff.Joins, diff.Leaves, p.notifyJoin, p.notifyLeave)
+	diff.After = p.state.clone()
+	p.notifyDiff(diff)
+}
+
+// notifyDiff notifies the OnDiff callbacks. Must be called with mu locked.
+func (p *Presence) notifyDiff(diff PresenceDiff) {
+	for _, cb := range p.diffCallbacks {
+		cb := cb
+		p.notify(func() { cb(diff) })
+	}
+}
+
+// notifyJoin notifies the OnJoin callbacks. Must be called with mu locked.
+func (p *Presence) notifyJoin(key string, current PresenceEntry, joined PresenceEntry) {
+	for _, cb := range p.joinCallbacks {
+		cb := cb
+		p.notify(func() { cb(key, current, joined) })
+	}
+}
+
+// notifyLeave notifies the OnLeave callbacks. Must be called with mu locked.
+func (p *Presence) notifyLeave(key string, current PresenceEntry, left PresenceEntry) {
+	for _, cb := range p.leaveCallbacks {
+		cb := cb
+		p.notify(func() { cb(key, current, left) })
+	}
+}
+
+// notifySync notifies the OnSync callbacks. Must be called with mu locked.
+func (p *Presence) notifySync() {
+	for _, cb := range p.syncCallbacks {
+		p.notify(cb)
+	}
+}
+
+// notify queues the given callback to be called after the ones queued before it. Must be called with mu locked.
+func (p *Presence) notify(callback func()) {
+	p.queue = append(p.queue, callback)
+	if !p.notifying {
+		p.notifying = true
+		p.channel.socket.run(p.drain)
+	}
+}
+
+// drain calls the queued callbacks in order until there are none left.
+func (p *Presence) drain() {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.notifying = false
+			p.mu.Unlock()
+			return
+		}
+		callback := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		callback()
+	}
+}
+
+// presenceChange is called by syncPresenceState and syncPresenceDiff for each key whose metas changed, with the entry
+// of the key and the metas that joined or left. For joins the entry is the one before the change, and for leaves the
+// one after it.
+type presenceChange func(key string, current PresenceEntry, changed PresenceEntry)
+
+// syncPresenceState returns the state after replacing the given state with a new one sent by the server, and the joins
+// and leaves between them. Metas that are in both keep their place, so that a key's metas stay in the order they
+// joined.
+func syncPresenceState(state PresenceState, newState PresenceState, onJoin, onLeave presenceChange) (PresenceState, PresenceState, PresenceState) {
+	joins := make(PresenceState)
+	leaves := make(PresenceState)
+
+	for key, entry := range state {
+		if _, ok := newState[key]; !ok {
+			leaves[key] = entry
+		}
+	}
+	for key, newEntry := range newState {
+		entry, ok := state[key]
+		if !ok {
+			joins[key] = newEntry
+			continue
+		}
+
+		newRefs := presenceRefs(newEntry.Metas)
+		currentRefs := presenceRefs(entry.Metas)
+		var joinedMetas, leftMetas []map[string]any
+		for _, meta := range newEntry.Metas {
+			if !currentRefs[presenceRef(meta)] {
+				joinedMetas = append(joinedMetas, meta)
+			}
+		}
+		for _, meta := range entry.Metas {
+			if !newRefs[presenceRef(meta)] {
+				leftMetas = append(leftMetas, meta)
+			}
+		}
+		if len(joinedMetas) > 0 {
+			joins[key] = PresenceEntry{Metas: joinedMetas}
+		}
+		if len(leftMetas) > 0 {
+			leaves[key] = PresenceEntry{Metas: leftMetas}
+		}
+	}
+
+	synced := state.clone()
+	syncPresenceDiff(synced, joins, leaves, onJoin, onLeave)
+	return synced, joins, leaves
+}
+
+// syncPresenceDiff applies the given joins and leaves to the state, in the order of their keys.
+func syncPresenceDiff(state PresenceState, joins PresenceState, leaves PresenceState, onJoin, onLeave presenceChange) {
+	for _, key := range joins.keys() {
+		joined := joins[key]
+		current := state[key]
+		metas := append([]map[string]any(nil), joined.Metas...)
+		if len(current.Metas) > 0 {
+			joinedRefs := presenceRefs(joined.Metas)
+			var kept []map[string]any
+			for _, meta := range current.Metas {
+				if !joinedRefs[presenceRef(meta)] {
+					kept = append(kept, meta)
+				}
+			}
+			metas = append(kept, metas...)
+		}
+		state[key] = PresenceEntry{Metas: metas}
+		onJoin(key, current, joined)
+	}
+
+	for _, key := range leaves.keys() {
+		left := leaves[key]
+		current, ok := state[key]
+		if !ok {
+			continue
+		}
+		leftRefs := presenceRefs(left.Metas)
+		var kept []map[string]any
+		for _, meta := range current.Metas {
+			if !leftRefs[presenceRef(meta)] {
+				kept = append(kept, meta)
+			}
+		}
+		if len(kept) == 0 {
+			delete(state, key)
+		} else {
+			state[key] = PresenceEntry{Metas: kept}
+		}
+		onLeave(key, PresenceEntry{Metas: kept}, left)
+	}
+}
+
+func presenceRef(meta map[string]any) string {
+	ref, _ := meta["phx_ref"].(string)
+	return ref
+}
+
+func presenceRefs(metas []map[string]any) map[string]bool {
+	refs := make(map[string]bool, len(metas))
+	for _, meta := range metas {
+		refs[presenceRef(meta)] = true
+	}
+	return refs
+}
+
+// keys returns the keys of the state in order.
+func (s PresenceState) keys() []string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clone returns a copy of the state whose entries can be changed without changing the original.
+func (s PresenceState) clone() PresenceState {
+	clone := make(PresenceState, len(s))
+	for key, entry := range s {
+		clone[key] = PresenceEntry{Metas: append([]map[string]any(nil), entry.Metas...)}
+	}
+	return clone
+}
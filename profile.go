@@ -0,0 +1,95 @@
+package phx
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// EndpointProfile is a named set of connection settings, such as for a dev, staging or prod environment, that a
+// Socket can switch between without rebuilding its configuration.
+type EndpointProfile struct {
+	// EndPoint is the URL to connect to.
+	EndPoint *url.URL
+
+	// Params are added to the query of EndPoint, overriding any params of the same name.
+	Params map[string]string
+
+	// RequestHeader is sent in the initial connection.
+	RequestHeader http.Header
+
+	// TLSConfig is used for connections when the Transport is a Websocket with a GorillaDialer. If nil, the default
+	// TLS configuration is used, rather than the one of the previous profile.
+	TLSConfig *tls.Config
+}
+
+// NewSocketWithProfiles creates a Socket with the given profiles, using the named one.
+func NewSocketWithProfiles(profiles map[string]*EndpointProfile, name string) (*Socket, error) {
+	socket := NewSocket(&url.URL{})
+	socket.Profiles = profiles
+	err := socket.UseProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return socket, nil
+}
+
+// Profile returns the name of the profile in use, or "" if UseProfile has not been called.
+func (s *Socket) Profile() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.profile
+}
+
+// UseProfile applies the settings of the named profile from Profiles to the Socket. If the Socket is connected or
+// connecting, it reconnects to the new endpoint and the joined Channels are rejoined there.
+func (s *Socket) UseProfile(name string) error {
+	profile, ok := s.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile '%v'", name)
+	}
+	if profile.EndPoint == nil {
+		return fmt.Errorf("profile '%v' has no endpoint", name)
+	}
+
+	endPoint := *profile.EndPoint
+	if len(profile.Params) > 0 {
+		q := endPoint.Query()
+		for k, v := range profile.Params {
+			q.Set(k, v)
+		}
+		endPoint.RawQuery = q.Encode()
+	}
+
+	if ws, ok := s.Transport.(*Websocket); ok {
+		if gd, ok := ws.getDialer().(*GorillaDialer); ok {
+			dialer := *gd.Dialer
+			dialer.TLSClientConfig = profile.TLSConfig
+			ws.setDialer(NewGorillaDialer(&dialer))
+		}
+	}
+
+	s.mu.Lock()
+	s.profile = name
+	s.mu.Unlock()
+
+	requestHeader := profile.RequestHeader.Clone()
+	s.setEndPoint(&endPoint, requestHeader)
+	s.Logger.Printf(LogInfo, "socket", "using profile '%v' with endpoint %v", name, &endPoint)
+
+	if !s.IsConnectedOrConnecting() {
+		return nil
+	}
+
+	switcher, ok := s.Transport.(EndpointSwitcher)
+	if !ok {
+		return fmt.Errorf("transport %T cannot switch endpoints while connected", s.Transport)
+	}
+	err := switcher.SwitchEndpoint(s.endPointWithVsn(), requestHeader)
+	if err != nil {
+		return err
+	}
+	return s.Reconnect()
+}
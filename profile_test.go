@@ -0,0 +1,37 @@
+package phx
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUseProfileWhileReconnecting(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	socket.ReconnectAfterFunc = func(tries int) time.Duration { return time.Millisecond }
+	socket.Profiles = map[string]*EndpointProfile{
+		"a": {EndPoint: &url.URL{Scheme: "ws", Host: "a.example.test", Path: "/socket"}},
+		"b": {EndPoint: &url.URL{Scheme: "ws", Host: "b.example.test", Path: "/socket"}, RequestHeader: http.Header{"X-B": {"1"}}},
+	}
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = socket.Disconnect() }()
+
+	// Each switch reconnects, so that the connection's goroutines read the endpoint while the next switch writes it
+	for i := 0; i < 50; i++ {
+		name := "a"
+		if i%2 == 1 {
+			name = "b"
+		}
+		if err := socket.UseProfile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	endPoint, requestHeader := socket.endPoint()
+	if endPoint.Host != "b.example.test" || requestHeader.Get("X-B") != "1" {
+		t.Errorf("using %v with header %v, want the last profile", endPoint, requestHeader)
+	}
+}
@@ -0,0 +1,134 @@
+package phx
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// maxProtocolErrorFrame is the number of bytes of the offending frame kept in a ProtocolError.
+const maxProtocolErrorFrame = 256
+
+// ProtocolErrorKind is the kind of protocol violation reported in a ProtocolError.
+type ProtocolErrorKind int
+
+const (
+	// ProtocolMalformed is a message that could not be decoded by the Serializer.
+	ProtocolMalformed ProtocolErrorKind = iota
+
+	// ProtocolInvalidUTF8 is a text frame that isn't valid UTF-8, as websocket requires text frames to be.
+	ProtocolInvalidUTF8
+
+	// ProtocolUnexpectedBinary is a binary frame received with a Serializer that doesn't support them, such as
+	// JSONSerializerV1.
+	ProtocolUnexpectedBinary
+
+	// ProtocolUnexpectedFrame is a frame of a type other than text or binary that the connection passed on, other than
+	// the ping and pong frames that may be interleaved with the fragments of a message, which are ignored.
+	ProtocolUnexpectedFrame
+
+	// ProtocolPanic is a message whose decoding or dispatching panicked. The panic is recovered, so that a malformed
+	// message can't bring down the application.
+	ProtocolPanic
+)
+
+func (k ProtocolErrorKind) String() string {
+	switch k {
+	case ProtocolMalformed:
+		return "malformed"
+	case ProtocolInvalidUTF8:
+		return "invalid_utf8"
+	case ProtocolUnexpectedBinary:
+		return "unexpected_binary"
+	case ProtocolUnexpectedFrame:
+		return "unexpected_frame"
+	case ProtocolPanic:
+		return "panic"
+	}
+	return "unknown"
+}
+
+// ProtocolError is a message received from the server that violates the protocol, as passed to the OnProtocolError
+// callbacks. The message is dropped with DropDecodeFailure, and the connection is kept open.
+type ProtocolError struct {
+	Kind ProtocolErrorKind
+
+	// Frame is the start of the offending frame, up to 256 bytes.
+	Frame []byte
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("protocol error: %v", e.Kind)
+	}
+	return fmt.Sprintf("protocol error: %v: %v", e.Kind, e.Err)
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// OnProtocolError registers the given callback to be called with every message received from the server that
+// violates the protocol.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnProtocolError(callback func(*ProtocolError)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.protocolErrorCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// checkFrame returns the ProtocolError of the given received frame that must not be decoded, if any.
+func (s *Socket) checkFrame(frame inboundFrame) *ProtocolError {
+	_, v1 := s.Serializer.(*JSONSerializerV1)
+	switch {
+	case frame.binary && v1:
+		return newProtocolError(ProtocolUnexpectedBinary, frame.data, nil)
+	case !frame.binary && !utf8.Valid(frame.data):
+		return newProtocolError(ProtocolInvalidUTF8, frame.data, nil)
+	}
+	return nil
+}
+
+// recoverFrame recovers from a panic while decoding or dispatching the given received frame, reporting it as a
+// ProtocolError. Must be deferred.
+func (s *Socket) recoverFrame(frame inboundFrame) {
+	if r := recover(); r != nil {
+		s.onProtocolError(newProtocolError(ProtocolPanic, frame.data, fmt.Errorf("%v", r)))
+	}
+}
+
+// implements TransportHandler
+
+// onProtocolError is called with every message received that violates the protocol.
+func (s *Socket) onProtocolError(protocolErr *ProtocolError) {
+	s.reportProtocolError(protocolErr, "", "")
+}
+
+// reportProtocolError drops the message of the given topic and event, if known, that violates the protocol, and
+// calls the OnProtocolError callbacks.
+func (s *Socket) reportProtocolError(protocolErr *ProtocolError, topic string, event string) {
+	if topic == "" {
+		s.Logger.Printf(LogWarning, "socket", "dropping message: %v", protocolErr)
+	} else {
+		s.Logger.Printf(LogWarning, "socket", "dropping '%v' on '%v': %v", event, topic, protocolErr)
+	}
+	s.drop(DropDecodeFailure, topic, event)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cb := range s.protocolErrorCallbacks {
+		cb := cb
+		s.run(func() { cb(protocolErr) })
+	}
+}
+
+func newProtocolError(kind ProtocolErrorKind, frame []byte, err error) *ProtocolError {
+	if len(frame) > maxProtocolErrorFrame {
+		frame = frame[:maxProtocolErrorFrame]
+	}
+	return &ProtocolError{Kind: kind, Frame: append([]byte(nil), frame...), Err: err}
+}
@@ -1,10 +1,41 @@
 package phx
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrPushTimeout is returned when waiting for the reply to a Push times out.
+var ErrPushTimeout = errors.New("timeout waiting for reply")
+
+// ErrPushReleased is returned when waiting for the reply to a Push whose Channel's join ended first, because it was
+// left, closed by the server, gave up joining or was removed. The reply will never be processed, or the buffered Push
+// will never be sent. See Channel.Done.
+var ErrPushReleased = errors.New("push was released because the channel's join ended")
+
+// Reply is the reply of the server to a Push.
+type Reply struct {
+	// Status is the status of the reply, such as "ok" or "error".
+	Status string
+
+	// Response is the response the server sent with the reply.
+	Response any
+}
+
+// ReplyError is returned when waiting for the reply to a Push and the server replies with an "error" status.
+type ReplyError struct {
+	// Response is the response the server sent with the error.
+	Response any
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("error reply: %v", e.Response)
+}
+
 type pushCallback func(response any)
 
 type pushBinding struct {
@@ -21,17 +52,32 @@ type Push struct {
 	// Payload is whatever payload you want to attach to the push. Must be JSON serializable.
 	Payload any
 
-	// Timeout is the time to wait for a reply before triggering a "timeout" event.
+	// Timeout is the time to wait for a reply before triggering a "timeout" event. Zero uses the Socket's PushTimeout.
 	Timeout time.Duration
 
+	// OrderingKey, if set, sends this Push in order with other pushes with the same key, but independently of pushes
+	// with other keys, such as the updates for one entity.
+	OrderingKey string
+
 	mu           sync.RWMutex
 	channel      *Channel
 	Ref          Ref
-	timeoutTimer *time.Timer
+	timeoutTimer Timer
 	callbacks    []*pushBinding
 	sent         bool
 	bindingRef   Ref
 	reply        any
+	timedOut     bool
+	released     bool // timed out right away because the Channel's join ended, see ErrPushReleased
+	sentAt       time.Time
+	repliedAt    time.Time
+	stampID      string
+	bufferedSize int
+	pendingSize  int // size of the sent message while awaiting its reply, counted in Socket.pendingPushBytes
+	binary       bool
+	awaiting     bool            // counted in the Socket's awaitingReplies
+	done         <-chan struct{} // the Channel's Done channel of the join the Push was sent in
+	endSpan      func(error)     // ends the phx.push span of the Instrumenter
 }
 
 // NewPush gets a new Push ready to send and allows you to attach event handlers for replies, errors, timeouts.
@@ -48,62 +94,219 @@ func NewPush(channel *Channel, event string, payload any, timeout time.Duration)
 
 // Send will actually push the event to the server.
 func (p *Push) Send() error {
-	p.reset()
-	p.Ref = p.channel.socket.MakeRef()
-	p.timeoutTimer = time.AfterFunc(p.Timeout, p.timeout)
+	msg, err := p.prepare()
+	if err != nil {
+		return err
+	}
 
-	err := p.channel.socket.PushMessage(Message{
-		Topic:   p.channel.topic,
-		Event:   p.Event,
-		Payload: p.Payload,
-		Ref:     p.Ref,
-		JoinRef: p.channel.JoinRef(),
-	})
+	var size int
+	if p.OrderingKey != "" {
+		size, err = p.channel.socket.pushMessageInLane(msg, p.OrderingKey, func(err error) {
+			p.channel.stats.error()
+		})
+	} else {
+		size, err = p.channel.socket.pushMessage(msg, false)
+	}
 	if err != nil {
+		p.channel.stats.error()
 		return err
 	}
-	p.sent = true
+	p.markSent(size)
+
+	return nil
+}
 
-	p.bindingRef = p.channel.OnRef(p.Ref, string(ReplyEvent), func(payload any) {
+// prepare gets the Push ready to be sent with a new Ref, listening for its reply and starting its timeout, and returns
+// the Message to send.
+func (p *Push) prepare() (Message, error) {
+	p.reset()
+
+	payload := p.Payload
+	if p.Event == string(JoinEvent) {
+		params, err := p.channel.joinParams()
+		if err != nil {
+			return Message{}, fmt.Errorf("join interceptor: %w", err)
+		}
+		payload = params
+		if p.channel.joinPayload != nil {
+			payload = p.channel.joinPayload(params)
+		}
+	} else if p.binary {
+		data, _ := payload.([]byte)
+		payload = binaryPayload(data)
+	} else if p.Event != string(LeaveEvent) {
+		if stamper := p.channel.socket.Stamper; stamper != nil {
+			p.mu.Lock()
+			payload = stamper.stamp(payload, p.channel.socket.Clock.Now(), &p.stampID, p.channel.socket.random())
+			p.mu.Unlock()
+		}
+		if serializer := p.channel.PayloadSerializer; serializer != nil {
+			var err error
+			payload, err = p.channel.marshalPayload(serializer, payload)
+			if err != nil {
+				return Message{}, err
+			}
+		}
+	}
+
+	// Ref is stored atomically, as Channel.JoinRef reads it from other goroutines
+	ref := p.channel.socket.MakeRef()
+	atomic.StoreUint64((*uint64)(&p.Ref), uint64(ref))
+
+	// Listen for the reply before sending, so that a quick reply can't be missed
+	bindingRef := p.channel.OnRef(ref, string(ReplyEvent), func(payload any) {
 		// This runs in the Transports goroutine
+		if p.Event == string(JoinEvent) {
+			payload = p.channel.interceptJoinReply(payload)
+		}
+
 		p.mu.Lock()
 		defer p.mu.Unlock()
 
 		p.cancelTimeout()
+		p.settle()
 		p.channel.Off(p.bindingRef)
 		p.reply = payload
+		p.repliedAt = p.channel.socket.Clock.Now()
+		p.channel.stats.replied(p.Event, p.repliedAt.Sub(p.sentAt))
 		p.callCallbacks(payload)
 	})
 
-	return nil
+	p.mu.Lock()
+	p.timedOut = false
+	p.released = false
+	p.reply = nil
+	p.sentAt = p.channel.socket.Clock.Now()
+	p.bindingRef = bindingRef
+	if !p.awaiting {
+		p.awaiting = true
+		atomic.AddInt64(&p.channel.socket.awaitingReplies, 1)
+		p.channel.trackPending(p, true)
+	}
+	p.startSpan()
+	p.mu.Unlock()
+	p.startTimeout()
+
+	return Message{
+		Topic:   p.channel.topic,
+		Event:   p.Event,
+		Payload: payload,
+		Ref:     ref,
+		JoinRef: p.channel.JoinRef(),
+	}, nil
+}
+
+// markSent records that the Message of the Push was sent, taking size bytes.
+func (p *Push) markSent(size int) {
+	p.mu.Lock()
+	p.sent = true
+	awaiting := p.awaiting && p.pendingSize == 0
+	if awaiting {
+		p.pendingSize = size
+		atomic.AddInt64(&p.channel.socket.pendingPushBytes, int64(size))
+	}
+	p.mu.Unlock()
+	if awaiting {
+		p.channel.socket.checkMemory()
+	}
+	p.channel.stats.sent(size, p.channel.socket.Clock.Now())
 }
 
 func (p *Push) IsSent() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	return p.sent
 }
 
+// IsTimedOut returns true if the Push timed out waiting for a reply, including when it was released.
+func (p *Push) IsTimedOut() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.timedOut
+}
+
+// IsReleased returns true if the Push timed out right away because the join of its Channel ended before it was
+// replied to, or before it could be sent. See ErrPushReleased.
+func (p *Push) IsReleased() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.released
+}
+
+// timeoutErr returns the error for a "timeout" of the Push: ErrPushReleased if it was released, or else ErrPushTimeout.
+func (p *Push) timeoutErr() error {
+	if p.IsReleased() {
+		return ErrPushReleased
+	}
+	return ErrPushTimeout
+}
+
 // Receive registers the given event handler for the given status.
 // Built in Events such as Join, Leave will respond with "ok", "error" and "timeout".
 // Custom event handlers (handle_in/3) in your Channel on the server can respond with any string event they want.
 // If a custom event handler (handle_in/3) does not reply (returns :noreply) then the only events that will trigger
 // here are "error" and "timeout".
+//
+// If the reply already arrived, or the Push already timed out, less than Channel.ReplyCacheTTL ago, a callback for its
+// status is called right away, so handlers attached after sending still fire.
 func (p *Push) Receive(status string, callback pushCallback) {
+	p.mu.Lock()
+	if (p.reply != nil || p.timedOut) && p.channel.socket.Clock.Now().Sub(p.repliedAt) > p.channel.ReplyCacheTTL {
+		// Forget the reply, so that it can be freed while the Push is still referenced
+		p.reply = nil
+		p.timedOut = false
+	}
 	if p.reply != nil {
 		replyStatus, replyResponse, ok := p.deconstructPayload(p.reply)
 		if ok && replyStatus == status {
+			p.mu.Unlock()
 			callback(replyResponse)
 			return
 		}
 	}
-
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	if p.timedOut && status == "timeout" {
+		p.mu.Unlock()
+		callback(nil)
+		return
+	}
 	p.callbacks = append(p.callbacks, &pushBinding{status: status, callback: callback})
+	p.mu.Unlock()
+}
+
+// OnTimeout registers the given callback to be called if the server doesn't reply within Timeout, such as when the
+// reply is lost. It is a shorthand for Receive("timeout", ...).
+func (p *Push) OnTimeout(callback func()) {
+	p.Receive("timeout", func(response any) { callback() })
+}
+
+// Await blocks until the server replies to the Push, and returns the reply. An "error" reply is returned along with a
+// *ReplyError. If there is no reply within the given timeout, or within the Push's Timeout, ErrPushTimeout is returned,
+// and if the Channel's join ends first, ErrPushReleased. A timeout of zero waits for as long as the Push's Timeout.
+// Use AwaitCtx to wait with a context instead.
+func (p *Push) Await(timeout time.Duration) (Reply, error) {
+	if timeout <= 0 {
+		timeout = p.Timeout
+	}
+	ctx, cancel := p.channel.socket.timeoutContext(timeout)
+	defer cancel()
+	reply, err := p.AwaitCtx(ctx)
+	if err == context.Canceled {
+		err = ErrPushTimeout
+	}
+	return reply, err
 }
 
 func (p *Push) callCallbacks(payload any) {
 	status, response, ok := p.deconstructPayload(payload)
 	if ok {
+		if status == "error" {
+			p.channel.stats.error()
+		}
+		p.emitTelemetry(status)
+		p.finishSpan(status, response)
 		p.trigger(status, response)
 	}
 }
@@ -132,11 +335,31 @@ func (p *Push) deconstructPayload(payload any) (status string, response any, ok
 func (p *Push) trigger(status string, response any) {
 	for _, callback := range p.callbacks {
 		if callback.status == status {
-			go callback.callback(response)
+			cb := callback.callback
+			p.channel.socket.run(func() { cb(response) })
 		}
 	}
 }
 
+func (p *Push) startTimeout() {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = p.channel.socket.PushTimeout
+	}
+	done := p.channel.Done()
+	if isDone(done) || p.Event == string(LeaveEvent) {
+		// Pushes made after the join ended, such as ones buffered until the next join, still time out, and so do
+		// leaves, which end the join themselves
+		done = nil
+	}
+	timer := p.channel.socket.Clock.AfterFunc(timeout, p.timeout)
+	p.mu.Lock()
+	p.timeoutTimer = timer
+	p.done = done
+	p.mu.Unlock()
+}
+
+// cancelTimeout stops the timeout timer. Must be called with mu locked.
 func (p *Push) cancelTimeout() {
 	if p.timeoutTimer != nil {
 		p.timeoutTimer.Stop()
@@ -149,14 +372,75 @@ func (p *Push) timeout() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if isDone(p.done) {
+		// The join ended since, and releasing it raced with this timeout
+		p.releaseLocked()
+		return
+	}
+	p.timedOut = true
+	p.settle()
+	p.repliedAt = p.channel.socket.Clock.Now()
+	p.channel.stats.error()
+	p.emitTelemetry("timeout")
+	p.finishSpan("timeout", nil)
 	p.trigger("timeout", nil)
 }
 
+// release times out the Push right away as released when the join of its Channel ended, so that whoever waits for its
+// reply isn't left hanging. A Push that was buffered until the join is always released, while one that was sent is only
+// released if it was sent in the join that ended, unlike a leave, which ends the join itself and still times out.
+// Returns true if the Push was released.
+func (p *Push) release(buffered bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !buffered && (!p.awaiting || !isDone(p.done)) {
+		return false
+	}
+	if p.timedOut || (p.timeoutTimer != nil && !p.timeoutTimer.Stop()) {
+		// Already timed out, or about to, see timeout
+		return false
+	}
+	p.releaseLocked()
+	return true
+}
+
+// releaseLocked settles the Push as released and calls its "timeout" callbacks. Must be called with mu locked.
+func (p *Push) releaseLocked() {
+	p.timeoutTimer = nil
+	p.timedOut = true
+	p.released = true
+	p.settle()
+	p.repliedAt = p.channel.socket.Clock.Now()
+	if end := p.endSpan; end != nil {
+		p.endSpan = nil
+		end(ErrPushReleased)
+	}
+	p.trigger("timeout", nil)
+}
+
+// settle stops counting this Push as awaiting a reply. Must be called with mu locked.
+func (p *Push) settle() {
+	if p.awaiting {
+		p.awaiting = false
+		atomic.AddInt64(&p.channel.socket.awaitingReplies, -1)
+		atomic.AddInt64(&p.channel.socket.pendingPushBytes, -int64(p.pendingSize))
+		p.pendingSize = 0
+		p.channel.trackPending(p, false)
+	}
+}
+
 // reset this push so that it will no longer timeout and won't process messages from the server.
 func (p *Push) reset() {
+	p.mu.Lock()
 	p.cancelTimeout()
-	if p.Ref != 0 {
-		p.channel.Off(p.Ref)
+	p.settle()
+	bindingRef := p.bindingRef
+	p.bindingRef = 0
+	p.mu.Unlock()
+
+	if bindingRef != 0 {
+		p.channel.Off(bindingRef)
 	}
-	p.Ref = 0
+	atomic.StoreUint64((*uint64)(&p.Ref), 0)
 }
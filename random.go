@@ -0,0 +1,52 @@
+package phx
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ongkong/phxx/retry"
+)
+
+// lockedRandom reads from the Rand of a Socket, one read at a time, so that Rand needn't be safe for concurrent use.
+type lockedRandom struct {
+	socket *Socket
+}
+
+func (r lockedRandom) Read(p []byte) (int, error) {
+	r.socket.randMu.Lock()
+	defer r.socket.randMu.Unlock()
+
+	random := r.socket.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	return io.ReadFull(random, p)
+}
+
+// random returns the source of randomness of the Socket.
+func (s *Socket) random() io.Reader {
+	return lockedRandom{socket: s}
+}
+
+// defaultReconnectAfter is the default ReconnectAfterFunc, which jitters retry.ReconnectSchedule with randomness from
+// Rand.
+func (s *Socket) defaultReconnectAfter(tries int) time.Duration {
+	return retry.JitterFrom(s.random(), retry.ReconnectSchedule, retry.ReconnectJitter)(tries)
+}
+
+// defaultRejoinAfter is the default RejoinAfterFunc of the Channels of the Socket, which jitters retry.RejoinSchedule
+// with randomness from Rand.
+func (s *Socket) defaultRejoinAfter(tries int) time.Duration {
+	return retry.JitterFrom(s.random(), retry.RejoinSchedule, retry.RejoinJitter)(tries)
+}
+
+// newUUID returns a version 4 UUID read from the given source of randomness.
+func newUUID(random io.Reader) string {
+	var b [16]byte
+	_, _ = io.ReadFull(random, b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
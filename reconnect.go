@@ -0,0 +1,194 @@
+package phx
+
+import (
+	"time"
+)
+
+// ReconnectInfo describes a successful reconnection, as passed to Socket.OnReconnected callbacks.
+type ReconnectInfo struct {
+	// Downtime is the time between losing the connection and it being opened again.
+	Downtime time.Duration
+
+	// Attempts is the number of connection attempts it took to reconnect, including the successful one.
+	Attempts int
+
+	// AllRejoined is true if every Channel that was joined when the connection was lost rejoined successfully.
+	AllRejoined bool
+}
+
+// OnReconnected registers the given callback to be called after the Socket reconnects following the loss of its
+// connection, once every previously joined Channel has rejoined or failed to do so within its PushTimeout. This can be
+// used to decide whether a full resync is needed or the live stream can be trusted.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnReconnected(callback func(info ReconnectInfo)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.reconnectedCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// noteConnClose records when the connection was lost and which channels will need to rejoin.
+func (s *Socket) noteConnClose() {
+	// Closing on purpose with Disconnect is not a lost connection
+	if s.ConnectionState() == ConnectionClosing {
+		return
+	}
+
+	var rejoin []*Channel
+	for _, channel := range s.channelList() {
+		if channel.IsJoined() || channel.IsJoining() || channel.IsErrored() {
+			rejoin = append(rejoin, channel)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.disconnectedAt.IsZero() {
+		s.disconnectedAt = s.Clock.Now()
+		s.connectAttempts = 0
+		s.rejoinChannels = rejoin
+	}
+}
+
+// OnGiveUp registers the given callback to be called when the Socket gives up reconnecting after
+// MaxReconnectAttempts failed attempts in a row, with the error of the last one. The Socket is disconnected by then,
+// and can be connected again with Connect.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnGiveUp(callback func(err error)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.giveUpCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// noteConnError counts failed connection attempts while disconnected, and records the error of the last one. Returns
+// the number of attempts that failed in a row.
+func (s *Socket) noteConnError(err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connectAttempts++
+	s.lastConnectErr = err
+	return s.connectAttempts
+}
+
+// giveUp disconnects after the given number of failed connection attempts, and calls the OnGiveUp callbacks with the
+// error of the last one.
+func (s *Socket) giveUp(attempts int, err error) {
+	s.Logger.Printf(LogError, "socket", "giving up after %v failed connection attempts: %v", attempts, err)
+	_ = s.Disconnect()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cb := range s.giveUpCallbacks {
+		cb := cb
+		s.run(func() { cb(err) })
+	}
+}
+
+// forgetDisconnect clears the reconnection state, such as when the user disconnects on purpose.
+func (s *Socket) forgetDisconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.disconnectedAt = time.Time{}
+	s.connectAttempts = 0
+	s.rejoinChannels = nil
+}
+
+// noteConnOpen calls the OnReconnected callbacks if this open is a reconnection.
+func (s *Socket) noteConnOpen() {
+	s.mu.Lock()
+	disconnectedAt := s.disconnectedAt
+	info := ReconnectInfo{
+		Downtime: s.Clock.Now().Sub(disconnectedAt),
+		Attempts: s.connectAttempts + 1,
+	}
+	channels := s.rejoinChannels
+	s.disconnectedAt = time.Time{}
+	s.connectAttempts = 0
+	s.rejoinChannels = nil
+	s.mu.Unlock()
+
+	if disconnectedAt.IsZero() {
+		return
+	}
+	s.instrumentReconnect(info.Downtime, info.Attempts)
+
+	if s.ResyncAfter > 0 && info.Downtime > s.ResyncAfter {
+		for _, channel := range channels {
+			channel.requestResync(info.Downtime)
+		}
+	}
+
+	goLabeled(s.profilerLabels(roleReconnect), func() {
+		info.AllRejoined = s.waitForRejoin(channels)
+		s.Logger.Printf(LogInfo, "socket", "Reconnected after %v and %v attempts. All rejoined: %v", info.Downtime, info.Attempts, info.AllRejoined)
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, cb := range s.reconnectedCallbacks {
+			cb := cb
+			s.run(func() { cb(info) })
+		}
+	})
+}
+
+// waitForRejoin waits for the given channels to be joined again and returns true if they all did, or false if any of
+// them was closed or didn't within their PushTimeout. It waits on the Socket's Clock, so that it follows a fake one in
+// tests, and checks the channels again whenever one of them joins, errors or closes.
+func (s *Socket) waitForRejoin(channels []*Channel) bool {
+	changed := make(chan struct{}, 1)
+	signal := func(payload any) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	var timeout time.Duration
+	for _, channel := range channels {
+		if channel.PushTimeout > timeout {
+			timeout = channel.PushTimeout
+		}
+		refs := []Ref{channel.OnJoin(signal), channel.OnError(signal), channel.OnClose(signal)}
+		defer func(channel *Channel) {
+			for _, ref := range refs {
+				channel.Off(ref)
+			}
+		}(channel)
+	}
+	deadline := s.Clock.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		joined, closed := rejoinState(channels)
+		if joined || closed {
+			return joined
+		}
+		select {
+		case <-changed:
+		case <-deadline.C():
+			joined, _ = rejoinState(channels)
+			return joined
+		}
+	}
+}
+
+// rejoinState returns whether all the given channels are joined, and whether any of them is closed or removed, so
+// that it won't rejoin.
+func rejoinState(channels []*Channel) (joined bool, closed bool) {
+	joined = true
+	for _, channel := range channels {
+		if !channel.IsJoined() {
+			joined = false
+		}
+		if channel.IsClosed() || channel.IsRemoved() {
+			closed = true
+		}
+	}
+	return joined, closed
+}
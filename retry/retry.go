@@ -0,0 +1,229 @@
+// Package retry retries operations using the same backoff schedules the phx package uses to reconnect Sockets and
+// rejoin Channels, so that applications can retry their own channel-level operations, such as the pushes of a
+// resync, consistently with the library.
+package retry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Schedule returns the time to wait before the given try, starting with 1 for the first retry. It has the same
+// signature as Socket.ReconnectAfterFunc and Channel.RejoinAfterFunc, so schedules can be used for either.
+type Schedule func(tries int) time.Duration
+
+// Steps returns a Schedule that waits for each of the given steps in turn, and then for after on every later try.
+func Steps(steps []time.Duration, after time.Duration) Schedule {
+	steps = append([]time.Duration(nil), steps...)
+	return func(tries int) time.Duration {
+		if tries >= 1 && tries-1 < len(steps) {
+			return steps[tries-1]
+		}
+		return after
+	}
+}
+
+// Exponential returns a Schedule that waits for base on the first try and doubles the wait on every later try, up to
+// max.
+func Exponential(base time.Duration, max time.Duration) Schedule {
+	return func(tries int) time.Duration {
+		wait := base
+		for i := 1; i < tries; i++ {
+			wait *= 2
+			if wait >= max || wait <= 0 {
+				return max
+			}
+		}
+		if wait > max {
+			return max
+		}
+		return wait
+	}
+}
+
+// Jitter returns a Schedule that randomly shortens or lengthens the waits of the given schedule by up to the given
+// fraction, such as 0.2 for ±20%, so that many clients retrying at once don't all hit the server at the same time.
+func Jitter(schedule Schedule, fraction float64) Schedule {
+	return JitterFrom(rand.Reader, schedule, fraction)
+}
+
+// JitterFrom returns a Schedule like Jitter, whose randomness is read from the given source, such as crypto/rand.Reader
+// or a seeded *math/rand.Rand to reproduce the same waits. If the source is nil or fails, the waits of the schedule
+// are returned unchanged. The source must be safe for concurrent use if the Schedule is.
+func JitterFrom(random io.Reader, schedule Schedule, fraction float64) Schedule {
+	return func(tries int) time.Duration {
+		wait := schedule(tries)
+		f, ok := float64From(random)
+		if !ok {
+			return wait
+		}
+		delta := float64(wait) * fraction * (2*f - 1)
+		wait += time.Duration(delta)
+		if wait < 0 {
+			return 0
+		}
+		return wait
+	}
+}
+
+// FullJitter returns a Schedule that waits for a random time between zero and the wait of the given schedule, such
+// as FullJitter(Exponential(100*time.Millisecond, 30*time.Second)). It spreads retries further apart than Jitter, at
+// the cost of some retries coming sooner than the schedule.
+func FullJitter(schedule Schedule) Schedule {
+	return FullJitterFrom(rand.Reader, schedule)
+}
+
+// FullJitterFrom returns a Schedule like FullJitter, whose randomness is read from the given source, like JitterFrom.
+// If the source is nil or fails, the waits of the schedule are returned unchanged.
+func FullJitterFrom(random io.Reader, schedule Schedule) Schedule {
+	return func(tries int) time.Duration {
+		wait := schedule(tries)
+		if wait <= 0 {
+			return 0
+		}
+		f, ok := float64From(random)
+		if !ok {
+			return wait
+		}
+		return time.Duration(f * float64(wait))
+	}
+}
+
+// float64From returns a random number in [0, 1) read from the given source, or false if it is nil or fails.
+func float64From(random io.Reader) (float64, bool) {
+	if random == nil {
+		return 0, false
+	}
+	var b [8]byte
+	_, err := io.ReadFull(random, b[:])
+	if err != nil {
+		return 0, false
+	}
+	// The top 53 bits, which a float64 represents exactly
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53), true
+}
+
+// ReconnectSchedule is the schedule a Socket uses to reconnect by default, with a Jitter of ReconnectJitter.
+var ReconnectSchedule = Steps([]time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	150 * time.Millisecond,
+	200 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1000 * time.Millisecond,
+	2000 * time.Millisecond,
+}, 5000*time.Millisecond)
+
+// ReconnectJitter is the fraction by which a Socket randomly shortens or lengthens the waits of ReconnectSchedule by
+// default, so that clients that lost their connections at once don't all reconnect at the same time.
+const ReconnectJitter = 0.2
+
+// RejoinSchedule is the schedule a Channel uses to rejoin by default, with a Jitter of RejoinJitter.
+var RejoinSchedule = Steps([]time.Duration{
+	1000 * time.Millisecond,
+	2000 * time.Millisecond,
+	5000 * time.Millisecond,
+}, 10000*time.Millisecond)
+
+// RejoinJitter is the fraction by which a Channel randomly shortens or lengthens the waits of RejoinSchedule by
+// default, so that clients whose joins failed at once don't all rejoin at the same time.
+const RejoinJitter = 0.2
+
+// Policy determines how Do retries an operation.
+type Policy struct {
+	// Schedule is the time to wait before each retry. Defaults to RejoinSchedule.
+	Schedule Schedule
+
+	// MaxTries is the number of times the operation is called before giving up. Zero (the default) means retry until
+	// the operation succeeds, returns a permanent error, or the context is done.
+	MaxTries int
+
+	// Retryable, if set, is called with every error returned by the operation, and returns whether it should be
+	// retried. Errors wrapped with Permanent are never retried, whether or not Retryable is set.
+	Retryable func(err error) bool
+
+	// OnRetry, if set, is called before waiting to retry with the number of tries so far, the error of the last try
+	// and the time that will be waited.
+	OnRetry func(tries int, err error, wait time.Duration)
+}
+
+// permanentError marks an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps the given error so that Do returns it right away instead of retrying. Do returns the wrapped error,
+// not the wrapper.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if the given error was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Do calls op until it returns nil, returns an error that isn't retryable, MaxTries is reached, or the context is
+// done, waiting between tries according to the policy's Schedule. It returns nil on success, the error of the last
+// try, wrapped, when giving up, or the context's error, mentioning the error of the last try, when the context is done first.
+func Do(ctx context.Context, policy Policy, op func(ctx context.Context) error) error {
+	schedule := policy.Schedule
+	if schedule == nil {
+		schedule = RejoinSchedule
+	}
+
+	for tries := 1; ; tries++ {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		err = op(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+		if policy.MaxTries > 0 && tries >= policy.MaxTries {
+			return fmt.Errorf("giving up after %d tries: %w", tries, err)
+		}
+
+		wait := schedule(tries)
+		if policy.OnRetry != nil {
+			policy.OnRetry(tries, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w after %d tries, last error: %v", ctx.Err(), tries, err)
+		case <-timer.C:
+		}
+	}
+}
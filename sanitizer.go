@@ -0,0 +1,199 @@
+package phx
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SanitizeAction is what a Sanitizer does with a string that is too long or isn't valid UTF-8.
+type SanitizeAction int
+
+const (
+	// SanitizeTruncate truncates strings to MaxStringLength, and replaces invalid UTF-8 with the replacement
+	// character.
+	SanitizeTruncate SanitizeAction = iota
+
+	// SanitizeRemove removes the field or element holding the string.
+	SanitizeRemove
+
+	// SanitizeDrop drops the whole message, which is reported to the OnDrop callbacks with DropMiddleware.
+	SanitizeDrop
+)
+
+func (a SanitizeAction) String() string {
+	switch a {
+	case SanitizeTruncate:
+		return "truncate"
+	case SanitizeRemove:
+		return "remove"
+	case SanitizeDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// SanitizedField describes a string that a Sanitizer found in a payload, as passed to Sanitizer.OnSanitize.
+type SanitizedField struct {
+	Topic string
+	Event string
+
+	// Path is where the string is in the payload, such as "user.name" or "items[2]", or empty for the payload itself.
+	Path string
+
+	// Length is the length of the string in bytes.
+	Length int
+
+	// InvalidUTF8 is true if the string isn't valid UTF-8, and false if it is too long.
+	InvalidUTF8 bool
+
+	// Action is what was done with it.
+	Action SanitizeAction
+}
+
+// Sanitizer cleans the strings in the payloads of inbound messages before any handler sees them, so that hostile or
+// broken payloads don't reach the databases and logs downstream that choke on them. Add its Intercept method to a
+// Socket with Socket.Use. It looks at string values, including those in maps and slices, but not at map keys.
+type Sanitizer struct {
+	// MaxStringLength is the length in bytes beyond which strings are sanitized. Zero means no limit.
+	MaxStringLength int
+
+	// Action is what is done with strings that are too long or aren't valid UTF-8. Defaults to SanitizeTruncate.
+	Action SanitizeAction
+
+	// OnSanitize, if set, is called with every string that was sanitized. It is called in the Socket's reading
+	// goroutine, so it should not block.
+	OnSanitize func(field SanitizedField)
+}
+
+// NewSanitizer creates a Sanitizer that truncates strings to the given length in bytes.
+func NewSanitizer(maxStringLength int) *Sanitizer {
+	return &Sanitizer{MaxStringLength: maxStringLength}
+}
+
+// Intercept implements Interceptor, sanitizing the payloads of inbound messages. Payloads are copied where they
+// change, rather than changed in place.
+func (z *Sanitizer) Intercept(next MessageHandler) MessageHandler {
+	return func(dir Direction, msg Message) error {
+		if dir != Inbound {
+			return next(dir, msg)
+		}
+
+		sanitizing := sanitizing{sanitizer: z, msg: &msg}
+		payload, _, keep := sanitizing.value(msg.Payload, "")
+		if sanitizing.drop {
+			return nil
+		}
+		if !keep {
+			payload = nil
+		}
+		msg.Payload = payload
+		return next(dir, msg)
+	}
+}
+
+// sanitizing is the state of sanitizing the payload of one message.
+type sanitizing struct {
+	sanitizer *Sanitizer
+	msg       *Message
+	drop      bool
+}
+
+// value returns the sanitized value at the given path, whether it changed, and false if it must be removed.
+func (s *sanitizing) value(value any, path string) (any, bool, bool) {
+	switch v := value.(type) {
+	case string:
+		return s.string(v, path)
+	case map[string]any:
+		var sanitized map[string]any
+		for key, item := range v {
+			item, changed, keep := s.value(item, joinPath(path, key))
+			if !changed || s.drop {
+				continue
+			}
+			if sanitized == nil {
+				sanitized = shallowCopy(v)
+			}
+			if keep {
+				sanitized[key] = item
+			} else {
+				delete(sanitized, key)
+			}
+		}
+		if sanitized == nil {
+			return v, false, true
+		}
+		return sanitized, true, true
+	case []any:
+		var sanitized []any
+		for i, item := range v {
+			item, changed, keep := s.value(item, path+"["+strconv.Itoa(i)+"]")
+			if s.drop {
+				break
+			}
+			if changed && sanitized == nil {
+				sanitized = append(make([]any, 0, len(v)), v[:i]...)
+			}
+			if sanitized != nil && keep {
+				sanitized = append(sanitized, item)
+			}
+		}
+		if sanitized == nil {
+			return v, false, true
+		}
+		return sanitized, true, true
+	}
+	return value, false, true
+}
+
+// string returns the given string sanitized, whether it changed, and false if it must be removed.
+func (s *sanitizing) string(value string, path string) (any, bool, bool) {
+	maxLength := s.sanitizer.MaxStringLength
+	invalid := !utf8.ValidString(value)
+	if !invalid && (maxLength <= 0 || len(value) <= maxLength) {
+		return value, false, true
+	}
+
+	action := s.sanitizer.Action
+	if s.sanitizer.OnSanitize != nil {
+		s.sanitizer.OnSanitize(SanitizedField{
+			Topic:       s.msg.Topic,
+			Event:       s.msg.Event,
+			Path:        path,
+			Length:      len(value),
+			InvalidUTF8: invalid,
+			Action:      action,
+		})
+	}
+
+	switch action {
+	case SanitizeRemove:
+		return nil, true, false
+	case SanitizeDrop:
+		s.drop = true
+		return nil, true, false
+	}
+	if invalid {
+		value = strings.ToValidUTF8(value, string(utf8.RuneError))
+	}
+	if maxLength > 0 && len(value) > maxLength {
+		value = truncateUTF8(value, maxLength)
+	}
+	return value, true, true
+}
+
+// truncateUTF8 returns the longest prefix of the given string of at most n bytes that doesn't split a rune.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// joinPath returns the path of the given key of the map at the given path.
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
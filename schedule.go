@@ -0,0 +1,109 @@
+package phx
+
+import (
+	"sync"
+	"time"
+)
+
+// ScheduledPush is a Push that will be sent at a later time, as returned by Channel.PushAt and Channel.PushAfter.
+// Since the Channel buffers pushes while it is not joined, a ScheduledPush that comes due while the Socket is
+// reconnecting is sent once the Channel has rejoined.
+type ScheduledPush struct {
+	mu        sync.Mutex
+	channel   *Channel
+	event     string
+	payload   any
+	timer     Timer
+	push      *Push
+	canceled  bool
+	callbacks []*pushBinding
+}
+
+// PushAt will push the given event and payload at the given time. Returns a ScheduledPush that can be canceled.
+func (c *Channel) PushAt(t time.Time, event string, payload any) *ScheduledPush {
+	return c.PushAfter(t.Sub(c.socket.Clock.Now()), event, payload)
+}
+
+// PushAfter will push the given event and payload after the given duration. Returns a ScheduledPush that can be
+// canceled.
+func (c *Channel) PushAfter(d time.Duration, event string, payload any) *ScheduledPush {
+	sp := &ScheduledPush{
+		channel: c,
+		event:   event,
+		payload: payload,
+	}
+
+	c.mu.Lock()
+	c.scheduled[sp] = struct{}{}
+	c.mu.Unlock()
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.timer = c.socket.Clock.AfterFunc(d, sp.send)
+
+	return sp
+}
+
+// Cancel stops the push from being sent. Returns false if it was already sent or canceled.
+func (sp *ScheduledPush) Cancel() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.canceled || sp.push != nil {
+		return false
+	}
+	sp.canceled = true
+	sp.forget()
+	return sp.timer.Stop()
+}
+
+// forget removes this ScheduledPush from its Channel once it is no longer pending.
+func (sp *ScheduledPush) forget() {
+	sp.channel.mu.Lock()
+	defer sp.channel.mu.Unlock()
+
+	delete(sp.channel.scheduled, sp)
+}
+
+// Push returns the Push that was sent, or nil if it hasn't been sent yet.
+func (sp *ScheduledPush) Push() *Push {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	return sp.push
+}
+
+// Receive registers the given event handler for the given status on the Push once it is sent. See Push.Receive.
+func (sp *ScheduledPush) Receive(status string, callback pushCallback) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.push != nil {
+		sp.push.Receive(status, callback)
+		return
+	}
+	sp.callbacks = append(sp.callbacks, &pushBinding{status: status, callback: callback})
+}
+
+// send is called by the timer when the push is due. It runs in the Timer's goroutine.
+func (sp *ScheduledPush) send() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.canceled {
+		return
+	}
+	sp.forget()
+
+	push, err := sp.channel.Push(sp.event, sp.payload)
+	if err != nil {
+		sp.channel.socket.Logger.Printf(LogError, "channel", "error sending scheduled push '%v' on '%v': %v", sp.event, sp.channel.topic, err)
+		return
+	}
+
+	for _, binding := range sp.callbacks {
+		push.Receive(binding.status, binding.callback)
+	}
+	sp.callbacks = nil
+	sp.push = push
+}
@@ -0,0 +1,137 @@
+package phx
+
+import "errors"
+
+// ErrSendQueueFull is returned when sending a message while the Transport's send queue is full and the Socket's
+// SendQueuePolicy is OverflowError.
+var ErrSendQueueFull = errors.New("send queue is full")
+
+// OverflowPolicy is what happens to a message sent while the Transport's send queue is full, such as while the
+// connection is down for long. See Socket.SendQueuePolicy.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest message in the queue to make room.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the message being sent.
+	OverflowDropNewest
+
+	// OverflowError returns ErrSendQueueFull for the message being sent.
+	OverflowError
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// SendQueueFull describes a message sent while the send queue was full, as passed to Socket.OnSendQueueFull
+// callbacks.
+type SendQueueFull struct {
+	// Policy is the SendQueuePolicy that was applied.
+	Policy OverflowPolicy
+
+	// Size is the number of messages the queue holds.
+	Size int
+
+	// Dropped is true if a message was dropped, which is also reported to the OnDrop callbacks with
+	// DropQueueOverflow.
+	Dropped bool
+}
+
+// OnSendQueueFull registers the given callback to be called whenever a message is sent while the Transport's send
+// queue is full.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnSendQueueFull(callback func(SendQueueFull)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.sendQueueFullCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// implements TransportHandler
+
+// sendQueue returns the size of the send queue and what to do when it is full.
+func (s *Socket) sendQueue() (int, OverflowPolicy) {
+	size := s.SendQueueSize
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+	return size, s.SendQueuePolicy
+}
+
+// onSendQueueFull is called when a message is sent while the send queue is full.
+func (s *Socket) onSendQueueFull(dropped bool) {
+	size, policy := s.sendQueue()
+	s.Logger.Printf(LogWarning, "socket", "send queue of %v messages is full, applying policy %v", size, policy)
+	if dropped {
+		s.drop(DropQueueOverflow, "", "")
+	}
+
+	full := SendQueueFull{Policy: policy, Size: size, Dropped: dropped}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cb := range s.sendQueueFullCallbacks {
+		cb := cb
+		s.run(func() { cb(full) })
+	}
+}
+
+// offerQueue puts the given item in the given queue according to the given policy, calling dropped with each item that
+// is dropped or rejected, and full when the queue is found full. Returns ErrSendQueueFull for OverflowError. Blocking
+// for room gives up once done is closed, as the queue won't be read anymore.
+func offerQueue[T any](queue chan T, item T, policy OverflowPolicy, done chan struct{}, dropped func(T), full func(dropped bool)) error {
+	select {
+	case queue <- item:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case OverflowDropNewest:
+		dropped(item)
+		full(true)
+		return nil
+	case OverflowError:
+		dropped(item)
+		full(false)
+		return ErrSendQueueFull
+	case OverflowDropOldest:
+		for {
+			select {
+			case oldest := <-queue:
+				dropped(oldest)
+				full(true)
+			default:
+			}
+			select {
+			case queue <- item:
+				return nil
+			default:
+			}
+		}
+	}
+
+	full(false)
+	select {
+	case queue <- item:
+		return nil
+	case <-done:
+		dropped(item)
+		return errors.New("cannot Send when not connected or connecting")
+	}
+}
@@ -11,6 +11,16 @@ type Serializer interface {
 	decode([]byte) (*Message, error)
 }
 
+// envelopeDecoder is implemented by Serializers that can decode the envelope of a message (its refs, topic and event)
+// separately from its payload, so that the Socket can skip parsing the payloads of messages that nothing handles.
+type envelopeDecoder interface {
+	// decodeEnvelope decodes the given data to a Message without a Payload, and returns the encoded payload.
+	decodeEnvelope([]byte) (*Message, []byte, error)
+
+	// decodePayload decodes a payload returned by decodeEnvelope.
+	decodePayload([]byte) (any, error)
+}
+
 // JSONSerializerV1 implements the original JSON protocol, which is a JSON object with keys and values
 
 type JSONSerializerV1 struct{}
@@ -28,7 +38,6 @@ func (s *JSONSerializerV1) encode(msg *Message) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	//fmt.Printf("encode: %+v -> %s\n", msg, data)
 	return data, nil
 }
 
@@ -38,10 +47,33 @@ func (s *JSONSerializerV1) decode(data []byte) (*Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	//fmt.Printf("decode: %s -> %+v\n", data, msg)
 	return &msg, nil
 }
 
+func (s *JSONSerializerV1) decodeEnvelope(data []byte) (*Message, []byte, error) {
+	var envelope struct {
+		JoinRef string          `json:"join_ref"`
+		Ref     string          `json:"ref"`
+		Topic   string          `json:"topic"`
+		Event   string          `json:"event"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	jm := JSONMessage{JoinRef: envelope.JoinRef, Ref: envelope.Ref, Topic: envelope.Topic, Event: envelope.Event}
+	msg, err := jm.Message()
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, envelope.Payload, nil
+}
+
+func (s *JSONSerializerV1) decodePayload(data []byte) (any, error) {
+	return decodeJSONPayload(data)
+}
+
 //// JSONSerializerV2 implements the V2 protocol, which is basically `[joinRef, ref, topic, event, payload]`.
 
 type JSONSerializerV2 struct{}
@@ -61,7 +93,6 @@ func (s *JSONSerializerV2) encode(msg *Message) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	//fmt.Printf("encode: %+v -> %s\n", msg, data)
 	return data, nil
 }
 
@@ -72,12 +103,42 @@ func (s *JSONSerializerV2) decode(data []byte) (*Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	//fmt.Printf("decode: %s -> %#v\n", data, tmp)
 	msg, err := jm.Message()
 	if err != nil {
 		return nil, err
 	}
 
-	//fmt.Printf("decode: %s -> %+v\n", data, msg)
 	return msg, nil
 }
+
+func (s *JSONSerializerV2) decodeEnvelope(data []byte) (*Message, []byte, error) {
+	var jm JSONMessage
+	var payload json.RawMessage
+	tmp := []any{&jm.JoinRef, &jm.Ref, &jm.Topic, &jm.Event, &payload}
+	err := json.Unmarshal(data, &tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := jm.Message()
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, payload, nil
+}
+
+func (s *JSONSerializerV2) decodePayload(data []byte) (any, error) {
+	return decodeJSONPayload(data)
+}
+
+// decodeJSONPayload decodes a payload the way decode would have as part of the whole message.
+func decodeJSONPayload(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var payload any
+	err := json.Unmarshal(data, &payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,52 @@
+package phx
+
+// MessageSerializer is implemented by serializers outside of this package, such as for MessagePack or a bespoke
+// format, to match a custom serializer on the server. Use one as a Socket's Serializer with NewCustomSerializer.
+type MessageSerializer interface {
+	// Encode encodes the given message, and returns true if it must be sent in a binary frame rather than a text
+	// frame. Payloads pushed with Channel.PushBinary are given as []byte.
+	Encode(msg Message) (data []byte, binary bool, err error)
+
+	// Decode decodes a message received in a text or binary frame.
+	Decode(data []byte) (Message, error)
+}
+
+// customSerializer is a Serializer that uses a MessageSerializer.
+type customSerializer struct {
+	version    string
+	serializer MessageSerializer
+}
+
+// NewCustomSerializer returns a Serializer that encodes and decodes messages with the given MessageSerializer, and
+// asks the server for the given version of the protocol with the "vsn" parameter, such as "2.0.0". The Socket hands
+// it every message, including binary frames and the payloads of Channel.PushBinary, instead of using the binary
+// format of the JSON serializers.
+func NewCustomSerializer(vsn string, serializer MessageSerializer) Serializer {
+	return &customSerializer{version: vsn, serializer: serializer}
+}
+
+func (s *customSerializer) vsn() string {
+	return s.version
+}
+
+func (s *customSerializer) encode(msg *Message) ([]byte, error) {
+	data, _, err := s.encodeFrame(msg)
+	return data, err
+}
+
+// encodeFrame encodes the given message, and returns true if it must be sent in a binary frame.
+func (s *customSerializer) encodeFrame(msg *Message) ([]byte, bool, error) {
+	m := *msg
+	if payload, ok := m.Payload.(binaryPayload); ok {
+		m.Payload = []byte(payload)
+	}
+	return s.serializer.Encode(m)
+}
+
+func (s *customSerializer) decode(data []byte) (*Message, error) {
+	msg, err := s.serializer.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
@@ -0,0 +1,7 @@
+//go:build !tinygo && !phx_rawjson
+
+package phx
+
+func defaultSerializer() Serializer {
+	return NewJSONSerializerV2()
+}
@@ -0,0 +1,409 @@
+//go:build tinygo || phx_rawjson
+
+package phx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// This file contains a reduced serializer for builds with the "tinygo" or "phx_rawjson" build tags, such as embedded
+// clients built with TinyGo. It encodes and decodes the V2 frame envelope by hand without encoding/json reflection,
+// and leaves payloads as bytes that the application encodes and decodes itself. It is the default Serializer in
+// these builds, so that sending and receiving frames doesn't go through encoding/json.
+//
+// The tags only replace the Serializer: the package still links encoding/json, which the gorilla/websocket Dialer,
+// the JSONCodec of TypedChannel and optional features such as Socket.Signer, session stores, Exporters, Compression
+// and the LongPoll transport use. A TinyGo build therefore still needs TinyGo's encoding/json support, and clients
+// that want to avoid its reflection should stick to RawPayloads and leave those features unset.
+
+// RawPayload is a payload that is already encoded as JSON. RawSerializerV2 requires outbound payloads to be a
+// RawPayload, []byte or nil, or plain values it encodes by hand: strings, bools, numbers, and maps and slices of them,
+// such as the params of joins. It decodes all inbound payloads to a RawPayload.
+type RawPayload []byte
+
+// RawSerializerV2 implements the V2 protocol, `[joinRef, ref, topic, event, payload]`, without reflection.
+type RawSerializerV2 struct{}
+
+func NewRawSerializerV2() *RawSerializerV2 {
+	return &RawSerializerV2{}
+}
+
+func defaultSerializer() Serializer {
+	return NewRawSerializerV2()
+}
+
+func (s *RawSerializerV2) vsn() string {
+	return "2.0.0"
+}
+
+func (s *RawSerializerV2) encode(msg *Message) ([]byte, error) {
+	payload, err := appendRawValue(nil, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 32+len(msg.Topic)+len(msg.Event)+len(payload))
+	data = append(data, '[')
+	if msg.JoinRef != 0 {
+		data = appendRawString(data, strconv.FormatUint(uint64(msg.JoinRef), 10))
+	} else {
+		data = append(data, "null"...)
+	}
+	data = append(data, ',')
+	data = appendRawString(data, strconv.FormatUint(uint64(msg.Ref), 10))
+	data = append(data, ',')
+	data = appendRawString(data, msg.Topic)
+	data = append(data, ',')
+	data = appendRawString(data, msg.Event)
+	data = append(data, ',')
+	data = append(data, payload...)
+	data = append(data, ']')
+	return data, nil
+}
+
+func (s *RawSerializerV2) decode(data []byte) (*Message, error) {
+	d := rawDecoder{data: data}
+
+	d.expect('[')
+	joinRef := d.stringOrNull()
+	d.expect(',')
+	ref := d.stringOrNull()
+	d.expect(',')
+	topic := d.stringOrNull()
+	d.expect(',')
+	event := d.stringOrNull()
+	d.expect(',')
+	payload := d.value()
+	d.expect(']')
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	jm := JSONMessage{
+		JoinRef: joinRef,
+		Ref:     ref,
+		Topic:   topic,
+		Event:   event,
+		Payload: RawPayload(payload),
+	}
+
+	// Replies are unwrapped so that Push can see their status, leaving the response raw
+	if event == string(ReplyEvent) {
+		reply := rawDecoder{data: payload}
+		jm.Payload = reply.reply()
+		if reply.err != nil {
+			return nil, reply.err
+		}
+	}
+
+	return jm.Message()
+}
+
+// appendRawValue appends the given payload as JSON. Only the plain values the library itself sends, and those already
+// encoded, are supported. Map keys are sorted, like encoding/json does.
+func appendRawValue(data []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(data, "null"...), nil
+	case RawPayload:
+		return append(data, v...), nil
+	case []byte:
+		return append(data, v...), nil
+	case string:
+		return appendRawString(data, v), nil
+	case bool:
+		return strconv.AppendBool(data, v), nil
+	case int:
+		return strconv.AppendInt(data, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(data, v, 10), nil
+	case uint64:
+		return strconv.AppendUint(data, v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("raw serializer can't encode %v", v)
+		}
+		return strconv.AppendFloat(data, v, 'g', -1, 64), nil
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data = append(data, '{')
+		for i, key := range keys {
+			if i > 0 {
+				data = append(data, ',')
+			}
+			data = appendRawString(data, key)
+			data = append(data, ':')
+			data = appendRawString(data, v[key])
+		}
+		return append(data, '}'), nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data = append(data, '{')
+		for i, key := range keys {
+			if i > 0 {
+				data = append(data, ',')
+			}
+			data = appendRawString(data, key)
+			data = append(data, ':')
+			var err error
+			data, err = appendRawValue(data, v[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(data, '}'), nil
+	case []any:
+		data = append(data, '[')
+		for i, item := range v {
+			if i > 0 {
+				data = append(data, ',')
+			}
+			var err error
+			data, err = appendRawValue(data, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(data, ']'), nil
+	case []map[string]any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return appendRawValue(data, items)
+	}
+	return nil, fmt.Errorf("raw serializer requires a RawPayload or a plain value, got %T", value)
+}
+
+// appendRawString appends s as a JSON string.
+func appendRawString(data []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	data = append(data, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			data = append(data, '\\', c)
+		case c < 0x20:
+			data = append(data, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			data = append(data, c)
+		}
+	}
+	return append(data, '"')
+}
+
+// rawDecoder is a minimal JSON decoder for the V2 frame envelope. The first error is kept in err, after which all
+// methods do nothing.
+type rawDecoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *rawDecoder) fail(msg string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("invalid frame at offset %d: %s", d.pos, msg)
+	}
+}
+
+func (d *rawDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *rawDecoder) expect(c byte) {
+	if d.err != nil {
+		return
+	}
+	d.skipSpace()
+	if d.pos >= len(d.data) || d.data[d.pos] != c {
+		d.fail("expected '" + string(c) + "'")
+		return
+	}
+	d.pos++
+}
+
+// stringOrNull decodes a string, or null as "".
+func (d *rawDecoder) stringOrNull() string {
+	if d.err != nil {
+		return ""
+	}
+	d.skipSpace()
+	if d.pos+4 <= len(d.data) && string(d.data[d.pos:d.pos+4]) == "null" {
+		d.pos += 4
+		return ""
+	}
+	if d.pos >= len(d.data) || d.data[d.pos] != '"' {
+		d.fail("expected string")
+		return ""
+	}
+	d.pos++
+
+	var s []byte
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		d.pos++
+		switch {
+		case c == '"':
+			return string(s)
+		case c == '\\':
+			s = d.escape(s)
+			if d.err != nil {
+				return ""
+			}
+		case c < 0x20:
+			d.fail("control character in string")
+			return ""
+		default:
+			s = append(s, c)
+		}
+	}
+	d.fail("unterminated string")
+	return ""
+}
+
+// escape decodes the escape sequence after a backslash in a string.
+func (d *rawDecoder) escape(s []byte) []byte {
+	if d.pos >= len(d.data) {
+		d.fail("unterminated escape")
+		return s
+	}
+	c := d.data[d.pos]
+	d.pos++
+	switch c {
+	case '"', '\\', '/':
+		return append(s, c)
+	case 'b':
+		return append(s, '\b')
+	case 'f':
+		return append(s, '\f')
+	case 'n':
+		return append(s, '\n')
+	case 'r':
+		return append(s, '\r')
+	case 't':
+		return append(s, '\t')
+	case 'u':
+		r := d.hex4()
+		if r >= 0xd800 && r < 0xdc00 && d.pos+1 < len(d.data) && d.data[d.pos] == '\\' && d.data[d.pos+1] == 'u' {
+			d.pos += 2
+			low := d.hex4()
+			r = (r-0xd800)<<10 + (low - 0xdc00) + 0x10000
+		}
+		return utf8.AppendRune(s, r)
+	}
+	d.fail("invalid escape")
+	return s
+}
+
+func (d *rawDecoder) hex4() rune {
+	if d.pos+4 > len(d.data) {
+		d.fail("short unicode escape")
+		return 0
+	}
+	n, err := strconv.ParseUint(string(d.data[d.pos:d.pos+4]), 16, 32)
+	if err != nil {
+		d.fail("invalid unicode escape")
+		return 0
+	}
+	d.pos += 4
+	return rune(n)
+}
+
+// reply decodes a reply payload, `{"status": status, "response": response}`, to a map with the status as a string and
+// the response as a RawPayload.
+func (d *rawDecoder) reply() map[string]any {
+	reply := make(map[string]any, 2)
+	d.expect('{')
+	for d.err == nil {
+		key := d.stringOrNull()
+		d.expect(':')
+		if key == "status" {
+			reply[key] = d.stringOrNull()
+		} else {
+			reply[key] = RawPayload(d.value())
+		}
+
+		d.skipSpace()
+		if d.pos < len(d.data) && d.data[d.pos] == '}' {
+			d.pos++
+			break
+		}
+		d.expect(',')
+	}
+	return reply
+}
+
+// value returns the bytes of the next JSON value without decoding it.
+func (d *rawDecoder) value() []byte {
+	if d.err != nil {
+		return nil
+	}
+	d.skipSpace()
+	start := d.pos
+	depth := 0
+	inString := false
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case inString:
+			if c == '\\' {
+				d.pos++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth == 0 {
+				return d.trimmed(start)
+			}
+			depth--
+		case c == ',' && depth == 0:
+			return d.trimmed(start)
+		}
+		d.pos++
+	}
+	d.err = errors.New("invalid frame: unterminated payload")
+	return nil
+}
+
+// trimmed returns the data from start to the current position without trailing whitespace.
+func (d *rawDecoder) trimmed(start int) []byte {
+	end := d.pos
+	for end > start {
+		switch d.data[end-1] {
+		case ' ', '\t', '\n', '\r':
+			end--
+			continue
+		}
+		break
+	}
+	if end == start {
+		d.fail("missing payload")
+		return nil
+	}
+	return d.data[start:end]
+}
@@ -0,0 +1,39 @@
+//go:build !tinygo && !phx_rawjson
+
+package phx
+
+import (
+	"testing"
+)
+
+func TestPayloadDecodedOnlyWhenWanted(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	channel := socket.Channel("room:1", nil)
+	channel.On("handled", func(payload any) {})
+
+	tests := []struct {
+		name    string
+		data    string
+		decoded bool
+	}{
+		{"unbound event", `[null,null,"room:1","unhandled",{"body":"hi"}]`, false},
+		{"bound event", `[null,null,"room:1","handled",{"body":"hi"}]`, true},
+		{"acknowledgement", `[null,null,"room:1","unhandled",{"ack_ref":"1"}]`, true},
+		{"other topic", `[null,null,"room:2","handled",{"body":"hi"}]`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, payload, err := socket.decodeEnvelope([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload == nil {
+				t.Fatal("serializer decoded the payload with the envelope")
+			}
+			socket.handleMessage(msg, payload, len(test.data))
+			if decoded := msg.Payload != nil; decoded != test.decoded {
+				t.Errorf("payload decoded %v, want %v", decoded, test.decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package phx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionStore persists the identity and subscriptions of a client so that they can be shared between restarts, or
+// between several instances of a horizontally scaled worker that need to coordinate which instance holds which
+// subscriptions. Set Socket.SessionStore to have joined Channels recorded in the subscription manifest automatically.
+type SessionStore interface {
+	// ClientID returns the stored client id, or "" if none has been set.
+	ClientID() (string, error)
+
+	// SetClientID stores the client id.
+	SetClientID(id string) error
+
+	// ResumeToken returns the stored resume token for the given topic, or "" if none has been set.
+	ResumeToken(topic string) (string, error)
+
+	// SetResumeToken stores a resume token for the given topic. An empty token removes it.
+	SetResumeToken(topic string, token string) error
+
+	// Manifest returns all subscriptions currently recorded, by every client sharing the store, ordered by client id
+	// and topic.
+	Manifest() ([]ManifestEntry, error)
+
+	// AddSubscription records that the client of the given entry holds a subscription to its topic, replacing an
+	// entry of the same client and topic.
+	AddSubscription(entry ManifestEntry) error
+
+	// RemoveSubscription removes the subscription of this client, as identified by ClientID, to the given topic from
+	// the manifest. The subscriptions of other clients to the topic are kept.
+	RemoveSubscription(topic string) error
+}
+
+// ManifestEntry is a subscription recorded in a SessionStore.
+type ManifestEntry struct {
+	// Topic is the topic of the joined Channel.
+	Topic string `json:"topic"`
+
+	// Params are the params the Channel was joined with.
+	Params map[string]string `json:"params,omitempty"`
+
+	// ClientID is the id of the client holding the subscription.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// sessionData is the identity of one client kept by the SessionStore implementations.
+type sessionData struct {
+	ClientID     string            `json:"client_id"`
+	ResumeTokens map[string]string `json:"resume_tokens"`
+}
+
+func newSessionData() *sessionData {
+	return &sessionData{
+		ResumeTokens: make(map[string]string),
+	}
+}
+
+// addManifestEntry returns the given manifest with the given entry added, or replacing the entry of the same client
+// and topic, ordered by client id and topic.
+func addManifestEntry(manifest []ManifestEntry, entry ManifestEntry) []ManifestEntry {
+	manifest = removeManifestEntry(manifest, entry.ClientID, entry.Topic)
+	manifest = append(manifest, entry)
+	sort.Slice(manifest, func(i, j int) bool {
+		if manifest[i].ClientID != manifest[j].ClientID {
+			return manifest[i].ClientID < manifest[j].ClientID
+		}
+		return manifest[i].Topic < manifest[j].Topic
+	})
+	return manifest
+}
+
+// removeManifestEntry returns the given manifest without the entry of the given client and topic.
+func removeManifestEntry(manifest []ManifestEntry, clientID string, topic string) []ManifestEntry {
+	kept := manifest[:0]
+	for _, entry := range manifest {
+		if entry.ClientID != clientID || entry.Topic != topic {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// MemorySessionStore is a SessionStore that keeps everything in memory. It can be shared by several Sockets in the
+// same process.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	data     *sessionData
+	manifest []ManifestEntry
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		data: newSessionData(),
+	}
+}
+
+func (s *MemorySessionStore) ClientID() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.ClientID, nil
+}
+
+func (s *MemorySessionStore) SetClientID(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.ClientID = id
+	return nil
+}
+
+func (s *MemorySessionStore) ResumeToken(topic string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.data.ResumeTokens[topic], nil
+}
+
+func (s *MemorySessionStore) SetResumeToken(topic string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token == "" {
+		delete(s.data.ResumeTokens, topic)
+	} else {
+		s.data.ResumeTokens[topic] = token
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) Manifest() ([]ManifestEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]ManifestEntry(nil), s.manifest...), nil
+}
+
+func (s *MemorySessionStore) AddSubscription(entry ManifestEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.manifest = addManifestEntry(s.manifest, entry)
+	return nil
+}
+
+func (s *MemorySessionStore) RemoveSubscription(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.manifest = removeManifestEntry(s.manifest, s.data.ClientID, topic)
+	return nil
+}
+
+// FileSessionStore is a SessionStore that keeps everything in a JSON file, which several processes can share, such as
+// the instances of a horizontally scaled worker. Each process keeps its own client id and resume tokens in the file,
+// under its Instance, while the manifest holds the subscriptions of all of them.
+//
+// Changes are made while holding a lock file next to the file, named like it with a ".lock" suffix, so that processes
+// don't lose each other's changes, and the file is replaced atomically, so that it can be read without the lock. A
+// lock file that is older than 30 seconds is assumed to be left over by a process that died while holding it, and is
+// removed.
+type FileSessionStore struct {
+	// Instance identifies this process among the processes sharing the file, such as a hostname or a pod name. Set it
+	// before using the store. Defaults to "", for a file that is used by a single process.
+	Instance string
+
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSessionStore(path string) *FileSessionStore {
+	return &FileSessionStore{
+		path: path,
+	}
+}
+
+// sessionFile is the content of the file of a FileSessionStore.
+type sessionFile struct {
+	Instances map[string]*sessionData `json:"instances"`
+	Manifest  []ManifestEntry         `json:"manifest"`
+}
+
+// instance returns the data of the Instance of the store, adding it if there is none.
+func (s *FileSessionStore) instance(file *sessionFile) *sessionData {
+	data, ok := file.Instances[s.Instance]
+	if !ok || data == nil {
+		data = newSessionData()
+		file.Instances[s.Instance] = data
+	}
+	if data.ResumeTokens == nil {
+		data.ResumeTokens = make(map[string]string)
+	}
+	return data
+}
+
+func (s *FileSessionStore) ClientID() (string, error) {
+	var id string
+	err := s.view(func(file *sessionFile) {
+		id = s.instance(file).ClientID
+	})
+	return id, err
+}
+
+func (s *FileSessionStore) SetClientID(id string) error {
+	return s.update(func(file *sessionFile) {
+		s.instance(file).ClientID = id
+	})
+}
+
+func (s *FileSessionStore) ResumeToken(topic string) (string, error) {
+	var token string
+	err := s.view(func(file *sessionFile) {
+		token = s.instance(file).ResumeTokens[topic]
+	})
+	return token, err
+}
+
+func (s *FileSessionStore) SetResumeToken(topic string, token string) error {
+	return s.update(func(file *sessionFile) {
+		data := s.instance(file)
+		if token == "" {
+			delete(data.ResumeTokens, topic)
+		} else {
+			data.ResumeTokens[topic] = token
+		}
+	})
+}
+
+func (s *FileSessionStore) Manifest() ([]ManifestEntry, error) {
+	var manifest []ManifestEntry
+	err := s.view(func(file *sessionFile) {
+		manifest = file.Manifest
+	})
+	return manifest, err
+}
+
+func (s *FileSessionStore) AddSubscription(entry ManifestEntry) error {
+	return s.update(func(file *sessionFile) {
+		file.Manifest = addManifestEntry(file.Manifest, entry)
+	})
+}
+
+func (s *FileSessionStore) RemoveSubscription(topic string) error {
+	return s.update(func(file *sessionFile) {
+		file.Manifest = removeManifestEntry(file.Manifest, s.instance(file).ClientID, topic)
+	})
+}
+
+func (s *FileSessionStore) view(fn func(file *sessionFile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.read()
+	if err != nil {
+		return err
+	}
+	fn(file)
+	return nil
+}
+
+func (s *FileSessionStore) update(fn func(file *sessionFile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := s.read()
+	if err != nil {
+		return err
+	}
+	fn(file)
+	return s.write(file)
+}
+
+// lock takes the lock file of the store, waiting for another process to release it, and returns a function that
+// releases it.
+func (s *FileSessionStore) lock() (func(), error) {
+	lockPath := s.path + ".lock"
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		info, err := os.Stat(lockPath)
+		if err == nil && time.Since(info.ModTime()) > fileLockStale {
+			// Left over by a process that died while holding it
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for lock file '%v'", lockPath)
+		}
+		time.Sleep(fileLockRetry)
+	}
+}
+
+func (s *FileSessionStore) read() (*sessionFile, error) {
+	file := &sessionFile{}
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if file.Instances == nil {
+		file.Instances = make(map[string]*sessionData)
+	}
+	return file, nil
+}
+func (s *FileSessionStore) write(file *sessionFile) error {
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it over the original so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
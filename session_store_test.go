@@ -0,0 +1,72 @@
+package phx
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileSessionStoreSharedByInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	// Each store stands in for a process, with its own mutex, so that only the lock file serializes them
+	var stores []*FileSessionStore
+	for i := 0; i < 4; i++ {
+		store := NewFileSessionStore(path)
+		store.Instance = fmt.Sprintf("worker-%d", i)
+		if err := store.SetClientID(store.Instance); err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		wg.Add(1)
+		go func(store *FileSessionStore) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				entry := ManifestEntry{Topic: fmt.Sprintf("room:%d", i), ClientID: store.Instance}
+				if err := store.AddSubscription(entry); err != nil {
+					t.Error(err)
+				}
+			}
+		}(store)
+	}
+	wg.Wait()
+
+	manifest, err := stores[0].Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 40 {
+		t.Fatalf("manifest has %v entries, want 40", len(manifest))
+	}
+
+	if err := stores[1].RemoveSubscription("room:1"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = stores[0].Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range manifest {
+		if entry.ClientID == "worker-1" && entry.Topic == "room:1" {
+			t.Error("subscription of worker-1 to room:1 was not removed")
+		}
+	}
+	if len(manifest) != 39 {
+		t.Errorf("manifest has %v entries, want 39", len(manifest))
+	}
+
+	for _, store := range stores {
+		id, err := store.ClientID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != store.Instance {
+			t.Errorf("instance %v has client id %v", store.Instance, id)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package phx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KeyProvider supplies the keys a MessageSigner signs and verifies messages with. Keys are identified by an id that is
+// sent along with every signature, so that keys can be rotated without interrupting verification.
+type KeyProvider interface {
+	// SigningKey returns the id and key to sign outbound messages with.
+	SigningKey() (keyID string, key []byte, err error)
+
+	// VerificationKey returns the key with the given id to verify an inbound message with, or an error if the key is
+	// unknown or no longer trusted.
+	VerificationKey(keyID string) ([]byte, error)
+}
+
+// RotatingKeyProvider is a KeyProvider holding several keys, one of which is used for signing. Keys can be added and
+// removed at any time, so a new key can be trusted for verification before it is used for signing, and an old key
+// can be retired after every peer has stopped using it.
+type RotatingKeyProvider struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+func NewRotatingKeyProvider(keyID string, key []byte) *RotatingKeyProvider {
+	return &RotatingKeyProvider{
+		keys:    map[string][]byte{keyID: key},
+		current: keyID,
+	}
+}
+
+// Add trusts the given key for verification.
+func (p *RotatingKeyProvider) Add(keyID string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys[keyID] = key
+}
+
+// Use signs with the given key from now on. The key must have been added.
+func (p *RotatingKeyProvider) Use(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.keys[keyID]; !ok {
+		return fmt.Errorf("unknown key '%v'", keyID)
+	}
+	p.current = keyID
+	return nil
+}
+
+// Remove stops trusting the given key. The key used for signing cannot be removed.
+func (p *RotatingKeyProvider) Remove(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if keyID == p.current {
+		return errors.New("cannot remove the signing key")
+	}
+	delete(p.keys, keyID)
+	return nil
+}
+
+func (p *RotatingKeyProvider) SigningKey() (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *RotatingKeyProvider) VerificationKey(keyID string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key '%v'", keyID)
+	}
+	return key, nil
+}
+
+// MessageSigner HMAC-SHA256 signs the payloads of outbound messages and verifies the payloads of inbound messages.
+// Set it as Socket.Signer to use it; inbound messages that fail verification are dropped before any handlers run.
+//
+// A signed payload is replaced by an envelope holding the JSON encoded payload as a string, so that the signature
+// is computed over the exact bytes that were sent, along with the time it was signed at, in Unix milliseconds, and a
+// random nonce:
+//
+//	{"kid": "key id", "sig": "base64 HMAC", "ts": 1700000000000, "nonce": "base64", "data": "{\"the\":\"payload\"}"}
+//
+// The HMAC covers the topic, event, join ref, ref, reply status, ts, nonce and data, each followed by a zero byte
+// except the data, with the refs as decimal strings and the refs and status empty if the message has none. So a relay
+// can neither move a payload to another topic or event, nor rebind a reply to another push or flip its status.
+// Messages signed more than MaxAge ago, or that far in the future, are rejected, and so are nonces that were already
+// seen within MaxAge, so that signed messages can't be replayed.
+//
+// Heartbeats and the phx_close and phx_error events generated by the server itself are not signed. For replies, the
+// response is signed rather than the whole payload.
+type MessageSigner struct {
+	Keys KeyProvider
+
+	// MaxAge is how long a signed message can be verified after it was signed, allowing for clock skew and delivery
+	// delays. Its nonce is remembered for as long to reject replays. Zero uses the default of 5 minutes.
+	MaxAge time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonces of verified messages, until they are too old to be replayed
+	nextPrune time.Time
+}
+
+func NewMessageSigner(keys KeyProvider) *MessageSigner {
+	return &MessageSigner{
+		Keys:   keys,
+		MaxAge: defaultSignatureMaxAge,
+	}
+}
+
+// signedPayload is the envelope a signed payload is sent in.
+type signedPayload struct {
+	KeyID     string `json:"kid"`
+	Signature string `json:"sig"`
+	Timestamp int64  `json:"ts"`
+	Nonce     string `json:"nonce"`
+	Data      string `json:"data"`
+}
+
+// sign replaces the payload of the given message with an envelope signed at the given time, with a nonce read from
+// the given source of randomness.
+func (s *MessageSigner) sign(msg *Message, now time.Time, random io.Reader) error {
+	if !isSignedMessage(msg) {
+		return nil
+	}
+
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	keyID, key, err := s.Keys.SigningKey()
+	if err != nil {
+		return err
+	}
+
+	var nonce [16]byte
+	_, err = io.ReadFull(random, nonce[:])
+	if err != nil {
+		return fmt.Errorf("reading nonce: %w", err)
+	}
+
+	envelope := signedPayload{
+		KeyID:     keyID,
+		Timestamp: now.UnixMilli(),
+		Nonce:     base64.StdEncoding.EncodeToString(nonce[:]),
+		Data:      string(data),
+	}
+	sig := signature(key, msg, "", envelope.Timestamp, envelope.Nonce, data)
+	envelope.Signature = base64.StdEncoding.EncodeToString(sig)
+	msg.Payload = envelope
+	return nil
+}
+
+// verify checks the signature of the payload of the given message received at the given time, and replaces it with
+// the verified payload.
+func (s *MessageSigner) verify(msg *Message, now time.Time) error {
+	if !isSignedMessage(msg) {
+		return nil
+	}
+
+	if msg.Event == string(ReplyEvent) {
+		reply, ok := msg.Payload.(map[string]any)
+		if !ok {
+			return errors.New("reply is not a map")
+		}
+		status, _ := reply["status"].(string)
+		response, err := s.verifyPayload(msg, status, reply["response"], now)
+		if err != nil {
+			return err
+		}
+		verified := make(map[string]any, len(reply))
+		for k, v := range reply {
+			verified[k] = v
+		}
+		verified["response"] = response
+		msg.Payload = verified
+		return nil
+	}
+
+	payload, err := s.verifyPayload(msg, "", msg.Payload, now)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+// verifyPayload verifies the given signed envelope of the given message, whose reply has the given status, and returns
+// the payload it holds.
+func (s *MessageSigner) verifyPayload(msg *Message, status string, payload any, now time.Time) (any, error) {
+	envelope, ok := payload.(map[string]any)
+	if !ok {
+		return nil, errors.New("payload is not signed")
+	}
+	keyID, _ := envelope["kid"].(string)
+	sig, _ := envelope["sig"].(string)
+	ts, _ := envelope["ts"].(float64)
+	nonce, _ := envelope["nonce"].(string)
+	data, _ := envelope["data"].(string)
+	if keyID == "" || sig == "" || nonce == "" {
+		return nil, errors.New("payload is not signed")
+	}
+
+	maxAge := s.maxAge()
+	signedAt := time.UnixMilli(int64(ts))
+	if age := now.Sub(signedAt); age > maxAge || age < -maxAge {
+		return nil, fmt.Errorf("signed %v ago, outside of the %v window", age, maxAge)
+	}
+
+	key, err := s.Keys.VerificationKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(expected, signature(key, msg, status, int64(ts), nonce, []byte(data))) {
+		return nil, errors.New("invalid signature")
+	}
+	if !s.rememberNonce(nonce, signedAt.Add(maxAge), now) {
+		return nil, errors.New("replayed message")
+	}
+
+	var verified any
+	err = json.Unmarshal([]byte(data), &verified)
+	if err != nil {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// maxAge returns the MaxAge, or the default if it is zero.
+func (s *MessageSigner) maxAge() time.Duration {
+	if s.MaxAge <= 0 {
+		return defaultSignatureMaxAge
+	}
+	return s.MaxAge
+}
+
+// rememberNonce records the given nonce of a verified message until the given time, after which the message is too
+// old to verify anyway. Returns false if the nonce was already seen, so that the message is a replay.
+func (s *MessageSigner) rememberNonce(nonce string, until time.Time, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nonces == nil {
+		s.nonces = make(map[string]time.Time)
+	}
+	if now.After(s.nextPrune) {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.nextPrune = now.Add(s.maxAge())
+	}
+
+	if _, seen := s.nonces[nonce]; seen {
+		return false
+	}
+	s.nonces[nonce] = until
+	return true
+}
+
+// signature returns the HMAC-SHA256 of the topic, event, join ref and ref of the given message, the given reply status,
+// timestamp and nonce, and the data.
+func signature(key []byte, msg *Message, status string, ts int64, nonce string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fields := []string{
+		msg.Topic, msg.Event, refString(msg.JoinRef), refString(msg.Ref), status, strconv.FormatInt(ts, 10), nonce,
+	}
+	for _, field := range fields {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// refString returns the given ref as a decimal string, or an empty string if there is none.
+func refString(ref Ref) string {
+	if ref == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(ref), 10)
+}
+
+// isSignedMessage returns false for the messages that are never signed.
+func isSignedMessage(msg *Message) bool {
+	return msg.Topic != "phoenix" && msg.Event != string(CloseEvent) && msg.Event != string(ErrorEvent)
+}
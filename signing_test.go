@@ -0,0 +1,115 @@
+package phx
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// roundTrip encodes and decodes the payload of the given message like a JSON Serializer would.
+func roundTrip(t *testing.T, msg Message) *Message {
+	t.Helper()
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Payload = nil
+	if err := json.Unmarshal(data, &msg.Payload); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+// signedReply returns a reply with the given status to the given ref, signed like a server would.
+func signedReply(key []byte, ref Ref, status string, now time.Time) Message {
+	msg := Message{Topic: "room:1", Event: string(ReplyEvent), Ref: ref, JoinRef: 1}
+	data := []byte(`{"n":1}`)
+	ts := now.UnixMilli()
+	nonce := base64.StdEncoding.EncodeToString([]byte(now.String()))
+	msg.Payload = map[string]any{
+		"status": status,
+		"response": signedPayload{
+			KeyID:     "k1",
+			Signature: base64.StdEncoding.EncodeToString(signature(key, &msg, status, ts, nonce, data)),
+			Timestamp: ts,
+			Nonce:     nonce,
+			Data:      string(data),
+		},
+	}
+	return msg
+}
+
+func TestMessageSigner(t *testing.T) {
+	key := []byte("secret")
+	signer := NewMessageSigner(NewRotatingKeyProvider("k1", key))
+	now := time.Now()
+
+	msg := Message{Topic: "room:1", Event: "new_msg", Payload: map[string]any{"body": "hi"}, Ref: 2, JoinRef: 1}
+	if err := signer.sign(&msg, now, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	received := roundTrip(t, msg)
+	if err := signer.verify(received, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if body := received.Payload.(map[string]any)["body"]; body != "hi" {
+		t.Errorf("verified payload body %v, want hi", body)
+	}
+
+	if err := signer.verify(roundTrip(t, msg), now); err == nil {
+		t.Error("verified a replayed message")
+	}
+
+	rebound := msg
+	rebound.Topic = "room:2"
+	if err := signer.verify(roundTrip(t, rebound), now); err == nil {
+		t.Error("verified a message moved to another topic")
+	}
+}
+
+func TestMessageSignerRejectsStaleMessages(t *testing.T) {
+	signer := NewMessageSigner(NewRotatingKeyProvider("k1", []byte("secret")))
+	signer.MaxAge = time.Minute
+	now := time.Now()
+
+	for _, signedAt := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		msg := Message{Topic: "room:1", Event: "new_msg", Payload: map[string]any{}, Ref: 2, JoinRef: 1}
+		if err := signer.sign(&msg, signedAt, rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.verify(roundTrip(t, msg), now); err == nil {
+			t.Errorf("verified a message signed at %v, %v from now", signedAt, signedAt.Sub(now))
+		}
+	}
+}
+
+func TestMessageSignerCoversReplies(t *testing.T) {
+	key := []byte("secret")
+	signer := NewMessageSigner(NewRotatingKeyProvider("k1", key))
+	now := time.Now()
+
+	if err := signer.verify(roundTrip(t, signedReply(key, 2, "ok", now)), now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	flipped := roundTrip(t, signedReply(key, 2, "ok", now.Add(time.Millisecond)))
+	flipped.Payload.(map[string]any)["status"] = "error"
+	if err := signer.verify(flipped, now); err == nil {
+		t.Error("verified a reply whose status was flipped")
+	}
+
+	rebound := roundTrip(t, signedReply(key, 2, "ok", now.Add(2*time.Millisecond)))
+	rebound.Ref = 3
+	if err := signer.verify(rebound, now); err == nil {
+		t.Error("verified a reply rebound to another ref")
+	}
+
+	rejoined := roundTrip(t, signedReply(key, 2, "ok", now.Add(3*time.Millisecond)))
+	rejoined.JoinRef = 4
+	if err := signer.verify(rejoined, now); err == nil {
+		t.Error("verified a reply rebound to another join")
+	}
+}
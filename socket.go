@@ -1,9 +1,14 @@
 package phx
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,37 +24,264 @@ type Socket struct {
 	// RequestHeader is an http.Header map to send in the initial connection.
 	RequestHeader http.Header
 
-	// Transport is the main transport mechanism to use to connect to the server. Only Websocket is implemented currently.
+	// Credentials, if set, is the token to authenticate with, sent in the query, a header or the first message of
+	// every connection. Defaults to nil, which leaves authentication to EndPoint, RequestHeader and the providers.
+	Credentials *Credentials
+
+	// Name identifies the Socket in the "phx.socket" pprof label of the goroutines it and its Transport start, which
+	// also have a "phx.role" label such as "reader", "writer", "connmgr" or "dispatcher". Defaults to the host of
+	// EndPoint.
+	Name string
+
+	// Transport is the main transport mechanism to use to connect to the server. Defaults to Websocket, or use
+	// LongPoll where websockets are blocked.
 	Transport Transport
 
 	// Specify a logger for Errors, Warnings, Info and Debug messages. Defaults to phx.NoopLogger.
 	Logger Logger
 
+	// Clock is the source of time for timers, such as heartbeats, push timeouts, reconnects and rejoins, and for the
+	// times the library stamps and reports. Set it before creating Channels. Defaults to the real clock.
+	Clock Clock
+
+	// Instrumenter, if set, receives spans and metrics for connection attempts, reconnects, pushes and their replies,
+	// heartbeats and traffic, such as to export them with OpenTelemetry. Defaults to nil.
+	Instrumenter Instrumenter
+
 	// Timeout for initial handshake with server.
 	ConnectTimeout time.Duration
 
-	// ReconnectAfterFunc is a function that returns the time to delay reconnections based on the given tries
+	// PushTimeout is the default Channel.PushTimeout of the Channels created for this Socket, after which pushes that
+	// weren't replied to trigger their "timeout" callbacks. It also bounds the replies to control messages, such as
+	// the one sent by JoinBatch. Set it before creating Channels. Defaults to 10 seconds.
+	PushTimeout time.Duration
+
+	// ReconnectAfterFunc is a function that returns the time to delay reconnections based on the given tries. Defaults
+	// to retry.ReconnectSchedule with a Jitter of retry.ReconnectJitter, read from Rand. The retry package has more
+	// schedules, such as retry.FullJitter(retry.Exponential(base, max)).
 	ReconnectAfterFunc func(tries int) time.Duration
 
-	// HeartbeatInterval is the duration between heartbeats sent to the server to keep the connection alive.
+	// MaxReconnectAttempts is the number of connection attempts in a row that may fail before the Socket gives up,
+	// disconnects and calls the OnGiveUp callbacks. Zero (the default) keeps trying until Disconnect is called.
+	MaxReconnectAttempts int
+
+	// ReconnectStableAfter is the time a connection must stay open before it is considered stable and the tries passed
+	// to ReconnectAfterFunc start over. A connection lost sooner counts as another try, so a server that accepts
+	// connections and then immediately drops them is reconnected to with increasing delays instead of in a tight loop.
+	ReconnectStableAfter time.Duration
+
+	// HeartbeatInterval is the duration between heartbeats sent to the server to keep the connection alive. A heartbeat
+	// that isn't replied to within the interval reconnects, unless other messages were received within it, which show
+	// that the connection is alive while the reply is delayed behind them.
 	HeartbeatInterval time.Duration
 
+	// HeartbeatPayloadFunc, if set, is called before every heartbeat and returns its payload, such as client stats or a
+	// nonce for the server to echo in its reply. Replies are given to the OnHeartbeatReply callbacks. Defaults to nil,
+	// which sends heartbeats without a payload.
+	HeartbeatPayloadFunc func() any
+
+	// HeartbeatDuringTransfer determines how heartbeats are sent while a transfer started with BeginTransfer is in
+	// progress. Defaults to HeartbeatQueued.
+	HeartbeatDuringTransfer HeartbeatTransferMode
+
+	// HeartbeatSkipAfterWrite, if set, skips a heartbeat when application data was written within this duration
+	// before it was due, treating the write as proof of liveness to reduce redundant traffic on chatty connections,
+	// like phoenix.js does for reads. A heartbeat that is sent still times out if it isn't replied to. Zero (the
+	// default) always sends heartbeats.
+	HeartbeatSkipAfterWrite time.Duration
+
+	// BeforeDisconnectTimeout is the time the BeforeDisconnect hooks may take before their context is canceled.
+	// Defaults to 5 seconds.
+	BeforeDisconnectTimeout time.Duration
+
+	// ResyncAfter is the downtime after which a reconnection calls the ResyncFunc of every rejoined Channel, instead of
+	// assuming the stream of messages continued uninterrupted. Zero (the default) disables resyncing.
+	ResyncAfter time.Duration
+
+	// MemoryLimit is a soft limit on the bytes held in internal buffers, such as the send queue and the pushes buffered
+	// by Channels that are not joined. When exceeded, the OnMemoryPressure callbacks are called and the oldest
+	// buffered pushes are dropped, each triggering its "timeout" handlers. Zero (the default) means no limit.
+	MemoryLimit int64
+
+	// SendQueueSize is the number of messages the Transport queues to be sent, such as while it is reconnecting.
+	// Defaults to 1000.
+	SendQueueSize int
+
+	// SendQueuePolicy is what happens to a message sent while the send queue is full. Set it, and SendQueueSize,
+	// before connecting. Defaults to OverflowBlock.
+	SendQueuePolicy OverflowPolicy
+
+	// SlowConsumerThreshold is the average time from a message being dispatched to a Channel handler until the handler
+	// returns, above which the handler is reported to the OnSlowConsumer callbacks. Zero (the default) disables
+	// measuring handlers.
+	SlowConsumerThreshold time.Duration
+
+	// TrackBindingSites, if set, records where each Channel binding was registered, as reported by Channel.Bindings, to
+	// find handlers that leak by being registered again on every reconnect. It costs a stack walk per binding.
+	TrackBindingSites bool
+
+	// Features are the capabilities enabled for this Socket. Set it before connecting. Defaults to
+	// FeatureBinaryPayloads and FeatureJoinBatching.
+	Features Features
+
+	// InboundQueueSize is the number of received messages that may wait to be dispatched with FeatureInboundQueue,
+	// beyond which the Transport stops reading until they are. Defaults to 1000.
+	InboundQueueSize int
+
 	// Serializer encodes/decodes messages to/from the server. Must work with a Serializer on the server.
-	// Defaults to JSONSerializerV2
+	// Defaults to JSONSerializerV2, or RawSerializerV2 in builds with the "tinygo" or "phx_rawjson" tags.
 	Serializer Serializer
 
+	// Profiles are the named endpoint profiles that can be switched between with UseProfile. Defaults to nil.
+	Profiles map[string]*EndpointProfile
+
+	// ManualDispatch, if set, queues every callback instead of running it in its own goroutine, so that the host's
+	// event loop can run them all on one thread by calling Poll or ProcessNext. Set it before connecting.
+	ManualDispatch bool
+
+	// Signer, if set, signs the payloads of outbound messages and drops inbound messages that fail verification.
+	// Defaults to nil.
+	Signer *MessageSigner
+
+	// Compression, if set, compresses the payloads of outbound messages and decompresses those of inbound messages
+	// with a trained dictionary. Defaults to nil.
+	Compression *PayloadCompression
+
+	// Stamper, if set, adds a client timestamp, sequence number and id to the payloads of outbound pushes.
+	// Defaults to nil.
+	Stamper *PushStamper
+
+	// Codec converts typed values to and from payloads for TypedChannel. Defaults to JSONCodec.
+	Codec PayloadCodec
+
+	// Rand is the source of randomness for the default reconnect and rejoin jitter and for the ids of PushStamper and
+	// Peers, such as a seeded *math/rand.Rand to reproduce the same schedules and ids in deterministic tests. The Socket
+	// reads from it one read at a time, so it needn't be safe for concurrent use. Defaults to crypto/rand.Reader.
+	Rand io.Reader
+
+	// Analyzer, if set, records the size and topic of every inbound message. Defaults to nil.
+	Analyzer *PayloadAnalyzer
+
+	// SessionStore, if set, records the subscriptions of joined Channels so they can be coordinated between restarts
+	// or several instances. Defaults to nil, which records nothing.
+	SessionStore SessionStore
+
 	// miscellaneous private members
-	refGenerator     *atomicRef
+	refGenerator *atomicRef
+
+	// callbacks and channels, guarded by handlersMu. Callbacks are called after releasing it, so they may register
+	// more callbacks or create Channels.
+	handlersMu       sync.RWMutex
 	openCallbacks    map[Ref]func()
 	closeCallbacks   map[Ref]func()
 	errorCallbacks   map[Ref]func(error)
 	messageCallbacks map[Ref]func(Message)
 	channels         map[string]*Channel
 
+	// held while creating a Channel, so that concurrent calls for the same topic return the same Channel
+	newChannelMu sync.Mutex
+
+	// held while reading from Rand
+	randMu sync.Mutex
+
 	// heartbeat related state
+	hbMu    sync.Mutex
 	hbMsg   chan *Message
 	hbClose chan any
-	hbRef   Ref
+	hbRef   uint64 // accessed atomically
+
+	// number of transfers in progress, accessed atomically
+	transfers int32
+
+	// time in unix nanoseconds of the last write of application data, and of the last message received, accessed
+	// atomically
+	lastWriteAt int64
+	lastReadAt  int64
+
+	// guards EndPoint and RequestHeader, which UseProfile changes while the connection's goroutines read them
+	endPointMu sync.RWMutex
+
+	// reconnection related state
+	mu                      sync.RWMutex
+	reconnectedCallbacks    map[Ref]func(ReconnectInfo)
+	disconnectedAt          time.Time
+	connectAttempts         int
+	rejoinChannels          []*Channel
+	lanes                   map[string]*sendLane
+	dropCallbacks           map[Ref]func(DropEvent)
+	dropCounts              map[DropReason]uint64
+	slowConsumerCallbacks   map[Ref]func(HandlerTiming)
+	disconnectCallbacks     map[Ref]func(DisconnectInfo)
+	heartbeatReplyCallbacks map[Ref]func(HeartbeatReply)
+	telemetryCallbacks      map[Ref]func(TelemetryEvent)
+	sendQueueFullCallbacks  map[Ref]func(SendQueueFull)
+	protocolErrorCallbacks  map[Ref]func(*ProtocolError)
+	giveUpCallbacks         map[Ref]func(error)
+	connectStartedAt        time.Time
+	beforeDisconnectHooks   []beforeDisconnectHook
+	beforeConnectHooks      []beforeConnectHook
+	paramsProviders         []paramsProvider
+	exporters               map[Ref]*Exporter
+	migrations              map[Ref]payloadMigration
+	headerProviders         []headerProvider
+	connectTargetHooks      []connectTargetHook
+	payloadSerializers      []payloadSerializerRule
+	disconnectRequested     bool
+	lastErr                 error
+
+	// received messages waiting to be dispatched with FeatureInboundQueue, and whether a goroutine is dispatching them
+	inboundMu      sync.Mutex
+	inbound        chan inboundFrame
+	inboundRunning bool
+
+	// callbacks queued for Poll and ProcessNext when ManualDispatch is set
+	dispatchMu    sync.Mutex
+	dispatchQueue []func()
+	dispatchReady chan struct{}
+
+	// memory accounting, accessed atomically
+	memoryPressureCallbacks map[Ref]func(MemoryUsage)
+	pushBufferBytes         int64
+	laneBytes               int64
+	inboundBytes            int64
+	exportBytes             int64
+	pendingPushBytes        int64
+	memoryPressure          int32
+
+	// number of Pushes that were sent and are waiting for a reply, accessed atomically
+	awaitingReplies int64
+
+	// ends the phx.connect span of the current connection attempt, guarded by mu
+	endConnectSpan func(error)
+
+	// name of the profile in use, guarded by mu
+	profile string
+
+	// interceptors added with InterceptJoins, guarded by mu
+	joinInterceptors []JoinInterceptor
+
+	// interceptors added with Use, guarded by mu
+	interceptors []Interceptor
+
+	// handlers for replies to messages sent on the "phoenix" topic other than heartbeats, by ref, guarded by mu
+	controlReplies map[Ref]func(payload any)
+
+	// channels closed when the connection opens, and the error of the last failed connection attempt, guarded by mu
+	openWaiters    []chan struct{}
+	lastConnectErr error
+
+	// whether the connection is waiting for the reply to the authentication message, and a counter of the
+	// authentication messages sent, guarded by mu
+	authenticating bool
+	authAttempt    uint64
+
+	// OnStateChange callbacks, the last state they were notified of, and the notifications waiting to be called in
+	// order, guarded by stateMu
+	stateMu        sync.Mutex
+	stateCallbacks map[Ref]func(old ConnectionState, new ConnectionState)
+	lastState      ConnectionState
+	stateQueue     []func()
+	stateNotifying bool
 }
 
 // NewSocket creates a Socket that connects to the given endPoint using the default websocket Transport.
@@ -58,79 +290,250 @@ type Socket struct {
 // If a custom websocket.Dialer is needed, such as to set up a Proxy, then create a custom WebSocket
 func NewSocket(endPoint *url.URL) *Socket {
 	socket := &Socket{
-		EndPoint:           endPoint,
-		Logger:             NewNoopLogger(),
-		ConnectTimeout:     defaultConnectTimeout,
-		ReconnectAfterFunc: defaultReconnectAfterFunc,
-		HeartbeatInterval:  defaultHeartbeatInterval,
-		Serializer:         NewJSONSerializerV2(),
-		refGenerator:       newAtomicRef(),
-		openCallbacks:      make(map[Ref]func()),
-		closeCallbacks:     make(map[Ref]func()),
-		errorCallbacks:     make(map[Ref]func(error)),
-		messageCallbacks:   make(map[Ref]func(Message)),
-		channels:           make(map[string]*Channel),
+		EndPoint:                endPoint,
+		Logger:                  NewNoopLogger(),
+		Clock:                   NewRealClock(),
+		ConnectTimeout:          defaultConnectTimeout,
+		PushTimeout:             defaultPushTimeout,
+		ReconnectStableAfter:    defaultReconnectStableAfter,
+		HeartbeatInterval:       defaultHeartbeatInterval,
+		BeforeDisconnectTimeout: defaultBeforeDisconnectTimeout,
+		SendQueueSize:           defaultSendQueueSize,
+		Features:                defaultFeatures,
+		InboundQueueSize:        defaultInboundQueueSize,
+		Serializer:              defaultSerializer(),
+		Codec:                   NewJSONCodec(),
+		refGenerator:            newAtomicRef(),
+		openCallbacks:           make(map[Ref]func()),
+		closeCallbacks:          make(map[Ref]func()),
+		errorCallbacks:          make(map[Ref]func(error)),
+		messageCallbacks:        make(map[Ref]func(Message)),
+		reconnectedCallbacks:    make(map[Ref]func(ReconnectInfo)),
+		channels:                make(map[string]*Channel),
+		lanes:                   make(map[string]*sendLane),
+		dropCallbacks:           make(map[Ref]func(DropEvent)),
+		dropCounts:              make(map[DropReason]uint64),
+		slowConsumerCallbacks:   make(map[Ref]func(HandlerTiming)),
+		disconnectCallbacks:     make(map[Ref]func(DisconnectInfo)),
+		heartbeatReplyCallbacks: make(map[Ref]func(HeartbeatReply)),
+		controlReplies:          make(map[Ref]func(payload any)),
+		telemetryCallbacks:      make(map[Ref]func(TelemetryEvent)),
+		protocolErrorCallbacks:  make(map[Ref]func(*ProtocolError)),
+		giveUpCallbacks:         make(map[Ref]func(error)),
+		dispatchReady:           make(chan struct{}, 1),
+		memoryPressureCallbacks: make(map[Ref]func(MemoryUsage)),
+		sendQueueFullCallbacks:  make(map[Ref]func(SendQueueFull)),
+		stateCallbacks:          make(map[Ref]func(old ConnectionState, new ConnectionState)),
+		exporters:               make(map[Ref]*Exporter),
+		migrations:              make(map[Ref]payloadMigration),
+		lastState:               ConnectionClosed,
 	}
+	socket.ReconnectAfterFunc = socket.defaultReconnectAfter
 	socket.Transport = NewWebsocket(socket)
 	return socket
 }
 
 // Connect will start connection attempts with the server until successful or canceled with Disconnect.
 func (s *Socket) Connect() error {
-	// Add the 'vsn' query parameter to the connection url
-	q := s.EndPoint.Query()
-	q.Set("vsn", s.Serializer.vsn())
-	s.EndPoint.RawQuery = q.Encode()
+	endPoint, requestHeader := s.endPoint()
+	if endPoint.Query().Get("vsn") != s.Serializer.vsn() {
+		endPoint = s.endPointWithVsn()
+		s.setEndPoint(endPoint, requestHeader)
+	}
+	s.disconnectInfo() // clear any state left from a previous connection
+	s.noteConnectStarted()
 
-	s.Logger.Printf(LogInfo, "socket", "connecting to %v\n", s.EndPoint)
+	s.Logger.Printf(LogInfo, "socket", "connecting to %v\n", endPoint)
 
-	err := s.Transport.Connect(s.EndPoint, s.RequestHeader, s.ConnectTimeout)
+	err := s.Transport.Connect(endPoint, requestHeader, s.ConnectTimeout)
 	if err != nil {
 		s.Logger.Println(LogError, "socket", err)
 		return err
 	}
+	s.onConnStateChange()
+
+	return nil
+}
+
+// ConnectWithin connects like Connect, and then waits up to the given duration for the connection to open, including
+// any retries, for startup sequences that must fail fast if the server is unavailable. If the connection doesn't open
+// in time, the Socket stops trying to connect and the error of the last attempt is returned.
+func (s *Socket) ConnectWithin(d time.Duration) error {
+	timer := s.Clock.NewTimer(d)
+	defer timer.Stop()
 
+	err := s.connectAndWait(func(opened <-chan struct{}) bool {
+		select {
+		case <-opened:
+			return true
+		case <-timer.C():
+			return false
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("could not connect within %v: %w", d, err)
+	}
 	return nil
 }
 
+// connectAndWait connects like Connect, and then calls wait with a channel that is closed when the connection opens.
+// If wait returns false before then, the Socket stops trying to connect and the error of the last attempt is returned.
+func (s *Socket) connectAndWait(wait func(opened <-chan struct{}) bool) error {
+	opened := make(chan struct{})
+	s.mu.Lock()
+	s.openWaiters = append(s.openWaiters, opened)
+	s.lastConnectErr = nil
+	s.mu.Unlock()
+
+	err := s.Connect()
+	if err != nil {
+		s.forgetOpenWaiter(opened)
+		return err
+	}
+
+	if wait(opened) || !s.forgetOpenWaiter(opened) {
+		// Opened, possibly just as the wait ended
+		return nil
+	}
+
+	s.mu.RLock()
+	err = s.lastConnectErr
+	s.mu.RUnlock()
+	if err == nil {
+		err = errors.New("connection did not open")
+	}
+
+	_ = s.Disconnect()
+	return err
+}
+
+// forgetOpenWaiter stops the given channel from being closed when the connection opens. Returns false if it already
+// was.
+func (s *Socket) forgetOpenWaiter(opened chan struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, waiter := range s.openWaiters {
+		if waiter == opened {
+			s.openWaiters = append(s.openWaiters[:i:i], s.openWaiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// notifyOpenWaiters closes the channels of the callers of ConnectWithin waiting for the connection to open.
+func (s *Socket) notifyOpenWaiters() {
+	s.mu.Lock()
+	waiters := s.openWaiters
+	s.openWaiters = nil
+	s.mu.Unlock()
+
+	for _, waiter := range waiters {
+		close(waiter)
+	}
+}
+
+// endPoint returns the EndPoint and RequestHeader.
+func (s *Socket) endPoint() (*url.URL, http.Header) {
+	s.endPointMu.RLock()
+	defer s.endPointMu.RUnlock()
+
+	return s.EndPoint, s.RequestHeader
+}
+
+// setEndPoint replaces the EndPoint and RequestHeader.
+func (s *Socket) setEndPoint(endPoint *url.URL, requestHeader http.Header) {
+	s.endPointMu.Lock()
+	defer s.endPointMu.Unlock()
+
+	s.EndPoint = endPoint
+	s.RequestHeader = requestHeader
+}
+
+// endPointWithVsn returns a copy of EndPoint with the 'vsn' query parameter added.
+func (s *Socket) endPointWithVsn() *url.URL {
+	current, _ := s.endPoint()
+	endPoint := *current
+	q := endPoint.Query()
+	q.Set("vsn", s.Serializer.vsn())
+	endPoint.RawQuery = q.Encode()
+	return &endPoint
+}
+
 // Disconnect or stop trying to Connect to server.
 func (s *Socket) Disconnect() error {
-	err := s.Transport.Disconnect()
+	return s.disconnect(nil, s.Transport.Disconnect)
+}
+
+// DisconnectWithCode disconnects like Disconnect, closing the connection with the given close code and reason, so that
+// the server can tell why the client left, such as 4000 for a user logging out or CloseGoingAway for the application
+// shutting down. Codes from 4000 to 4999 are free for applications to use. Transports that don't implement
+// CodeDisconnecter close the connection without a code.
+func (s *Socket) DisconnectWithCode(code int, reason string) error {
+	transport, ok := s.Transport.(CodeDisconnecter)
+	if !ok {
+		s.Logger.Printf(LogWarning, "socket", "transport can't send close code %v, disconnecting without it", code)
+		return s.Disconnect()
+	}
+	return s.disconnect(nil, func() error { return transport.DisconnectWithCode(code, reason) })
+}
+
+func (s *Socket) disconnect(flush func(), disconnectTransport func() error) error {
+	s.callBeforeDisconnect()
+	if flush != nil {
+		flush()
+	}
+	s.forgetDisconnect()
+	s.noteDisconnectRequested()
+	err := disconnectTransport()
 	if err != nil {
 		s.Logger.Println(LogError, "socket", err)
 		return err
 	}
+	s.onConnStateChange()
 
 	return nil
 }
 
 // Reconnect with the server.
 func (s *Socket) Reconnect() error {
+	s.noteDisconnectRequested()
 	err := s.Transport.Reconnect()
 	if err != nil {
 		s.Logger.Println(LogError, "socket", err)
 		return err
 	}
+	s.onConnStateChange()
 
 	return nil
 }
 
+// IsConnected returns true if the connection is open, and authenticated if the Credentials are sent in the first
+// message.
 func (s *Socket) IsConnected() bool {
-	return s.Transport != nil && s.Transport.IsConnected()
+	return s.Transport != nil && s.Transport.IsConnected() && !s.isAuthenticating()
 }
 
 func (s *Socket) IsConnectedOrConnecting() bool {
-	return s.Transport != nil &&
-		(s.Transport.ConnectionState() == ConnectionConnecting || s.Transport.ConnectionState() == ConnectionOpen)
+	if s.Transport == nil {
+		return false
+	}
+	state := s.Transport.ConnectionState()
+	return state == ConnectionConnecting || state == ConnectionReconnecting || state == ConnectionOpen
 }
 
+// ConnectionState returns the state of the connection. A connection that is open but waiting for the server to accept
+// the Credentials sent in the first message is still connecting. See OnStateChange to be notified of changes.
 func (s *Socket) ConnectionState() ConnectionState {
-	if s.Transport != nil {
-		return s.Transport.ConnectionState()
-	} else {
+	if s.Transport == nil {
 		return ConnectionClosed
-
 	}
+	state := s.Transport.ConnectionState()
+	if state == ConnectionOpen && s.isAuthenticating() {
+		return ConnectionConnecting
+	}
+	return state
 }
 
 func (s *Socket) Push(topic string, event string, payload any, joinRef Ref) (Ref, error) {
@@ -148,18 +551,102 @@ func (s *Socket) Push(topic string, event string, payload any, joinRef Ref) (Ref
 }
 
 func (s *Socket) PushMessage(msg Message) error {
-	data, err := s.Serializer.encode(&msg)
-	if err != nil {
-		return err
+	_, err := s.pushMessage(msg, false)
+	return err
+}
+
+// pushMessage passes the given message through the interceptors, then encodes and sends it, ahead of any queued messages if priority is true and the Transport
+// supports it. Returns the size of the encoded message.
+func (s *Socket) pushMessage(msg Message, priority bool) (int, error) {
+	size := 0
+	err := s.intercept(Outbound, msg, func(msg Message) error {
+		data, binary, err := s.encode(&msg)
+		if err != nil {
+			return err
+		}
+
+		err = s.send(data, binary, priority)
+		if err != nil {
+			return err
+		}
+		s.checkMemory()
+		if msg.Topic != "phoenix" || msg.Event != string(HeartBeatEvent) {
+			s.noteWrite()
+		}
+
+		s.Logger.Printf(LogDebug, "socket", "Sent message %+v", msg)
+		size = len(data)
+		return nil
+	})
+	return size, err
+}
+
+// send hands the given encoded message to the Transport.
+func (s *Socket) send(data []byte, binary bool, priority bool) error {
+	err := s.sendFrame(data, binary, priority)
+	if err == nil {
+		s.instrumentTraffic("sent", len(data))
 	}
+	return err
+}
 
-	err = s.Transport.Send(data)
-	if err != nil {
-		return err
+func (s *Socket) sendFrame(data []byte, binary bool, priority bool) error {
+	if binary {
+		sender, ok := s.Transport.(BinarySender)
+		if !ok {
+			return errors.New("transport does not support binary messages")
+		}
+		return sender.SendBinary(data)
+	}
+	if sender, ok := s.Transport.(PrioritySender); ok && priority {
+		return sender.SendPriority(data)
 	}
+	return s.Transport.Send(data)
+}
 
-	s.Logger.Printf(LogDebug, "socket", "Sent message %+v", msg)
-	return nil
+// encode applies the outbound hooks to the given message and encodes it with the Serializer, or in the binary wire
+// format if it has a binary payload. Returns true if the message must be sent in a binary frame.
+func (s *Socket) encode(msg *Message) ([]byte, bool, error) {
+	custom, isCustom := s.Serializer.(*customSerializer)
+	if payload, ok := msg.Payload.(binaryPayload); ok && !isCustom {
+		if s.Signer != nil && isSignedMessage(msg) {
+			return nil, false, errors.New("binary messages can't be signed")
+		}
+		data, err := encodeBinaryPush(msg, payload)
+		return data, true, err
+	}
+	if s.Compression != nil {
+		err := s.Compression.compress(msg)
+		if err != nil {
+			return nil, false, fmt.Errorf("compressing message: %w", err)
+		}
+	}
+	if s.Signer != nil {
+		err := s.Signer.sign(msg, s.Clock.Now(), s.random())
+		if err != nil {
+			return nil, false, fmt.Errorf("signing message: %w", err)
+		}
+	}
+	if isCustom {
+		return custom.encodeFrame(msg)
+	}
+	data, err := s.Serializer.encode(msg)
+	return data, false, err
+}
+
+// BeginTransfer marks the start of a large transfer, such as a chunked upload, during which heartbeats are handled
+// according to HeartbeatDuringTransfer. Every call must be matched with a call to EndTransfer.
+func (s *Socket) BeginTransfer() {
+	atomic.AddInt32(&s.transfers, 1)
+}
+
+// EndTransfer marks the end of a transfer started with BeginTransfer.
+func (s *Socket) EndTransfer() {
+	atomic.AddInt32(&s.transfers, -1)
+}
+
+func (s *Socket) isTransferring() bool {
+	return atomic.LoadInt32(&s.transfers) > 0
 }
 
 // MakeRef returns a unique Ref for this Socket.
@@ -167,11 +654,16 @@ func (s *Socket) MakeRef() Ref {
 	return s.refGenerator.nextRef()
 }
 
-// OnOpen registers the given callback to be called whenever the Socket is opened successfully.
+// OnOpen registers the given callback to be called whenever the Socket is opened successfully. Like every callback, it
+// runs apart from the goroutines managing the connection, so it may join Channels, such as with
+// socket.Channel(topic, params).Join() to set them up on every connection. A Channel that is already joining, such as
+// when rejoining after a reconnect, returns an error from Join that can be ignored.
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (s *Socket) OnOpen(callback func()) Ref {
 	ref := s.MakeRef()
+	s.handlersMu.Lock()
 	s.openCallbacks[ref] = callback
+	s.handlersMu.Unlock()
 	return ref
 }
 
@@ -179,15 +671,21 @@ func (s *Socket) OnOpen(callback func()) Ref {
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (s *Socket) OnClose(callback func()) Ref {
 	ref := s.MakeRef()
+	s.handlersMu.Lock()
 	s.closeCallbacks[ref] = callback
+	s.handlersMu.Unlock()
 	return ref
 }
 
-// OnError registers the given callback to be called whenever the Socket has an error
+// OnError registers the given callback to be called whenever the Socket has an error. The errors of failed connection
+// attempts match ErrUnauthorized, ErrTLS, ErrDNS, ErrConnectionRefused or ErrTimeout with errors.Is when their cause
+// is known.
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (s *Socket) OnError(callback func(error)) Ref {
 	ref := s.MakeRef()
+	s.handlersMu.Lock()
 	s.errorCallbacks[ref] = callback
+	s.handlersMu.Unlock()
 	return ref
 }
 
@@ -195,66 +693,107 @@ func (s *Socket) OnError(callback func(error)) Ref {
 // Returns a unique Ref that can be used to cancel this callback via Off.
 func (s *Socket) OnMessage(callback func(Message)) Ref {
 	ref := s.MakeRef()
+	s.handlersMu.Lock()
 	s.messageCallbacks[ref] = callback
+	s.handlersMu.Unlock()
 	return ref
 }
 
 // Off cancels the given callback from being called.
 func (s *Socket) Off(ref Ref) {
-	_, ok := s.openCallbacks[ref]
-	if ok {
-		delete(s.openCallbacks, ref)
-		return
-	}
-
-	_, ok = s.closeCallbacks[ref]
-	if ok {
-		delete(s.closeCallbacks, ref)
-		return
-	}
+	s.handlersMu.Lock()
+	delete(s.openCallbacks, ref)
+	delete(s.closeCallbacks, ref)
+	delete(s.errorCallbacks, ref)
+	delete(s.messageCallbacks, ref)
+	s.handlersMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reconnectedCallbacks, ref)
+	delete(s.dropCallbacks, ref)
+	delete(s.memoryPressureCallbacks, ref)
+	delete(s.sendQueueFullCallbacks, ref)
+	delete(s.protocolErrorCallbacks, ref)
+	delete(s.giveUpCallbacks, ref)
+	delete(s.slowConsumerCallbacks, ref)
+	delete(s.disconnectCallbacks, ref)
+	delete(s.heartbeatReplyCallbacks, ref)
+	delete(s.telemetryCallbacks, ref)
+	delete(s.migrations, ref)
+	s.offBeforeDisconnect(ref)
+	s.offProviders(ref)
+	s.offExporter(ref)
+	s.offStateChange(ref)
+}
 
-	_, ok = s.errorCallbacks[ref]
-	if ok {
-		delete(s.errorCallbacks, ref)
-		return
-	}
+// Channel creates a new instance of phx.Channel, or returns an existing instance if it had already been created.
+func (s *Socket) Channel(topic string, params map[string]string) *Channel {
+	return NewChannel(topic, params, s)
+}
 
-	_, ok = s.messageCallbacks[ref]
-	if ok {
-		delete(s.messageCallbacks, ref)
+// recordSubscription adds or removes the given channel's topic from the SessionStore's manifest, if one is set.
+func (s *Socket) recordSubscription(channel *Channel, joined bool) {
+	if s.SessionStore == nil {
 		return
 	}
-}
 
-// Channel creates a new instance of phx.Channel, or returns an existing instance if it had already been created.
-func (s *Socket) Channel(topic string, params map[string]string) *Channel {
-	channel, exists := s.channels[topic]
-	if exists {
-		return channel
+	var err error
+	if joined {
+		var clientID string
+		clientID, err = s.SessionStore.ClientID()
+		if err == nil {
+			err = s.SessionStore.AddSubscription(ManifestEntry{Topic: channel.topic, Params: channel.params, ClientID: clientID})
+		}
 	} else {
-		channel := NewChannel(topic, params, s)
-		return channel
+		err = s.SessionStore.RemoveSubscription(channel.topic)
+	}
+	if err != nil {
+		s.Logger.Printf(LogError, "socket", "error recording subscription to '%v' in session store: %v", channel.topic, err)
 	}
 }
 
 func (s *Socket) hasChannel(topic string) bool {
-	_, exists := s.channels[topic]
+	_, exists := s.getChannel(topic)
 	return exists
 }
 
 func (s *Socket) getChannel(topic string) (*Channel, bool) {
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+
 	channel, exists := s.channels[topic]
 	return channel, exists
 }
 
+// channelList returns a snapshot of the Channels of this Socket, which can be iterated without holding any locks.
+func (s *Socket) channelList() []*Channel {
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+
+	channels := make([]*Channel, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
 func (s *Socket) addChannel(channel *Channel) {
+	s.handlersMu.Lock()
 	s.channels[channel.topic] = channel
-	s.Logger.Printf(LogDebug, "socket", "Added channel '%v'. Open channels: %v", channel.topic, len(s.channels))
+	count := len(s.channels)
+	s.handlersMu.Unlock()
+	s.Logger.Printf(LogDebug, "socket", "Added channel '%v'. Open channels: %v", channel.topic, count)
 }
 
 func (s *Socket) removeChannel(channel *Channel) {
-	delete(s.channels, channel.topic)
-	s.Logger.Printf(LogDebug, "socket", "Removed channel '%v'. Open channels: %v", channel.topic, len(s.channels))
+	s.handlersMu.Lock()
+	if s.channels[channel.topic] == channel {
+		delete(s.channels, channel.topic)
+	}
+	count := len(s.channels)
+	s.handlersMu.Unlock()
+	s.Logger.Printf(LogDebug, "socket", "Removed channel '%v'. Open channels: %v", channel.topic, count)
 }
 
 // implements TransportHandler
@@ -263,35 +802,83 @@ func (s *Socket) reconnectAfter(tries int) time.Duration {
 	return s.ReconnectAfterFunc(tries)
 }
 
+func (s *Socket) clock() Clock {
+	return s.Clock
+}
+
+func (s *Socket) logger() Logger {
+	return s.Logger
+}
+
+func (s *Socket) reconnectStableAfter() time.Duration {
+	return s.ReconnectStableAfter
+}
+
 func (s *Socket) onConnOpen() {
-	s.Logger.Printf(LogInfo, "socket", "Connected to %v", s.EndPoint)
+	endPoint, _ := s.endPoint()
+	logFields(s.Logger, LogInfo, "socket", "Connected", "url", endPoint.Redacted())
+	s.finishConnectSpan(nil)
 	s.startHeartbeat()
+	if s.authenticatesFirst() {
+		s.authenticate(s.onConnAuthenticated)
+		return
+	}
+	s.onConnAuthenticated()
+}
+
+// onConnAuthenticated finishes opening a connection once it is ready for Channels to join.
+func (s *Socket) onConnAuthenticated() {
+	s.onConnStateChange()
+	s.handlersMu.RLock()
 	for _, cb := range s.openCallbacks {
-		go cb()
+		s.run(cb)
 	}
+	s.handlersMu.RUnlock()
+	s.noteConnOpen()
+	s.emitSocketConnected()
+	s.notifyOpenWaiters()
 }
 
 func (s *Socket) onConnClose() {
-	s.Logger.Printf(LogInfo, "socket", "Disconnected from %v", s.EndPoint)
+	endPoint, _ := s.endPoint()
+	logFields(s.Logger, LogInfo, "socket", "Disconnected", "url", endPoint.Redacted())
 	s.stopHeartbeat()
+	s.forgetAuthentication()
+	s.onConnStateChange()
+	s.noteConnClose()
+	s.noteConnectStarted()
+	s.handlersMu.RLock()
 	for _, cb := range s.closeCallbacks {
-		go cb()
+		s.run(cb)
 	}
+	s.handlersMu.RUnlock()
+	s.callDisconnectCallbacks()
 }
 
 func (s *Socket) callErrorCallbacks(err error) {
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
 	for _, cb := range s.errorCallbacks {
-		go cb(err)
+		cb := cb
+		s.run(func() { cb(err) })
 	}
 }
 
 func (s *Socket) onConnError(err error) {
-	s.Logger.Printf(LogError, "socket", "Connection error: %s", err)
+	err = classifyConnectError(err)
+	logFields(s.Logger, LogError, "socket", "Connection error", "error", err)
+	s.finishConnectSpan(err)
+	attempts := s.noteConnError(err)
 	s.callErrorCallbacks(err)
+	if s.MaxReconnectAttempts > 0 && attempts == s.MaxReconnectAttempts {
+		// Disconnecting waits for the Transport, which is the caller
+		go s.giveUp(attempts, err)
+	}
 }
 
 func (s *Socket) onWriteError(err error) {
 	s.Logger.Printf(LogError, "socket", "Write error: %s", err)
+	s.noteLastError(err)
 	s.callErrorCallbacks(err)
 }
 
@@ -299,83 +886,282 @@ func (s *Socket) onReadError(err error) {
 	// Don't log errors when the connection was closed
 	if !strings.Contains(err.Error(), "use of closed network connection") {
 		s.Logger.Printf(LogError, "socket", "Read error: %s", err)
+		s.noteLastError(err)
 		s.callErrorCallbacks(err)
 	}
 }
 
 func (s *Socket) onConnMessage(data []byte) {
-	msg, err := s.Serializer.decode(data)
+	s.noteRead()
+	s.receive(inboundFrame{data: data})
+}
+
+func (s *Socket) onConnBinaryMessage(data []byte) {
+	s.noteRead()
+	s.receive(inboundFrame{data: data, binary: true})
+}
+
+// receive dispatches the given received message, or queues it to be dispatched with FeatureInboundQueue.
+func (s *Socket) receive(frame inboundFrame) {
+	if s.Features.Has(FeatureInboundQueue) {
+		s.enqueueInbound(frame)
+		return
+	}
+	s.dispatchFrame(frame)
+}
+
+// dispatchFrame decodes and dispatches the given received message, reporting the ones that violate the protocol, and
+// any panic, to the OnProtocolError callbacks instead.
+func (s *Socket) dispatchFrame(frame inboundFrame) {
+	defer s.recoverFrame(frame)
+
+	if protocolErr := s.checkFrame(frame); protocolErr != nil {
+		s.onProtocolError(protocolErr)
+		return
+	}
+	if frame.binary {
+		s.dispatchBinary(frame.data)
+		return
+	}
+	msg, payload, err := s.decodeEnvelope(frame.data)
+	if err != nil {
+		s.onProtocolError(newProtocolError(ProtocolMalformed, frame.data, err))
+		return
+	}
+	s.handleMessage(msg, payload, len(frame.data))
+}
+
+func (s *Socket) dispatchBinary(data []byte) {
+	var msg *Message
+	var err error
+	if custom, ok := s.Serializer.(*customSerializer); ok {
+		msg, err = custom.decode(data)
+	} else {
+		msg, err = decodeBinaryMessage(data)
+	}
 	if err != nil {
-		s.Logger.Println(LogError, "socket", "could not decode data to Message:", err)
+		s.onProtocolError(newProtocolError(ProtocolMalformed, data, err))
 		return
 	}
+	s.handleMessage(msg, nil, len(data))
+}
 
+// handleMessage dispatches a received message of the given size, whose payload is still encoded if given.
+func (s *Socket) handleMessage(msg *Message, payload []byte, size int) {
+	var err error
 	s.Logger.Printf(LogDebug, "socket", "Received message: %+v", msg)
+	s.instrumentTraffic("received", size)
+
+	if s.Analyzer != nil {
+		s.Analyzer.observe(msg.Topic, size, s.Clock.Now())
+	}
+
+	if s.Signer != nil {
+		err = s.Signer.verify(msg, s.Clock.Now())
+		if err != nil {
+			s.Logger.Printf(LogWarning, "socket", "dropping unverified message %+v: %v", msg, err)
+			s.drop(DropUnverified, msg.Topic, msg.Event)
+			return
+		}
+	}
+
+	// Only parse the payload if something is going to look at it
+	if payload != nil && s.wantsPayload(msg, payload) {
+		msg.Payload, err = s.Serializer.(envelopeDecoder).decodePayload(payload)
+		if err != nil {
+			s.reportProtocolError(newProtocolError(ProtocolMalformed, payload, err), msg.Topic, msg.Event)
+			return
+		}
+	}
 
-	if msg.Topic == "phoenix" && msg.Ref == s.hbRef {
-		// Send this message to the heartbeat goroutine
-		s.hbMsg <- msg
+	if s.Compression != nil {
+		err = s.Compression.decompress(msg)
+		if err != nil {
+			s.Logger.Printf(LogWarning, "socket", "dropping '%v' on '%v' that could not be decompressed: %v", msg.Event, msg.Topic, err)
+			s.drop(DropDecodeFailure, msg.Topic, msg.Event)
+			return
+		}
+	}
+
+	err = s.intercept(Inbound, *msg, func(msg Message) error {
+		s.routeMessage(&msg, size)
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrIntercepted) {
+			s.Logger.Printf(LogWarning, "socket", "dropping '%v' on '%v': %v", msg.Event, msg.Topic, err)
+		}
+		s.drop(DropMiddleware, msg.Topic, msg.Event)
+	}
+}
+
+// routeMessage hands the given received message of the given size to the heartbeat, OnMessage callbacks, Exporters
+// and Channels.
+func (s *Socket) routeMessage(msg *Message, size int) {
+	if msg.Topic == "phoenix" && msg.Ref == Ref(atomic.LoadUint64(&s.hbRef)) {
+		// Send this message to the heartbeat goroutine, unless it has stopped
+		hbMsg, hbClose := s.heartbeatChans()
+		if hbMsg != nil {
+			select {
+			case hbMsg <- msg:
+			case <-hbClose:
+			}
+		}
 		return
 	}
 
+	if msg.Topic == "phoenix" && msg.Event == string(ReplyEvent) && s.handleControlReply(msg) {
+		return
+	}
+
+	s.handlersMu.RLock()
 	for _, cb := range s.messageCallbacks {
-		go cb(*msg)
+		cb := cb
+		msgCopy := *msg
+		s.run(func() { cb(msgCopy) })
 	}
+	handled := len(s.messageCallbacks) > 0
+	s.handlersMu.RUnlock()
 
-	for _, channel := range s.channels {
-		channel.process(msg)
+	if s.export(msg, size) {
+		handled = true
+	}
+
+	for _, channel := range s.channelList() {
+		if channel.process(msg, size) {
+			handled = true
+		}
+	}
+	if !handled {
+		s.drop(DropUnhandled, msg.Topic, msg.Event)
+	}
+}
+
+// decodeEnvelope decodes the given data to a Message. If the Serializer can decode payloads separately, the Message
+// is returned without its Payload, which is returned encoded instead. Signed messages are always decoded whole, as
+// they can't be verified without their payload.
+func (s *Socket) decodeEnvelope(data []byte) (*Message, []byte, error) {
+	decoder, ok := s.Serializer.(envelopeDecoder)
+	if !ok || s.Signer != nil {
+		msg, err := s.Serializer.decode(data)
+		return msg, nil, err
 	}
+	return decoder.decodeEnvelope(data)
+}
+
+// wantsPayload returns true if the given encoded payload of the given message would be looked at by the heartbeat, an
+// Interceptor, an OnMessage callback, an Exporter or a Channel.
+func (s *Socket) wantsPayload(msg *Message, payload []byte) bool {
+	if msg.Topic == "phoenix" || s.hasInterceptors() {
+		return true
+	}
+
+	s.handlersMu.RLock()
+	hasMessageCallbacks := len(s.messageCallbacks) > 0
+	s.handlersMu.RUnlock()
+	if hasMessageCallbacks || s.exportsMessage(msg) {
+		return true
+	}
+
+	for _, channel := range s.channelList() {
+		if channel.wantsPayload(msg, payload) {
+			return true
+		}
+	}
+	return false
 }
 
 /*
  * Heartbeat related functionality
  */
 func (s *Socket) startHeartbeat() {
+	s.hbMu.Lock()
+	defer s.hbMu.Unlock()
+
+	// Each heartbeat goroutine gets its own channels, so that one that is slow to stop can't outlive its connection
 	s.hbClose = make(chan any)
 	s.hbMsg = make(chan *Message)
 	if startHeartbeat {
-		go s.heartbeat()
+		hbClose, hbMsg := s.hbClose, s.hbMsg
+		goLabeled(s.profilerLabels(roleHeartbeat), func() { s.heartbeat(hbClose, hbMsg) })
 	}
 }
 
 func (s *Socket) stopHeartbeat() {
-	close(s.hbClose)
-	close(s.hbMsg)
+	s.hbMu.Lock()
+	defer s.hbMu.Unlock()
+
+	if s.hbClose != nil {
+		close(s.hbClose)
+	}
+	s.hbClose = nil
+	s.hbMsg = nil
 }
 
-func (s *Socket) heartbeat() {
+func (s *Socket) heartbeatChans() (chan *Message, chan any) {
+	s.hbMu.Lock()
+	defer s.hbMu.Unlock()
+
+	return s.hbMsg, s.hbClose
+}
+
+func (s *Socket) heartbeat(hbClose chan any, hbMsg chan *Message) {
 	s.Logger.Println(LogDebug, "heartbeat", "heartbeat goroutine started")
 
 	defer func() {
 		s.Logger.Println(LogDebug, "heartbeat", "heartbeat goroutine stopped")
 	}()
 
-	s.hbRef = 0
+	atomic.StoreUint64(&s.hbRef, 0)
+	var sentAt time.Time
 
 	for {
-		timer := time.NewTimer(s.HeartbeatInterval)
+		timer := s.Clock.NewTimer(s.HeartbeatInterval)
 
 		select {
-		case <-s.hbClose:
+		case <-hbClose:
 			timer.Stop()
 			return
-		case msg := <-s.hbMsg:
+		case msg := <-hbMsg:
 			s.Logger.Println(LogDebug, "heartbeat", "Got heartbeat message", msg)
 			timer.Stop()
-			s.hbRef = 0
-		case <-timer.C:
+			atomic.StoreUint64(&s.hbRef, 0)
+			s.callHeartbeatReplyCallbacks(msg, sentAt)
+		case <-timer.C():
 			if !s.Transport.IsConnected() {
 				continue
 			}
-			if s.hbRef == 0 {
-				s.hbRef = s.MakeRef()
-				s.Logger.Println(LogDebug, "heartbeat", "Sending heartbeat", s.hbRef)
-				err := s.PushMessage(Message{Topic: "phoenix", Event: string(HeartBeatEvent), Payload: nil, Ref: s.hbRef})
+			transferring := s.isTransferring()
+			if transferring && s.HeartbeatDuringTransfer == HeartbeatSuppress {
+				// The transfer keeps the connection alive, so forget any outstanding heartbeat
+				s.Logger.Println(LogDebug, "heartbeat", "heartbeat suppressed during transfer")
+				atomic.StoreUint64(&s.hbRef, 0)
+				continue
+			}
+			if atomic.LoadUint64(&s.hbRef) == 0 {
+				if s.wroteRecently() {
+					s.Logger.Println(LogDebug, "heartbeat", "heartbeat skipped after recent write")
+					continue
+				}
+				if s.Features.Has(FeatureAdaptiveHeartbeat) && s.readRecently() {
+					s.Logger.Println(LogDebug, "heartbeat", "heartbeat skipped after recent read")
+					continue
+				}
+				hbRef := s.MakeRef()
+				atomic.StoreUint64(&s.hbRef, uint64(hbRef))
+				s.Logger.Println(LogDebug, "heartbeat", "Sending heartbeat", hbRef)
+				priority := transferring && s.HeartbeatDuringTransfer == HeartbeatPrioritize
+				sentAt = s.Clock.Now()
+				_, err := s.pushMessage(Message{Topic: "phoenix", Event: string(HeartBeatEvent), Payload: s.heartbeatPayload(), Ref: hbRef}, priority)
 				if err != nil {
 					s.Logger.Println(LogError, "heartbeat", "Error when sending heartbeat", err)
 				}
+			} else if s.readRecently() {
+				// The reply may be stuck behind a burst of messages, which show that the connection is alive anyway
+				s.Logger.Println(LogDebug, "heartbeat", "heartbeat reply late while receiving messages")
 			} else {
 				s.Logger.Println(LogDebug, "heartbeat", "heartbeat timeout")
+				s.noteLastError(ErrHeartbeatTimeout)
 				_ = s.Transport.Reconnect()
 			}
 		}
@@ -0,0 +1,177 @@
+package phx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SSEHandler is an http.Handler that serves selected inbound events of a Socket as Server-Sent Events, so that a web
+// service embedding this client can pass realtime updates on to its own browser clients without them connecting to
+// the Phoenix server. Events are selected and rendered by an Exporter, whose Subject is the name of each event, and
+// whose Body is its data.
+//
+// Every request gets the events that arrive while it is connected, with increasing ids. Clients that fall behind by
+// more than BufferSize events are disconnected, and reconnect with EventSource's own retry.
+type SSEHandler struct {
+	// BufferSize is the number of events that may wait to be written to each client. Defaults to 64.
+	BufferSize int
+
+	// KeepAlive is how often a comment is written to a client that has no events, so that proxies don't close the
+	// connection. Defaults to 15 seconds.
+	KeepAlive time.Duration
+
+	socket      *Socket
+	exporterRef Ref
+	mu          sync.Mutex
+	clients     map[*sseClient]struct{}
+	nextID      uint64
+	closed      bool
+}
+
+// sseClient is a request being served by an SSEHandler.
+type sseClient struct {
+	events chan []byte
+	gone   chan struct{}
+	once   sync.Once
+}
+
+// NewSSEHandler creates an SSEHandler that serves the events selected by the given Exporter, whose Sink is set to the
+// handler. Returns an error if the templates of the Exporter can't be parsed.
+func NewSSEHandler(socket *Socket, exporter *Exporter) (*SSEHandler, error) {
+	h := &SSEHandler{
+		BufferSize: defaultSSEBufferSize,
+		KeepAlive:  defaultSSEKeepAlive,
+		socket:     socket,
+		clients:    make(map[*sseClient]struct{}),
+	}
+	exporter.Sink = h
+	ref, err := socket.AddExporter(exporter)
+	if err != nil {
+		return nil, err
+	}
+	h.exporterRef = ref
+	return h, nil
+}
+
+// Close stops serving events and ends the requests being served.
+func (h *SSEHandler) Close() {
+	h.socket.Off(h.exporterRef)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for client := range h.clients {
+		delete(h.clients, client)
+		client.disconnect()
+	}
+}
+
+// Publish implements ExportSink, sending the event to every client.
+func (h *SSEHandler) Publish(subject string, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	frame := sseFrame(h.nextID, subject, data)
+	for client := range h.clients {
+		select {
+		case client.events <- frame:
+		default:
+			h.socket.Logger.Printf(LogWarning, "sse", "disconnecting client that fell behind")
+			delete(h.clients, client)
+			client.disconnect()
+		}
+	}
+	return nil
+}
+
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := h.addClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.removeClient(client)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := h.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultSSEKeepAlive
+	}
+	for {
+		timer := h.socket.Clock.NewTimer(keepAlive)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-client.gone:
+			timer.Stop()
+			return
+		case frame := <-client.events:
+			timer.Stop()
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+		case <-timer.C():
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *SSEHandler) addClient() (*sseClient, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errors.New("event stream is closed")
+	}
+	bufferSize := h.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSSEBufferSize
+	}
+	client := &sseClient{events: make(chan []byte, bufferSize), gone: make(chan struct{})}
+	h.clients[client] = struct{}{}
+	return client, nil
+}
+
+func (h *SSEHandler) removeClient(client *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+func (c *sseClient) disconnect() {
+	c.once.Do(func() { close(c.gone) })
+}
+
+// sseFrame formats an event of the given id and name, with every line of the data in its own data field.
+func sseFrame(id uint64, event string, data []byte) []byte {
+	var frame bytes.Buffer
+	fmt.Fprintf(&frame, "id: %d\nevent: %s\n", id, event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		frame.WriteString("data: ")
+		frame.Write(line)
+		frame.WriteByte('\n')
+	}
+	frame.WriteByte('\n')
+	return frame.Bytes()
+}
@@ -0,0 +1,89 @@
+package phx
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// PushStamper adds client-side metadata to the payloads of outbound pushes, so that the server can measure latency
+// and deduplicate pushes without the application adding them to every payload. Set Socket.Stamper to use it.
+//
+// Only payloads that are a map[string]any, map[string]string or nil are stamped; other payloads, such as structs, are
+// sent unchanged. Only pushes on a Channel are stamped, not joins, leaves, heartbeats or Socket.Push. The payload
+// given to Push is not modified.
+type PushStamper struct {
+	// TimestampKey is the key of the time the push was sent, in milliseconds since the Unix epoch. The time comes from
+	// Socket.Clock. Set to "" to leave it out.
+	TimestampKey string
+
+	// SequenceKey is the key of a number that increases with every push sent by the Socket, including pushes sent
+	// again. Set to "" to leave it out.
+	SequenceKey string
+
+	// IDKey is the key of a random UUID identifying the push, read from Socket.Rand. A push sent again, such as after a rejoin, keeps its
+	// id, so that the server can use it for idempotency. Set to "" to leave it out.
+	IDKey string
+
+	sequence uint64
+}
+
+// NewPushStamper creates a PushStamper with the keys "client_ts", "client_seq" and "client_id".
+func NewPushStamper() *PushStamper {
+	return &PushStamper{
+		TimestampKey: "client_ts",
+		SequenceKey:  "client_seq",
+		IDKey:        "client_id",
+	}
+}
+
+// stamp returns a copy of the given payload with the stamps added, or the payload itself if it can't be stamped. id
+// is the id of the push, which is generated from random and stored if it is empty.
+func (s *PushStamper) stamp(payload any, now time.Time, id *string, random io.Reader) any {
+	stamps := make(map[string]any, 3)
+	if s.TimestampKey != "" {
+		stamps[s.TimestampKey] = now.UnixMilli()
+	}
+	if s.SequenceKey != "" {
+		stamps[s.SequenceKey] = atomic.AddUint64(&s.sequence, 1)
+	}
+	if s.IDKey != "" {
+		if *id == "" {
+			*id = newUUID(random)
+		}
+		stamps[s.IDKey] = *id
+	}
+
+	switch p := payload.(type) {
+	case nil:
+		return stamps
+	case map[string]any:
+		stamped := make(map[string]any, len(p)+len(stamps))
+		for k, v := range p {
+			stamped[k] = v
+		}
+		for k, v := range stamps {
+			stamped[k] = v
+		}
+		return stamped
+	case map[string]string:
+		stamped := make(map[string]string, len(p)+len(stamps))
+		for k, v := range p {
+			stamped[k] = v
+		}
+		for k, v := range stamps {
+			switch v := v.(type) {
+			case int64:
+				stamped[k] = strconv.FormatInt(v, 10)
+			case uint64:
+				stamped[k] = strconv.FormatUint(v, 10)
+			default:
+				stamped[k] = fmt.Sprint(v)
+			}
+		}
+		return stamped
+	}
+	return payload
+}
@@ -0,0 +1,65 @@
+package phx
+
+// OnStateChange registers the given callback to be called whenever the ConnectionState of the Socket changes, such as
+// to show the status of the connection in a UI. Callbacks are called in the order the changes happened, one at a
+// time, and should not block. A state that only lasts until the next change may be skipped, but every change that is
+// reported starts from the state of the previous one.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnStateChange(callback func(old ConnectionState, new ConnectionState)) Ref {
+	ref := s.MakeRef()
+	s.stateMu.Lock()
+	s.stateCallbacks[ref] = callback
+	s.stateMu.Unlock()
+	return ref
+}
+
+// offStateChange removes the OnStateChange callback with the given ref.
+func (s *Socket) offStateChange(ref Ref) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	delete(s.stateCallbacks, ref)
+}
+
+// implements TransportHandler
+
+// onConnStateChange is called whenever the state of the connection may have changed, and notifies the OnStateChange
+// callbacks if it did.
+func (s *Socket) onConnStateChange() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state := s.ConnectionState()
+	old := s.lastState
+	if state == old {
+		return
+	}
+	s.lastState = state
+	s.Logger.Printf(LogDebug, "socket", "connection state changed from %v to %v", old, state)
+
+	for _, cb := range s.stateCallbacks {
+		cb := cb
+		s.stateQueue = append(s.stateQueue, func() { cb(old, state) })
+	}
+	if len(s.stateQueue) > 0 && !s.stateNotifying {
+		s.stateNotifying = true
+		s.run(s.drainStateChanges)
+	}
+}
+
+// drainStateChanges calls the queued OnStateChange callbacks in order until there are none left.
+func (s *Socket) drainStateChanges() {
+	for {
+		s.stateMu.Lock()
+		if len(s.stateQueue) == 0 {
+			s.stateNotifying = false
+			s.stateMu.Unlock()
+			return
+		}
+		callback := s.stateQueue[0]
+		s.stateQueue[0] = nil
+		s.stateQueue = s.stateQueue[1:]
+		s.stateMu.Unlock()
+
+		callback()
+	}
+}
@@ -0,0 +1,92 @@
+package phx
+
+import (
+	"sync"
+	"time"
+)
+
+// ChannelStats are counters of the traffic on a Channel, as returned by Channel.Stats.
+type ChannelStats struct {
+	// Topic is the topic of the Channel.
+	Topic string
+
+	// MessagesSent is the number of messages pushed on the Channel, including joins and leaves.
+	MessagesSent uint64
+
+	// MessagesReceived is the number of messages received for the Channel, including replies.
+	MessagesReceived uint64
+
+	// BytesSent is the number of encoded bytes pushed on the Channel.
+	BytesSent uint64
+
+	// BytesReceived is the number of encoded bytes received for the Channel.
+	BytesReceived uint64
+
+	// Errors is the number of error events, error replies, push timeouts and failed sends on the Channel.
+	Errors uint64
+
+	// LastActivity is the time a message was last sent or received on the Channel.
+	LastActivity time.Time
+
+	// ReplyLatencies are the times from sending a push until its reply was received, by event, such as "phx_join" for
+	// joins, to set SLOs on specific operations. Pushes that time out aren't included. Each reply is also emitted as a
+	// phoenix.channel_joined or phoenix.channel_handled_in TelemetryEvent, for exporting to metrics systems.
+	ReplyLatencies map[string]LatencyHistogram
+}
+
+// channelStats collects ChannelStats for a Channel.
+type channelStats struct {
+	mu    sync.Mutex
+	stats ChannelStats
+}
+
+func (s *channelStats) sent(size int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.MessagesSent++
+	s.stats.BytesSent += uint64(size)
+	s.stats.LastActivity = now
+}
+
+func (s *channelStats) received(size int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.MessagesReceived++
+	s.stats.BytesReceived += uint64(size)
+	s.stats.LastActivity = now
+}
+
+func (s *channelStats) replied(event string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stats.ReplyLatencies == nil {
+		s.stats.ReplyLatencies = make(map[string]LatencyHistogram)
+	}
+	histogram := s.stats.ReplyLatencies[event]
+	histogram.record(latency)
+	s.stats.ReplyLatencies[event] = histogram
+}
+
+func (s *channelStats) error() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Errors++
+}
+
+func (s *channelStats) snapshot() ChannelStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	if s.stats.ReplyLatencies != nil {
+		stats.ReplyLatencies = make(map[string]LatencyHistogram, len(s.stats.ReplyLatencies))
+		for event, histogram := range s.stats.ReplyLatencies {
+			stats.ReplyLatencies[event] = histogram.clone()
+		}
+	}
+	return stats
+}
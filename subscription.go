@@ -0,0 +1,124 @@
+package phx
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSubscriberDead is returned by Subscription.Err when the Subscription was canceled because its buffer stayed full
+// for longer than Channel.DeadSubscriberAfter.
+var ErrSubscriberDead = errors.New("subscriber stopped reading")
+
+// deadSubscriberEvent is generated by the client when a Subscription is canceled for not reading. Triggers
+// channel.OnDeadSubscriber().
+const deadSubscriberEvent = "phx_dead_subscriber"
+
+// A Subscription delivers the payloads of an event on a Go channel, for consumers that would rather receive than be
+// called back. Create one with Channel.Subscribe.
+type Subscription struct {
+	// C receives the payloads of the event, in the order they arrived. It is closed when the Subscription is canceled.
+	C <-chan any
+
+	// Event is the event the Subscription receives.
+	Event string
+
+	channel   *Channel
+	ch        chan any
+	binding   Ref
+	mu        sync.Mutex
+	fullSince time.Time
+	dropped   uint64
+	canceled  bool
+	err       error
+}
+
+// Subscribe returns a Subscription that delivers the payloads of the given event on a Go channel with room for the
+// given number of payloads. Delivery never waits for the subscriber: a payload that doesn't fit in the buffer is
+// dropped with DropSubscriberFull. If the buffer stays full for longer than DeadSubscriberAfter, the subscriber is
+// considered abandoned, the Subscription is canceled with ErrSubscriberDead and the OnDeadSubscriber callbacks are
+// called.
+func (c *Channel) Subscribe(event string, buffer int) *Subscription {
+	ch := make(chan any, buffer)
+	sub := &Subscription{
+		C:       ch,
+		Event:   event,
+		channel: c,
+		ch:      ch,
+	}
+	sub.binding = c.onInline(event, sub.deliver)
+	return sub
+}
+
+// OnDeadSubscriber will register the given callback for whenever a Subscription of this Channel is canceled because
+// its subscriber stopped reading. The callback gets the *Subscription.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (c *Channel) OnDeadSubscriber(callback func(payload any)) (bindingRef Ref) {
+	return c.On(deadSubscriberEvent, callback)
+}
+
+// Cancel stops the Subscription and closes C. Payloads still buffered in C can be received after it is canceled.
+func (s *Subscription) Cancel() {
+	s.cancel(nil)
+}
+
+// Err returns ErrSubscriberDead if the Subscription was canceled for not reading, and nil otherwise.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of payloads that were dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// deliver is called in the reading goroutine with each payload of the event.
+func (s *Subscription) deliver(payload any) {
+	s.mu.Lock()
+	if s.canceled {
+		s.mu.Unlock()
+		return
+	}
+	select {
+	case s.ch <- payload:
+		s.fullSince = time.Time{}
+		s.mu.Unlock()
+		return
+	default:
+	}
+
+	s.dropped++
+	now := s.channel.socket.Clock.Now()
+	if s.fullSince.IsZero() {
+		s.fullSince = now
+	}
+	deadAfter := s.channel.DeadSubscriberAfter
+	dead := deadAfter > 0 && now.Sub(s.fullSince) >= deadAfter
+	s.mu.Unlock()
+
+	s.channel.socket.drop(DropSubscriberFull, s.channel.topic, s.Event)
+	if dead {
+		s.channel.socket.Logger.Printf(LogWarning, "channel", "canceling subscription to '%v' on '%v': buffer full for %v", s.Event, s.channel.topic, deadAfter)
+		if s.cancel(ErrSubscriberDead) {
+			s.channel.trigger(deadSubscriberEvent, 0, s)
+		}
+	}
+}
+
+// cancel unbinds the Subscription and closes C, with the given reason. Returns false if it was already canceled.
+func (s *Subscription) cancel(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.canceled {
+		return false
+	}
+	s.canceled = true
+	s.err = err
+	s.channel.Off(s.binding)
+	close(s.ch)
+	return true
+}
@@ -0,0 +1,130 @@
+package phx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TelemetryEvent is an event emitted to the OnTelemetry callbacks. Events are named and shaped like the client-side
+// equivalents of the telemetry events Phoenix emits on the server, so that dashboards and conventions can be shared
+// between Elixir and Go:
+//
+//   - phoenix.socket_connected, when a connection is opened. Measurements: "duration" since connecting started or the
+//     previous connection was lost. Metadata: "endpoint", "transport", "vsn", "serializer", "features" and
+//     "result" ("ok").
+//   - phoenix.channel_joined, when the reply to a join is received or the join times out. Measurements: "duration"
+//     since the join was sent. Metadata: "topic", "params" and "result" ("ok", "error" or "timeout").
+//   - phoenix.channel_handled_in, when the reply to a push is received or the push times out, which is the client's
+//     view of the server handling the event. Measurements: "duration" since the push was sent. Metadata: "topic",
+//     "event" and "status", the status of the reply or "timeout".
+//
+// Durations are time.Durations.
+type TelemetryEvent struct {
+	// Name is the name of the event, such as []string{"phoenix", "channel_joined"} for [:phoenix, :channel_joined].
+	Name []string
+
+	// Measurements are the numeric values of the event, such as "duration".
+	Measurements map[string]any
+
+	// Metadata describes the context of the event, such as "topic".
+	Metadata map[string]any
+}
+
+// String returns the name of the event joined with dots, such as "phoenix.channel_joined".
+func (e TelemetryEvent) String() string {
+	return strings.Join(e.Name, ".")
+}
+
+var (
+	telemetrySocketConnected  = []string{"phoenix", "socket_connected"}
+	telemetryChannelJoined    = []string{"phoenix", "channel_joined"}
+	telemetryChannelHandledIn = []string{"phoenix", "channel_handled_in"}
+)
+
+// OnTelemetry registers the given callback to be called with every TelemetryEvent.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (s *Socket) OnTelemetry(callback func(TelemetryEvent)) Ref {
+	ref := s.MakeRef()
+	s.mu.Lock()
+	s.telemetryCallbacks[ref] = callback
+	s.mu.Unlock()
+	return ref
+}
+
+// hasTelemetry returns true if there are OnTelemetry callbacks, so that building events can be skipped otherwise.
+func (s *Socket) hasTelemetry() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.telemetryCallbacks) > 0
+}
+
+// emitTelemetry calls the OnTelemetry callbacks with the given event.
+func (s *Socket) emitTelemetry(name []string, duration time.Duration, metadata map[string]any) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, cb := range s.telemetryCallbacks {
+		cb := cb
+		event := TelemetryEvent{
+			Name:         name,
+			Measurements: map[string]any{"duration": duration},
+			Metadata:     metadata,
+		}
+		s.run(func() { cb(event) })
+	}
+}
+
+// noteConnectStarted records when connecting started, for the duration of phoenix.socket_connected.
+func (s *Socket) noteConnectStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connectStartedAt = s.Clock.Now()
+}
+
+// emitSocketConnected emits phoenix.socket_connected for a connection that just opened.
+func (s *Socket) emitSocketConnected() {
+	if !s.hasTelemetry() {
+		return
+	}
+
+	s.mu.RLock()
+	duration := s.Clock.Now().Sub(s.connectStartedAt)
+	s.mu.RUnlock()
+
+	endPoint, _ := s.endPoint()
+	s.emitTelemetry(telemetrySocketConnected, duration, map[string]any{
+		"endpoint":   endPoint.String(),
+		"transport":  fmt.Sprintf("%T", s.Transport),
+		"vsn":        s.Serializer.vsn(),
+		"serializer": fmt.Sprintf("%T", s.Serializer),
+		"features":   s.Features.String(),
+		"result":     "ok",
+	})
+}
+
+// emitTelemetry emits phoenix.channel_joined or phoenix.channel_handled_in for a push that was replied to with the
+// given status, or timed out.
+func (p *Push) emitTelemetry(status string) {
+	socket := p.channel.socket
+	if !socket.hasTelemetry() {
+		return
+	}
+
+	duration := socket.Clock.Now().Sub(p.sentAt)
+	if p.Event == string(JoinEvent) {
+		socket.emitTelemetry(telemetryChannelJoined, duration, map[string]any{
+			"topic":  p.channel.topic,
+			"params": p.channel.params,
+			"result": status,
+		})
+		return
+	}
+	socket.emitTelemetry(telemetryChannelHandledIn, duration, map[string]any{
+		"topic":  p.channel.topic,
+		"event":  p.Event,
+		"status": status,
+	})
+}
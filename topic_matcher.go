@@ -0,0 +1,80 @@
+package phx
+
+import "strings"
+
+// TopicMatcher decides which topics a Channel receives messages for, in addition to its own topic. Set
+// Channel.TopicMatcher to receive the broadcasts a server sends to topics derived from the joined one, such as
+// "room:lobby:typing" for a Channel joined to "room:lobby", on the one Channel. Use middleware added with Channel.Use
+// to see which topic a message was sent to.
+//
+// Only events are routed to a Channel for other topics; replies, closes and errors, and messages tied to a join by
+// their JoinRef, always belong to the Channel of their own topic.
+type TopicMatcher interface {
+	// MatchTopic returns true if messages for the given topic should be received.
+	MatchTopic(topic string) bool
+}
+
+// ExactTopic is a TopicMatcher that matches one topic.
+type ExactTopic string
+
+func (t ExactTopic) MatchTopic(topic string) bool {
+	return topic == string(t)
+}
+
+// PrefixTopic is a TopicMatcher that matches all topics starting with the given prefix, such as "room:lobby:".
+type PrefixTopic string
+
+func (t PrefixTopic) MatchTopic(topic string) bool {
+	return strings.HasPrefix(topic, string(t))
+}
+
+// GlobTopic is a TopicMatcher that matches topics against a pattern, in which "*" matches any run of characters
+// other than ':', so that it stands for one segment of the topic, and "**" matches any run of characters. For example,
+// "room:*:typing" matches "room:lobby:typing", and "room:**" matches every topic starting with "room:".
+type GlobTopic string
+
+func (t GlobTopic) MatchTopic(topic string) bool {
+	return matchGlob(string(t), topic)
+}
+
+func matchGlob(pattern string, topic string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] != '*' {
+			if len(topic) == 0 || topic[0] != pattern[0] {
+				return false
+			}
+			pattern, topic = pattern[1:], topic[1:]
+			continue
+		}
+
+		anything := strings.HasPrefix(pattern, "**")
+		if anything {
+			pattern = pattern[2:]
+		} else {
+			pattern = pattern[1:]
+		}
+		// Try every length for the wildcard, shortest first
+		for i := 0; i <= len(topic); i++ {
+			if matchGlob(pattern, topic[i:]) {
+				return true
+			}
+			if i < len(topic) && topic[i] == ':' && !anything {
+				return false
+			}
+		}
+		return false
+	}
+	return len(topic) == 0
+}
+
+// routesTopic returns true if the given message should be processed by this Channel, because it is for its topic or,
+// for events not tied to a join, for a topic matched by its TopicMatcher.
+func (c *Channel) routesTopic(msg *Message) bool {
+	if msg.Topic == c.topic {
+		return true
+	}
+	if c.TopicMatcher == nil || msg.JoinRef != 0 || msg.Event == string(ReplyEvent) || isLifecycleEvent(msg.Event) {
+		return false
+	}
+	return c.TopicMatcher.MatchTopic(msg.Topic)
+}
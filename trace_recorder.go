@@ -0,0 +1,189 @@
+package phx
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// threads of the timeline written by TraceRecorder
+const (
+	traceConnectThread = iota + 1
+	tracePushThread
+	traceHeartbeatThread
+)
+
+// TraceRecorder is an Instrumenter that records a timeline of the connects, joins, pushes, replies, reconnects and
+// heartbeats of a Socket, and writes it in the Chrome trace event format, which chrome://tracing and Perfetto
+// (ui.perfetto.dev) display, to debug ordering and latency issues visually. Set it as Socket.Instrumenter.
+type TraceRecorder struct {
+	// Next, if set, is passed every span and metric as well, such as to keep exporting them elsewhere.
+	Next Instrumenter
+
+	// MaxEvents is the number of events kept, beyond which the oldest are dropped. Defaults to 100000.
+	MaxEvents int
+
+	// Clock is used to timestamp the events. Defaults to the real clock.
+	Clock Clock
+
+	mu     sync.Mutex
+	start  time.Time // of the first event, which the timestamps are relative to
+	events []traceEvent
+	nextID uint64
+}
+
+// traceEvent is an event in the Chrome trace event format.
+type traceEvent struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp float64        `json:"ts"`
+	Duration  float64        `json:"dur,omitempty"`
+	Scope     string         `json:"s,omitempty"`
+	ID        uint64         `json:"id,omitempty"`
+	PID       int            `json:"pid"`
+	TID       int            `json:"tid"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{
+		MaxEvents: defaultTraceMaxEvents,
+		Clock:     NewRealClock(),
+	}
+}
+
+// StartSpan implements Instrumenter, recording phx.connect spans on the connects track, and phx.push spans, named
+// after the event, as async spans on the pushes track, as pushes overlap.
+func (r *TraceRecorder) StartSpan(name string, attrs map[string]any) func(err error) {
+	var next func(err error)
+	if r.Next != nil {
+		next = r.Next.StartSpan(name, attrs)
+	}
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	r.mu.Unlock()
+
+	begin := traceEvent{Name: name, Category: name, Phase: "b", ID: id, TID: traceConnectThread, Args: attrs}
+	if name == "phx.push" {
+		begin.Name = traceName(attrs["event"], name)
+		begin.TID = tracePushThread
+	}
+	r.record(begin)
+
+	return func(err error) {
+		end := traceEvent{Name: begin.Name, Category: name, Phase: "e", ID: id, TID: begin.TID}
+		if err != nil {
+			end.Args = map[string]any{"error": err.Error()}
+		}
+		r.record(end)
+		if next != nil {
+			next(err)
+		}
+	}
+}
+
+// Count implements Instrumenter, recording replies and reconnects as instant events.
+func (r *TraceRecorder) Count(name string, n int64, attrs map[string]any) {
+	if r.Next != nil {
+		r.Next.Count(name, n, attrs)
+	}
+
+	switch name {
+	case "phx.replies":
+		r.record(traceEvent{Name: "reply " + traceName(attrs["status"], ""), Category: name, Phase: "i", Scope: "t",
+			TID: tracePushThread, Args: attrs})
+	case "phx.reconnects":
+		r.record(traceEvent{Name: "reconnected", Category: name, Phase: "i", Scope: "g", TID: traceConnectThread,
+			Args: attrs})
+	}
+}
+
+// Observe implements Instrumenter, recording each heartbeat from its sending until its reply on the heartbeats track.
+func (r *TraceRecorder) Observe(name string, value float64, attrs map[string]any) {
+	if r.Next != nil {
+		r.Next.Observe(name, value, attrs)
+	}
+
+	if name == "phx.heartbeat.rtt" {
+		rtt := time.Duration(value * float64(time.Second))
+		r.recordAt(traceEvent{Name: "heartbeat", Category: name, Phase: "X", TID: traceHeartbeatThread,
+			Duration: micros(rtt)}, r.clock().Now().Add(-rtt))
+	}
+}
+
+// Reset discards the recorded events, and starts the timeline over.
+func (r *TraceRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = nil
+	r.start = time.Time{}
+}
+
+// WriteTo writes the recorded events as a Chrome trace JSON object.
+func (r *TraceRecorder) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	events := make([]traceEvent, 0, len(r.events)+3)
+	for i, name := range []string{"connects", "pushes", "heartbeats"} {
+		events = append(events, traceEvent{Name: "thread_name", Phase: "M", PID: 1, TID: traceConnectThread + i,
+			Args: map[string]any{"name": name}})
+	}
+	events = append(events, r.events...)
+	r.mu.Unlock()
+
+	data, err := json.Marshal(map[string]any{"traceEvents": events, "displayTimeUnit": "ms"})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (r *TraceRecorder) record(event traceEvent) {
+	r.recordAt(event, r.clock().Now())
+}
+
+// recordAt records the given event as happening at the given time.
+func (r *TraceRecorder) recordAt(event traceEvent, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.start.IsZero() {
+		r.start = at
+	}
+	event.PID = 1
+	event.Timestamp = micros(at.Sub(r.start))
+
+	maxEvents := r.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = defaultTraceMaxEvents
+	}
+	if len(r.events) >= maxEvents {
+		r.events = append(r.events[:0], r.events[len(r.events)-maxEvents+1:]...)
+	}
+	r.events = append(r.events, event)
+}
+
+func (r *TraceRecorder) clock() Clock {
+	if r.Clock == nil {
+		return realClock{}
+	}
+	return r.Clock
+}
+
+// traceName returns the given attribute as a string, or fallback if it isn't one.
+func traceName(attr any, fallback string) string {
+	if name, ok := attr.(string); ok && name != "" {
+		return name
+	}
+	return fallback
+}
+
+// micros returns the given duration in microseconds, the unit of the Chrome trace event format.
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
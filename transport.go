@@ -3,6 +3,7 @@ package phx
 import (
 	"net/http"
 	"net/url"
+	"runtime/pprof"
 	"time"
 )
 
@@ -16,6 +17,29 @@ type Transport interface {
 	Send([]byte) error
 }
 
+// PrioritySender is implemented by Transports that can send a message ahead of any messages queued with Send, such as
+// heartbeats that must not wait behind a large transfer.
+type PrioritySender interface {
+	SendPriority([]byte) error
+}
+
+// BinarySender is implemented by Transports that can send binary frames, as needed by Channel.PushBinary.
+type BinarySender interface {
+	SendBinary([]byte) error
+}
+
+// CodeDisconnecter is implemented by Transports that can close the connection with a close code and reason, as used by
+// Socket.DisconnectWithCode.
+type CodeDisconnecter interface {
+	DisconnectWithCode(code int, reason string) error
+}
+
+// EndpointSwitcher is implemented by Transports that can change the endpoint they connect to while started. The new
+// endpoint is used from the next reconnection.
+type EndpointSwitcher interface {
+	SwitchEndpoint(endPoint *url.URL, requestHeader http.Header) error
+}
+
 // TransportHandler defines the interface that handles the activity of the Transport. This is usually just a Socket,
 // but a custom TransportHandler can be implemented to stand in between a Transport and Socket.
 type TransportHandler interface {
@@ -25,5 +49,44 @@ type TransportHandler interface {
 	onWriteError(error)
 	onReadError(error)
 	onConnMessage([]byte)
+	onConnBinaryMessage([]byte)
+	onProtocolError(*ProtocolError)
+	onConnStateChange()
 	reconnectAfter(int) time.Duration
+	reconnectStableAfter() time.Duration
+	sendQueue() (size int, policy OverflowPolicy)
+	onSendQueueFull(dropped bool)
+	clock() Clock
+	logger() Logger
+	connectTarget(endPoint *url.URL, requestHeader http.Header) (*url.URL, http.Header, error)
+	profilerLabels(role string) pprof.LabelSet
+}
+
+// readySignal is a channel that is closed while a condition holds, so that goroutines can block until it does instead
+// of polling for it. It must be updated whenever the condition may have changed, with the lock that guards it held.
+type readySignal struct {
+	ch chan struct{}
+}
+
+// set updates the signal with whether the condition holds.
+func (r *readySignal) set(ready bool) {
+	r.wait()
+	select {
+	case <-r.ch:
+		if !ready {
+			r.ch = make(chan struct{})
+		}
+	default:
+		if ready {
+			close(r.ch)
+		}
+	}
+}
+
+// wait returns a channel that is closed once the condition holds.
+func (r *readySignal) wait() <-chan struct{} {
+	if r.ch == nil {
+		r.ch = make(chan struct{})
+	}
+	return r.ch
 }
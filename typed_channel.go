@@ -0,0 +1,155 @@
+package phx
+
+import (
+	"context"
+	"fmt"
+)
+
+// decodeErrorEvent is generated by the client when a typed handler can't decode the payload of an event. Triggers
+// channel.OnDecodeError().
+const decodeErrorEvent = "phx_decode_error"
+
+// DecodeError is the payload of the OnDecodeError callbacks of a Channel, for an event whose payload a typed handler
+// could not decode.
+type DecodeError struct {
+	// Topic and Event of the message.
+	Topic string
+	Event string
+
+	// Payload is the payload that could not be decoded.
+	Payload any
+
+	// Err is the error of the PayloadCodec, or of a PayloadMigrator.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("could not decode '%v' payload on '%v': %v", e.Event, e.Topic, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// On registers the given callback for the given event of the Channel, with the payload decoded into a T with the
+// Socket's Codec, such as a struct with json tags:
+//
+//	phx.On(channel, "new_msg", func(msg ChatMessage) { ... })
+//
+// Payloads that cannot be decoded are logged, and passed to the OnDecodeError callbacks of the Channel as a
+// *DecodeError instead.
+// Returns a unique Ref that can be used to cancel this callback via Channel.Off.
+func On[T any](channel *Channel, event string, callback func(T)) Ref {
+	return channel.On(event, func(payload any) {
+		var v T
+		if channel.decodeTyped(channel.socket.Codec, event, payload, &v) {
+			callback(v)
+		}
+	})
+}
+
+// OnDecodeError will register the given callback for whenever a handler registered with On or TypedChannel.OnEvent
+// can't migrate or decode the payload of an event. The payload is a *DecodeError.
+// Returns a unique Ref that can be used to cancel this callback via Off.
+func (c *Channel) OnDecodeError(callback func(payload any)) (bindingRef Ref) {
+	return c.On(decodeErrorEvent, callback)
+}
+
+// decodeTyped migrates the given payload of the given event with the Socket's migrators and decodes it into v with the
+// given codec, and returns true if it could. Otherwise, the error is logged and passed to the OnDecodeError callbacks.
+func (c *Channel) decodeTyped(codec PayloadCodec, event string, payload any, v any) bool {
+	migrated, err := c.socket.migratePayload(event, payload)
+	if err == nil {
+		err = codec.Decode(migrated, v)
+	}
+	if err == nil {
+		return true
+	}
+	c.reportDecodeError(event, payload, err)
+	return false
+}
+
+// reportDecodeError logs the given error decoding the given payload of the given event, and passes it to the
+// OnDecodeError callbacks.
+func (c *Channel) reportDecodeError(event string, payload any, err error) {
+	decodeErr := &DecodeError{Topic: c.topic, Event: event, Payload: payload, Err: err}
+	c.socket.Logger.Printf(LogError, "channel", "%v", decodeErr)
+	c.trigger(decodeErrorEvent, 0, decodeErr)
+}
+
+// TypedChannel wraps a Channel whose events share one schema, so that requests and responses are converted to and from
+// Go types with the Socket's PayloadCodec instead of handling raw payloads.
+type TypedChannel[Req, Resp any] struct {
+	// Channel is the underlying Channel.
+	Channel *Channel
+
+	// Event is the event pushed by Push and listened to by OnEvent.
+	Event string
+
+	// Codec overrides the Socket's Codec for this TypedChannel if set.
+	Codec PayloadCodec
+}
+
+// NewTypedChannel creates a TypedChannel that pushes and listens to the given event on the given Channel.
+func NewTypedChannel[Req, Resp any](channel *Channel, event string) *TypedChannel[Req, Resp] {
+	return &TypedChannel[Req, Resp]{
+		Channel: channel,
+		Event:   event,
+	}
+}
+
+// Push encodes and sends the given request to the server and blocks until there is a reply. An "ok" reply is decoded
+// and returned. An "error" reply is returned as a *ReplyError, no reply before the Channel's PushTimeout results in
+// ErrPushTimeout, and if the Channel's join ends first, ErrPushReleased. Use PushCtx to wait with a context instead.
+func (t *TypedChannel[Req, Resp]) Push(req Req) (resp Resp, err error) {
+	ctx, cancel := t.Channel.socket.timeoutContext(t.Channel.PushTimeout)
+	defer cancel()
+	resp, err = t.PushCtx(ctx, req)
+	if err == context.Canceled {
+		err = ErrPushTimeout
+	}
+	return
+}
+
+// PushCtx pushes the given request like Push, and blocks until there is a reply or the context is done. If the context
+// is done first, the context's error is returned.
+func (t *TypedChannel[Req, Resp]) PushCtx(ctx context.Context, req Req) (resp Resp, err error) {
+	payload, err := t.codec().Encode(req)
+	if err != nil {
+		return
+	}
+
+	// Listen for the reply before sending, so that a quick reply can't be missed
+	push := NewPush(t.Channel, t.Event, payload, t.Channel.PushTimeout)
+	wait := listenReply(push)
+	if _, err = t.Channel.pushOrBuffer(push); err != nil {
+		return
+	}
+
+	reply, err := wait(ctx)
+	if err != nil {
+		return
+	}
+
+	err = t.codec().Decode(reply.Response, &resp)
+	return
+}
+
+// OnEvent registers the given callback for the Event, with the payload decoded into a Resp. Payloads that cannot be
+// decoded are logged, and passed to the OnDecodeError callbacks of the Channel.
+// Returns a unique Ref that can be used to cancel this callback via Channel.Off.
+func (t *TypedChannel[Req, Resp]) OnEvent(callback func(resp Resp)) Ref {
+	return t.Channel.On(t.Event, func(payload any) {
+		var resp Resp
+		if t.Channel.decodeTyped(t.codec(), t.Event, payload, &resp) {
+			callback(resp)
+		}
+	})
+}
+
+func (t *TypedChannel[Req, Resp]) codec() PayloadCodec {
+	if t.Codec != nil {
+		return t.Codec
+	}
+	return t.Channel.socket.Codec
+}
@@ -0,0 +1,64 @@
+package phx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	phx "github.com/ongkong/phxx"
+)
+
+// typedPush calls TypedChannel.Push in a goroutine and returns a channel that receives its error. The request is a map
+// rather than a struct, so that RawSerializerV2 can encode it in builds with the phx_rawjson tag.
+func typedPush(typed *phx.TypedChannel[map[string]any, map[string]any]) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := typed.Push(map[string]any{"n": 1})
+		errs <- err
+	}()
+	return errs
+}
+
+func TestTypedChannelPushReleasedOnLeave(t *testing.T) {
+	server, _, channel := joinedChannel(t, "room:1")
+	server.Handle("room:*", "slow", noReply)
+	channel.PushTimeout = time.Minute
+	typed := phx.NewTypedChannel[map[string]any, map[string]any](channel, "slow")
+
+	errs := typedPush(typed)
+	if _, err := server.WaitFor("room:1", "slow", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Leave(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := receive(t, errs); !errors.Is(err, phx.ErrPushReleased) {
+		t.Fatalf("got %v, want ErrPushReleased", err)
+	}
+}
+
+func TestTypedChannelPushTimeout(t *testing.T) {
+	server, _, channel := joinedChannel(t, "room:1")
+	server.Handle("room:*", "slow", noReply)
+	channel.PushTimeout = 50 * time.Millisecond
+	typed := phx.NewTypedChannel[map[string]any, map[string]any](channel, "slow")
+
+	if err := receive(t, typedPush(typed)); !errors.Is(err, phx.ErrPushTimeout) {
+		t.Fatalf("got %v, want ErrPushTimeout", err)
+	}
+}
+
+func TestTypedChannelPushCtx(t *testing.T) {
+	server, _, channel := joinedChannel(t, "room:1")
+	server.Handle("room:*", "slow", noReply)
+	channel.PushTimeout = time.Minute
+	typed := phx.NewTypedChannel[map[string]any, map[string]any](channel, "slow")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := typed.PushCtx(ctx, map[string]any{"n": 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -1,35 +1,85 @@
 package phx
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// outgoingFrame is a message queued to be sent in a frame of the given type.
+type outgoingFrame struct {
+	frameType FrameType
+	data      []byte
+}
+
 // Websocket is a Transport that connects to the server via Websockets.
 type Websocket struct {
-	Dialer          *websocket.Dialer
-	Handler         TransportHandler
-	conn            *websocket.Conn
+	// Dialer is the gorilla websocket.Dialer that connections are opened with when ConnDialer is nil. Defaults to
+	// websocket.DefaultDialer.
+	//
+	// Deprecated: Set ConnDialer instead, such as to a GorillaDialer created with a custom websocket.Dialer.
+	Dialer *websocket.Dialer
+
+	// ConnDialer opens the websocket connections. Defaults to nil, which opens them with a GorillaDialer using Dialer.
+	ConnDialer Dialer
+
+	Handler TransportHandler
+
+	// Logger, if set, logs the activity of the connection instead of the Logger of the Handler, such as the Socket's.
+	Logger Logger
+
+	// ClientTrace, if set, is called for each phase of establishing the websocket connection: DNS lookup, TCP
+	// connect, TLS handshake and the upgrade response. This allows existing net/http/httptrace instrumentation
+	// to time websocket connection attempts.
+	ClientTrace *httptrace.ClientTrace
+
+	// EnableCompression, if set, negotiates permessage-deflate compression with the server. Set it before connecting.
+	// Requires a Dialer that applies the DialOptions, as GorillaDialer does.
+	EnableCompression bool
+
+	// Subprotocols are the websocket subprotocols offered to the server, in order of preference. Set it before
+	// connecting. Requires a Dialer that applies the DialOptions, as GorillaDialer does.
+	Subprotocols []string
+
+	// MountPoints are the paths to try in turn, on the same host and with the same parameters, when the endpoint
+	// responds to the upgrade with 404 Not Found, such as DefaultMountPoints. The first that connects is logged and
+	// used from then on. Defaults to nil, which doesn't probe.
+	MountPoints []string
+
+	conn            WebsocketConn
 	endPoint        *url.URL
 	requestHeader   http.Header
 	connectTimeout  time.Duration
-	done            chan any
+	done            chan struct{} // closed by shutdown, the channels below are handed to the goroutines by startup
 	close           chan bool
 	reconnect       chan bool
 	closeMsg        chan bool
-	send            chan []byte
-	connectionTries int
+	send            chan outgoingFrame
+	sendPolicy      OverflowPolicy
+	sendPriority    chan outgoingFrame
 	mu              sync.RWMutex
 	started         bool
 	closing         bool
 	reconnecting    bool
 	waitingForClose bool
+	closeCode       int         // sent when closing instead of CloseNormalClosure if set, guarded by mu
+	closeReason     string      // sent along with closeCode
+	ready           readySignal // closed while the connection is ready, guarded by mu
+	queuedBytes     int64
+
+	// the time in nanoseconds each frame may take to be read or written, and whether either was ever set, accessed
+	// atomically
+	readTimeout  int64
+	writeTimeout int64
+	deadlines    int32
 }
 
 func NewWebsocket(handler TransportHandler) *Websocket {
@@ -39,6 +89,24 @@ func NewWebsocket(handler TransportHandler) *Websocket {
 	}
 }
 
+// SetWriteTimeout sets the time each frame may take to be written, after which the peer is considered stalled and the
+// connection is reconnected, so that a peer that stops reading can't block the send queue forever. It applies from the
+// next frame. Zero (the default) waits forever. Requires a WebsocketConn that implements WebsocketDeadliner, as those
+// of GorillaDialer do.
+func (w *Websocket) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&w.writeTimeout, int64(timeout))
+	atomic.StoreInt32(&w.deadlines, 1)
+}
+
+// SetReadTimeout sets the time to wait for each frame to be received, after which the peer is considered stalled and
+// the connection is reconnected. It must be longer than the Socket's HeartbeatInterval, as the replies to heartbeats
+// may be all the server sends on an idle connection. It applies from the next frame. Zero (the default) waits forever.
+// Requires a WebsocketConn that implements WebsocketDeadliner, as those of GorillaDialer do.
+func (w *Websocket) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&w.readTimeout, int64(timeout))
+	atomic.StoreInt32(&w.deadlines, 1)
+}
+
 // implements Transport
 
 func (w *Websocket) Connect(endPoint *url.URL, requestHeader http.Header, connectTimeout time.Duration) error {
@@ -46,6 +114,31 @@ func (w *Websocket) Connect(endPoint *url.URL, requestHeader http.Header, connec
 		return errors.New("connect was already called")
 	}
 
+	newEndpoint, err := websocketEndpoint(endPoint)
+	if err != nil {
+		return err
+	}
+
+	w.setEndpoint(newEndpoint, requestHeader)
+	w.connectTimeout = connectTimeout
+
+	w.startup()
+	return nil
+}
+
+// SwitchEndpoint implements EndpointSwitcher, connecting to the given endpoint from the next reconnection.
+func (w *Websocket) SwitchEndpoint(endPoint *url.URL, requestHeader http.Header) error {
+	newEndpoint, err := websocketEndpoint(endPoint)
+	if err != nil {
+		return err
+	}
+
+	w.setEndpoint(newEndpoint, requestHeader)
+	return nil
+}
+
+// websocketEndpoint returns the websocket url to connect to for the given endpoint.
+func websocketEndpoint(endPoint *url.URL) (*url.URL, error) {
 	// Copy the passed in endpoint so we can modify it
 	newEndpoint := *endPoint
 
@@ -64,23 +157,24 @@ func (w *Websocket) Connect(endPoint *url.URL, requestHeader http.Header, connec
 	}
 
 	if newEndpoint.Scheme != "ws" && newEndpoint.Scheme != "wss" {
-		return errors.New("invalid scheme for websocket transport, must be 'ws://' or 'wss://'")
+		return nil, errors.New("invalid scheme for websocket transport, must be 'ws://' or 'wss://'")
 	}
 
-	w.endPoint = &newEndpoint
-	w.requestHeader = requestHeader
-	w.connectTimeout = connectTimeout
-
-	w.startup()
-	return nil
+	return &newEndpoint, nil
 }
 
 func (w *Websocket) Disconnect() error {
+	return w.DisconnectWithCode(CloseNormalClosure, "")
+}
+
+// DisconnectWithCode disconnects like Disconnect, sending the given close code and reason in the close message.
+func (w *Websocket) DisconnectWithCode(code int, reason string) error {
 	if !w.isStarted() {
 		return errors.New("not connected")
 	}
 
 	if w.connIsSet() {
+		w.setCloseFrame(code, reason)
 		w.sendClose()
 	} else {
 		w.shutdown()
@@ -108,85 +202,157 @@ func (w *Websocket) ConnectionState() ConnectionState {
 		return ConnectionClosed
 	} else if w.isClosing() {
 		return ConnectionClosing
+	} else if w.isReconnecting() {
+		return ConnectionReconnecting
 	} else {
 		return ConnectionConnecting
 	}
 }
 
 func (w *Websocket) Send(msg []byte) error {
-	if w.isClosing() {
+	return w.enqueue(false, outgoingFrame{frameType: TextFrame, data: msg})
+}
+
+// SendBinary implements BinarySender, sending the message in a binary frame.
+func (w *Websocket) SendBinary(msg []byte) error {
+	return w.enqueue(false, outgoingFrame{frameType: BinaryFrame, data: msg})
+}
+
+// QueuedBytes implements QueueSizer, returning the bytes waiting in the send queues.
+func (w *Websocket) QueuedBytes() int64 {
+	return atomic.LoadInt64(&w.queuedBytes)
+}
+
+// SendPriority implements PrioritySender, sending the message before any messages already queued with Send.
+func (w *Websocket) SendPriority(msg []byte) error {
+	return w.enqueue(true, outgoingFrame{frameType: TextFrame, data: msg})
+}
+
+// enqueue puts the frame in the send queue, or the priority queue, applying the send policy if it is full.
+func (w *Websocket) enqueue(priority bool, frame outgoingFrame) error {
+	// The queues are taken with the state they belong to, so that a concurrent shutdown can't be missed
+	w.mu.RLock()
+	started, closing, done := w.started, w.closing, w.done
+	queue, policy := w.send, w.sendPolicy
+	if priority {
+		queue, policy = w.sendPriority, OverflowBlock
+	}
+	w.mu.RUnlock()
+
+	if closing {
 		return errors.New("cannot Send when closing connection")
 	}
 
-	if !w.isStarted() {
+	if !started {
 		return errors.New("cannot Send when not connected or connecting")
 	}
 
-	w.send <- msg
-	return nil
+	atomic.AddInt64(&w.queuedBytes, int64(len(frame.data)))
+	return offerQueue(queue, frame, policy, done, func(dropped outgoingFrame) {
+		atomic.AddInt64(&w.queuedBytes, -int64(len(dropped.data)))
+	}, w.Handler.onSendQueueFull)
 }
 
 func (w *Websocket) startup() {
-	w.connectionTries = 0
-
-	w.done = make(chan any)
-	w.close = make(chan bool)
-	w.closeMsg = make(chan bool)
-	w.reconnect = make(chan bool)
-	w.send = make(chan []byte, messageQueueLength)
-
-	w.setReconnecting(false)
-	w.setClosing(false)
+	atomic.StoreInt64(&w.queuedBytes, 0)
 
-	go w.connectionManager()
-	go w.connectionWriter()
-	go w.connectionReader()
+	done := make(chan struct{})
+	// close and reconnect are buffered, as they are sent to with mu held, which the connectionManager may be waiting for
+	closeCh := make(chan bool, 1)
+	closeMsg := make(chan bool)
+	reconnect := make(chan bool, 1)
+	size, policy := w.Handler.sendQueue()
+	send := make(chan outgoingFrame, size)
+	sendPriority := make(chan outgoingFrame, priorityQueueLength)
 
-	w.setStarted(true)
+	w.mu.Lock()
+	w.done, w.close, w.closeMsg, w.reconnect = done, closeCh, closeMsg, reconnect
+	w.send, w.sendPolicy, w.sendPriority = send, policy, sendPriority
+	w.started = true
+	w.reconnecting = false
+	w.closing = false
+	w.updateReady()
+	w.mu.Unlock()
+	w.Handler.onConnStateChange()
+
+	// The goroutines are given their channels, as a later startup replaces the fields while they may still be exiting
+	goLabeled(w.Handler.profilerLabels(roleConnMgr), func() { w.connectionManager(done, closeCh, reconnect, closeMsg) })
+	goLabeled(w.Handler.profilerLabels(roleWriter), func() { w.connectionWriter(done, send, sendPriority) })
+	goLabeled(w.Handler.profilerLabels(roleReader), func() { w.connectionReader(done, closeMsg) })
 }
 
+// shutdown tells the goroutines to exit. The queues are left open, as Send may be about to put a message in them, and
+// are dropped on the next startup.
 func (w *Websocket) shutdown() {
-	//fmt.Println("shutdown")
+	defer w.Handler.onConnStateChange()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	// Tell the goroutines to exit
-	close(w.done)
-	close(w.close)
-	close(w.closeMsg)
-	close(w.reconnect)
-	close(w.send)
+	if !w.started {
+		return
+	}
+	w.log().Println(LogDebug, "websocket", "shutting down")
 
-	w.setStarted(false)
-	w.setReconnecting(false)
-	w.setClosing(false)
+	close(w.done)
+	w.started = false
+	w.reconnecting = false
+	w.closing = false
+	w.updateReady()
 }
 
 func (w *Websocket) dial() error {
-	w.Dialer.HandshakeTimeout = w.connectTimeout
-	conn, _, err := w.Dialer.Dial(w.endPoint.String(), w.requestHeader)
+	ctx, cancel := context.WithTimeout(context.Background(), w.connectTimeout)
+	defer cancel()
+
+	if w.ClientTrace != nil {
+		ctx = httptrace.WithClientTrace(ctx, w.ClientTrace)
+	}
+	if w.EnableCompression || len(w.Subprotocols) > 0 {
+		ctx = withDialOptions(ctx, DialOptions{EnableCompression: w.EnableCompression, Subprotocols: w.Subprotocols})
+	}
+
+	dialer := w.getDialer()
+	endPoint, requestHeader := w.getEndpoint()
+	endPoint, requestHeader, err := w.Handler.connectTarget(endPoint, requestHeader)
+	if err != nil {
+		return err
+	}
+
+	conn, resp, err := dialer.Dial(ctx, endPoint.String(), requestHeader)
+	if err != nil && resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		err = newHandshakeError(resp, err)
+	}
+	if err != nil {
+		conn, resp, err = w.dialMountPoints(ctx, endPoint, requestHeader, err)
+	}
 	if err != nil {
 		return err
 	}
 	if conn == nil {
 		panic("Unexpected for conn to be nil")
 	}
-	//w.socket.Logger.Debugf("Connected conn: %+v\n\n", conn)
-	//w.socket.Logger.Debugf("Connected resp: %+v\n", resp)
+	if resp != nil {
+		logFields(w.log(), LogDebug, "websocket", "dialed", "url", endPoint.Redacted(), "status", resp.StatusCode,
+			"subprotocol", resp.Header.Get("Sec-WebSocket-Protocol"),
+			"extensions", resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
 
 	w.setConn(conn)
 	return nil
 }
 
-func (w *Websocket) closeConn() {
-	//fmt.Println("closeConn")
+func (w *Websocket) closeConn(closeMsg chan bool) {
+	w.log().Println(LogDebug, "websocket", "closing connection")
 
 	if w.connIsSet() {
 		// attempt to gracefully close the connection by sending a close websocket message
-		err := w.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		code, reason := w.takeCloseFrame()
+		err := w.conn.WriteClose(code, reason)
 		if err == nil {
 			// Wait for a close message to be received by `connectionReader`, or time out after 5 seconds
 			w.setWaitingForClose(true)
 			select {
-			case <-w.closeMsg:
+			case <-closeMsg:
 			case <-time.After(3 * time.Second):
 			}
 		}
@@ -202,41 +368,42 @@ func (w *Websocket) closeConn() {
 	}
 
 	w.Handler.onConnClose()
-	w.setClosing(false)
 }
 
-func (w *Websocket) writeToConn(data []byte) error {
+func (w *Websocket) writeToConn(frame outgoingFrame) error {
 	if !w.connIsReady() {
 		return errors.New("connection is not open")
 	}
 
-	return w.conn.WriteMessage(websocket.TextMessage, data)
+	err := w.applyDeadline(w.conn, &w.writeTimeout, WebsocketDeadliner.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	return w.conn.WriteMessage(frame.frameType, frame.data)
 }
 
-func (w *Websocket) readFromConn() ([]byte, error) {
+func (w *Websocket) readFromConn() (FrameType, []byte, error) {
 	if !w.connIsReady() {
-		return nil, errors.New("connection is not open")
+		return 0, nil, errors.New("connection is not open")
 	}
 
-	messageType, data, err := w.conn.ReadMessage()
+	err := w.applyDeadline(w.conn, &w.readTimeout, WebsocketDeadliner.SetReadDeadline)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	if messageType != websocket.TextMessage {
-		return nil, errors.New(fmt.Sprint("Got unsupported websocket message type", messageType))
-	}
-
-	return data, nil
+	return w.conn.ReadMessage()
 }
 
-func (w *Websocket) connectionManager() {
-	//fmt.Println("connectionManager started")
-	//defer fmt.Println("connectionManager stopped")
+func (w *Websocket) connectionManager(done chan struct{}, closeCh chan bool, reconnect chan bool, closeMsg chan bool) {
+	w.log().Println(LogDebug, "websocket", "connectionManager started")
+	defer w.log().Println(LogDebug, "websocket", "connectionManager stopped")
 
+	connectionTries := 0
+	var connectedAt time.Time
 	for {
 		// Check if we have been told to finish
 		select {
-		case <-w.done:
+		case <-done:
 			return
 		default:
 		}
@@ -246,118 +413,174 @@ func (w *Websocket) connectionManager() {
 			if err != nil {
 				w.Handler.onConnError(err)
 				w.setReconnecting(true)
-				w.connectionTries++
-				delay := w.Handler.reconnectAfter(w.connectionTries)
-				select {
-				case <-w.done:
-				case <-time.After(delay):
-				}
+				connectionTries++
+				sleepClock(w.Handler.clock(), w.Handler.reconnectAfter(connectionTries), done)
 				continue
-			} else {
-				w.setReconnecting(false)
-				w.Handler.onConnOpen()
 			}
+			if isDone(done) {
+				// Disconnected while dialing, so nobody else will close the connection
+				w.mu.Lock()
+				conn := w.conn
+				w.conn = nil
+				w.updateReady()
+				w.mu.Unlock()
+				conn.Close()
+				return
+			}
+			connectedAt = w.Handler.clock().Now()
+			w.setReconnecting(false)
+			w.Handler.onConnOpen()
 		}
 
 		select {
-		case <-w.done:
+		case <-done:
 			return
-		case <-w.close:
-			w.closeConn()
+		case <-closeCh:
+			w.closeConn(closeMsg)
 			w.shutdown()
-		case <-w.reconnect:
-			w.closeConn()
+		case <-reconnect:
+			w.closeConn(closeMsg)
+
+			// Only start the tries over once the connection has proven to be stable, otherwise back off before
+			// dialing again, in case the server is accepting and then dropping connections.
+			if w.Handler.clock().Now().Sub(connectedAt) >= w.Handler.reconnectStableAfter() {
+				connectionTries = 0
+			} else {
+				connectionTries++
+				sleepClock(w.Handler.clock(), w.Handler.reconnectAfter(connectionTries), done)
+			}
 		}
 	}
 }
 
-func (w *Websocket) connectionWriter() {
-	//fmt.Println("connectionWriter started")
-	//defer fmt.Println("connectionWriter stopped")
+func (w *Websocket) connectionWriter(done chan struct{}, send chan outgoingFrame, sendPriority chan outgoingFrame) {
+	w.log().Println(LogDebug, "websocket", "connectionWriter started")
+	defer w.log().Println(LogDebug, "websocket", "connectionWriter stopped")
 
 	for {
 		// Check if we have been told to finish
 		select {
-		case <-w.done:
+		case <-done:
 			return
 		default:
 		}
 
-		if !w.connIsReady() {
-			time.Sleep(busyWait)
+		if !w.waitReady(done) {
+			return
+		}
+
+		// Priority messages, such as heartbeats, are always sent before queued messages
+		select {
+		case frame := <-sendPriority:
+			w.writeQueued(frame, done)
 			continue
+		default:
 		}
 
 		select {
-		case <-w.done:
+		case <-done:
 			return
-		case data := <-w.send:
-			// If there is a message to send, but we're not connected, then wait until we are.
-			if !w.connIsReady() {
-				time.Sleep(busyWait)
-				continue
-			}
+		case frame := <-sendPriority:
+			w.writeQueued(frame, done)
+		case frame := <-send:
+			w.writeQueued(frame, done)
+		}
+	}
+}
 
-			// Send the message
-			err := w.writeToConn(data)
+// writeQueued writes a message taken off of one of the send queues to the connection.
+func (w *Websocket) writeQueued(frame outgoingFrame, done chan struct{}) {
+	atomic.AddInt64(&w.queuedBytes, -int64(len(frame.data)))
 
-			// If there were any errors sending, then tell the connectionManager to reconnect
-			if err != nil {
-				w.Handler.onWriteError(err)
-				w.sendReconnect()
-				time.Sleep(busyWait)
-				continue
-			}
-		}
+	// If there is a message to send, but we're not connected, then wait until we are.
+	if !w.waitReady(done) {
+		return
+	}
+
+	// Send the message
+	err := w.writeToConn(frame)
+
+	// If there were any errors sending, then tell the connectionManager to reconnect
+	if err != nil {
+		w.Handler.onWriteError(err)
+		w.sendReconnect()
 	}
 }
 
-func (w *Websocket) connectionReader() {
-	//fmt.Println("connectionReader started")
-	//defer fmt.Println("connectionReader stopped")
+func (w *Websocket) connectionReader(done chan struct{}, closeMsg chan bool) {
+	w.log().Println(LogDebug, "websocket", "connectionReader started")
+	defer w.log().Println(LogDebug, "websocket", "connectionReader stopped")
 
 	for {
 		// Check if we have been told to finish
 		select {
-		case <-w.done:
-			//fmt.Println("connectionReader stopping")
+		case <-done:
 			return
 		default:
 		}
 
 		// Wait until we're connected
-		if !w.connIsReady() {
-			time.Sleep(busyWait)
-			continue
+		if !w.waitReady(done) {
+			return
 		}
 
 		// Read the next message from the websocket. This blocks until there is a message or error
-		data, err := w.readFromConn()
+		frameType, data, err := w.readFromConn()
 
 		// If there were any errors, tell the connectionManager to reconnect
 		if err != nil {
-			//fmt.Printf("read error %e %v\n", err, err)
-			if websocket.IsCloseError(err, 1000) && w.isWaitingForClose() {
+			if isCloseError(err, CloseNormalClosure) && w.isWaitingForClose() {
 				// tell the connectionManager that we got the close message
-				w.closeMsg <- true
+				select {
+				case closeMsg <- true:
+				case <-done:
+				}
 			} else {
 				w.Handler.onReadError(err)
 				w.sendReconnect()
 			}
-
-			time.Sleep(busyWait)
 			continue
 		}
 
-		w.Handler.onConnMessage(data)
+		switch frameType {
+		case TextFrame:
+			w.Handler.onConnMessage(data)
+		case BinaryFrame:
+			w.Handler.onConnBinaryMessage(data)
+		case pingFrame, pongFrame:
+			// Control frames may come between the fragments of a message, and are answered by the connection
+		default:
+			err := fmt.Errorf("unsupported websocket frame type %v", frameType)
+			w.Handler.onProtocolError(newProtocolError(ProtocolUnexpectedFrame, data, err))
+		}
+	}
+}
+
+// applyDeadline sets the deadline of the next frame on the given connection with the given setter, according to the
+// given timeout, unless no timeout was ever set.
+func (w *Websocket) applyDeadline(conn WebsocketConn, timeout *int64, set func(WebsocketDeadliner, time.Time) error) error {
+	if atomic.LoadInt32(&w.deadlines) == 0 {
+		return nil
+	}
+	deadliner, ok := conn.(WebsocketDeadliner)
+	if !ok {
+		return nil
+	}
+
+	var deadline time.Time
+	if t := time.Duration(atomic.LoadInt64(timeout)); t > 0 {
+		deadline = time.Now().Add(t)
 	}
+	return set(deadliner, deadline)
 }
 
 func (w *Websocket) setStarted(started bool) {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.started = started
+	w.updateReady()
 }
 
 func (w *Websocket) isStarted() bool {
@@ -368,10 +591,12 @@ func (w *Websocket) isStarted() bool {
 }
 
 func (w *Websocket) setClosing(closing bool) {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.closing = closing
+	w.updateReady()
 }
 
 func (w *Websocket) isClosing() bool {
@@ -382,22 +607,26 @@ func (w *Websocket) isClosing() bool {
 }
 
 func (w *Websocket) sendClose() {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.closing == true {
+	if w.closing == true || !w.started {
 		return
 	}
 
 	w.closing = true
+	w.updateReady()
 	w.close <- true
 }
 
 func (w *Websocket) setReconnecting(reconnecting bool) {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.reconnecting = reconnecting
+	w.updateReady()
 }
 
 func (w *Websocket) isReconnecting() bool {
@@ -408,22 +637,58 @@ func (w *Websocket) isReconnecting() bool {
 }
 
 func (w *Websocket) sendReconnect() {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.reconnecting || w.closing {
+	if w.reconnecting || w.closing || !w.started {
 		return
 	}
 
 	w.reconnecting = true
+	w.updateReady()
 	w.reconnect <- true
 }
 
-func (w *Websocket) setConn(conn *websocket.Conn) {
+func (w *Websocket) setEndpoint(endPoint *url.URL, requestHeader http.Header) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.endPoint = endPoint
+	w.requestHeader = requestHeader
+}
+
+func (w *Websocket) getEndpoint() (*url.URL, http.Header) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.endPoint, w.requestHeader
+}
+
+func (w *Websocket) setDialer(dialer Dialer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.ConnDialer = dialer
+}
+
+func (w *Websocket) getDialer() Dialer {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.ConnDialer == nil {
+		return NewGorillaDialer(w.Dialer)
+	}
+	return w.ConnDialer
+}
+
+func (w *Websocket) setConn(conn WebsocketConn) {
+	defer w.Handler.onConnStateChange()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	w.conn = conn
+	w.updateReady()
 }
 
 func (w *Websocket) connIsSet() bool {
@@ -440,6 +705,56 @@ func (w *Websocket) connIsReady() bool {
 	return w.started && !w.closing && !w.reconnecting && w.conn != nil
 }
 
+// updateReady updates the ready signal after a change to the state of the connection. Must be called with mu locked.
+func (w *Websocket) updateReady() {
+	w.ready.set(w.started && !w.closing && !w.reconnecting && w.conn != nil)
+}
+
+// waitReady blocks until the connection is ready to be read from and written to. Returns false if the Websocket was
+// shut down first.
+func (w *Websocket) waitReady(done chan struct{}) bool {
+	w.mu.Lock()
+	ready := w.ready.wait()
+	w.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// setCloseFrame sets the close code and reason to send when the connection is next closed.
+func (w *Websocket) setCloseFrame(code int, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closeCode = code
+	w.closeReason = reason
+}
+
+// takeCloseFrame returns the close code and reason to send, and resets them for the next connection.
+func (w *Websocket) takeCloseFrame() (int, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	code, reason := w.closeCode, w.closeReason
+	if code == 0 {
+		code, reason = CloseNormalClosure, ""
+	}
+	w.closeCode, w.closeReason = 0, ""
+	return code, reason
+}
+
+// log returns the Logger of the Websocket, or of its Handler if it isn't set.
+func (w *Websocket) log() Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return w.Handler.logger()
+}
+
 func (w *Websocket) setWaitingForClose(waitingForClose bool) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
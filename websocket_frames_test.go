@@ -0,0 +1,156 @@
+package phx
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// frameRecorder collects what a Socket reports for the frames injected into its connection.
+type frameRecorder struct {
+	messages chan Message
+	errors   chan *ProtocolError
+}
+
+func recordFrames(socket *Socket) *frameRecorder {
+	r := &frameRecorder{
+		messages: make(chan Message, 16),
+		errors:   make(chan *ProtocolError, 16),
+	}
+	socket.OnMessage(func(msg Message) { r.messages <- msg })
+	socket.OnProtocolError(func(err *ProtocolError) { r.errors <- err })
+	return r
+}
+
+// nextError waits for the next ProtocolError.
+func (r *frameRecorder) nextError(t *testing.T) *ProtocolError {
+	t.Helper()
+	select {
+	case err := <-r.errors:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("no protocol error was reported")
+		return nil
+	}
+}
+
+// events waits for n messages and returns their events.
+func (r *frameRecorder) events(t *testing.T, n int) map[string]bool {
+	t.Helper()
+	events := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-r.messages:
+			events[msg.Event] = true
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d messages", i, n)
+		}
+	}
+	return events
+}
+
+// noErrors fails if a ProtocolError was reported.
+func (r *frameRecorder) noErrors(t *testing.T) {
+	t.Helper()
+	select {
+	case err := <-r.errors:
+		t.Errorf("unexpected protocol error: %v", err)
+	default:
+	}
+}
+
+// connectFake connects the given Socket and returns the connection it dialed.
+func connectFake(t *testing.T, socket *Socket, dialer *fakeDialer) *fakeConn {
+	t.Helper()
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Disconnect() })
+	return dialer.lastConn(t)
+}
+
+func TestWebsocketInvalidUTF8Frame(t *testing.T) {
+	socket, dialer := newFakeSocket(t)
+	recorder := recordFrames(socket)
+	conn := connectFake(t, socket, dialer)
+
+	invalid := []byte("[null,null,\"room:1\",\"bad\",{\"body\":\"\xff\xfe\"}]")
+	conn.inject(TextFrame, invalid)
+	err := recorder.nextError(t)
+	if err.Kind != ProtocolInvalidUTF8 {
+		t.Errorf("kind = %v, want %v", err.Kind, ProtocolInvalidUTF8)
+	}
+	if !bytes.Equal(err.Frame, invalid) {
+		t.Errorf("frame = %q, want %q", err.Frame, invalid)
+	}
+
+	// The frame is dropped, but the connection is kept
+	conn.inject(TextFrame, []byte(`[null,null,"room:1","good",{}]`))
+	if events := recorder.events(t, 1); !events["good"] {
+		t.Errorf("received %v, want good", events)
+	}
+	if n := dialer.dialed(); n != 1 {
+		t.Errorf("dialed %d connections, want 1", n)
+	}
+}
+
+func TestWebsocketControlFramesBetweenFragments(t *testing.T) {
+	socket, dialer := newFakeSocket(t)
+	recorder := recordFrames(socket)
+	conn := connectFake(t, socket, dialer)
+
+	// Ping and pong frames may come between the fragments of a message, which the connection reassembles around them
+	conn.inject(TextFrame, []byte(`[null,null,"room:1","before",{}]`))
+	conn.inject(pingFrame, []byte("ping"))
+	conn.inject(pongFrame, nil)
+	conn.inject(TextFrame, []byte(`[null,null,"room:1","after",{}]`))
+
+	events := recorder.events(t, 2)
+	if !events["before"] || !events["after"] {
+		t.Errorf("received %v, want before and after", events)
+	}
+	recorder.noErrors(t)
+	if n := dialer.dialed(); n != 1 {
+		t.Errorf("dialed %d connections, want 1", n)
+	}
+}
+
+func TestWebsocketUnexpectedFrames(t *testing.T) {
+	socket, dialer := newFakeSocket(t)
+	socket.Serializer = NewJSONSerializerV1()
+	recorder := recordFrames(socket)
+	conn := connectFake(t, socket, dialer)
+
+	conn.inject(BinaryFrame, []byte{0, 1, 2})
+	if err := recorder.nextError(t); err.Kind != ProtocolUnexpectedBinary {
+		t.Errorf("kind = %v, want %v", err.Kind, ProtocolUnexpectedBinary)
+	}
+
+	conn.inject(FrameType(3), []byte("reserved"))
+	if err := recorder.nextError(t); err.Kind != ProtocolUnexpectedFrame {
+		t.Errorf("kind = %v, want %v", err.Kind, ProtocolUnexpectedFrame)
+	}
+
+	conn.inject(TextFrame, []byte(`{"topic":"room:1","event":"good","payload":{},"ref":null}`))
+	if events := recorder.events(t, 1); !events["good"] {
+		t.Errorf("received %v, want good", events)
+	}
+	if n := dialer.dialed(); n != 1 {
+		t.Errorf("dialed %d connections, want 1", n)
+	}
+}
+
+func TestWebsocketMalformedBinaryFrame(t *testing.T) {
+	socket, dialer := newFakeSocket(t)
+	recorder := recordFrames(socket)
+	conn := connectFake(t, socket, dialer)
+
+	conn.inject(BinaryFrame, []byte{0, 200})
+	if err := recorder.nextError(t); err.Kind != ProtocolMalformed {
+		t.Errorf("kind = %v, want %v", err.Kind, ProtocolMalformed)
+	}
+	conn.inject(TextFrame, []byte(`[null,null,"room:1","good",{}]`))
+	if events := recorder.events(t, 1); !events["good"] {
+		t.Errorf("received %v, want good", events)
+	}
+}
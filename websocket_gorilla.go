@@ -0,0 +1,143 @@
+package phx
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// GorillaDialer is a Dialer that uses github.com/gorilla/websocket. It is the default Dialer of the Websocket transport.
+// If a custom websocket.Dialer is needed, such as to set up a Proxy or TLS configuration, create a GorillaDialer with it.
+type GorillaDialer struct {
+	Dialer *websocket.Dialer
+}
+
+// NewGorillaDialer creates a GorillaDialer using the given websocket.Dialer, or websocket.DefaultDialer if nil.
+func NewGorillaDialer(dialer *websocket.Dialer) *GorillaDialer {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return &GorillaDialer{
+		Dialer: dialer,
+	}
+}
+
+func (d *GorillaDialer) Dial(ctx context.Context, url string, requestHeader http.Header) (WebsocketConn, *http.Response, error) {
+	// Copy the dialer so that we don't modify a shared one, such as websocket.DefaultDialer
+	dialer := *d.Dialer
+
+	if trace := httptrace.ContextClientTrace(ctx); trace != nil {
+		dialer.NetDialContext = traceNetDial(trace, netDialFunc(&dialer))
+	}
+	options := DialOptionsFromContext(ctx)
+	if options.EnableCompression {
+		dialer.EnableCompression = true
+	}
+	if len(options.Subprotocols) > 0 {
+		dialer.Subprotocols = options.Subprotocols
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, url, requestHeader)
+	if err != nil {
+		return nil, resp, err
+	}
+	return &gorillaConn{conn: conn}, resp, nil
+}
+
+// gorillaConn adapts a *websocket.Conn to WebsocketConn.
+type gorillaConn struct {
+	conn *websocket.Conn
+}
+
+func (c *gorillaConn) ReadMessage() (FrameType, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return 0, nil, &CloseError{Code: closeErr.Code, Text: closeErr.Text}
+	}
+	return FrameType(messageType), data, err
+}
+
+func (c *gorillaConn) WriteMessage(frameType FrameType, data []byte) error {
+	return c.conn.WriteMessage(int(frameType), data)
+}
+
+func (c *gorillaConn) WriteClose(code int, text string) error {
+	return c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+func (c *gorillaConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *gorillaConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *gorillaConn) Close() error {
+	return c.conn.Close()
+}
+
+// netDialFunc returns the function the given dialer would use to make TCP connections.
+func netDialFunc(dialer *websocket.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer.NetDialContext != nil {
+		return dialer.NetDialContext
+	}
+	if dialer.NetDial != nil {
+		netDial := dialer.NetDial
+		return func(_ context.Context, network, addr string) (net.Conn, error) {
+			return netDial(network, addr)
+		}
+	}
+	netDialer := &net.Dialer{}
+	return netDialer.DialContext
+}
+
+// traceNetDial wraps the given dial function to report the DNS and TCP connect phases to the given trace. The websocket
+// dialer itself reports the connection, TLS handshake and first response byte.
+func traceNetDial(trace *httptrace.ClientTrace, netDial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		ips := []string{host}
+		if net.ParseIP(host) == nil {
+			if trace.DNSStart != nil {
+				trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+			}
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if trace.DNSDone != nil {
+				trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+			}
+			if err != nil {
+				return nil, err
+			}
+			ips = ips[:0]
+			for _, ip := range addrs {
+				ips = append(ips, ip.String())
+			}
+		}
+
+		var conn net.Conn
+		for _, ip := range ips {
+			ipAddr := net.JoinHostPort(ip, port)
+			if trace.ConnectStart != nil {
+				trace.ConnectStart(network, ipAddr)
+			}
+			conn, err = netDial(ctx, network, ipAddr)
+			if trace.ConnectDone != nil {
+				trace.ConnectDone(network, ipAddr, err)
+			}
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,240 @@
+package phx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFrame is a frame read from a fakeConn.
+type fakeFrame struct {
+	frameType FrameType
+	data      []byte
+	err       error
+}
+
+// fakeConn is a WebsocketConn that replies "ok" to every message with a ref, like a Phoenix server that accepts all
+// joins and pushes, and lets tests inject raw frames.
+type fakeConn struct {
+	frames    chan fakeFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		frames: make(chan fakeFrame, 1024),
+		closed: make(chan struct{}),
+	}
+}
+
+// inject queues the given frame to be read, as if the server sent it.
+func (c *fakeConn) inject(frameType FrameType, data []byte) {
+	select {
+	case c.frames <- fakeFrame{frameType: frameType, data: data}:
+	case <-c.closed:
+	}
+}
+
+func (c *fakeConn) ReadMessage() (FrameType, []byte, error) {
+	select {
+	case frame := <-c.frames:
+		return frame.frameType, frame.data, frame.err
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed connection")
+	}
+}
+
+func (c *fakeConn) WriteMessage(frameType FrameType, data []byte) error {
+	select {
+	case <-c.closed:
+		return errors.New("use of closed connection")
+	default:
+	}
+
+	var msg [5]json.RawMessage
+	if frameType != TextFrame || json.Unmarshal(data, &msg) != nil || string(msg[1]) == "null" {
+		return nil
+	}
+	reply := fmt.Sprintf(`[%s,%s,%s,"phx_reply",{"status":"ok","response":{}}]`, msg[0], msg[1], msg[2])
+	select {
+	case c.frames <- fakeFrame{frameType: TextFrame, data: []byte(reply)}:
+	case <-c.closed:
+	}
+	return nil
+}
+
+func (c *fakeConn) WriteClose(code int, text string) error {
+	// The server echoes the close frame
+	select {
+	case c.frames <- fakeFrame{err: &CloseError{Code: code, Text: text}}:
+	case <-c.closed:
+	}
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// fakeDialer is a Dialer that connects to a new fakeConn every time.
+type fakeDialer struct {
+	mu    sync.Mutex
+	conns []*fakeConn
+}
+
+func (d *fakeDialer) Dial(ctx context.Context, url string, requestHeader http.Header) (WebsocketConn, *http.Response, error) {
+	conn := newFakeConn()
+	d.mu.Lock()
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+	return conn, &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}}, nil
+}
+
+// lastConn returns the most recently dialed connection, waiting for the first one.
+func (d *fakeDialer) lastConn(t *testing.T) *fakeConn {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		n := len(d.conns)
+		var conn *fakeConn
+		if n > 0 {
+			conn = d.conns[n-1]
+		}
+		d.mu.Unlock()
+		if conn != nil {
+			return conn
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no connection was dialed")
+	return nil
+}
+
+// dialed returns the number of connections dialed so far.
+func (d *fakeDialer) dialed() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.conns)
+}
+
+// newFakeSocket returns a Socket whose Websocket dials fakeConns.
+func newFakeSocket(t *testing.T) (*Socket, *fakeDialer) {
+	t.Helper()
+	endPoint, err := url.Parse("ws://example.test/socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := NewSocket(endPoint)
+	dialer := &fakeDialer{}
+	socket.Transport.(*Websocket).ConnDialer = dialer
+	return socket, dialer
+}
+
+func TestWebsocketConcurrentUse(t *testing.T) {
+	socket, _ := newFakeSocket(t)
+	socket.ReconnectAfterFunc = func(tries int) time.Duration { return time.Millisecond }
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channel := socket.Channel(fmt.Sprintf("room:%d", i), nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Errors are expected while the Socket is disconnected, what matters is that nothing panics or races
+				if join, err := channel.Join(); err == nil {
+					_, _ = join.Await(50 * time.Millisecond)
+				}
+				for j := 0; j < 10; j++ {
+					_, _ = channel.Push("ping", map[string]any{"n": j})
+				}
+				if leave, err := channel.Leave(); err == nil {
+					_, _ = leave.Await(50 * time.Millisecond)
+				}
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = socket.Disconnect()
+			_ = socket.Connect()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	_ = socket.Disconnect()
+}
+
+func TestWebsocketSendAfterDisconnect(t *testing.T) {
+	socket, dialer := newFakeSocket(t)
+	if err := socket.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	dialer.lastConn(t)
+
+	ws := socket.Transport.(*Websocket)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = ws.Send([]byte(`[null,null,"phoenix","noop",{}]`))
+				_ = ws.SendPriority([]byte(`[null,null,"phoenix","noop",{}]`))
+			}
+		}()
+	}
+	_ = ws.Disconnect()
+	wg.Wait()
+
+	if err := ws.Send([]byte(`[null,null,"phoenix","noop",{}]`)); err == nil {
+		t.Fatal("expected Send to fail after Disconnect")
+	}
+}
+
+func TestWebsocketDeprecatedDialer(t *testing.T) {
+	ws := NewWebsocket(nil)
+	custom := &websocket.Dialer{HandshakeTimeout: time.Second}
+	ws.Dialer = custom
+
+	if gd, ok := ws.getDialer().(*GorillaDialer); !ok || gd.Dialer != custom {
+		t.Errorf("dialing with %#v, want a GorillaDialer using the websocket.Dialer set in Dialer", ws.getDialer())
+	}
+
+	fake := &fakeDialer{}
+	ws.ConnDialer = fake
+	if ws.getDialer() != Dialer(fake) {
+		t.Errorf("dialing with %#v, want the ConnDialer", ws.getDialer())
+	}
+}
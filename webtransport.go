@@ -0,0 +1,120 @@
+//go:build phx_webtransport
+
+package phx
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// This file contains an experimental transport that speaks the Phoenix protocol over a WebTransport (HTTP/3) stream,
+// for networks where TCP head-of-line blocking hurts latency. It requires a server that accepts the same framing and
+// is only built with the "phx_webtransport" build tag.
+
+// webTransportCloseFrame is the frame type of a close frame, matching the websocket opcode.
+const webTransportCloseFrame FrameType = 8
+
+// webTransportMaxFrame is the largest frame that will be read from a stream.
+const webTransportMaxFrame = 64 * 1024 * 1024
+
+// WebTransportStreamOpener establishes a WebTransport session and opens the bidirectional stream messages are sent
+// over. Implement it with a WebTransport library, such as github.com/quic-go/webtransport-go.
+type WebTransportStreamOpener interface {
+	// OpenStream establishes a session with the given https url, sending the given headers, and opens a
+	// bidirectional stream on it. Closing the stream must close the session.
+	OpenStream(ctx context.Context, url string, requestHeader http.Header) (io.ReadWriteCloser, *http.Response, error)
+}
+
+// WebTransportDialer is a Dialer that frames messages over a WebTransport stream. Each frame is a one byte FrameType,
+// followed by the four byte big-endian length of the data, then the data. Close frames carry a two byte close code
+// followed by the close text, like websocket close frames.
+type WebTransportDialer struct {
+	Opener WebTransportStreamOpener
+}
+
+// NewWebTransport creates a Websocket transport that connects over WebTransport with the given opener instead of
+// websockets. Everything else, such as reconnecting and serializing, is shared with the websocket transport. The
+// endpoint is given with a "ws" or "wss" scheme as usual, which is converted to "http" or "https".
+func NewWebTransport(handler TransportHandler, opener WebTransportStreamOpener) *Websocket {
+	w := NewWebsocket(handler)
+	w.ConnDialer = &WebTransportDialer{Opener: opener}
+	return w
+}
+
+func (d *WebTransportDialer) Dial(ctx context.Context, url string, requestHeader http.Header) (WebsocketConn, *http.Response, error) {
+	if strings.HasPrefix(url, "ws") {
+		url = "http" + strings.TrimPrefix(url, "ws")
+	}
+
+	stream, resp, err := d.Opener.OpenStream(ctx, url, requestHeader)
+	if err != nil {
+		return nil, resp, err
+	}
+	return &webTransportConn{stream: stream, reader: bufio.NewReader(stream)}, resp, nil
+}
+
+// webTransportConn implements WebsocketConn over a framed stream.
+type webTransportConn struct {
+	stream  io.ReadWriteCloser
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+func (c *webTransportConn) ReadMessage() (FrameType, []byte, error) {
+	var header [5]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	frameType := FrameType(header[0])
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > webTransportMaxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.reader, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if frameType == webTransportCloseFrame {
+		if len(data) < 2 {
+			return 0, nil, errors.New("invalid close frame")
+		}
+		return 0, nil, &CloseError{Code: int(binary.BigEndian.Uint16(data)), Text: string(data[2:])}
+	}
+
+	return frameType, data, nil
+}
+
+func (c *webTransportConn) WriteMessage(frameType FrameType, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var header [5]byte
+	header[0] = byte(frameType)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+
+	_, err := c.stream.Write(append(header[:], data...))
+	return err
+}
+
+func (c *webTransportConn) WriteClose(code int, text string) error {
+	data := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(data, uint16(code))
+	data = append(data, text...)
+	return c.WriteMessage(webTransportCloseFrame, data)
+}
+
+func (c *webTransportConn) Close() error {
+	return c.stream.Close()
+}